@@ -5,10 +5,14 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"log"
+	"os"
+	"sync/atomic"
 	"time"
 
+	"github.com/openfaas/faas-netes/pkg/async"
 	clientset "github.com/openfaas/faas-netes/pkg/client/clientset/versioned"
 	informers "github.com/openfaas/faas-netes/pkg/client/informers/externalversions"
 	v1 "github.com/openfaas/faas-netes/pkg/client/informers/externalversions/openfaas/v1"
@@ -16,38 +20,60 @@ import (
 	"github.com/openfaas/faas-netes/pkg/controller"
 	"github.com/openfaas/faas-netes/pkg/handlers"
 	"github.com/openfaas/faas-netes/pkg/k8s"
+	"github.com/openfaas/faas-netes/pkg/metrics"
+	"github.com/openfaas/faas-netes/pkg/proxy"
 	"github.com/openfaas/faas-netes/pkg/server"
 	"github.com/openfaas/faas-netes/pkg/signals"
+	"github.com/openfaas/faas-netes/pkg/tracing"
+	"github.com/openfaas/faas-netes/pkg/webhook"
 	version "github.com/openfaas/faas-netes/version"
 	faasProvider "github.com/openfaas/faas-provider"
 	"github.com/openfaas/faas-provider/logs"
-	"github.com/openfaas/faas-provider/proxy"
 	providertypes "github.com/openfaas/faas-provider/types"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
 	kubeinformers "k8s.io/client-go/informers"
 	v1apps "k8s.io/client-go/informers/apps/v1"
 	v1core "k8s.io/client-go/informers/core/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/client-go/transport"
 	glog "k8s.io/klog"
 
 	// required to authenticate against GKE clusters
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 
+	// the time zones of the scaling schedules are embedded, as the image
+	// has no zoneinfo
+	_ "time/tzdata"
+
 	// required for updating and validating the CRD clientset
 	_ "k8s.io/code-generator/cmd/client-gen/generators"
 	// main.go:36:2: import "sigs.k8s.io/controller-tools/cmd/controller-gen" is a program, not an importable package
 	// _ "sigs.k8s.io/controller-tools/cmd/controller-gen"
 )
 
+// tracingShutdownTimeout is how long the spans which are still buffered are
+// given to be exported on shutdown
+const tracingShutdownTimeout = 5 * time.Second
+
 func main() {
 	var kubeconfig string
 	var masterURL string
 	var (
 		operator,
-		verbose bool
+		verbose,
+		leaderElect,
+		webhookEnabled bool
 	)
+	var workers int
+	var leaderElectNamespace, leaderElectLeaseName string
+	webhookConfig := webhook.Config{}
 
 	flag.StringVar(&kubeconfig, "kubeconfig", "",
 		"Path to a kubeconfig. Only required if out-of-cluster.")
@@ -56,16 +82,65 @@ func main() {
 		"The address of the Kubernetes API server. Overrides any value in kubeconfig. Only required if out-of-cluster.")
 
 	flag.BoolVar(&operator, "operator", false, "Use the operator mode instead of faas-netes")
+	flag.IntVar(&workers, "workers", 1, "Number of Functions reconciled in parallel by the operator")
+	flag.BoolVar(&leaderElect, "leader-elect", false,
+		"Elect a leader with a Lease so that only one replica reconciles Functions and scales them.")
+	flag.StringVar(&leaderElectNamespace, "leader-elect-namespace", "openfaas",
+		"The namespace of the Lease used for leader election.")
+	flag.StringVar(&leaderElectLeaseName, "leader-elect-lease-name", "faas-netes-operator",
+		"The name of the Lease used for leader election.")
+	flag.BoolVar(&webhookEnabled, "webhook", false,
+		"Serve an admission webhook which validates and defaults Functions and Profiles.")
+	flag.IntVar(&webhookConfig.Port, "webhook-port", 8443, "The HTTPS port of the admission webhook.")
+	flag.StringVar(&webhookConfig.CertDir, "webhook-cert-dir", "",
+		"Directory with the tls.crt and tls.key of the webhook, a self-signed certificate is generated when empty.")
+	flag.StringVar(&webhookConfig.ServiceName, "webhook-service", "faas-netes-webhook",
+		"The name of the Service of the webhook, used for the self-signed certificate.")
+	flag.StringVar(&webhookConfig.ServiceNamespace, "webhook-namespace", "openfaas",
+		"The namespace of the Service of the webhook, used for the self-signed certificate.")
+	flag.StringVar(&webhookConfig.ConfigurationName, "webhook-configuration", "faas-netes-operator",
+		"The name of the webhook configurations which are given the CA of the self-signed certificate.")
 	flag.Parse()
 
+	if workers < 1 {
+		log.Fatalf("-workers must be at least 1, got %d", workers)
+	}
+
 	sha, release := version.GetReleaseInfo()
 	log.Printf("Version: %s\tcommit: %s\n", release, sha)
 
+	readConfig := config.ReadConfig{}
+	osEnv := providertypes.OsEnv{}
+	config, err := readConfig.Read(osEnv)
+
+	if err != nil {
+		log.Fatalf("Error reading config: %s", err.Error())
+	}
+
+	config.Fprint(verbose)
+
+	if err := config.ValidateLeaderElection(leaderElect); err != nil {
+		log.Fatalf("Error validating config: %s", err.Error())
+	}
+
+	shutdownTracing, err := tracing.Init(context.Background(), tracing.Config{
+		Exporter:    config.TracingExporter,
+		File:        config.TracingFile,
+		SampleRatio: config.TracingSampleRatio,
+	})
+	if err != nil {
+		log.Fatalf("Error setting up tracing: %s", err.Error())
+	}
+
 	clientCmdConfig, err := clientcmd.BuildConfigFromFlags(masterURL, kubeconfig)
 	if err != nil {
 		log.Fatalf("Error building kubeconfig: %s", err.Error())
 	}
 
+	// the Kubernetes API calls of traced requests and reconciles are
+	// recorded in their spans
+	clientCmdConfig.WrapTransport = transport.Wrappers(clientCmdConfig.WrapTransport, tracing.Transport)
+
 	kubeconfigQPS := 100
 	kubeconfigBurst := 250
 
@@ -82,16 +157,11 @@ func main() {
 		log.Fatalf("Error building OpenFaaS clientset: %s", err.Error())
 	}
 
-	readConfig := config.ReadConfig{}
-	osEnv := providertypes.OsEnv{}
-	config, err := readConfig.Read(osEnv)
-
+	dynamicClient, err := dynamic.NewForConfig(clientCmdConfig)
 	if err != nil {
-		log.Fatalf("Error reading config: %s", err.Error())
+		log.Fatalf("Error building dynamic client: %s", err.Error())
 	}
 
-	config.Fprint(verbose)
-
 	deployConfig := k8s.DeploymentConfig{
 		RuntimeHTTPPort: 8080,
 		HTTPProbe:       config.HTTPProbe,
@@ -131,6 +201,7 @@ func main() {
 
 	profileLister := profileInformerFactory.Openfaas().V1().Profiles().Lister()
 	factory := k8s.NewFunctionFactory(kubeClient, deployConfig, profileLister)
+	factory.Dynamic = dynamicClient
 
 	setup := serverSetup{
 		config:                 config,
@@ -140,14 +211,45 @@ func main() {
 		profileInformerFactory: profileInformerFactory,
 		kubeClient:             kubeClient,
 		faasClient:             faasClient,
+		workers:                workers,
+		leaderElect:            leaderElect,
+		leaderElectNamespace:   leaderElectNamespace,
+		leaderElectLeaseName:   leaderElectLeaseName,
+		webhookEnabled:         webhookEnabled,
+		webhookConfig:          webhookConfig,
 	}
 
+	// set up signals so we handle the first shutdown signal gracefully
+	stopCh := signals.SetupSignalHandler()
+
 	if operator {
 		log.Println("Starting operator")
-		runOperator(setup, config)
+		runOperator(setup, config, stopCh)
+
+		// the operator returns once its workers have stopped
+		flushTracing(shutdownTracing)
 	} else {
+		// the HTTP server of the controller does not return on shutdown, so
+		// the spans are flushed once the signal is received
+		go func() {
+			<-stopCh
+			flushTracing(shutdownTracing)
+		}()
+
 		log.Println("Starting controller")
-		runController(setup)
+		runController(setup, stopCh)
+	}
+}
+
+// flushTracing exports the spans which are still buffered and stops the
+// exporter, it gives up after tracingShutdownTimeout so that a collector
+// which is down does not hold up the shutdown
+func flushTracing(shutdown func(context.Context) error) {
+	ctx, cancel := context.WithTimeout(context.Background(), tracingShutdownTimeout)
+	defer cancel()
+
+	if err := shutdown(ctx); err != nil {
+		log.Printf("Error flushing traces: %s", err.Error())
 	}
 }
 
@@ -155,6 +257,7 @@ type customInformers struct {
 	EndpointsInformer  v1core.EndpointsInformer
 	DeploymentInformer v1apps.DeploymentInformer
 	FunctionsInformer  v1.FunctionInformer
+	PodsInformer       v1core.PodInformer
 }
 
 func startInformers(setup serverSetup, stopCh <-chan struct{}, operator bool) customInformers {
@@ -162,6 +265,7 @@ func startInformers(setup serverSetup, stopCh <-chan struct{}, operator bool) cu
 	faasInformerFactory := setup.faasInformerFactory
 
 	var functions v1.FunctionInformer
+	var pods v1core.PodInformer
 	if operator {
 		// go faasInformerFactory.Start(stopCh)
 
@@ -170,6 +274,20 @@ func startInformers(setup serverSetup, stopCh <-chan struct{}, operator bool) cu
 		if ok := cache.WaitForNamedCacheSync("faas-netes:functions", stopCh, functions.Informer().HasSynced); !ok {
 			log.Fatalf("failed to wait for cache to sync")
 		}
+
+		// pods are used to report the resolved image digest in the Function status
+		pods = kubeInformerFactory.Core().V1().Pods()
+		go pods.Informer().Run(stopCh)
+		if ok := cache.WaitForNamedCacheSync("faas-netes:pods", stopCh, pods.Informer().HasSynced); !ok {
+			log.Fatalf("failed to wait for cache to sync")
+		}
+
+		// services are watched so that manual changes are reverted by the operator
+		services := kubeInformerFactory.Core().V1().Services()
+		go services.Informer().Run(stopCh)
+		if ok := cache.WaitForNamedCacheSync("faas-netes:services", stopCh, services.Informer().HasSynced); !ok {
+			log.Fatalf("failed to wait for cache to sync")
+		}
 	}
 
 	// go kubeInformerFactory.Start(stopCh)
@@ -186,6 +304,13 @@ func startInformers(setup serverSetup, stopCh <-chan struct{}, operator bool) cu
 		log.Fatalf("failed to wait for cache to sync")
 	}
 
+	// secrets are watched so that functions are rolled when a secret they use changes
+	secrets := kubeInformerFactory.Core().V1().Secrets()
+	go secrets.Informer().Run(stopCh)
+	if ok := cache.WaitForNamedCacheSync("faas-netes:secrets", stopCh, secrets.Informer().HasSynced); !ok {
+		log.Fatalf("failed to wait for cache to sync")
+	}
+
 	// go setup.profileInformerFactory.Start(stopCh)
 
 	profileInformerFactory := setup.profileInformerFactory
@@ -199,21 +324,71 @@ func startInformers(setup serverSetup, stopCh <-chan struct{}, operator bool) cu
 		EndpointsInformer:  endpoints,
 		DeploymentInformer: deployments,
 		FunctionsInformer:  functions,
+		PodsInformer:       pods,
 	}
 }
 
 // runController runs the faas-netes imperative controller
-func runController(setup serverSetup) {
+func runController(setup serverSetup, stopCh <-chan struct{}) {
 	config := setup.config
 	kubeClient := setup.kubeClient
 	factory := setup.functionFactory
 
-	// set up signals so we handle the first shutdown signal gracefully
-	stopCh := signals.SetupSignalHandler()
+	// the indexes of the config watcher are added before the informers are started
+	if err := k8s.AddConfigIndexers(setup.kubeInformerFactory.Apps().V1().Deployments()); err != nil {
+		log.Fatalf("Error adding config watcher indexes: %s", err.Error())
+	}
+
 	operator := false
 	listers := startInformers(setup, stopCh, operator)
 
-	functionLookup := k8s.NewFunctionLookup(config.DefaultFunctionNamespace, listers.EndpointsInformer.Lister())
+	functionLookup := k8s.NewFunctionLookup(config.DefaultFunctionNamespace, listers.EndpointsInformer.Lister(), listers.DeploymentInformer.Lister())
+	functionLookup.WriteTimeout = config.FaaSConfig.WriteTimeout
+	listers.DeploymentInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		DeleteFunc: functionLookup.DeploymentDeleted,
+	})
+	if config.ColdStartTimeout > 0 {
+		functionLookup.ColdStart = k8s.NewColdStart(kubeClient, listers.DeploymentInformer.Lister(), listers.EndpointsInformer, config.ColdStartTimeout)
+	}
+
+	// the loops which scale the functions and the config watcher run on one
+	// replica, the leader, so that the replicas do not overwrite each other's
+	// decisions
+	runScaling := func(stopCh <-chan struct{}) {
+		k8s.NewConfigWatcher(factory,
+			setup.kubeInformerFactory.Apps().V1().Deployments(),
+			setup.kubeInformerFactory.Core().V1().Secrets(),
+			setup.profileInformerFactory.Openfaas().V1().Profiles())
+
+		if config.Autoscaler {
+			autoscaler := k8s.NewAutoscaler(kubeClient, listers.DeploymentInformer.Lister(), functionLookup.Stats, k8s.AutoscalerConfig{
+				Interval:        config.AutoscalerInterval,
+				ScaleUpWindow:   config.AutoscalerScaleUpWindow,
+				ScaleDownWindow: config.AutoscalerScaleDownWindow,
+			})
+			go autoscaler.Run(stopCh)
+		}
+		if config.ScaleToZero {
+			idler := k8s.NewIdler(kubeClient, listers.DeploymentInformer.Lister(), functionLookup.Stats, k8s.NewEventRecorder(kubeClient), k8s.IdlerConfig{
+				Interval:        config.ScaleToZeroInterval,
+				DefaultDuration: config.ScaleToZeroDuration,
+			})
+			go idler.Run(stopCh)
+		}
+		if config.ScalingSchedule {
+			scheduler := k8s.NewScheduler(kubeClient, listers.DeploymentInformer.Lister(), k8s.NewEventRecorder(kubeClient), k8s.SchedulerConfig{
+				Interval: config.ScalingScheduleInterval,
+				Location: config.ScalingScheduleTimezone,
+			})
+			go scheduler.Run(stopCh)
+		}
+		<-stopCh
+	}
+	if setup.leaderElect {
+		go runLeaderElection(setup, stopCh, runScaling)
+	} else {
+		go runScaling(stopCh)
+	}
 
 	bootstrapHandlers := providertypes.FaaSHandlers{
 		FunctionProxy:        proxy.NewHandlerFunc(config.FaaSConfig, functionLookup),
@@ -230,11 +405,32 @@ func runController(setup serverSetup) {
 		ListNamespaceHandler: handlers.MakeNamespacesLister(config.DefaultFunctionNamespace, config.ClusterRole, kubeClient),
 	}
 
+	metrics.InstrumentHandlers(&bootstrapHandlers)
+	tracing.InstrumentHandlers(&bootstrapHandlers)
+	faasProvider.Router().Path("/metrics").Handler(promhttp.Handler())
+
+	credentials, err := handlers.ReadBasicAuth(config.FaaSConfig)
+	if err != nil {
+		log.Fatalf("Error reading basic auth credentials: %s", err.Error())
+	}
+	handlers.HandleTraffic(faasProvider.Router(), faasProvider.NameExpression, config.DefaultFunctionNamespace,
+		kubeClient, listers.DeploymentInformer.Lister(), credentials)
+
+	if config.AsyncQueue != async.QueueNone {
+		queue, err := async.NewQueue(config.AsyncQueue, config.AsyncQueueDir, config.AsyncQueueSize)
+		if err != nil {
+			log.Fatalf("Error creating async queue: %s", err.Error())
+		}
+
+		async.HandleFuncs(faasProvider.Router(), faasProvider.NameExpression, queue, config.AsyncMaxBodySize, credentials)
+		async.NewWorkers(queue, functionLookup, config.FaaSConfig, config.AsyncTimeout).Start(config.AsyncWorkers, stopCh)
+	}
+
 	faasProvider.Serve(&bootstrapHandlers, &config.FaaSConfig)
 }
 
 // runOperator runs the CRD Operator
-func runOperator(setup serverSetup, cfg config.BootstrapConfig) {
+func runOperator(setup serverSetup, cfg config.BootstrapConfig, stopCh <-chan struct{}) {
 	kubeClient := setup.kubeClient
 	faasClient := setup.faasClient
 	kubeInformerFactory := setup.kubeInformerFactory
@@ -246,27 +442,120 @@ func runOperator(setup serverSetup, cfg config.BootstrapConfig) {
 	}
 
 	setupLogging()
-	// set up signals so we handle the first shutdown signal gracefully
-	stopCh := signals.SetupSignalHandler()
-	// set up signals so we handle the first shutdown signal gracefully
-
-	operator := true
-	listers := startInformers(setup, stopCh, operator)
 
+	// the controller adds indexes to the informers, so it is created before they are started
 	ctrl := controller.NewController(
 		kubeClient,
 		faasClient,
 		kubeInformerFactory,
 		faasInformerFactory,
+		setup.profileInformerFactory,
 		factory,
 	)
 
-	srv := server.New(faasClient, kubeClient, listers.EndpointsInformer, listers.DeploymentInformer.Lister(), cfg.ClusterRole, cfg)
+	operator := true
+	listers := startInformers(setup, stopCh, operator)
+
+	srv := server.New(faasClient, kubeClient, listers.EndpointsInformer, listers.DeploymentInformer, cfg.ClusterRole, cfg)
+
+	// the HTTP server runs on every replica, so that reads and invocations
+	// are served even when this replica is not the leader
+	go srv.Start(stopCh)
+
+	// the API server calls the webhook through its Service, so it is also
+	// served by every replica
+	if setup.webhookEnabled {
+		namespaceScope := cfg.DefaultFunctionNamespace
+		if cfg.ClusterRole {
+			namespaceScope = ""
+		}
+
+		secrets := kubeInformerFactory.Core().V1().Secrets().Lister()
+		hook := webhook.New(setup.webhookConfig, kubeClient, factory, secrets, namespaceScope)
+		go func() {
+			if err := hook.Start(); err != nil {
+				glog.Fatalf("Error running admission webhook: %s", err.Error())
+			}
+		}()
+	}
+
+	run := func(stopCh <-chan struct{}) {
+		srv.StartScaling(stopCh)
+		if err := ctrl.Run(setup.workers, stopCh); err != nil {
+			glog.Fatalf("Error running controller: %s", err.Error())
+		}
+	}
+
+	if !setup.leaderElect {
+		run(stopCh)
+		return
+	}
 
-	go srv.Start()
-	if err := ctrl.Run(1, stopCh); err != nil {
-		glog.Fatalf("Error running controller: %s", err.Error())
+	runLeaderElection(setup, stopCh, run)
+}
+
+// runLeaderElection blocks until stopCh is closed, calling run while this
+// replica holds the Lease. The Lease is only released after run returns, so
+// that the next leader does not start while the workqueue is still draining.
+// When the Lease is lost the process exits so that it can not reconcile
+// alongside the new leader.
+func runLeaderElection(setup serverSetup, stopCh <-chan struct{}, run func(stopCh <-chan struct{})) {
+	id, err := os.Hostname()
+	if err != nil {
+		glog.Fatalf("Error getting hostname for leader election: %s", err.Error())
+	}
+
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Name:      setup.leaderElectLeaseName,
+			Namespace: setup.leaderElectNamespace,
+		},
+		Client: setup.kubeClient.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity: id,
+		},
 	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var leading int32
+	go func() {
+		<-stopCh
+		// the leader cancels once its workers have stopped
+		if atomic.LoadInt32(&leading) == 0 {
+			cancel()
+		}
+	}()
+
+	glog.Infof("Waiting to acquire Lease %s/%s as %s", lock.LeaseMeta.Namespace, lock.LeaseMeta.Name, id)
+	leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		ReleaseOnCancel: true,
+		LeaseDuration:   15 * time.Second,
+		RenewDeadline:   10 * time.Second,
+		RetryPeriod:     2 * time.Second,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(_ context.Context) {
+				atomic.StoreInt32(&leading, 1)
+				glog.Infof("Acquired Lease %s/%s, starting workers", lock.LeaseMeta.Namespace, lock.LeaseMeta.Name)
+				run(stopCh)
+				cancel()
+			},
+			OnStoppedLeading: func() {
+				if ctx.Err() != nil {
+					glog.Infof("Released Lease %s/%s", lock.LeaseMeta.Namespace, lock.LeaseMeta.Name)
+					return
+				}
+				glog.Fatalf("Lost Lease %s/%s", lock.LeaseMeta.Namespace, lock.LeaseMeta.Name)
+			},
+			OnNewLeader: func(identity string) {
+				if identity != id {
+					glog.Infof("Current leader is %s", identity)
+				}
+			},
+		},
+	})
 }
 
 // serverSetup is a container for the config and clients needed to start the
@@ -279,6 +568,12 @@ type serverSetup struct {
 	kubeInformerFactory    kubeinformers.SharedInformerFactory
 	faasInformerFactory    informers.SharedInformerFactory
 	profileInformerFactory informers.SharedInformerFactory
+	workers                int
+	leaderElect            bool
+	leaderElectNamespace   string
+	leaderElectLeaseName   string
+	webhookEnabled         bool
+	webhookConfig          webhook.Config
 }
 
 func setupLogging() {
@@ -6,8 +6,13 @@ import (
 )
 
 // +genclient
-// +genclient:noStatus
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Image",type=string,JSONPath=`.spec.image`
+// +kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+// +kubebuilder:printcolumn:name="Replicas",type=integer,JSONPath=`.status.replicas`
+// +kubebuilder:printcolumn:name="Available",type=integer,JSONPath=`.status.availableReplicas`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
 // Function describes an OpenFaaS function
 type Function struct {
@@ -15,6 +20,8 @@ type Function struct {
 	metav1.ObjectMeta `json:"metadata,omitempty"`
 
 	Spec FunctionSpec `json:"spec"`
+	// +optional
+	Status FunctionStatus `json:"status,omitempty"`
 }
 
 // FunctionSpec is the spec for a Function resource
@@ -47,6 +54,69 @@ type FunctionResources struct {
 	CPU    string `json:"cpu,omitempty"`
 }
 
+// FunctionStatus is the observed state of a Function resource, it is written
+// by the operator each time the Function is reconciled
+type FunctionStatus struct {
+	// ObservedGeneration is the most recent generation of the Function
+	// that has been reconciled by the operator
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Replicas is the number of desired replicas of the function Deployment
+	// +optional
+	Replicas int32 `json:"replicas,omitempty"`
+	// AvailableReplicas is the number of function replicas that are ready
+	// to receive traffic
+	// +optional
+	AvailableReplicas int32 `json:"availableReplicas,omitempty"`
+	// ImageDigest is the image reference, including its digest, that the
+	// running function replicas were started with
+	// +optional
+	ImageDigest string `json:"imageDigest,omitempty"`
+	// ScalingWindow is the name of the active window of the scaling schedule
+	// of the function
+	// +optional
+	ScalingWindow string `json:"scalingWindow,omitempty"`
+	// Conditions describe the current state of the Function
+	// +optional
+	Conditions []FunctionCondition `json:"conditions,omitempty"`
+}
+
+// FunctionConditionType is the type of a FunctionCondition
+type FunctionConditionType string
+
+const (
+	// FunctionReady is true when the function has at least one available replica
+	// or has been intentionally scaled to zero
+	FunctionReady FunctionConditionType = "Ready"
+	// FunctionDeployed is true when the Deployment and Service of the
+	// function have been created or updated
+	FunctionDeployed FunctionConditionType = "Deployed"
+	// FunctionSecretsResolved is true when all the secrets referenced by the
+	// function exist in its namespace
+	FunctionSecretsResolved FunctionConditionType = "SecretsResolved"
+	// FunctionProfilesApplied is true when all the profiles referenced by the
+	// function have been found and applied to its Deployment
+	FunctionProfilesApplied FunctionConditionType = "ProfilesApplied"
+)
+
+// FunctionCondition describes the state of a Function at a certain point
+type FunctionCondition struct {
+	// Type of the condition
+	Type FunctionConditionType `json:"type"`
+	// Status of the condition, one of True, False, Unknown
+	Status corev1.ConditionStatus `json:"status"`
+	// LastTransitionTime is the last time the condition changed from one status
+	// to another
+	// +optional
+	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty"`
+	// Reason is a one-word CamelCase reason for the condition's last transition
+	// +optional
+	Reason string `json:"reason,omitempty"`
+	// Message is a human readable description of the condition
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
 
 // FunctionList is a list of Function resources
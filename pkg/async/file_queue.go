@@ -0,0 +1,198 @@
+// Copyright 2020 OpenFaaS Author(s)
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package async
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const requestFileExt = ".json"
+
+// fileQueue is a Queue which writes each request to a file in a directory.
+// The file is removed when the request is done, so that the requests which
+// were queued or in progress when the process stopped are invoked again when
+// the queue is opened.
+type fileQueue struct {
+	dir  string
+	size int
+
+	lock sync.Mutex
+	// pending are the names of the files which were not dequeued, oldest first
+	pending []string
+	// stored counts the files in the directory, pending or in progress
+	stored int
+	seq    int64
+	notify chan struct{}
+}
+
+// NewFileQueue opens the queue in dir, creating the directory when it does
+// not exist. The queue holds at most size requests, including the ones found
+// in the directory.
+func NewFileQueue(dir string, size int) (Queue, error) {
+	if len(dir) == 0 {
+		return nil, fmt.Errorf("a directory is required for the %s queue", QueueFile)
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("unable to create the queue directory: %s", err)
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the queue directory: %s", err)
+	}
+
+	q := &fileQueue{
+		dir:     dir,
+		size:    size,
+		pending: []string{},
+		notify:  make(chan struct{}, 1),
+	}
+
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), requestFileExt) {
+			continue
+		}
+		q.pending = append(q.pending, file.Name())
+	}
+	// the names start with the time the request was queued
+	sort.Strings(q.pending)
+	q.stored = len(q.pending)
+
+	if q.stored > 0 {
+		log.Printf("Found %d asynchronous requests in %s\n", q.stored, dir)
+		q.signal()
+	}
+
+	return q, nil
+}
+
+func (q *fileQueue) Enqueue(req *Request) error {
+	q.lock.Lock()
+	if q.stored >= q.size {
+		q.lock.Unlock()
+		return ErrQueueFull
+	}
+	q.stored++
+	q.seq++
+	name := fmt.Sprintf("%020d-%06d%s", time.Now().UnixNano(), q.seq%1000000, requestFileExt)
+	q.lock.Unlock()
+
+	if err := q.write(name, req); err != nil {
+		q.lock.Lock()
+		q.stored--
+		q.lock.Unlock()
+		return err
+	}
+
+	q.lock.Lock()
+	q.pending = append(q.pending, name)
+	q.lock.Unlock()
+
+	q.signal()
+	return nil
+}
+
+func (q *fileQueue) Dequeue(ctx context.Context) (*Request, error) {
+	for {
+		q.lock.Lock()
+		if len(q.pending) == 0 {
+			q.lock.Unlock()
+
+			select {
+			case <-q.notify:
+				continue
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+
+		name := q.pending[0]
+		q.pending = q.pending[1:]
+		more := len(q.pending) > 0
+		q.lock.Unlock()
+
+		// wake up another worker for the remaining requests
+		if more {
+			q.signal()
+		}
+
+		req, err := q.read(name)
+		if err != nil {
+			log.Printf("Dropping unreadable asynchronous request %s: %s\n", name, err)
+			q.remove(name)
+			continue
+		}
+		return req, nil
+	}
+}
+
+func (q *fileQueue) Done(req *Request) error {
+	if len(req.file) == 0 {
+		return fmt.Errorf("request %s was not dequeued from %s", req.CallID, q.dir)
+	}
+	return q.remove(req.file)
+}
+
+// write stores the request in a temporary file first, so that a partially
+// written request is never read
+func (q *fileQueue) write(name string, req *Request) error {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	tmp := filepath.Join(q.dir, name+".tmp")
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("unable to write request: %s", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(q.dir, name)); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("unable to write request: %s", err)
+	}
+	return nil
+}
+
+func (q *fileQueue) read(name string) (*Request, error) {
+	data, err := ioutil.ReadFile(filepath.Join(q.dir, name))
+	if err != nil {
+		return nil, err
+	}
+
+	req := &Request{}
+	if err := json.Unmarshal(data, req); err != nil {
+		return nil, err
+	}
+	req.file = name
+	return req, nil
+}
+
+func (q *fileQueue) remove(name string) error {
+	err := os.Remove(filepath.Join(q.dir, name))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	q.lock.Lock()
+	q.stored--
+	q.lock.Unlock()
+	return nil
+}
+
+func (q *fileQueue) signal() {
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright 2020 OpenFaaS Author(s)
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package async
+
+import (
+	"io/ioutil"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/openfaas/faas-provider/auth"
+	"github.com/openfaas/faas-provider/httputil"
+)
+
+const (
+	// CallIDHeader identifies an asynchronous request, it is generated when
+	// the request does not have one and is returned to the caller
+	CallIDHeader = "X-Call-Id"
+	// CallbackURLHeader is the URL which the result of the function is
+	// POSTed to. faas-netes makes the request, so a caller can have it POST
+	// to any URL that faas-netes can reach, including in-cluster services.
+	CallbackURLHeader = "X-Callback-Url"
+)
+
+// HandleFuncs registers the asynchronous invocation routes on the router,
+// they match the /function/ routes of the provider. The provider only
+// protects its own routes, so the routes are wrapped with basic auth when
+// credentials are given.
+func HandleFuncs(router *mux.Router, nameExpression string, queue Queue, maxBodySize int64, credentials *auth.BasicAuthCredentials) {
+	handler := MakeQueueHandler(queue, maxBodySize)
+	if credentials != nil {
+		handler = auth.DecorateWithBasicAuth(handler, credentials)
+	}
+
+	router.HandleFunc("/async-function/{name:["+nameExpression+"]+}", handler)
+	router.HandleFunc("/async-function/{name:["+nameExpression+"]+}/", handler)
+	router.HandleFunc("/async-function/{name:["+nameExpression+"]+}/{params:.*}", handler)
+}
+
+// MakeQueueHandler creates the handler which queues asynchronous invocations
+// and responds with 202 Accepted and the call id of the request. Bodies of
+// more than maxBodySize bytes are rejected, because each queued request is
+// held in full until it is invoked.
+func MakeQueueHandler(queue Queue, maxBodySize int64) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Body != nil {
+			defer r.Body.Close()
+		}
+
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		vars := mux.Vars(r)
+		functionName := vars["name"]
+		if functionName == "" {
+			httputil.Errorf(w, http.StatusBadRequest, "Provide function name in the request path")
+			return
+		}
+
+		body := []byte{}
+		if r.Body != nil {
+			var err error
+			body, err = ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+			if err != nil {
+				if int64(len(body)) >= maxBodySize {
+					httputil.Errorf(w, http.StatusRequestEntityTooLarge, "Request body is larger than %d bytes", maxBodySize)
+					return
+				}
+				httputil.Errorf(w, http.StatusBadRequest, "Unable to read the request body: %s", err.Error())
+				return
+			}
+		}
+
+		callID := r.Header.Get(CallIDHeader)
+		if len(callID) == 0 {
+			callID = newCallID()
+		}
+
+		header := r.Header.Clone()
+		header.Set(CallIDHeader, callID)
+		header.Del(CallbackURLHeader)
+
+		req := &Request{
+			CallID:      callID,
+			Function:    functionName,
+			Path:        vars["params"],
+			QueryString: r.URL.RawQuery,
+			Header:      header,
+			Body:        body,
+			CallbackURL: r.Header.Get(CallbackURLHeader),
+			QueuedAt:    time.Now(),
+		}
+
+		if err := queue.Enqueue(req); err != nil {
+			log.Printf("Unable to queue request %s for %s: %s\n", callID, functionName, err)
+			httputil.Errorf(w, http.StatusServiceUnavailable, "Unable to queue the request for: %s.", functionName)
+			return
+		}
+
+		w.Header().Set(CallIDHeader, callID)
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright 2020 OpenFaaS Author(s)
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package async
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	// QueueMemory keeps the requests in memory, they are lost on a restart
+	QueueMemory = "memory"
+	// QueueFile writes the requests to a directory, they are invoked again
+	// after a restart when they were not done
+	QueueFile = "file"
+	// QueueNone disables the asynchronous invocations
+	QueueNone = "none"
+)
+
+// ErrQueueFull is returned by Enqueue when the queue holds as many requests
+// as its size
+var ErrQueueFull = errors.New("queue is full")
+
+// Request is an asynchronous invocation of a function
+type Request struct {
+	CallID      string      `json:"callId"`
+	Function    string      `json:"function"`
+	Path        string      `json:"path,omitempty"`
+	QueryString string      `json:"queryString,omitempty"`
+	Header      http.Header `json:"header,omitempty"`
+	Body        []byte      `json:"body,omitempty"`
+	CallbackURL string      `json:"callbackUrl,omitempty"`
+	QueuedAt    time.Time   `json:"queuedAt"`
+
+	// file is the name of the file of the request in a file queue
+	file string
+}
+
+// Queue holds the asynchronous requests until a worker invokes the function
+type Queue interface {
+	// Enqueue stores the request, ErrQueueFull is returned when there is no
+	// space left for it
+	Enqueue(req *Request) error
+
+	// Dequeue blocks until a request is available or the context is done
+	Dequeue(ctx context.Context) (*Request, error)
+
+	// Done removes a request returned by Dequeue once it was processed
+	Done(req *Request) error
+}
+
+// NewQueue creates the Queue of the kind, with space for size requests. The
+// dir is only used by the file queue.
+func NewQueue(kind, dir string, size int) (Queue, error) {
+	switch kind {
+	case QueueMemory:
+		return NewMemoryQueue(size), nil
+	case QueueFile:
+		return NewFileQueue(dir, size)
+	default:
+		return nil, fmt.Errorf("unknown queue %q, must be one of %s or %s", kind, QueueMemory, QueueFile)
+	}
+}
+
+// memoryQueue is a Queue backed by a buffered channel
+type memoryQueue struct {
+	requests chan *Request
+}
+
+// NewMemoryQueue creates a Queue which keeps at most size requests in memory
+func NewMemoryQueue(size int) Queue {
+	return &memoryQueue{requests: make(chan *Request, size)}
+}
+
+func (q *memoryQueue) Enqueue(req *Request) error {
+	select {
+	case q.requests <- req:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+func (q *memoryQueue) Dequeue(ctx context.Context) (*Request, error) {
+	select {
+	case req := <-q.requests:
+		return req, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (q *memoryQueue) Done(req *Request) error {
+	return nil
+}
+
+// newCallID returns a random id for a request which was not given one
+func newCallID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,111 @@
+// Copyright 2020 OpenFaaS Author(s)
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package async
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func Test_MemoryQueue_Full(t *testing.T) {
+	queue := NewMemoryQueue(1)
+
+	if err := queue.Enqueue(&Request{CallID: "1"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := queue.Enqueue(&Request{CallID: "2"}); err != ErrQueueFull {
+		t.Errorf("want %s, got %v", ErrQueueFull, err)
+	}
+}
+
+func Test_FileQueue_KeepsRequestsUntilDone(t *testing.T) {
+	dir, err := ioutil.TempDir("", "async")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	queue, err := NewFileQueue(dir, 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	for _, id := range []string{"first", "second"} {
+		if err := queue.Enqueue(&Request{CallID: id, Function: "nodeinfo", Body: []byte(id)}); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	if err := queue.Enqueue(&Request{CallID: "third"}); err != ErrQueueFull {
+		t.Errorf("want %s, got %v", ErrQueueFull, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	first, err := queue.Dequeue(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if first.CallID != "first" || string(first.Body) != "first" {
+		t.Errorf("want the oldest request first, got %s", first.CallID)
+	}
+	if err := queue.Done(first); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// the second request was not done, so it is found when the queue is opened again
+	second, err := queue.Dequeue(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	reopened, err := NewFileQueue(dir, 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	got, err := reopened.Dequeue(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got.CallID != second.CallID || got.Function != "nodeinfo" {
+		t.Errorf("want the request which was not done, got %s", got.CallID)
+	}
+}
+
+func Test_FileQueue_DequeueWaitsForEnqueue(t *testing.T) {
+	dir, err := ioutil.TempDir("", "async")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	queue, err := NewFileQueue(dir, 10)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := queue.Dequeue(ctx); err != context.DeadlineExceeded {
+		t.Errorf("want the context error for an empty queue, got %v", err)
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		queue.Enqueue(&Request{CallID: "late"})
+	}()
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	req, err := queue.Dequeue(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if req.CallID != "late" {
+		t.Errorf("want the request queued while waiting, got %s", req.CallID)
+	}
+}
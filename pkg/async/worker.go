@@ -0,0 +1,171 @@
+// Copyright 2020 OpenFaaS Author(s)
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package async
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/openfaas/faas-netes/pkg/proxy"
+	fproxy "github.com/openfaas/faas-provider/proxy"
+	"github.com/openfaas/faas-provider/types"
+)
+
+// Workers invoke the functions for the requests in a Queue and POST the
+// results to the callback URL of the requests
+type Workers struct {
+	queue    Queue
+	resolver proxy.BaseURLResolver
+	client   *http.Client
+	timeout  time.Duration
+}
+
+// NewWorkers creates the Workers for the queue, a function is given at most
+// timeout to respond
+func NewWorkers(queue Queue, resolver proxy.BaseURLResolver, config types.FaaSConfig, timeout time.Duration) *Workers {
+	return &Workers{
+		queue:    queue,
+		resolver: resolver,
+		client:   fproxy.NewProxyClient(timeout, config.GetMaxIdleConns(), config.GetMaxIdleConnsPerHost()),
+		timeout:  timeout,
+	}
+}
+
+// Start runs n workers until stopCh is closed, the requests which are in
+// progress are cancelled
+func (w *Workers) Start(n int, stopCh <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-stopCh
+		cancel()
+	}()
+
+	for i := 0; i < n; i++ {
+		go w.run(ctx)
+	}
+}
+
+func (w *Workers) run(ctx context.Context) {
+	for {
+		req, err := w.queue.Dequeue(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("Unable to dequeue asynchronous request: %s\n", err)
+			continue
+		}
+
+		w.process(ctx, req)
+
+		if ctx.Err() != nil {
+			// the request is kept by a persistent queue and invoked again
+			return
+		}
+		if err := w.queue.Done(req); err != nil {
+			log.Printf("Unable to remove asynchronous request %s: %s\n", req.CallID, err)
+		}
+	}
+}
+
+// process invokes the function and sends the result to the callback URL
+func (w *Workers) process(ctx context.Context, req *Request) {
+	start := time.Now()
+
+	status, header, body := w.invoke(ctx, req)
+	duration := time.Since(start)
+
+	log.Printf("Invoked %s asynchronously, call id: %s, status: %d, took %f seconds\n",
+		req.Function, req.CallID, status, duration.Seconds())
+
+	if len(req.CallbackURL) == 0 {
+		return
+	}
+
+	if err := w.callback(ctx, req, status, header, body, duration); err != nil {
+		log.Printf("Callback to %s for %s failed, call id: %s: %s\n", req.CallbackURL, req.Function, req.CallID, err)
+	}
+}
+
+// invoke calls the function through the resolver, errors are returned as the
+// status and body that the proxy would have responded with
+func (w *Workers) invoke(ctx context.Context, req *Request) (int, http.Header, []byte) {
+	ctx, cancel := context.WithTimeout(ctx, w.timeout)
+	defer cancel()
+
+	upstreamReq, err := http.NewRequestWithContext(ctx, http.MethodPost, "", bytes.NewReader(req.Body))
+	if err != nil {
+		return http.StatusInternalServerError, nil, []byte(err.Error())
+	}
+	if req.Header != nil {
+		upstreamReq.Header = req.Header.Clone()
+	}
+
+	functionAddr, done, err := w.resolver.ResolveRequest(req.Function, upstreamReq)
+	if err != nil {
+		log.Printf("resolver error: no endpoints for %s: %s\n", req.Function, err.Error())
+		return http.StatusServiceUnavailable, nil, []byte(fmt.Sprintf("No endpoints available for: %s.", req.Function))
+	}
+	defer done()
+
+	upstreamReq.URL = &url.URL{
+		Scheme:   functionAddr.Scheme,
+		Host:     functionAddr.Host,
+		Path:     "/" + req.Path,
+		RawQuery: req.QueryString,
+	}
+	upstreamReq.Host = functionAddr.Host
+
+	response, err := w.client.Do(upstreamReq)
+	if err != nil {
+		log.Printf("error with async request to: %s, %s\n", upstreamReq.URL.String(), err.Error())
+		return http.StatusInternalServerError, nil, []byte(fmt.Sprintf("Can't reach service for: %s.", req.Function))
+	}
+	defer response.Body.Close()
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return http.StatusInternalServerError, nil, []byte(fmt.Sprintf("Unable to read the response of: %s.", req.Function))
+	}
+
+	return response.StatusCode, response.Header, body
+}
+
+// callback POSTs the result of the function with its headers, the status of
+// the function is set in the X-Function-Status header
+func (w *Workers) callback(ctx context.Context, req *Request, status int, header http.Header, body []byte, duration time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, w.timeout)
+	defer cancel()
+
+	callbackReq, err := http.NewRequestWithContext(ctx, http.MethodPost, req.CallbackURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	for k, v := range header {
+		callbackReq.Header[k] = v
+	}
+	callbackReq.Header.Set(CallIDHeader, req.CallID)
+	callbackReq.Header.Set("X-Function-Name", req.Function)
+	callbackReq.Header.Set("X-Function-Status", strconv.Itoa(status))
+	callbackReq.Header.Set("X-Duration-Seconds", fmt.Sprintf("%f", duration.Seconds()))
+
+	response, err := w.client.Do(callbackReq)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("unexpected status %d", response.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+// Copyright 2020 OpenFaaS Author(s)
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package async
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/openfaas/faas-provider/auth"
+	"github.com/openfaas/faas-provider/types"
+)
+
+type testResolver struct {
+	url url.URL
+	err error
+}
+
+func (r *testResolver) ResolveRequest(functionName string, req *http.Request) (url.URL, func(), error) {
+	if r.err != nil {
+		return url.URL{}, nil, r.err
+	}
+	return r.url, func() {}, nil
+}
+
+type callback struct {
+	header http.Header
+	body   string
+}
+
+func newCallbackServer(t *testing.T) (*httptest.Server, chan callback) {
+	callbacks := make(chan callback, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		callbacks <- callback{header: r.Header, body: string(body)}
+	}))
+	return server, callbacks
+}
+
+func invokeAsync(t *testing.T, queue Queue, path string, header http.Header) *httptest.ResponseRecorder {
+	t.Helper()
+
+	router := mux.NewRouter()
+	HandleFuncs(router, "-a-zA-Z_0-9.", queue, 1024, nil)
+
+	req := httptest.NewRequest(http.MethodPost, path, nil)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+func Test_Workers_InvokeAndCallback(t *testing.T) {
+	function := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Result", "done")
+		fmt.Fprintf(w, "path=%s query=%s call=%s", r.URL.Path, r.URL.RawQuery, r.Header.Get(CallIDHeader))
+	}))
+	defer function.Close()
+
+	callbackServer, callbacks := newCallbackServer(t)
+	defer callbackServer.Close()
+
+	queue := NewMemoryQueue(10)
+	rr := invokeAsync(t, queue, "/async-function/nodeinfo/info?verbose=1", http.Header{
+		CallbackURLHeader: []string{callbackServer.URL},
+		CallIDHeader:      []string{"abc"},
+	})
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("want status %d, got %d: %s", http.StatusAccepted, rr.Code, rr.Body.String())
+	}
+	if got := rr.Header().Get(CallIDHeader); got != "abc" {
+		t.Errorf("want the call id of the request, got %q", got)
+	}
+
+	u, _ := url.Parse(function.URL)
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	NewWorkers(queue, &testResolver{url: *u}, types.FaaSConfig{}, time.Second).Start(1, stopCh)
+
+	select {
+	case got := <-callbacks:
+		if got.body != "path=/info query=verbose=1 call=abc" {
+			t.Errorf("want the response of the function, got %q", got.body)
+		}
+		if status := got.header.Get("X-Function-Status"); status != "200" {
+			t.Errorf("want X-Function-Status 200, got %q", status)
+		}
+		if got.header.Get("X-Result") != "done" || got.header.Get(CallIDHeader) != "abc" {
+			t.Errorf("want the function headers and call id, got %v", got.header)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("want a callback with the result")
+	}
+}
+
+func Test_Workers_CallbackWithResolveError(t *testing.T) {
+	callbackServer, callbacks := newCallbackServer(t)
+	defer callbackServer.Close()
+
+	queue := NewMemoryQueue(10)
+	rr := invokeAsync(t, queue, "/async-function/nodeinfo", http.Header{CallbackURLHeader: []string{callbackServer.URL}})
+	if len(rr.Header().Get(CallIDHeader)) == 0 {
+		t.Errorf("want a call id to be generated")
+	}
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	NewWorkers(queue, &testResolver{err: fmt.Errorf("no subsets available")}, types.FaaSConfig{}, time.Second).Start(1, stopCh)
+
+	select {
+	case got := <-callbacks:
+		if status := got.header.Get("X-Function-Status"); status != "503" {
+			t.Errorf("want X-Function-Status 503, got %q", status)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("want a callback with the error")
+	}
+}
+
+func Test_QueueHandler_Rejects(t *testing.T) {
+	queue := NewMemoryQueue(0)
+
+	rr := invokeAsync(t, queue, "/async-function/nodeinfo", nil)
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("want status %d for a full queue, got %d", http.StatusServiceUnavailable, rr.Code)
+	}
+}
+
+func Test_QueueHandler_RejectsLargeBody(t *testing.T) {
+	queue := NewMemoryQueue(10)
+
+	router := mux.NewRouter()
+	HandleFuncs(router, "-a-zA-Z_0-9.", queue, 4, nil)
+
+	req := httptest.NewRequest(http.MethodPost, "/async-function/nodeinfo", strings.NewReader("too large"))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("want status %d for a large body, got %d", http.StatusRequestEntityTooLarge, rr.Code)
+	}
+}
+
+func Test_QueueHandler_BasicAuth(t *testing.T) {
+	queue := NewMemoryQueue(10)
+	credentials := &auth.BasicAuthCredentials{User: "admin", Password: "secret"}
+
+	router := mux.NewRouter()
+	HandleFuncs(router, "-a-zA-Z_0-9.", queue, 1024, credentials)
+
+	req := httptest.NewRequest(http.MethodPost, "/async-function/nodeinfo", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("want status %d without credentials, got %d", http.StatusUnauthorized, rr.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/async-function/nodeinfo", nil)
+	req.SetBasicAuth(credentials.User, credentials.Password)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusAccepted {
+		t.Errorf("want status %d with credentials, got %d", http.StatusAccepted, rr.Code)
+	}
+}
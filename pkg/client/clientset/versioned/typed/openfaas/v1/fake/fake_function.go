@@ -92,6 +92,18 @@ func (c *FakeFunctions) Update(ctx context.Context, function *openfaasv1.Functio
 	return obj.(*openfaasv1.Function), err
 }
 
+// UpdateStatus was generated because the type contains a Status member.
+// Add a +genclient:noStatus comment above the type to avoid generating UpdateStatus().
+func (c *FakeFunctions) UpdateStatus(ctx context.Context, function *openfaasv1.Function, opts v1.UpdateOptions) (*openfaasv1.Function, error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewUpdateSubresourceAction(functionsResource, "status", c.ns, function), &openfaasv1.Function{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*openfaasv1.Function), err
+}
+
 // Delete takes name of the function and deletes it. Returns an error if one occurs.
 func (c *FakeFunctions) Delete(ctx context.Context, name string, opts v1.DeleteOptions) error {
 	_, err := c.Fake.
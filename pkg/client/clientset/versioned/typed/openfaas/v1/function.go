@@ -30,6 +30,7 @@ type FunctionsGetter interface {
 type FunctionInterface interface {
 	Create(ctx context.Context, function *v1.Function, opts metav1.CreateOptions) (*v1.Function, error)
 	Update(ctx context.Context, function *v1.Function, opts metav1.UpdateOptions) (*v1.Function, error)
+	UpdateStatus(ctx context.Context, function *v1.Function, opts metav1.UpdateOptions) (*v1.Function, error)
 	Delete(ctx context.Context, name string, opts metav1.DeleteOptions) error
 	DeleteCollection(ctx context.Context, opts metav1.DeleteOptions, listOpts metav1.ListOptions) error
 	Get(ctx context.Context, name string, opts metav1.GetOptions) (*v1.Function, error)
@@ -125,6 +126,22 @@ func (c *functions) Update(ctx context.Context, function *v1.Function, opts meta
 	return
 }
 
+// UpdateStatus was generated because the type contains a Status member.
+// Add a +genclient:noStatus comment above the type to avoid generating UpdateStatus().
+func (c *functions) UpdateStatus(ctx context.Context, function *v1.Function, opts metav1.UpdateOptions) (result *v1.Function, err error) {
+	result = &v1.Function{}
+	err = c.client.Put().
+		Namespace(c.ns).
+		Resource("functions").
+		Name(function.Name).
+		SubResource("status").
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Body(function).
+		Do(ctx).
+		Into(result)
+	return
+}
+
 // Delete takes name of the function and deletes it. Returns an error if one occurs.
 func (c *functions) Delete(ctx context.Context, name string, opts metav1.DeleteOptions) error {
 	return c.client.Delete().
@@ -6,6 +6,8 @@ package config
 import (
 	"fmt"
 	"log"
+	"strconv"
+	"time"
 
 	ftypes "github.com/openfaas/faas-provider/types"
 )
@@ -16,6 +18,22 @@ var validPullPolicyOptions = map[string]bool{
 	"Never":        true,
 }
 
+// defaultAsyncMaxBodySize is the largest body of an asynchronous request
+// which is queued when async_max_body_size is not set, 1MiB
+const defaultAsyncMaxBodySize = 1024 * 1024
+
+var validAsyncQueueOptions = map[string]bool{
+	"memory": true,
+	"file":   true,
+	"none":   true,
+}
+
+var validTracingExporterOptions = map[string]bool{
+	"none":   true,
+	"otlp":   true,
+	"stdout": true,
+}
+
 // ReadConfig constitutes config from env variables
 type ReadConfig struct {
 }
@@ -64,6 +82,74 @@ func (ReadConfig) Read(hasEnv ftypes.HasEnv) (BootstrapConfig, error) {
 
 	cfg.ImagePullPolicy = imagePullPolicy
 
+	// by default a cold start can take half of the write timeout, so that
+	// there is still time to invoke the function and write its response
+	cfg.ColdStartTimeout = ftypes.ParseIntOrDurationValue(hasEnv.Getenv("cold_start_timeout"), cfg.FaaSConfig.WriteTimeout/2)
+
+	// the /async-function/ endpoint is opt-in, so that an upgrade does not
+	// expose a new endpoint
+	cfg.AsyncQueue = ftypes.ParseString(hasEnv.Getenv("async_queue"), "none")
+	if !validAsyncQueueOptions[cfg.AsyncQueue] {
+		return cfg, fmt.Errorf("invalid async_queue configured: %s", cfg.AsyncQueue)
+	}
+	cfg.AsyncQueueDir = hasEnv.Getenv("async_queue_dir")
+	if cfg.AsyncQueue == "file" && len(cfg.AsyncQueueDir) == 0 {
+		return cfg, fmt.Errorf("async_queue_dir is required when async_queue is file")
+	}
+	cfg.AsyncQueueSize = ftypes.ParseIntValue(hasEnv.Getenv("async_queue_size"), 1000)
+	cfg.AsyncWorkers = ftypes.ParseIntValue(hasEnv.Getenv("async_workers"), 1)
+	cfg.AsyncMaxBodySize = int64(ftypes.ParseIntValue(hasEnv.Getenv("async_max_body_size"), defaultAsyncMaxBodySize))
+	if cfg.AsyncMaxBodySize <= 0 {
+		return cfg, fmt.Errorf("invalid async_max_body_size configured: %d, must be a positive number of bytes", cfg.AsyncMaxBodySize)
+	}
+	cfg.AsyncTimeout = ftypes.ParseIntOrDurationValue(hasEnv.Getenv("async_timeout"), cfg.FaaSConfig.WriteTimeout)
+
+	cfg.TracingExporter = ftypes.ParseString(hasEnv.Getenv("tracing_exporter"), "none")
+	if !validTracingExporterOptions[cfg.TracingExporter] {
+		return cfg, fmt.Errorf("invalid tracing_exporter configured: %s", cfg.TracingExporter)
+	}
+	cfg.TracingFile = hasEnv.Getenv("tracing_file")
+	cfg.TracingSampleRatio = 1
+	if value := hasEnv.Getenv("tracing_sample_ratio"); len(value) > 0 {
+		ratio, err := strconv.ParseFloat(value, 64)
+		if err != nil || ratio < 0 || ratio > 1 {
+			return cfg, fmt.Errorf("invalid tracing_sample_ratio configured: %s, must be between 0 and 1", value)
+		}
+		cfg.TracingSampleRatio = ratio
+	}
+
+	cfg.Autoscaler = ftypes.ParseBoolValue(hasEnv.Getenv("autoscaler"), false)
+	cfg.AutoscalerInterval = ftypes.ParseIntOrDurationValue(hasEnv.Getenv("autoscaler_interval"), 15*time.Second)
+	if cfg.AutoscalerInterval <= 0 {
+		return cfg, fmt.Errorf("invalid autoscaler_interval configured: %s, must be positive", cfg.AutoscalerInterval)
+	}
+	cfg.AutoscalerScaleUpWindow = ftypes.ParseIntOrDurationValue(hasEnv.Getenv("autoscaler_scale_up_window"), 0)
+	cfg.AutoscalerScaleDownWindow = ftypes.ParseIntOrDurationValue(hasEnv.Getenv("autoscaler_scale_down_window"), 5*time.Minute)
+
+	cfg.ScaleToZero = ftypes.ParseBoolValue(hasEnv.Getenv("scale_to_zero"), false)
+	cfg.ScaleToZeroInterval = ftypes.ParseIntOrDurationValue(hasEnv.Getenv("scale_to_zero_interval"), 30*time.Second)
+	if cfg.ScaleToZeroInterval <= 0 {
+		return cfg, fmt.Errorf("invalid scale_to_zero_interval configured: %s, must be positive", cfg.ScaleToZeroInterval)
+	}
+	cfg.ScaleToZeroDuration = ftypes.ParseIntOrDurationValue(hasEnv.Getenv("scale_to_zero_duration"), 15*time.Minute)
+	if cfg.ScaleToZeroDuration <= 0 {
+		return cfg, fmt.Errorf("invalid scale_to_zero_duration configured: %s, must be positive", cfg.ScaleToZeroDuration)
+	}
+
+	cfg.ScalingSchedule = ftypes.ParseBoolValue(hasEnv.Getenv("scaling_schedule"), false)
+	cfg.ScalingScheduleInterval = ftypes.ParseIntOrDurationValue(hasEnv.Getenv("scaling_schedule_interval"), time.Minute)
+	if cfg.ScalingScheduleInterval <= 0 {
+		return cfg, fmt.Errorf("invalid scaling_schedule_interval configured: %s, must be positive", cfg.ScalingScheduleInterval)
+	}
+	cfg.ScalingScheduleTimezone = time.UTC
+	if name := hasEnv.Getenv("scaling_schedule_timezone"); len(name) > 0 {
+		location, err := time.LoadLocation(name)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid scaling_schedule_timezone configured: %s", err)
+		}
+		cfg.ScalingScheduleTimezone = location
+	}
+
 	return cfg, nil
 }
 
@@ -120,6 +206,126 @@ type BootstrapConfig struct {
 
 	// ClusterRole determines whether the operator should have cluster wide access
 	ClusterRole bool
+
+	// ColdStartTimeout is how long the proxy holds a request to a function
+	// with zero replicas while it is scaled up. Value is set via the
+	// cold_start_timeout environment variable, zero disables the scale up.
+	ColdStartTimeout time.Duration
+
+	// AsyncQueue selects the queue of the /async-function/ endpoint, one of
+	// memory, file or none to disable it. Value is set via the async_queue
+	// environment variable and defaults to none.
+	AsyncQueue string
+
+	// AsyncQueueDir is the directory of the file queue, set via the
+	// async_queue_dir environment variable.
+	AsyncQueueDir string
+
+	// AsyncQueueSize is the number of requests the queue holds before new
+	// requests are rejected, set via the async_queue_size environment variable.
+	AsyncQueueSize int
+
+	// AsyncWorkers is the number of queued requests invoked in parallel, set
+	// via the async_workers environment variable.
+	AsyncWorkers int
+
+	// AsyncMaxBodySize is the largest request body in bytes which is queued,
+	// set via the async_max_body_size environment variable.
+	AsyncMaxBodySize int64
+
+	// AsyncTimeout is how long a function is given to respond to a queued
+	// request, set via the async_timeout environment variable. It defaults to
+	// the write timeout.
+	AsyncTimeout time.Duration
+
+	// TracingExporter selects where the OpenTelemetry spans are sent, one of
+	// none, otlp or stdout. Value is set via the tracing_exporter environment
+	// variable and defaults to none. The otlp exporter reads the standard
+	// OTEL_EXPORTER_OTLP_* environment variables.
+	TracingExporter string
+
+	// TracingFile is written by the stdout exporter instead of stdout, set
+	// via the tracing_file environment variable.
+	TracingFile string
+
+	// TracingSampleRatio is the share of the traces started by faas-netes
+	// which are recorded, set via the tracing_sample_ratio environment
+	// variable. It defaults to 1.
+	TracingSampleRatio float64
+
+	// Autoscaler enables the scaling of functions with the requests measured
+	// by the proxy, instead of the alerts of the gateway. Value is set via the
+	// autoscaler environment variable and defaults to false.
+	Autoscaler bool
+
+	// AutoscalerInterval is how often the functions are scaled, set via the
+	// autoscaler_interval environment variable. It defaults to 15s.
+	AutoscalerInterval time.Duration
+
+	// AutoscalerScaleUpWindow is how long the traffic must stay high before
+	// replicas are added, set via the autoscaler_scale_up_window environment
+	// variable. It defaults to 0, so that functions scale up right away.
+	AutoscalerScaleUpWindow time.Duration
+
+	// AutoscalerScaleDownWindow is how long the traffic must stay low before
+	// replicas are removed, set via the autoscaler_scale_down_window
+	// environment variable. It defaults to 5m.
+	AutoscalerScaleDownWindow time.Duration
+
+	// ScaleToZero enables the scaling to zero of the functions labelled with
+	// com.openfaas.scale.zero=true once they are idle, instead of the
+	// faas-idler. Value is set via the scale_to_zero environment variable and
+	// defaults to false.
+	ScaleToZero bool
+
+	// ScaleToZeroInterval is how often the idle functions are looked for, set
+	// via the scale_to_zero_interval environment variable. It defaults to 30s.
+	ScaleToZeroInterval time.Duration
+
+	// ScaleToZeroDuration is how long a function must go without invocations
+	// before it is scaled to zero, unless it has a
+	// com.openfaas.scale.zero-duration annotation. Value is set via the
+	// scale_to_zero_duration environment variable and defaults to 15m.
+	ScaleToZeroDuration time.Duration
+
+	// ScalingSchedule enables the scaling windows declared by functions with
+	// the com.openfaas.scale.schedule annotation. Value is set via the
+	// scaling_schedule environment variable and defaults to false.
+	ScalingSchedule bool
+
+	// ScalingScheduleInterval is how often the windows are checked, set via
+	// the scaling_schedule_interval environment variable. It defaults to 1m.
+	ScalingScheduleInterval time.Duration
+
+	// ScalingScheduleTimezone is the time zone of the windows of the
+	// functions without a com.openfaas.scale.schedule.timezone annotation.
+	// Value is set via the scaling_schedule_timezone environment variable
+	// and defaults to UTC.
+	ScalingScheduleTimezone *time.Location
+}
+
+// ValidateLeaderElection returns an error for the features which can not run
+// with leader election. Leader election is used to run several replicas,
+// which each proxy a share of the invocations, but these features only see
+// the invocations proxied by their own replica.
+func (c BootstrapConfig) ValidateLeaderElection(leaderElect bool) error {
+	if !leaderElect {
+		return nil
+	}
+
+	// the leader would only measure its own share of the load and under-scale
+	// the functions by the number of replicas
+	if c.Autoscaler {
+		return fmt.Errorf("autoscaler can not be used with -leader-elect, it needs a single replica")
+	}
+
+	// the leader would scale a function to zero while it is still invoked
+	// through the other replicas
+	if c.ScaleToZero {
+		return fmt.Errorf("scale_to_zero can not be used with -leader-elect, it needs a single replica")
+	}
+
+	return nil
 }
 
 // Fprint pretty-prints the config with the stdlib logger. One line per config value.
@@ -144,5 +350,25 @@ func (c BootstrapConfig) Fprint(verbose bool) {
 		log.Printf("LivenessProbeTimeoutSeconds: %d\n", c.LivenessProbeTimeoutSeconds)
 		log.Printf("LivenessProbePeriodSeconds: %d\n", c.LivenessProbePeriodSeconds)
 		log.Printf("ClusterRole: %v\n", c.ClusterRole)
+		log.Printf("ColdStartTimeout: %s\n", c.ColdStartTimeout)
+		log.Printf("AsyncQueue: %s\n", c.AsyncQueue)
+		log.Printf("AsyncQueueDir: %s\n", c.AsyncQueueDir)
+		log.Printf("AsyncQueueSize: %d\n", c.AsyncQueueSize)
+		log.Printf("AsyncWorkers: %d\n", c.AsyncWorkers)
+		log.Printf("AsyncMaxBodySize: %d\n", c.AsyncMaxBodySize)
+		log.Printf("AsyncTimeout: %s\n", c.AsyncTimeout)
+		log.Printf("TracingExporter: %s\n", c.TracingExporter)
+		log.Printf("TracingFile: %s\n", c.TracingFile)
+		log.Printf("TracingSampleRatio: %v\n", c.TracingSampleRatio)
+		log.Printf("Autoscaler: %v\n", c.Autoscaler)
+		log.Printf("AutoscalerInterval: %s\n", c.AutoscalerInterval)
+		log.Printf("AutoscalerScaleUpWindow: %s\n", c.AutoscalerScaleUpWindow)
+		log.Printf("AutoscalerScaleDownWindow: %s\n", c.AutoscalerScaleDownWindow)
+		log.Printf("ScaleToZero: %v\n", c.ScaleToZero)
+		log.Printf("ScaleToZeroInterval: %s\n", c.ScaleToZeroInterval)
+		log.Printf("ScaleToZeroDuration: %s\n", c.ScaleToZeroDuration)
+		log.Printf("ScalingSchedule: %v\n", c.ScalingSchedule)
+		log.Printf("ScalingScheduleInterval: %s\n", c.ScalingScheduleInterval)
+		log.Printf("ScalingScheduleTimezone: %s\n", c.ScalingScheduleTimezone)
 	}
 }
@@ -6,6 +6,7 @@ package config
 
 import (
 	"testing"
+	"time"
 )
 
 type EnvBucket struct {
@@ -102,3 +103,338 @@ func TestRead_ImagePullPolicy_empty(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestRead_ColdStartTimeout(t *testing.T) {
+	cases := []struct {
+		name string
+		env  map[string]string
+		want time.Duration
+	}{
+		{"defaults to half of the write timeout", map[string]string{"write_timeout": "60s"}, 30 * time.Second},
+		{"can be set", map[string]string{"cold_start_timeout": "45s"}, 45 * time.Second},
+		{"zero disables the scale up", map[string]string{"cold_start_timeout": "0"}, 0},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			env := NewEnvBucket()
+			for k, v := range tc.env {
+				env.Setenv(k, v)
+			}
+
+			config, err := ReadConfig{}.Read(env)
+			if err != nil {
+				t.Fatalf("Unexpected error while reading env %s", err.Error())
+			}
+			if config.ColdStartTimeout != tc.want {
+				t.Errorf("ColdStartTimeout want: %s, got: %s", tc.want, config.ColdStartTimeout)
+			}
+		})
+	}
+}
+
+func TestRead_AsyncQueue(t *testing.T) {
+	cases := []struct {
+		name    string
+		env     map[string]string
+		want    string
+		wantErr bool
+	}{
+		{"defaults to none", map[string]string{}, "none", false},
+		{"memory", map[string]string{"async_queue": "memory"}, "memory", false},
+		{"file with a directory", map[string]string{"async_queue": "file", "async_queue_dir": "/data/async"}, "file", false},
+		{"file without a directory", map[string]string{"async_queue": "file"}, "", true},
+		{"unknown queue", map[string]string{"async_queue": "nats"}, "", true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			env := NewEnvBucket()
+			for k, v := range tc.env {
+				env.Setenv(k, v)
+			}
+
+			config, err := ReadConfig{}.Read(env)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("want an error for env %v", tc.env)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error while reading env %s", err.Error())
+			}
+			if config.AsyncQueue != tc.want {
+				t.Errorf("AsyncQueue want: %s, got: %s", tc.want, config.AsyncQueue)
+			}
+		})
+	}
+}
+
+func TestRead_AsyncMaxBodySize(t *testing.T) {
+	cases := []struct {
+		name    string
+		env     map[string]string
+		want    int64
+		wantErr bool
+	}{
+		{"defaults to 1MiB", map[string]string{}, 1024 * 1024, false},
+		{"set in bytes", map[string]string{"async_max_body_size": "4096"}, 4096, false},
+		{"zero", map[string]string{"async_max_body_size": "0"}, 0, true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			env := NewEnvBucket()
+			for k, v := range tc.env {
+				env.Setenv(k, v)
+			}
+
+			config, err := ReadConfig{}.Read(env)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("want an error for env %v", tc.env)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error while reading env %s", err.Error())
+			}
+			if config.AsyncMaxBodySize != tc.want {
+				t.Errorf("AsyncMaxBodySize want: %d, got: %d", tc.want, config.AsyncMaxBodySize)
+			}
+		})
+	}
+}
+
+func TestRead_Tracing(t *testing.T) {
+	cases := []struct {
+		name         string
+		env          map[string]string
+		wantExporter string
+		wantRatio    float64
+		wantErr      bool
+	}{
+		{"defaults to none", map[string]string{}, "none", 1, false},
+		{"stdout with a ratio", map[string]string{"tracing_exporter": "stdout", "tracing_sample_ratio": "0.25"}, "stdout", 0.25, false},
+		{"unknown exporter", map[string]string{"tracing_exporter": "zipkin"}, "", 0, true},
+		{"ratio over 1", map[string]string{"tracing_exporter": "otlp", "tracing_sample_ratio": "2"}, "", 0, true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			env := NewEnvBucket()
+			for k, v := range tc.env {
+				env.Setenv(k, v)
+			}
+
+			config, err := ReadConfig{}.Read(env)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("want an error for env %v", tc.env)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error while reading env %s", err.Error())
+			}
+			if config.TracingExporter != tc.wantExporter {
+				t.Errorf("TracingExporter want: %s, got: %s", tc.wantExporter, config.TracingExporter)
+			}
+			if config.TracingSampleRatio != tc.wantRatio {
+				t.Errorf("TracingSampleRatio want: %v, got: %v", tc.wantRatio, config.TracingSampleRatio)
+			}
+		})
+	}
+}
+
+func TestRead_Autoscaler(t *testing.T) {
+	defaults := NewEnvBucket()
+	config, err := ReadConfig{}.Read(defaults)
+	if err != nil {
+		t.Fatalf("Unexpected error while reading env %s", err.Error())
+	}
+	if config.Autoscaler {
+		t.Errorf("Autoscaler want: false, got: true")
+	}
+	if config.AutoscalerInterval != 15*time.Second {
+		t.Errorf("AutoscalerInterval want: 15s, got: %s", config.AutoscalerInterval)
+	}
+	if config.AutoscalerScaleDownWindow != 5*time.Minute {
+		t.Errorf("AutoscalerScaleDownWindow want: 5m, got: %s", config.AutoscalerScaleDownWindow)
+	}
+
+	env := NewEnvBucket()
+	env.Setenv("autoscaler", "true")
+	env.Setenv("autoscaler_interval", "5s")
+	env.Setenv("autoscaler_scale_up_window", "30")
+	env.Setenv("autoscaler_scale_down_window", "1m")
+
+	config, err = ReadConfig{}.Read(env)
+	if err != nil {
+		t.Fatalf("Unexpected error while reading env %s", err.Error())
+	}
+	if !config.Autoscaler {
+		t.Errorf("Autoscaler want: true, got: false")
+	}
+	if config.AutoscalerInterval != 5*time.Second {
+		t.Errorf("AutoscalerInterval want: 5s, got: %s", config.AutoscalerInterval)
+	}
+	if config.AutoscalerScaleUpWindow != 30*time.Second {
+		t.Errorf("AutoscalerScaleUpWindow want: 30s, got: %s", config.AutoscalerScaleUpWindow)
+	}
+	if config.AutoscalerScaleDownWindow != time.Minute {
+		t.Errorf("AutoscalerScaleDownWindow want: 1m, got: %s", config.AutoscalerScaleDownWindow)
+	}
+
+	zero := NewEnvBucket()
+	zero.Setenv("autoscaler_interval", "0")
+	if _, err := (ReadConfig{}).Read(zero); err == nil {
+		t.Errorf("want an error for an autoscaler_interval of 0")
+	}
+}
+
+func TestRead_ScaleToZero(t *testing.T) {
+	config, err := ReadConfig{}.Read(NewEnvBucket())
+	if err != nil {
+		t.Fatalf("Unexpected error while reading env %s", err.Error())
+	}
+	if config.ScaleToZero {
+		t.Errorf("ScaleToZero want: false, got: true")
+	}
+	if config.ScaleToZeroDuration != 15*time.Minute {
+		t.Errorf("ScaleToZeroDuration want: 15m, got: %s", config.ScaleToZeroDuration)
+	}
+
+	env := NewEnvBucket()
+	env.Setenv("scale_to_zero", "true")
+	env.Setenv("scale_to_zero_interval", "10s")
+	env.Setenv("scale_to_zero_duration", "30m")
+
+	config, err = ReadConfig{}.Read(env)
+	if err != nil {
+		t.Fatalf("Unexpected error while reading env %s", err.Error())
+	}
+	if !config.ScaleToZero {
+		t.Errorf("ScaleToZero want: true, got: false")
+	}
+	if config.ScaleToZeroInterval != 10*time.Second {
+		t.Errorf("ScaleToZeroInterval want: 10s, got: %s", config.ScaleToZeroInterval)
+	}
+	if config.ScaleToZeroDuration != 30*time.Minute {
+		t.Errorf("ScaleToZeroDuration want: 30m, got: %s", config.ScaleToZeroDuration)
+	}
+
+	zero := NewEnvBucket()
+	zero.Setenv("scale_to_zero_duration", "0")
+	if _, err := (ReadConfig{}).Read(zero); err == nil {
+		t.Errorf("want an error for a scale_to_zero_duration of 0")
+	}
+}
+
+func TestRead_ScalingSchedule(t *testing.T) {
+	config, err := ReadConfig{}.Read(NewEnvBucket())
+	if err != nil {
+		t.Fatalf("Unexpected error while reading env %s", err.Error())
+	}
+	if config.ScalingSchedule {
+		t.Errorf("ScalingSchedule want: false, got: true")
+	}
+	if config.ScalingScheduleInterval != time.Minute {
+		t.Errorf("ScalingScheduleInterval want: 1m, got: %s", config.ScalingScheduleInterval)
+	}
+	if config.ScalingScheduleTimezone != time.UTC {
+		t.Errorf("ScalingScheduleTimezone want: UTC, got: %s", config.ScalingScheduleTimezone)
+	}
+
+	env := NewEnvBucket()
+	env.Setenv("scaling_schedule", "true")
+	env.Setenv("scaling_schedule_interval", "30s")
+	env.Setenv("scaling_schedule_timezone", "Europe/London")
+
+	config, err = ReadConfig{}.Read(env)
+	if err != nil {
+		t.Fatalf("Unexpected error while reading env %s", err.Error())
+	}
+	if !config.ScalingSchedule {
+		t.Errorf("ScalingSchedule want: true, got: false")
+	}
+	if config.ScalingScheduleInterval != 30*time.Second {
+		t.Errorf("ScalingScheduleInterval want: 30s, got: %s", config.ScalingScheduleInterval)
+	}
+	if config.ScalingScheduleTimezone.String() != "Europe/London" {
+		t.Errorf("ScalingScheduleTimezone want: Europe/London, got: %s", config.ScalingScheduleTimezone)
+	}
+
+	unknown := NewEnvBucket()
+	unknown.Setenv("scaling_schedule_timezone", "Mars/Olympus")
+	if _, err := (ReadConfig{}).Read(unknown); err == nil {
+		t.Errorf("want an error for an unknown scaling_schedule_timezone")
+	}
+}
+
+func TestBootstrapConfig_ValidateLeaderElection(t *testing.T) {
+	cases := []struct {
+		name        string
+		config      BootstrapConfig
+		leaderElect bool
+		wantErr     bool
+	}{
+		{
+			name:        "single replica with scale to zero",
+			config:      BootstrapConfig{ScaleToZero: true},
+			leaderElect: false,
+		},
+		{
+			name:        "several replicas without scale to zero",
+			config:      BootstrapConfig{ScalingSchedule: true},
+			leaderElect: true,
+		},
+		{
+			name:        "several replicas with scale to zero",
+			config:      BootstrapConfig{ScaleToZero: true},
+			leaderElect: true,
+			wantErr:     true,
+		},
+		{
+			name:        "single replica with the autoscaler",
+			config:      BootstrapConfig{Autoscaler: true},
+			leaderElect: false,
+		},
+		{
+			name:        "several replicas with the autoscaler",
+			config:      BootstrapConfig{Autoscaler: true},
+			leaderElect: true,
+			wantErr:     true,
+		},
+		{
+			name:        "several replicas read from the environment",
+			config:      readAutoscalerConfig(t),
+			leaderElect: true,
+			wantErr:     true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.config.ValidateLeaderElection(tc.leaderElect)
+			if tc.wantErr && err == nil {
+				t.Errorf("want an error, got nil")
+			}
+			if !tc.wantErr && err != nil {
+				t.Errorf("want no error, got: %s", err)
+			}
+		})
+	}
+}
+
+func readAutoscalerConfig(t *testing.T) BootstrapConfig {
+	env := NewEnvBucket()
+	env.Setenv("autoscaler", "true")
+
+	config, err := ReadConfig{}.Read(env)
+	if err != nil {
+		t.Fatalf("Unexpected error while reading env %s", err.Error())
+	}
+	return config
+}
@@ -3,21 +3,22 @@ package controller
 import (
 	"context"
 	"fmt"
-	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/runtime"
-	"k8s.io/apimachinery/pkg/util/wait"
 	kubeinformers "k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
 	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	appslisters "k8s.io/client-go/listers/apps/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/workqueue"
@@ -28,12 +29,15 @@ import (
 	faasscheme "github.com/openfaas/faas-netes/pkg/client/clientset/versioned/scheme"
 	informers "github.com/openfaas/faas-netes/pkg/client/informers/externalversions"
 	listers "github.com/openfaas/faas-netes/pkg/client/listers/openfaas/v1"
+	"github.com/openfaas/faas-netes/pkg/k8s"
+	"github.com/openfaas/faas-netes/pkg/metrics"
+	"github.com/openfaas/faas-netes/pkg/tracing"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
 	controllerAgentName = "openfaas-operator"
 	faasKind            = "Function"
-	functionPort        = 8080
 	LabelMinReplicas    = "com.openfaas.scale.min"
 	// SuccessSynced is used as part of the Event 'reason' when a Function is synced
 	SuccessSynced = "Synced"
@@ -59,7 +63,13 @@ type Controller struct {
 	deploymentsLister appslisters.DeploymentLister
 	deploymentsSynced cache.InformerSynced
 	functionsLister   listers.FunctionLister
+	functionsIndexer  cache.Indexer
 	functionsSynced   cache.InformerSynced
+	podsLister        corelisters.PodLister
+	podsSynced        cache.InformerSynced
+	servicesSynced    cache.InformerSynced
+	secretsSynced     cache.InformerSynced
+	profilesSynced    cache.InformerSynced
 
 	// workqueue is a rate limited work queue. This is used to queue work to be
 	// processed instead of performing it as soon as a change happens. This
@@ -67,6 +77,9 @@ type Controller struct {
 	// time, and makes it easy to ensure we are never processing the same item
 	// simultaneously in two different workers.
 	workqueue workqueue.RateLimitingInterface
+	// statusqueue holds the Functions whose Deployment status changed, their
+	// status is updated without a full reconcile
+	statusqueue workqueue.RateLimitingInterface
 	// recorder is an event recorder for recording Event resources to the
 	// Kubernetes API.
 	recorder record.EventRecorder
@@ -81,11 +94,22 @@ func NewController(
 	faasclientset clientset.Interface,
 	kubeInformerFactory kubeinformers.SharedInformerFactory,
 	faasInformerFactory informers.SharedInformerFactory,
+	profileInformerFactory informers.SharedInformerFactory,
 	factory FunctionFactory) *Controller {
 
-	// obtain references to shared index informers for the Deployment and Function types
+	// obtain references to shared index informers for the Deployment, Pod,
+	// Service, Secret, Function and Profile types
 	deploymentInformer := kubeInformerFactory.Apps().V1().Deployments()
+	podInformer := kubeInformerFactory.Core().V1().Pods()
+	serviceInformer := kubeInformerFactory.Core().V1().Services()
+	secretInformer := kubeInformerFactory.Core().V1().Secrets()
 	faasInformer := faasInformerFactory.Openfaas().V1().Functions()
+	profileInformer := profileInformerFactory.Openfaas().V1().Profiles()
+
+	// the indexes must be added before the informer is started
+	if err := faasInformer.Informer().AddIndexers(functionIndexers()); err != nil {
+		glog.Fatalf("Error adding Function indexers: %s", err.Error())
+	}
 
 	// Create event broadcaster
 	// Add o6s types to the default Kubernetes Scheme so Events can be
@@ -103,8 +127,15 @@ func NewController(
 		deploymentsLister: deploymentInformer.Lister(),
 		deploymentsSynced: deploymentInformer.Informer().HasSynced,
 		functionsLister:   faasInformer.Lister(),
+		functionsIndexer:  faasInformer.Informer().GetIndexer(),
 		functionsSynced:   faasInformer.Informer().HasSynced,
+		podsLister:        podInformer.Lister(),
+		podsSynced:        podInformer.Informer().HasSynced,
+		servicesSynced:    serviceInformer.Informer().HasSynced,
+		secretsSynced:     secretInformer.Informer().HasSynced,
+		profilesSynced:    profileInformer.Informer().HasSynced,
 		workqueue:         workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "Functions"),
+		statusqueue:       workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "FunctionStatus"),
 		recorder:          recorder,
 		factory:           factory,
 	}
@@ -119,8 +150,47 @@ func NewController(
 		UpdateFunc: func(old, new interface{}) {
 			controller.enqueueFunction(new)
 		},
+		DeleteFunc: func(obj interface{}) {
+			if key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj); err == nil {
+				glog.V(2).Infof("Function '%s' deleted", key)
+			}
+		},
 	})
 
+	// Set up an event handler for when Deployment or Service resources change.
+	// This handler will lookup the owner of the given object, and if it is
+	// owned by a Function resource will enqueue that Function for processing,
+	// so that manual changes and deletions are reconciled straight away.
+	// Changes to the status of a Deployment only update the Function status.
+	ownedHandler := cache.ResourceEventHandlerFuncs{
+		AddFunc: controller.handleObject,
+		UpdateFunc: func(old, new interface{}) {
+			newObj := new.(metav1.Object)
+			oldObj := old.(metav1.Object)
+			if newObj.GetResourceVersion() == oldObj.GetResourceVersion() {
+				// Periodic resync will send update events for all known objects.
+				// Two different versions of the same object will always have
+				// different RVs.
+				return
+			}
+			if ownedObjectChanged(old, new) {
+				controller.handleObject(new)
+				return
+			}
+			if _, ok := new.(*appsv1.Deployment); ok {
+				controller.handleStatus(new)
+			}
+		},
+		DeleteFunc: controller.handleObject,
+	}
+	deploymentInformer.Informer().AddEventHandler(ownedHandler)
+	serviceInformer.Informer().AddEventHandler(ownedHandler)
+
+	// Set up event handlers for when Secret or Profile resources change. The
+	// Functions which use them are enqueued and rolled with a new config hash.
+	secretInformer.Informer().AddEventHandler(controller.referenceHandler(secretsIndex, cache.MetaNamespaceKeyFunc))
+	profileInformer.Informer().AddEventHandler(controller.referenceHandler(profilesIndex, profileKeyFunc))
+
 	// Set up an event handler for when functions related resources like pods, deployments, replica sets
 	// can't be materialized. This logs abnormal events like ImagePullBackOff, back-off restarting failed container,
 	// failed to start container, oci runtime errors, etc
@@ -149,24 +219,46 @@ func NewController(
 func (c *Controller) Run(threadiness int, stopCh <-chan struct{}) error {
 	defer runtime.HandleCrash()
 	defer c.workqueue.ShutDown()
+	defer c.statusqueue.ShutDown()
 
 	// Start the informer factories to begin populating the informer caches
 	// Wait for the caches to be synced before starting workers
 	glog.Info("Waiting for informer caches to sync")
-	if ok := cache.WaitForCacheSync(stopCh, c.deploymentsSynced, c.functionsSynced); !ok {
+	if ok := cache.WaitForCacheSync(stopCh, c.deploymentsSynced, c.functionsSynced, c.podsSynced, c.servicesSynced, c.secretsSynced, c.profilesSynced); !ok {
 		return fmt.Errorf("failed to wait for caches to sync")
 	}
 
-	glog.Info("Starting workers")
-	// Launch two workers to process Function resources
+	glog.Infof("Starting %d workers", threadiness)
+	// Launch the workers to process Function resources
+	var workers sync.WaitGroup
 	for i := 0; i < threadiness; i++ {
-		go wait.Until(c.runWorker, time.Second, stopCh)
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			c.runWorker()
+		}()
 	}
 
+	// a single worker updates the status of the Functions, it only reads
+	// from the listers and writes the status
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		for c.processNextStatusItem() {
+		}
+	}()
+
 	glog.Info("Started workers")
 	<-stopCh
 	glog.Info("Shutting down workers")
 
+	// After ShutDown no new items are accepted, the workers finish the items
+	// already in the queue and return once it is empty
+	c.workqueue.ShutDown()
+	c.statusqueue.ShutDown()
+	workers.Wait()
+	glog.Info("Workers stopped")
+
 	return nil
 }
 
@@ -195,8 +287,22 @@ func (c *Controller) processNextWorkItem() bool {
 			runtime.HandleError(fmt.Errorf("expected string in workqueue but got %#v", obj))
 			return nil
 		}
-		if err := c.syncHandler(key); err != nil {
-			return fmt.Errorf("error syncing '%s': %s", key, err.Error())
+		namespace, _, _ := cache.SplitMetaNamespaceKey(key)
+		start := time.Now()
+		err := c.syncHandler(key)
+
+		result := "success"
+		if err != nil {
+			result = "error"
+		}
+		metrics.ReconcileDuration.WithLabelValues(namespace, result).Observe(time.Since(start).Seconds())
+		metrics.ReconcileTotal.WithLabelValues(namespace, result).Inc()
+
+		if err != nil {
+			// Requeue the item with a backoff, the retry is counted in the
+			// workqueue metrics
+			c.workqueue.AddRateLimited(key)
+			return fmt.Errorf("error syncing '%s': %s, requeuing", key, err.Error())
 		}
 		c.workqueue.Forget(obj)
 		return nil
@@ -210,9 +316,35 @@ func (c *Controller) processNextWorkItem() bool {
 	return true
 }
 
+// processNextStatusItem reads a single Function off the statusqueue and
+// updates its status from its Deployment
+func (c *Controller) processNextStatusItem() bool {
+	obj, shutdown := c.statusqueue.Get()
+	if shutdown {
+		return false
+	}
+	defer c.statusqueue.Done(obj)
+
+	key, ok := obj.(string)
+	if !ok {
+		c.statusqueue.Forget(obj)
+		runtime.HandleError(fmt.Errorf("expected string in statusqueue but got %#v", obj))
+		return true
+	}
+
+	if err := c.syncStatusHandler(key); err != nil {
+		c.statusqueue.AddRateLimited(key)
+		runtime.HandleError(fmt.Errorf("error syncing status of '%s': %s, requeuing", key, err.Error()))
+		return true
+	}
+	c.statusqueue.Forget(obj)
+	return true
+}
+
 // syncHandler compares the actual state with the desired, and attempts to
-// converge the two.
-func (c *Controller) syncHandler(key string) error {
+// converge the two. Each reconcile is recorded as a span with the
+// Kubernetes API calls it makes.
+func (c *Controller) syncHandler(key string) (err error) {
 	// Convert the namespace/name string into a distinct namespace and name
 	namespace, name, err := cache.SplitMetaNamespaceKey(key)
 	if err != nil {
@@ -220,6 +352,15 @@ func (c *Controller) syncHandler(key string) error {
 		return nil
 	}
 
+	ctx, span := tracing.Tracer().Start(context.Background(), "reconcile",
+		trace.WithAttributes(tracing.FunctionKey.String(name), tracing.NamespaceKey.String(namespace)))
+	defer func() {
+		if err != nil {
+			tracing.RecordError(span, err)
+		}
+		span.End()
+	}()
+
 	// Get the Function resource with this namespace/name
 	function, err := c.functionsLister.Functions(namespace).Get(name)
 	if err != nil {
@@ -232,6 +373,12 @@ func (c *Controller) syncHandler(key string) error {
 		return err
 	}
 
+	// A Function with a deletion timestamp is waiting for its finalizer,
+	// remove the objects it owns before letting it go
+	if function.DeletionTimestamp != nil {
+		return c.finalizeFunction(key, function)
+	}
+
 	deploymentName := function.Spec.Name
 	if deploymentName == "" {
 		// We choose to absorb the error here as the worker would requeue the
@@ -241,83 +388,143 @@ func (c *Controller) syncHandler(key string) error {
 		return nil
 	}
 
+	function, err = c.ensureFinalizer(function)
+	if err != nil {
+		return fmt.Errorf("adding finalizer to '%s' failed: %v", key, err)
+	}
+
+	deployment, profiles, err := c.syncFunction(ctx, function)
+
+	// Record the outcome of the reconcile in the Function status, a failure
+	// to write the status requeues the Function like any other sync error
+	if statusErr := c.syncStatus(function, deployment, profiles, err); statusErr != nil {
+		glog.Errorf("Updating status for '%s' failed: %v", function.Spec.Name, statusErr)
+		if err == nil {
+			err = statusErr
+		}
+	}
+
+	return err
+}
+
+// syncFunction creates or updates the Deployment and Service for the Function
+// and returns the resulting Deployment, with the outcome of resolving its
+// Profiles when the reconcile got that far.
+func (c *Controller) syncFunction(ctx context.Context, function *faasv1.Function) (*appsv1.Deployment, *profileResult, error) {
+	deploymentName := function.Spec.Name
+	updated := false
+
 	// Get the deployment with the name specified in Function.spec
 	deployment, err := c.deploymentsLister.Deployments(function.Namespace).Get(deploymentName)
-	// If the resource doesn't exist, we'll create it
 	if errors.IsNotFound(err) {
-		err = nil
-		existingSecrets, err := c.getSecrets(function.Namespace, function.Spec.Secrets)
-		if err != nil {
-			return err
+		deployment = nil
+	} else if err != nil {
+		// If an error occurs during Get, we'll requeue the item so we can
+		// attempt processing again later. This could have been caused by a
+		// temporary network failure, or any other transient reason.
+		return nil, nil, fmt.Errorf("transient error: %v", err)
+	}
+
+	// If the Deployment is not controlled by this Function resource, we should log
+	// a warning to the event recorder and ret
+	if deployment != nil && !metav1.IsControlledBy(deployment, function) {
+		msg := fmt.Sprintf(MessageResourceExists, deployment.Name)
+		c.recorder.Event(function, corev1.EventTypeWarning, ErrResourceExists, msg)
+		return nil, nil, fmt.Errorf(msg)
+	}
+
+	existingSecrets, err := c.getSecrets(ctx, function.Namespace, function.Spec.Secrets)
+	if err != nil {
+		return deployment, nil, err
+	}
+
+	// Secrets and Profiles which can not be resolved are reported with Warning
+	// Events, the Function is requeued with a backoff until they are found
+	desired, profiles, resolveErr := newDeployment(function, deployment, existingSecrets, c.factory)
+	if resolveErr != nil {
+		glog.Warningf("Function %s: %v", function.Spec.Name, resolveErr)
+		c.recordConditionErrors(function, resolveErr)
+		if desired == nil {
+			return deployment, profiles, resolveErr
 		}
+	}
 
+	// If the resource doesn't exist, we'll create it
+	if deployment == nil {
+		updated = true
 		glog.Infof("Creating deployment for '%s'", function.Spec.Name)
-		deployment, err = c.kubeclientset.AppsV1().Deployments(function.Namespace).Create(
-			context.TODO(),
-			newDeployment(function, deployment, existingSecrets, c.factory),
-			metav1.CreateOptions{},
-		)
+		deployment, err = k8s.ApplyDeployment(ctx, c.kubeclientset, nil, desired)
 		if err != nil {
-			return err
+			return nil, profiles, err
 		}
 	}
 
 	svcGetOptions := metav1.GetOptions{}
-	_, getSvcErr := c.kubeclientset.CoreV1().Services(function.Namespace).Get(context.TODO(), deploymentName, svcGetOptions)
+	existingService, getSvcErr := c.kubeclientset.CoreV1().Services(function.Namespace).Get(ctx, deploymentName, svcGetOptions)
+	if getSvcErr == nil && metav1.GetControllerOf(existingService) == nil && existingService.Spec.Selector["faas_function"] == deploymentName {
+		// Services created before the operator set owner references, or by
+		// faas-netes in controller mode, are adopted so that they are cleaned up
+		// with the Function
+		glog.Infof("Adopting ClusterIP service for '%s'", function.Spec.Name)
+		if _, err := k8s.ApplyService(ctx, c.kubeclientset, existingService, newService(function, c.factory)); err != nil {
+			glog.Errorf("Adopting service for '%s' failed: %v", function.Spec.Name, err)
+		}
+	}
 	if errors.IsNotFound(getSvcErr) {
+		updated = true
 		glog.Infof("Creating ClusterIP service for '%s'", function.Spec.Name)
-		if _, err := c.kubeclientset.CoreV1().Services(function.Namespace).Create(context.TODO(), newService(function), metav1.CreateOptions{}); err != nil {
-			// If an error occurs during Service Create, we'll requeue the item
-			if errors.IsAlreadyExists(err) {
-				err = nil
-				glog.V(2).Infof("ClusterIP service '%s' already exists. Skipping creation.", function.Spec.Name)
-			} else {
-				return err
-			}
+		if _, err := k8s.ApplyService(ctx, c.kubeclientset, nil, newService(function, c.factory)); err != nil {
+			// If an error occurs during Service apply, we'll requeue the item
+			return deployment, profiles, err
 		}
 	}
 
-	// If an error occurs during Get/Create, we'll requeue the item so we can
-	// attempt processing again later. This could have been caused by a
-	// temporary network failure, or any other transient reason.
-	if err != nil {
-		return fmt.Errorf("transient error: %v", err)
+	// Update the Deployment resource if the Function definition differs, or if
+	// the live Deployment has been changed outside of the operator
+	specChanged := deploymentNeedsUpdate(function, deployment)
+	drift := deploymentDrift(desired, deployment)
+	if !specChanged && len(drift) > 0 {
+		msg := fmt.Sprintf(MessageDriftReverted, "Deployment", deployment.Name, strings.Join(drift, ", "))
+		glog.Infof("Function %s: %s", function.Spec.Name, msg)
+		c.recorder.Event(function, corev1.EventTypeWarning, DriftDetected, msg)
 	}
 
-	// If the Deployment is not controlled by this Function resource, we should log
-	// a warning to the event recorder and ret
-	if !metav1.IsControlledBy(deployment, function) {
-		msg := fmt.Sprintf(MessageResourceExists, deployment.Name)
-		c.recorder.Event(function, corev1.EventTypeWarning, ErrResourceExists, msg)
-		return fmt.Errorf(msg)
+	configChanged := configHashChanged(desired, deployment)
+	if !specChanged && configChanged {
+		msg := fmt.Sprintf(MessageConfigChanged, deployment.Name)
+		glog.Infof("Function %s: %s", function.Spec.Name, msg)
+		c.recorder.Event(function, corev1.EventTypeNormal, ConfigChanged, msg)
 	}
 
-	// Update the Deployment resource if the Function definition differs
-	if deploymentNeedsUpdate(function, deployment) {
+	if specChanged || configChanged || len(drift) > 0 {
 		glog.Infof("Updating deployment for '%s'", function.Spec.Name)
+		updated = true
 
-		existingSecrets, err := c.getSecrets(function.Namespace, function.Spec.Secrets)
-		if err != nil {
-			return err
-		}
-
-		deployment, err = c.kubeclientset.AppsV1().Deployments(function.Namespace).Update(
-			context.TODO(),
-			newDeployment(function, deployment, existingSecrets, c.factory),
-			metav1.UpdateOptions{},
-		)
-
+		updatedDeployment, err := k8s.ApplyDeployment(ctx, c.kubeclientset, deployment, desired)
 		if err != nil {
 			glog.Errorf("Updating deployment for '%s' failed: %v", function.Spec.Name, err)
+			return deployment, profiles, err
 		}
+		deployment = updatedDeployment
+	}
 
-		existingService, err := c.kubeclientset.CoreV1().Services(function.Namespace).Get(context.TODO(), function.Spec.Name, metav1.GetOptions{})
-		if err != nil {
-			return err
-		}
+	existingService, err = c.kubeclientset.CoreV1().Services(function.Namespace).Get(ctx, function.Spec.Name, metav1.GetOptions{})
+	if err != nil {
+		return deployment, profiles, err
+	}
 
-		existingService.Annotations = makeAnnotations(function)
-		_, err = c.kubeclientset.CoreV1().Services(function.Namespace).Update(context.TODO(), existingService, metav1.UpdateOptions{})
+	// Update the Service when the Function definition differs or when its
+	// selector or ports have been changed outside of the operator
+	serviceChanges := serviceDrift(newService(function, c.factory), existingService)
+	if !specChanged && len(serviceChanges) > 0 {
+		msg := fmt.Sprintf(MessageDriftReverted, "Service", existingService.Name, strings.Join(serviceChanges, ", "))
+		glog.Infof("Function %s: %s", function.Spec.Name, msg)
+		c.recorder.Event(function, corev1.EventTypeWarning, DriftDetected, msg)
+	}
+
+	if specChanged || len(serviceChanges) > 0 {
+		updated = true
+		_, err = k8s.ApplyService(ctx, c.kubeclientset, existingService, newService(function, c.factory))
 		if err != nil {
 			glog.Errorf("Updating service for '%s' failed: %v", function.Spec.Name, err)
 		}
@@ -327,11 +534,29 @@ func (c *Controller) syncHandler(key string) error {
 	// attempt processing again later. THis could have been caused by a
 	// temporary network failure, or any other transient reason.
 	if err != nil {
-		return err
+		return deployment, profiles, err
 	}
 
-	c.recorder.Event(function, corev1.EventTypeNormal, SuccessSynced, MessageResourceSynced)
-	return nil
+	// The HorizontalPodAutoscaler follows the scale labels of the Function,
+	// it is removed when they no longer ask for one
+	labels := map[string]string{}
+	if function.Spec.Labels != nil {
+		labels = *function.Spec.Labels
+	}
+	owner := *newFunctionOwnerReference(function)
+	if err := k8s.SyncHorizontalPodAutoscaler(ctx, c.factory.Factory.Dynamic, deploymentName, function.Namespace, labels, owner); err != nil {
+		glog.Errorf("Syncing HorizontalPodAutoscaler for '%s' failed: %v", function.Spec.Name, err)
+		return deployment, profiles, err
+	}
+
+	// Only record an Event when objects were changed, the Function is also
+	// synced each time one of the objects it owns changes
+	if updated {
+		c.recorder.Event(function, corev1.EventTypeNormal, SuccessSynced, MessageResourceSynced)
+	}
+
+	// a degraded rollout is retried until all Secrets and Profiles are found
+	return deployment, profiles, resolveErr
 }
 
 // enqueueFunction takes a Function resource and converts it into a namespace/name
@@ -344,7 +569,7 @@ func (c *Controller) enqueueFunction(obj interface{}) {
 		runtime.HandleError(err)
 		return
 	}
-	c.workqueue.AddRateLimited(key)
+	c.workqueue.Add(key)
 }
 
 // handleObject will take any resource implementing metav1.Object and attempt
@@ -369,88 +594,85 @@ func (c *Controller) handleObject(obj interface{}) {
 		glog.V(4).Infof("Recovered deleted object '%s' from tombstone", object.GetName())
 	}
 	glog.V(4).Infof("Processing object: %s", object.GetName())
-	if ownerRef := metav1.GetControllerOf(object); ownerRef != nil {
-		// If this object is not owned by a function, we should not do anything more
-		// with it.
-		if ownerRef.Kind != faasKind {
-			return
-		}
-
-		function, err := c.functionsLister.Functions(object.GetNamespace()).Get(ownerRef.Name)
-		if err != nil {
-			glog.Infof("Function '%s' deleted. Ignoring orphaned object '%s'", ownerRef.Name, object.GetSelfLink())
-			return
-		}
-
+	if function := c.ownerFunction(object); function != nil {
 		c.enqueueFunction(function)
-		return
 	}
 }
 
-// getSecrets queries Kubernetes for a list of secrets by name in the given k8s namespace.
-func (c *Controller) getSecrets(namespace string, secretNames []string) (map[string]*corev1.Secret, error) {
-	secrets := map[string]*corev1.Secret{}
-
-	for _, secretName := range secretNames {
-		secret, err := c.kubeclientset.CoreV1().Secrets(namespace).Get(context.TODO(), secretName, metav1.GetOptions{})
-		if err != nil {
-			return secrets, err
-		}
-		secrets[secretName] = secret
+// handleStatus enqueues the Function which owns the Deployment for a status
+// update, see syncStatusHandler
+func (c *Controller) handleStatus(obj interface{}) {
+	object, ok := obj.(metav1.Object)
+	if !ok {
+		return
 	}
 
-	return secrets, nil
-}
-
-// getReplicas returns the desired number of replicas for a function taking into account
-// the min replicas label, HPA, the OF autoscaler and scaled to zero deployments
-func getReplicas(function *faasv1.Function, deployment *appsv1.Deployment) *int32 {
-	var minReplicas *int32
-
-	// extract min replicas from label if specified
-	if function != nil && function.Spec.Labels != nil {
-		lb := *function.Spec.Labels
-		if value, exists := lb[LabelMinReplicas]; exists {
-			r, err := strconv.Atoi(value)
-			if err == nil && r > 0 {
-				minReplicas = int32p(int32(r))
-			}
-		}
+	function := c.ownerFunction(object)
+	if function == nil {
+		return
 	}
-
-	// extract current deployment replicas if specified
-	var deploymentReplicas *int32
-	if deployment != nil {
-		deploymentReplicas = deployment.Spec.Replicas
+	if key, err := cache.MetaNamespaceKeyFunc(function); err == nil {
+		c.statusqueue.Add(key)
 	}
+}
 
-	// do not set replicas if min replicas is not set
-	// and current deployment has no replicas count
-	if minReplicas == nil && deploymentReplicas == nil {
+// ownerFunction returns the Function which controls the object, nil when it
+// is not controlled by a Function or the Function no longer exists
+func (c *Controller) ownerFunction(object metav1.Object) *faasv1.Function {
+	ownerRef := metav1.GetControllerOf(object)
+	// If this object is not owned by a function, we should not do anything more
+	// with it.
+	if ownerRef == nil || ownerRef.Kind != faasKind {
 		return nil
 	}
 
-	// set replicas to min if deployment has no replicas and min replicas exists
-	if minReplicas != nil && deploymentReplicas == nil {
-		return minReplicas
+	function, err := c.functionsLister.Functions(object.GetNamespace()).Get(ownerRef.Name)
+	if err != nil {
+		glog.Infof("Function '%s' deleted. Ignoring orphaned object '%s'", ownerRef.Name, object.GetSelfLink())
+		return nil
 	}
+	return function
+}
 
-	// do not override replicas when deployment is scaled to zero
-	if deploymentReplicas != nil && *deploymentReplicas == 0 {
-		return deploymentReplicas
+// ownedObjectChanged returns true when the spec, labels or annotations of an
+// owned Deployment or Service changed. The status of an object and the
+// fields maintained by the API server, such as the managed fields, do not
+// need a reconcile.
+func ownedObjectChanged(old, new interface{}) bool {
+	oldObj, newObj := old.(metav1.Object), new.(metav1.Object)
+	if !equality.Semantic.DeepEqual(oldObj.GetLabels(), newObj.GetLabels()) ||
+		!equality.Semantic.DeepEqual(oldObj.GetAnnotations(), newObj.GetAnnotations()) {
+		return true
 	}
 
-	// do not override replicas when min is not specified
-	if minReplicas == nil && deploymentReplicas != nil {
-		return deploymentReplicas
+	// the API server does not set the generation of Services
+	if newService, ok := new.(*corev1.Service); ok {
+		oldService, ok := old.(*corev1.Service)
+		return !ok || !equality.Semantic.DeepEqual(oldService.Spec, newService.Spec)
 	}
+	return oldObj.GetGeneration() != newObj.GetGeneration()
+}
+
+// getSecrets queries Kubernetes for a list of secrets by name in the given k8s namespace.
+func (c *Controller) getSecrets(ctx context.Context, namespace string, secretNames []string) (map[string]*corev1.Secret, error) {
+	secrets := map[string]*corev1.Secret{}
 
-	// do not override HPA or OF autoscaler replicas if the value is greater than min
-	if minReplicas != nil && deploymentReplicas != nil {
-		if *deploymentReplicas >= *minReplicas {
-			return deploymentReplicas
+	for _, secretName := range secretNames {
+		secret, err := c.kubeclientset.CoreV1().Secrets(namespace).Get(ctx, secretName, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			// missing secrets are handled with the failure policy of the
+			// Function, see UpdateSecrets
+			continue
+		}
+		if err != nil {
+			return secrets, &conditionError{
+				condition: faasv1.FunctionSecretsResolved,
+				reason:    ReasonSecretNotFound,
+				err:       err,
+			}
 		}
+		secrets[secretName] = secret
 	}
 
-	return minReplicas
+	return secrets, nil
 }
@@ -0,0 +1,87 @@
+package controller
+
+import (
+	"testing"
+
+	listers "github.com/openfaas/faas-netes/pkg/client/listers/openfaas/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+func Test_Run_DrainsQueueOnShutdown(t *testing.T) {
+	synced := func() bool { return true }
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+
+	c := &Controller{
+		functionsLister:   listers.NewFunctionLister(indexer),
+		deploymentsSynced: synced,
+		functionsSynced:   synced,
+		podsSynced:        synced,
+		servicesSynced:    synced,
+		secretsSynced:     synced,
+		profilesSynced:    synced,
+		workqueue:         workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "Functions"),
+		statusqueue:       workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "FunctionStatus"),
+	}
+
+	for _, key := range []string{"openfaas-fn/nodeinfo", "openfaas-fn/figlet", "openfaas-fn/env"} {
+		c.workqueue.Add(key)
+	}
+
+	stopCh := make(chan struct{})
+	close(stopCh)
+
+	if err := c.Run(2, stopCh); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if c.workqueue.Len() != 0 {
+		t.Errorf("want the queue to be drained, got %d items", c.workqueue.Len())
+	}
+}
+
+func Test_ownedObjectChanged(t *testing.T) {
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "nodeinfo", Generation: 1, ResourceVersion: "1", Labels: map[string]string{"faas_function": "nodeinfo"}},
+	}
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "nodeinfo", ResourceVersion: "1"},
+		Spec:       corev1.ServiceSpec{Selector: map[string]string{"faas_function": "nodeinfo"}},
+	}
+
+	statusOnly := deployment.DeepCopy()
+	statusOnly.ResourceVersion = "2"
+	statusOnly.Status.AvailableReplicas = 1
+
+	scaled := deployment.DeepCopy()
+	scaled.Generation = 2
+
+	relabelled := deployment.DeepCopy()
+	relabelled.Labels["team"] = "payments"
+
+	reselected := service.DeepCopy()
+	reselected.Spec.Selector = map[string]string{"app": "other"}
+
+	cases := []struct {
+		name     string
+		old, new interface{}
+		want     bool
+	}{
+		{"deployment status", deployment, statusOnly, false},
+		{"deployment generation", deployment, scaled, true},
+		{"deployment labels", deployment, relabelled, true},
+		{"service spec", service, reselected, true},
+		{"service unchanged", service, service.DeepCopy(), false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := ownedObjectChanged(tc.old, tc.new); got != tc.want {
+				t.Errorf("want %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
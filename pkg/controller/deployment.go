@@ -3,6 +3,7 @@ package controller
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"strings"
 
 	"github.com/google/go-cmp/cmp"
@@ -11,8 +12,6 @@ import (
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/runtime/schema"
-	"k8s.io/apimachinery/pkg/util/intstr"
 	glog "k8s.io/klog"
 )
 
@@ -23,191 +22,124 @@ const (
 // newDeployment creates a new Deployment for a Function resource. It also sets
 // the appropriate OwnerReferences on the resource so handleObject can discover
 // the Function resource that 'owns' it.
+//
+// Secrets and Profiles which can not be resolved are handled with the failure
+// policy of the Function: by default no Deployment is returned, with the
+// degrade policy the Deployment is returned without them. In both cases the
+// returned error holds a conditionError for each failure. The outcome of
+// resolving the Profiles is returned for the status of the Function, it is nil
+// when the spec is invalid and the Profiles were not looked up.
 func newDeployment(
 	function *faasv1.Function,
 	existingDeployment *appsv1.Deployment,
 	existingSecrets map[string]*corev1.Secret,
-	factory FunctionFactory) *appsv1.Deployment {
+	factory FunctionFactory) (*appsv1.Deployment, *profileResult, error) {
 
 	ctx := context.TODO()
-	envVars := makeEnvVars(function)
-	labels := makeLabels(function)
-	nodeSelector := makeNodeSelector(function.Spec.Constraints)
-	probes, err := factory.MakeProbes(function)
-	if err != nil {
-		glog.Warningf("Function %s probes parsing failed: %v",
-			function.Spec.Name, err)
-	}
 
-	resources, err := makeResources(function)
+	policy, err := FunctionFailurePolicy(function)
 	if err != nil {
-		glog.Warningf("Function %s resources parsing failed: %v",
-			function.Spec.Name, err)
+		glog.Warningf("Function %s: %v, using %s", function.Spec.Name, err, policy)
 	}
 
-	annotations := makeAnnotations(function)
-
-	var serviceAccount string
+	// Secrets which can not be found are left out of the request and are
+	// handled with the failure policy below
+	request := functionToFunctionRequest(function)
+	secrets, missingSecrets := splitSecrets(function.Spec.Secrets, existingSecrets)
+	request.Secrets = secrets
 
-	if function.Spec.Annotations != nil {
-		annotations := *function.Spec.Annotations
-		if val, ok := annotations["com.openfaas.serviceaccount"]; ok && len(val) > 0 {
-			serviceAccount = val
+	// the Deployment is built the same way as in controller mode, the
+	// operator adds the owner reference and the function spec annotation
+	deploymentSpec, err := factory.Factory.MakeDeployment(request, existingSecrets)
+	if err != nil {
+		return nil, nil, &conditionError{
+			condition: faasv1.FunctionDeployed,
+			reason:    ReasonInvalidSpec,
+			err:       fmt.Errorf("invalid function spec: %v", err),
 		}
 	}
 
-	allowPrivilegeEscalation := false
-
-	deploymentSpec := &appsv1.Deployment{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:        function.Spec.Name,
-			Annotations: annotations,
-			Namespace:   function.Namespace,
-			OwnerReferences: []metav1.OwnerReference{
-				*metav1.NewControllerRef(function, schema.GroupVersionKind{
-					Group:   faasv1.SchemeGroupVersion.Group,
-					Version: faasv1.SchemeGroupVersion.Version,
-					Kind:    faasKind,
-				}),
-			},
-		},
-		Spec: appsv1.DeploymentSpec{
-			Replicas: getReplicas(function, existingDeployment),
-			Strategy: appsv1.DeploymentStrategy{
-				Type: appsv1.RollingUpdateDeploymentStrategyType,
-				RollingUpdate: &appsv1.RollingUpdateDeployment{
-					MaxUnavailable: &intstr.IntOrString{
-						Type:   intstr.Int,
-						IntVal: int32(0),
-					},
-					MaxSurge: &intstr.IntOrString{
-						Type:   intstr.Int,
-						IntVal: int32(1),
-					},
-				},
-			},
-			Selector: &metav1.LabelSelector{
-				MatchLabels: map[string]string{
-					"app":        function.Spec.Name,
-					"controller": function.Name,
-				},
-			},
-			RevisionHistoryLimit: int32p(5),
-			Template: corev1.PodTemplateSpec{
-				ObjectMeta: metav1.ObjectMeta{
-					Labels:      labels,
-					Annotations: annotations,
-				},
-				Spec: corev1.PodSpec{
-					NodeSelector: nodeSelector,
-					Containers: []corev1.Container{
-						{
-							Name:  function.Spec.Name,
-							Image: function.Spec.Image,
-							Ports: []corev1.ContainerPort{
-								{ContainerPort: int32(functionPort), Protocol: corev1.ProtocolTCP},
-							},
-							ImagePullPolicy: corev1.PullPolicy(factory.Factory.Config.ImagePullPolicy),
-							Env:             envVars,
-							Resources:       *resources,
-							LivenessProbe:   probes.Liveness,
-							ReadinessProbe:  probes.Readiness,
-							SecurityContext: &corev1.SecurityContext{
-								AllowPrivilegeEscalation: &allowPrivilegeEscalation,
-							},
-						},
-					},
-				},
-			},
-		},
-	}
-
-	if len(serviceAccount) > 0 {
-		deploymentSpec.Spec.Template.Spec.ServiceAccountName = serviceAccount
+	deploymentSpec.Annotations = makeAnnotations(function)
+	deploymentSpec.OwnerReferences = []metav1.OwnerReference{
+		*newFunctionOwnerReference(function),
 	}
+	deploymentSpec.Spec.Replicas = k8s.ApplyReplicas(existingDeployment, deploymentSpec.Spec.Template.Labels)
 
-	factory.ConfigureReadOnlyRootFilesystem(function, deploymentSpec)
-	factory.ConfigureContainerUserID(deploymentSpec)
+	// Deployments created by earlier versions of the operator select on the
+	// app and controller labels
+	k8s.KeepSelector(deploymentSpec, existingDeployment)
 
-	var currentAnnotations map[string]string
-	if existingDeployment != nil {
-		currentAnnotations = existingDeployment.Annotations
-	}
+	// the Deployment is built from the Function each time, so the values of
+	// Profiles which are no longer used are not part of it and are removed
+	// when it is applied
+	failures := conditionErrors{}
 
-	// compare the annotations from args to the cache copy of the deployment annotations
-	// at this point we have already updated the annotations to the new value, if we
-	// compare to that it will produce an empty list
 	profileNamespace := factory.Factory.Config.ProfilesNamespace
-	profileList, err := factory.GetProfilesToRemove(ctx, profileNamespace, annotations, currentAnnotations)
+	profileList, err := getProfiles(ctx, factory, profileNamespace, deploymentSpec.Spec.Template.Annotations)
+	profiles := &profileResult{err: err}
 	if err != nil {
-		// TODO: a simple warning doesn't seem strong enough if a profile can't be found or there is
-		// some other error
-		glog.Warningf("Function %s can not retrieve required Profiles in %s: %v", function.Spec.Name, profileNamespace, err)
+		failures = append(failures, &conditionError{
+			condition: faasv1.FunctionProfilesApplied,
+			reason:    ReasonProfileNotFound,
+			err:       err,
+		})
 	}
-	for _, profile := range profileList {
-		factory.RemoveProfile(profile, deploymentSpec)
+	if len(profileList) == 0 {
+		glog.V(2).Infof("Function %s: no profiles applied", function.Spec.Name)
 	}
-
-	if _, exists := annotations[k8s.ProfileAnnotationKey]; !exists {
-		glog.Infof("Function %s: no profiles specified", function.Spec.Name)
-	}
-
-	profileList, err = factory.GetProfiles(ctx, profileNamespace, annotations)
-	if err != nil {
-		// TODO: a simple warning doesn't seem strong enough if a profile can't be found or there is
-		// some other error
-		glog.Warningf("Function %s can not retrieve required Profiles in %s: %v", function.Spec.Name, profileNamespace, err)
-	}
-	// TODO: remove this or refactor to just print names
-	glog.Infof("Function %s: Applying profiles %+v", function.Spec.Name, profileList)
 	for _, profile := range profileList {
 		factory.ApplyProfile(profile, deploymentSpec)
 	}
 
-	if err := UpdateSecrets(function, deploymentSpec, existingSecrets); err != nil {
-		// TODO: a simple warning doesn't seem strong enough if we can't update the secrets
-		glog.Warningf("Function %s secrets update failed: %v",
-			function.Spec.Name, err)
+	if len(missingSecrets) > 0 {
+		failures = append(failures, &conditionError{
+			condition: faasv1.FunctionSecretsResolved,
+			reason:    ReasonSecretNotFound,
+			err: fmt.Errorf("required secrets '%s' were not found in the cluster",
+				strings.Join(missingSecrets, "', '")),
+		})
 	}
 
-	return deploymentSpec
-}
+	// a change to the referenced Secrets or Profiles changes the hash and
+	// rolls the function Pods
+	k8s.SetConfigHash(deploymentSpec, k8s.ConfigHash(existingSecrets, profileList))
 
-func makeEnvVars(function *faasv1.Function) []corev1.EnvVar {
-	envVars := []corev1.EnvVar{}
-
-	if len(function.Spec.Handler) > 0 {
-		envVars = append(envVars, corev1.EnvVar{
-			Name:  "fprocess",
-			Value: function.Spec.Handler,
-		})
+	if len(failures) == 0 {
+		return deploymentSpec, profiles, nil
 	}
 
-	if function.Spec.Environment != nil {
-		for k, v := range *function.Spec.Environment {
-			envVars = append(envVars, corev1.EnvVar{
-				Name:  k,
-				Value: v,
-			})
-		}
+	if policy != FailurePolicyDegrade {
+		return nil, profiles, failures
 	}
 
-	return envVars
+	for _, failure := range failures {
+		failure.degraded = true
+	}
+	return deploymentSpec, profiles, failures
 }
 
-func makeLabels(function *faasv1.Function) map[string]string {
-	labels := map[string]string{
-		"faas_function": function.Spec.Name,
-		"app":           function.Spec.Name,
-		"controller":    function.Name,
-	}
-	if function.Spec.Labels != nil {
-		for k, v := range *function.Spec.Labels {
-			labels[k] = v
+// getProfiles returns the Profiles named in the annotations which can be
+// found, and an error naming the ones which can not
+func getProfiles(ctx context.Context, factory FunctionFactory, namespace string, annotations map[string]string) ([]k8s.Profile, error) {
+	client := factory.Factory.NewProfileClient()
+
+	profiles := []k8s.Profile{}
+	missing := []string{}
+	for _, name := range k8s.ParseProfileNames(annotations) {
+		found, err := client.Get(ctx, namespace, name)
+		if err != nil {
+			glog.V(2).Infof("Profile %s/%s can not be retrieved: %v", namespace, name, err)
+			missing = append(missing, name)
+			continue
 		}
+		profiles = append(profiles, found...)
 	}
 
-	return labels
+	if len(missing) > 0 {
+		return profiles, fmt.Errorf("required Profiles %s can not be retrieved from %s",
+			strings.Join(missing, ", "), namespace)
+	}
+	return profiles, nil
 }
 
 func makeAnnotations(function *faasv1.Function) map[string]string {
@@ -235,22 +167,6 @@ func makeAnnotations(function *faasv1.Function) map[string]string {
 	return annotations
 }
 
-func makeNodeSelector(constraints []string) map[string]string {
-	selector := make(map[string]string)
-
-	if len(constraints) > 0 {
-		for _, constraint := range constraints {
-			parts := strings.Split(constraint, "=")
-
-			if len(parts) == 2 {
-				selector[parts[0]] = parts[1]
-			}
-		}
-	}
-
-	return selector
-}
-
 // deploymentNeedsUpdate determines if the function spec is different from the deployment spec
 func deploymentNeedsUpdate(function *faasv1.Function, deployment *appsv1.Deployment) bool {
 	prevFnSpecJson := deployment.ObjectMeta.Annotations[annotationFunctionSpec]
@@ -279,6 +195,19 @@ func deploymentNeedsUpdate(function *faasv1.Function, deployment *appsv1.Deploym
 	return false
 }
 
+// splitSecrets returns the names of the Secrets which were found and the
+// names of the ones which were not
+func splitSecrets(names []string, existingSecrets map[string]*corev1.Secret) (found []string, missing []string) {
+	for _, name := range names {
+		if _, ok := existingSecrets[name]; ok {
+			found = append(found, name)
+		} else {
+			missing = append(missing, name)
+		}
+	}
+	return found, missing
+}
+
 func int32p(i int32) *int32 {
 	return &i
 }
@@ -1,10 +1,16 @@
 package controller
 
 import (
+	"strings"
 	"testing"
 
+	"github.com/google/go-cmp/cmp"
 	faasv1 "github.com/openfaas/faas-netes/pkg/apis/openfaas/v1"
+	faasfake "github.com/openfaas/faas-netes/pkg/client/clientset/versioned/fake"
+	faasinformers "github.com/openfaas/faas-netes/pkg/client/informers/externalversions"
 	"github.com/openfaas/faas-netes/pkg/k8s"
+	types "github.com/openfaas/faas-provider/types"
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes/fake"
@@ -45,7 +51,10 @@ func Test_newDeployment(t *testing.T) {
 
 	secrets := map[string]*corev1.Secret{}
 
-	deployment := newDeployment(function, nil, secrets, factory)
+	deployment, _, err := newDeployment(function, nil, secrets, factory)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
 
 	if deployment.Spec.Template.Spec.ServiceAccountName != "kubesec" {
 		t.Errorf("ServiceAccountName should be %s", "kubesec")
@@ -105,7 +114,10 @@ func Test_newDeployment_PrometheusScrape_NotOverridden(t *testing.T) {
 
 	secrets := map[string]*corev1.Secret{}
 
-	deployment := newDeployment(function, nil, secrets, factory)
+	deployment, _, err := newDeployment(function, nil, secrets, factory)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
 
 	want := "true"
 
@@ -113,3 +125,173 @@ func Test_newDeployment_PrometheusScrape_NotOverridden(t *testing.T) {
 		t.Errorf("Annotation prometheus.io.scrape should be %s, was: %s", want, deployment.Spec.Template.Annotations["prometheus.io.scrape"])
 	}
 }
+
+func Test_newDeployment_FailurePolicy(t *testing.T) {
+	profiles := faasinformers.NewSharedInformerFactory(faasfake.NewSimpleClientset(), 0).Openfaas().V1().Profiles()
+	profiles.Informer().GetIndexer().Add(&faasv1.Profile{
+		ObjectMeta: metav1.ObjectMeta{Name: "gpu", Namespace: "openfaas"},
+		Spec: faasv1.ProfileSpec{
+			Tolerations: []corev1.Toleration{{Key: "gpu", Operator: corev1.TolerationOpExists}},
+		},
+	})
+
+	factory := FunctionFactory{
+		Factory: k8s.NewFunctionFactory(fake.NewSimpleClientset(), k8s.DeploymentConfig{
+			ProfilesNamespace: "openfaas",
+			LivenessProbe:     &k8s.ProbeConfig{},
+			ReadinessProbe:    &k8s.ProbeConfig{},
+		}, profiles.Lister()),
+	}
+
+	newFunction := func(policy string) *faasv1.Function {
+		annotations := map[string]string{k8s.ProfileAnnotationKey: "gpu,arm64"}
+		if len(policy) > 0 {
+			annotations[FailurePolicyAnnotation] = policy
+		}
+		return &faasv1.Function{
+			ObjectMeta: metav1.ObjectMeta{Name: "nodeinfo", Namespace: "openfaas-fn"},
+			Spec: faasv1.FunctionSpec{
+				Name:        "nodeinfo",
+				Image:       "functions/nodeinfo",
+				Annotations: &annotations,
+				Secrets:     []string{"api-key"},
+			},
+		}
+	}
+
+	t.Run("fail by default", func(t *testing.T) {
+		deployment, result, err := newDeployment(newFunction(""), nil, map[string]*corev1.Secret{}, factory)
+		if deployment != nil {
+			t.Errorf("want no Deployment")
+		}
+		if result == nil || result.err == nil || !strings.Contains(result.err.Error(), "arm64") {
+			t.Errorf("want the profile result to name arm64, got %+v", result)
+		}
+
+		condErrs := asConditionErrors(err)
+		if len(condErrs) != 2 {
+			t.Fatalf("want 2 condition errors, got %v", err)
+		}
+		if condErrs[0].reason != ReasonProfileNotFound || !strings.Contains(condErrs[0].Error(), "arm64") {
+			t.Errorf("want a ProfileNotFound error for arm64, got %s: %s", condErrs[0].reason, condErrs[0])
+		}
+		if condErrs[1].reason != ReasonSecretNotFound || !strings.Contains(condErrs[1].Error(), "api-key") {
+			t.Errorf("want a SecretNotFound error for api-key, got %s: %s", condErrs[1].reason, condErrs[1])
+		}
+		if condErrs.degraded() {
+			t.Errorf("want the errors not to be degraded")
+		}
+	})
+
+	t.Run("degrade", func(t *testing.T) {
+		deployment, _, err := newDeployment(newFunction(FailurePolicyDegrade), nil, map[string]*corev1.Secret{}, factory)
+		if deployment == nil {
+			t.Fatalf("want a Deployment, got error: %v", err)
+		}
+
+		condErrs := asConditionErrors(err)
+		if len(condErrs) != 2 || !condErrs.degraded() {
+			t.Fatalf("want 2 degraded condition errors, got %v", err)
+		}
+
+		tolerations := deployment.Spec.Template.Spec.Tolerations
+		if len(tolerations) != 1 || tolerations[0].Key != "gpu" {
+			t.Errorf("want the gpu Profile to be applied, got tolerations %+v", tolerations)
+		}
+		if len(deployment.Spec.Template.Spec.Volumes) != 0 {
+			t.Errorf("want no secrets volume, got %+v", deployment.Spec.Template.Spec.Volumes)
+		}
+	})
+}
+
+func Test_newDeployment_MatchesControllerMode(t *testing.T) {
+	function := &faasv1.Function{
+		ObjectMeta: metav1.ObjectMeta{Name: "nodeinfo", Namespace: "openfaas-fn"},
+		Spec: faasv1.FunctionSpec{
+			Name:        "nodeinfo",
+			Image:       "functions/nodeinfo",
+			Handler:     "node main.js",
+			Environment: &map[string]string{"write_debug": "true"},
+			Labels:      &map[string]string{LabelMinReplicas: "2"},
+			Annotations: &map[string]string{"topic": "orders"},
+			Constraints: []string{"kubernetes.io/arch=arm64"},
+			Secrets:     []string{"api-key"},
+			Limits:      &faasv1.FunctionResources{Memory: "128Mi"},
+		},
+	}
+	secrets := map[string]*corev1.Secret{
+		"api-key": {Data: map[string][]byte{"key": []byte("secret")}},
+	}
+
+	factory := NewFunctionFactory(fake.NewSimpleClientset(), k8s.DeploymentConfig{
+		RuntimeHTTPPort: 8080,
+		LivenessProbe:   &k8s.ProbeConfig{},
+		ReadinessProbe:  &k8s.ProbeConfig{},
+	})
+
+	operator, _, err := newDeployment(function, nil, secrets, factory)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	controllerMode, err := factory.Factory.MakeDeployment(types.FunctionDeployment{
+		Service:     "nodeinfo",
+		Namespace:   "openfaas-fn",
+		Image:       "functions/nodeinfo",
+		EnvProcess:  "node main.js",
+		EnvVars:     map[string]string{"write_debug": "true"},
+		Labels:      &map[string]string{LabelMinReplicas: "2"},
+		Annotations: &map[string]string{"topic": "orders"},
+		Constraints: []string{"kubernetes.io/arch=arm64"},
+		Secrets:     []string{"api-key"},
+		Limits:      &types.FunctionResources{Memory: "128Mi"},
+	}, secrets)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	k8s.SetConfigHash(controllerMode, k8s.ConfigHash(secrets, nil))
+
+	if diff := cmp.Diff(controllerMode.Spec, operator.Spec); diff != "" {
+		t.Errorf("want the same Deployment spec in both modes, diff (-controller +operator):\n%s", diff)
+	}
+
+	desiredService := newService(function, factory)
+	controllerService := factory.Factory.MakeService(functionToFunctionRequest(function))
+	if diff := cmp.Diff(controllerService.Spec, desiredService.Spec); diff != "" {
+		t.Errorf("want the same Service spec in both modes, diff (-controller +operator):\n%s", diff)
+	}
+}
+
+func Test_newDeployment_KeepsLegacySelector(t *testing.T) {
+	function := &faasv1.Function{
+		ObjectMeta: metav1.ObjectMeta{Name: "nodeinfo", Namespace: "openfaas-fn"},
+		Spec:       faasv1.FunctionSpec{Name: "nodeinfo", Image: "functions/nodeinfo"},
+	}
+
+	factory := NewFunctionFactory(fake.NewSimpleClientset(), k8s.DeploymentConfig{
+		LivenessProbe:  &k8s.ProbeConfig{},
+		ReadinessProbe: &k8s.ProbeConfig{},
+	})
+
+	live := &appsv1.Deployment{
+		Spec: appsv1.DeploymentSpec{
+			Selector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{"app": "nodeinfo", "controller": "nodeinfo"},
+			},
+		},
+	}
+
+	deployment, _, err := newDeployment(function, live, map[string]*corev1.Secret{}, factory)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if diff := cmp.Diff(live.Spec.Selector, deployment.Spec.Selector); diff != "" {
+		t.Errorf("want the selector of the live Deployment, diff:\n%s", diff)
+	}
+	for _, label := range []string{"app", "controller", k8s.FunctionLabel} {
+		if deployment.Spec.Template.Labels[label] != "nodeinfo" {
+			t.Errorf("want template label %s=nodeinfo, got %q", label, deployment.Spec.Template.Labels[label])
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package controller
+
+import (
+	"fmt"
+
+	"github.com/openfaas/faas-netes/pkg/k8s"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+)
+
+const (
+	// DriftDetected is used as part of the Event 'reason' when an object owned
+	// by a Function has been changed outside of the operator
+	DriftDetected = "DriftDetected"
+	// MessageDriftReverted is the message used for Events when changes made to
+	// an object owned by a Function are reverted
+	MessageDriftReverted = "Reverting changes to %s %q: %s"
+)
+
+// deploymentDrift compares the Deployment generated for a Function with the
+// live Deployment and returns the fields that differ. Only the fields set by
+// newDeployment are compared, so that values defaulted by the API server and
+// fields owned by other controllers, such as the replica count, do not count
+// as drift. Maps and lists merged by key, such as env or volumes, only drift
+// when an entry set by the operator is missing or changed, because server-side
+// apply leaves the entries added by other field managers in place.
+func deploymentDrift(desired, live *appsv1.Deployment) []string {
+	drift := []string{}
+
+	desiredTemplate := desired.Spec.Template
+	liveTemplate := live.Spec.Template
+
+	drift = append(drift, mapDrift("spec.template.metadata.labels", desiredTemplate.Labels, liveTemplate.Labels)...)
+	// the config hash changes with the referenced Secrets and Profiles, which
+	// is a rollout rather than drift, see configHashChanged
+	desiredAnnotations := map[string]string{}
+	for k, v := range desiredTemplate.Annotations {
+		if k != k8s.ConfigHashAnnotation {
+			desiredAnnotations[k] = v
+		}
+	}
+	drift = append(drift, mapDrift("spec.template.metadata.annotations", desiredAnnotations, liveTemplate.Annotations)...)
+
+	desiredPod := desiredTemplate.Spec
+	livePod := liveTemplate.Spec
+
+	if len(desiredPod.ServiceAccountName) > 0 && desiredPod.ServiceAccountName != livePod.ServiceAccountName {
+		drift = append(drift, "spec.template.spec.serviceAccountName")
+	}
+	drift = append(drift, mapDrift("spec.template.spec.nodeSelector", desiredPod.NodeSelector, livePod.NodeSelector)...)
+	if !imagePullSecretsContained(desiredPod.ImagePullSecrets, livePod.ImagePullSecrets) {
+		drift = append(drift, "spec.template.spec.imagePullSecrets")
+	}
+	if !volumeNamesContained(desiredPod.Volumes, livePod.Volumes) {
+		drift = append(drift, "spec.template.spec.volumes")
+	}
+	if !equality.Semantic.DeepEqual(desiredPod.Tolerations, livePod.Tolerations) &&
+		(len(desiredPod.Tolerations) > 0 || len(livePod.Tolerations) > 0) {
+		drift = append(drift, "spec.template.spec.tolerations")
+	}
+	if !equality.Semantic.DeepEqual(desiredPod.Affinity, livePod.Affinity) {
+		drift = append(drift, "spec.template.spec.affinity")
+	}
+	if !equality.Semantic.DeepEqual(desiredPod.RuntimeClassName, livePod.RuntimeClassName) {
+		drift = append(drift, "spec.template.spec.runtimeClassName")
+	}
+
+	for i, desiredContainer := range desiredPod.Containers {
+		liveContainer := findContainer(desiredContainer.Name, livePod.Containers)
+		path := fmt.Sprintf("spec.template.spec.containers[%d]", i)
+		if liveContainer == nil {
+			drift = append(drift, path)
+			continue
+		}
+
+		drift = append(drift, containerDrift(path, desiredContainer, *liveContainer)...)
+	}
+
+	return drift
+}
+
+// configHashChanged reports whether the Secrets or Profiles used by the
+// Function have changed since the live Deployment was rolled out
+func configHashChanged(desired, live *appsv1.Deployment) bool {
+	return desired.Spec.Template.Annotations[k8s.ConfigHashAnnotation] != live.Spec.Template.Annotations[k8s.ConfigHashAnnotation]
+}
+
+// containerDrift compares the fields of a function container set by newDeployment
+func containerDrift(path string, desired, live corev1.Container) []string {
+	drift := []string{}
+
+	if desired.Image != live.Image {
+		drift = append(drift, path+".image")
+	}
+	drift = append(drift, mapDrift(path+".env", envToMap(desired.Env), envToMap(live.Env))...)
+	if !equality.Semantic.DeepEqual(desired.Resources.Limits, live.Resources.Limits) &&
+		(len(desired.Resources.Limits) > 0 || len(live.Resources.Limits) > 0) {
+		drift = append(drift, path+".resources.limits")
+	}
+	if !equality.Semantic.DeepEqual(desired.Resources.Requests, live.Resources.Requests) &&
+		(len(desired.Resources.Requests) > 0 || len(live.Resources.Requests) > 0) {
+		drift = append(drift, path+".resources.requests")
+	}
+	if !containerPortsContained(desired.Ports, live.Ports) {
+		drift = append(drift, path+".ports")
+	}
+	if !probeEqual(desired.LivenessProbe, live.LivenessProbe) {
+		drift = append(drift, path+".livenessProbe")
+	}
+	if !probeEqual(desired.ReadinessProbe, live.ReadinessProbe) {
+		drift = append(drift, path+".readinessProbe")
+	}
+	if !volumeMountsContained(desired.VolumeMounts, live.VolumeMounts) {
+		drift = append(drift, path+".volumeMounts")
+	}
+	if !securityContextEqual(desired.SecurityContext, live.SecurityContext) {
+		drift = append(drift, path+".securityContext")
+	}
+
+	return drift
+}
+
+// serviceDrift compares the Service generated for a Function with the live
+// Service and returns the fields that differ
+func serviceDrift(desired, live *corev1.Service) []string {
+	drift := []string{}
+
+	drift = append(drift, mapDrift("spec.selector", desired.Spec.Selector, live.Spec.Selector)...)
+
+	for _, desiredPort := range desired.Spec.Ports {
+		found := false
+		for _, livePort := range live.Spec.Ports {
+			if desiredPort.Port == livePort.Port && desiredPort.TargetPort == livePort.TargetPort {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return append(drift, "spec.ports")
+		}
+	}
+
+	return drift
+}
+
+// mapDrift reports the desired keys which are missing or have a different value,
+// keys added by other tools are ignored
+func mapDrift(path string, desired, live map[string]string) []string {
+	for k, v := range desired {
+		if liveValue, ok := live[k]; !ok || liveValue != v {
+			return []string{path}
+		}
+	}
+	return nil
+}
+
+func envToMap(env []corev1.EnvVar) map[string]string {
+	values := make(map[string]string, len(env))
+	for _, e := range env {
+		values[e.Name] = e.Value
+	}
+	return values
+}
+
+func findContainer(name string, containers []corev1.Container) *corev1.Container {
+	for i := range containers {
+		if containers[i].Name == name {
+			return &containers[i]
+		}
+	}
+	return nil
+}
+
+func containerPortsContained(desired, live []corev1.ContainerPort) bool {
+	ports := map[int32]bool{}
+	for _, p := range live {
+		ports[p.ContainerPort] = true
+	}
+	for _, p := range desired {
+		if !ports[p.ContainerPort] {
+			return false
+		}
+	}
+	return true
+}
+
+func imagePullSecretsContained(desired, live []corev1.LocalObjectReference) bool {
+	names := map[string]bool{}
+	for _, s := range live {
+		names[s.Name] = true
+	}
+	for _, s := range desired {
+		if !names[s.Name] {
+			return false
+		}
+	}
+	return true
+}
+
+func volumeNamesContained(desired, live []corev1.Volume) bool {
+	names := map[string]bool{}
+	for _, v := range live {
+		names[v.Name] = true
+	}
+	for _, v := range desired {
+		if !names[v.Name] {
+			return false
+		}
+	}
+	return true
+}
+
+func volumeMountsContained(desired, live []corev1.VolumeMount) bool {
+	mounts := map[string]string{}
+	for _, m := range live {
+		mounts[m.Name] = m.MountPath
+	}
+	for _, m := range desired {
+		if path, ok := mounts[m.Name]; !ok || path != m.MountPath {
+			return false
+		}
+	}
+	return true
+}
+
+// probeEqual compares the probe fields set by the FunctionFactory, the
+// API server defaults the remaining fields
+func probeEqual(desired, live *corev1.Probe) bool {
+	if desired == nil || live == nil {
+		return desired == nil && live == nil
+	}
+
+	if desired.InitialDelaySeconds != live.InitialDelaySeconds ||
+		desired.PeriodSeconds != live.PeriodSeconds ||
+		desired.TimeoutSeconds != live.TimeoutSeconds {
+		return false
+	}
+
+	switch {
+	case desired.HTTPGet != nil:
+		return live.HTTPGet != nil &&
+			desired.HTTPGet.Path == live.HTTPGet.Path &&
+			desired.HTTPGet.Port == live.HTTPGet.Port
+	case desired.Exec != nil:
+		return live.Exec != nil &&
+			equality.Semantic.DeepEqual(desired.Exec.Command, live.Exec.Command)
+	}
+
+	return true
+}
+
+// securityContextEqual compares the container security settings set by the
+// FunctionFactory
+func securityContextEqual(desired, live *corev1.SecurityContext) bool {
+	if desired == nil {
+		return true
+	}
+	if live == nil {
+		return false
+	}
+
+	return boolPtrEqual(desired.ReadOnlyRootFilesystem, live.ReadOnlyRootFilesystem) &&
+		boolPtrEqual(desired.AllowPrivilegeEscalation, live.AllowPrivilegeEscalation) &&
+		(desired.RunAsUser == nil || (live.RunAsUser != nil && *desired.RunAsUser == *live.RunAsUser))
+}
+
+func boolPtrEqual(desired, live *bool) bool {
+	if desired == nil {
+		return true
+	}
+	return live != nil && *desired == *live
+}
@@ -0,0 +1,127 @@
+package controller
+
+import (
+	"testing"
+
+	faasv1 "github.com/openfaas/faas-netes/pkg/apis/openfaas/v1"
+	"github.com/openfaas/faas-netes/pkg/k8s"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newDriftTestDeployment(t *testing.T) *appsv1.Deployment {
+	t.Helper()
+
+	function := &faasv1.Function{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "nodeinfo",
+			Namespace: "openfaas-fn",
+		},
+		Spec: faasv1.FunctionSpec{
+			Name:        "nodeinfo",
+			Image:       "functions/nodeinfo",
+			Environment: &map[string]string{"write_debug": "true", "read_timeout": "10s"},
+			Limits:      &faasv1.FunctionResources{Memory: "128Mi"},
+		},
+	}
+
+	factory := NewFunctionFactory(fake.NewSimpleClientset(),
+		k8s.DeploymentConfig{
+			HTTPProbe:      true,
+			LivenessProbe:  &k8s.ProbeConfig{PeriodSeconds: 1, TimeoutSeconds: 3},
+			ReadinessProbe: &k8s.ProbeConfig{PeriodSeconds: 1, TimeoutSeconds: 3},
+		})
+
+	deployment, _, err := newDeployment(function, nil, map[string]*corev1.Secret{}, factory)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	return deployment
+}
+
+func Test_deploymentDrift_IgnoresServerDefaults(t *testing.T) {
+	desired := newDriftTestDeployment(t)
+	live := desired.DeepCopy()
+
+	// simulate the values defaulted by the API server and added by other tools
+	live.Spec.Template.Annotations["kubectl.kubernetes.io/restartedAt"] = "2021-01-01T00:00:00Z"
+	live.Spec.Template.Spec.RestartPolicy = corev1.RestartPolicyAlways
+	live.Spec.Template.Spec.ServiceAccountName = "default"
+	container := &live.Spec.Template.Spec.Containers[0]
+	container.TerminationMessagePath = "/dev/termination-log"
+	container.LivenessProbe.HTTPGet.Scheme = corev1.URISchemeHTTP
+	container.LivenessProbe.SuccessThreshold = 1
+	container.Env = []corev1.EnvVar{container.Env[1], container.Env[0]}
+
+	// entries added by other field managers are left in place by server-side apply
+	container.Env = append(container.Env, corev1.EnvVar{Name: "OTEL_SERVICE_NAME", Value: "nodeinfo"})
+	live.Spec.Template.Spec.Volumes = append(live.Spec.Template.Spec.Volumes, corev1.Volume{Name: "istio-envoy"})
+	live.Spec.Template.Spec.NodeSelector = map[string]string{"kubernetes.io/os": "linux"}
+
+	if drift := deploymentDrift(desired, live); len(drift) > 0 {
+		t.Errorf("want no drift, got %v", drift)
+	}
+}
+
+func Test_deploymentDrift_DetectsManualChanges(t *testing.T) {
+	desired := newDriftTestDeployment(t)
+	live := desired.DeepCopy()
+
+	live.Spec.Template.Spec.Containers[0].Image = "functions/nodeinfo:edited"
+	live.Spec.Template.Spec.Containers[0].Env[0].Value = "edited"
+	delete(live.Spec.Template.Labels, "faas_function")
+
+	drift := deploymentDrift(desired, live)
+
+	want := map[string]bool{
+		"spec.template.metadata.labels":          true,
+		"spec.template.spec.containers[0].image": true,
+		"spec.template.spec.containers[0].env":   true,
+	}
+	if len(drift) != len(want) {
+		t.Fatalf("want %d drifted fields, got %v", len(want), drift)
+	}
+	for _, field := range drift {
+		if !want[field] {
+			t.Errorf("unexpected drifted field %s", field)
+		}
+	}
+}
+
+func Test_serviceDrift(t *testing.T) {
+	function := &faasv1.Function{
+		ObjectMeta: metav1.ObjectMeta{Name: "nodeinfo", Namespace: "openfaas-fn"},
+		Spec:       faasv1.FunctionSpec{Name: "nodeinfo"},
+	}
+	factory := NewFunctionFactory(fake.NewSimpleClientset(), k8s.DeploymentConfig{RuntimeHTTPPort: 8080})
+	desired := newService(function, factory)
+
+	live := desired.DeepCopy()
+	live.Spec.ClusterIP = "10.0.0.10"
+	live.Spec.Ports = append(live.Spec.Ports, corev1.ServicePort{Name: "metrics", Port: 8081})
+	if drift := serviceDrift(desired, live); len(drift) > 0 {
+		t.Errorf("want no drift, got %v", drift)
+	}
+
+	live.Spec.Ports[0].TargetPort = intstr.FromInt(9000)
+	live.Spec.Selector = map[string]string{"app": "other"}
+	if drift := serviceDrift(desired, live); len(drift) != 2 {
+		t.Errorf("want 2 drifted fields, got %v", drift)
+	}
+}
+
+func Test_deploymentDrift_IgnoresConfigHash(t *testing.T) {
+	desired := newDriftTestDeployment(t)
+	live := desired.DeepCopy()
+	k8s.SetConfigHash(live, "previous")
+
+	if drift := deploymentDrift(desired, live); len(drift) > 0 {
+		t.Errorf("want no drift, got %v", drift)
+	}
+	if !configHashChanged(desired, live) {
+		t.Errorf("want config hash change to be detected")
+	}
+}
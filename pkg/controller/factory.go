@@ -33,8 +33,9 @@ func functionToFunctionRequest(in *faasv1.Function) types.FunctionDeployment {
 	lim, req := functionToFunctionResources(in)
 	return types.FunctionDeployment{
 		Annotations:            in.Spec.Annotations,
-		Service:                in.Name,
-		Labels:                 &in.Labels,
+		Service:                in.Spec.Name,
+		Namespace:              in.Namespace,
+		Labels:                 in.Spec.Labels,
 		Constraints:            in.Spec.Constraints,
 		EnvProcess:             in.Spec.Handler,
 		EnvVars:                env,
@@ -0,0 +1,175 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	faasv1 "github.com/openfaas/faas-netes/pkg/apis/openfaas/v1"
+	"github.com/openfaas/faas-netes/pkg/k8s"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	glog "k8s.io/klog"
+)
+
+const (
+	// FunctionFinalizer is added to Function resources so that the operator can
+	// remove the objects it created before the Function is deleted
+	FunctionFinalizer = "com.openfaas/cleanup"
+
+	// ErrCleanupFailed is used as part of the Event 'reason' when the objects
+	// owned by a Function can not be deleted
+	ErrCleanupFailed = "ErrCleanupFailed"
+	// MessageCleanupFailed is the message used for Events when an object owned
+	// by a Function can not be deleted
+	MessageCleanupFailed = "Deleting %s %q failed: %s"
+
+	// cleanupRequeueDelay is how long to wait before checking again if the
+	// objects owned by a deleted Function are gone
+	cleanupRequeueDelay = 2 * time.Second
+)
+
+// ownedObject is a type of object created by the operator for a Function,
+// which must be removed before the Function's finalizer is released
+type ownedObject struct {
+	kind   string
+	get    func(ctx context.Context, namespace, name string) (metav1.Object, error)
+	delete func(ctx context.Context, namespace, name string, opts metav1.DeleteOptions) error
+}
+
+// ownedObjects lists the types of object which the operator creates for a Function
+func (c *Controller) ownedObjects() []ownedObject {
+	return []ownedObject{
+		{
+			kind: "Deployment",
+			get: func(ctx context.Context, namespace, name string) (metav1.Object, error) {
+				return c.kubeclientset.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+			},
+			delete: func(ctx context.Context, namespace, name string, opts metav1.DeleteOptions) error {
+				return c.kubeclientset.AppsV1().Deployments(namespace).Delete(ctx, name, opts)
+			},
+		},
+		{
+			kind: "Service",
+			get: func(ctx context.Context, namespace, name string) (metav1.Object, error) {
+				return c.kubeclientset.CoreV1().Services(namespace).Get(ctx, name, metav1.GetOptions{})
+			},
+			delete: func(ctx context.Context, namespace, name string, opts metav1.DeleteOptions) error {
+				return c.kubeclientset.CoreV1().Services(namespace).Delete(ctx, name, opts)
+			},
+		},
+		{
+			kind: "HorizontalPodAutoscaler",
+			get: func(ctx context.Context, namespace, name string) (metav1.Object, error) {
+				return c.factory.Factory.Dynamic.Resource(k8s.HorizontalPodAutoscalerResource).Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
+			},
+			delete: func(ctx context.Context, namespace, name string, opts metav1.DeleteOptions) error {
+				return c.factory.Factory.Dynamic.Resource(k8s.HorizontalPodAutoscalerResource).Namespace(namespace).Delete(ctx, name, opts)
+			},
+		},
+	}
+}
+
+// finalizeFunction deletes the objects owned by a Function that is being
+// deleted and removes the finalizer once they are all gone. The Function is
+// requeued while objects are still terminating.
+func (c *Controller) finalizeFunction(key string, function *faasv1.Function) error {
+	if !hasFinalizer(function, FunctionFinalizer) {
+		return nil
+	}
+
+	remaining, err := c.cleanupFunction(function)
+	if err != nil {
+		return err
+	}
+
+	if remaining > 0 {
+		glog.V(2).Infof("Waiting for %d object(s) of '%s' to be deleted", remaining, key)
+		c.workqueue.AddAfter(key, cleanupRequeueDelay)
+		return nil
+	}
+
+	updated := function.DeepCopy()
+	updated.Finalizers = removeString(updated.Finalizers, FunctionFinalizer)
+	if _, err := c.faasclientset.OpenfaasV1().Functions(function.Namespace).Update(context.TODO(), updated, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("removing finalizer from '%s' failed: %v", key, err)
+	}
+
+	glog.Infof("Function '%s' cleanup completed", key)
+	return nil
+}
+
+// cleanupFunction deletes each object controlled by the Function and returns
+// the number of objects that still exist. Objects with the same name that are
+// not controlled by the Function are left in place.
+func (c *Controller) cleanupFunction(function *faasv1.Function) (int, error) {
+	ctx := context.TODO()
+	name := function.Spec.Name
+	if len(name) == 0 {
+		return 0, nil
+	}
+
+	foregroundPolicy := metav1.DeletePropagationForeground
+	opts := metav1.DeleteOptions{PropagationPolicy: &foregroundPolicy}
+
+	remaining := 0
+	for _, owned := range c.ownedObjects() {
+		obj, err := owned.get(ctx, function.Namespace, name)
+		if errors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return remaining, err
+		}
+
+		if !metav1.IsControlledBy(obj, function) {
+			glog.Infof("%s '%s' is not controlled by Function '%s', skipping deletion", owned.kind, name, function.Name)
+			continue
+		}
+
+		remaining++
+		if obj.GetDeletionTimestamp() != nil {
+			continue
+		}
+
+		glog.Infof("Deleting %s for '%s'", owned.kind, name)
+		if err := owned.delete(ctx, function.Namespace, name, opts); err != nil && !errors.IsNotFound(err) {
+			msg := fmt.Sprintf(MessageCleanupFailed, owned.kind, name, err.Error())
+			c.recorder.Event(function, corev1.EventTypeWarning, ErrCleanupFailed, msg)
+			return remaining, fmt.Errorf("%s", msg)
+		}
+	}
+
+	return remaining, nil
+}
+
+// ensureFinalizer adds the cleanup finalizer to the Function when it is missing
+func (c *Controller) ensureFinalizer(function *faasv1.Function) (*faasv1.Function, error) {
+	if hasFinalizer(function, FunctionFinalizer) {
+		return function, nil
+	}
+
+	updated := function.DeepCopy()
+	updated.Finalizers = append(updated.Finalizers, FunctionFinalizer)
+	return c.faasclientset.OpenfaasV1().Functions(function.Namespace).Update(context.TODO(), updated, metav1.UpdateOptions{})
+}
+
+func hasFinalizer(obj metav1.Object, finalizer string) bool {
+	for _, f := range obj.GetFinalizers() {
+		if f == finalizer {
+			return true
+		}
+	}
+	return false
+}
+
+func removeString(values []string, value string) []string {
+	result := []string{}
+	for _, v := range values {
+		if v != value {
+			result = append(result, v)
+		}
+	}
+	return result
+}
@@ -0,0 +1,117 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	faasv1 "github.com/openfaas/faas-netes/pkg/apis/openfaas/v1"
+	"github.com/openfaas/faas-netes/pkg/client/clientset/versioned/fake"
+	"github.com/openfaas/faas-netes/pkg/k8s"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+)
+
+func Test_finalizeFunction(t *testing.T) {
+	now := metav1.Now()
+	function := &faasv1.Function{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "nodeinfo",
+			Namespace:         "openfaas-fn",
+			UID:               "1234",
+			Finalizers:        []string{FunctionFinalizer},
+			DeletionTimestamp: &now,
+		},
+		Spec: faasv1.FunctionSpec{Name: "nodeinfo"},
+	}
+	owner := []metav1.OwnerReference{*newFunctionOwnerReference(function)}
+
+	kube := kubefake.NewSimpleClientset(
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "nodeinfo", Namespace: "openfaas-fn", OwnerReferences: owner}},
+		&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "nodeinfo", Namespace: "openfaas-fn", OwnerReferences: owner}},
+		&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "unmanaged", Namespace: "openfaas-fn"}},
+	)
+	client := fake.NewSimpleClientset(function)
+
+	hpa := &unstructured.Unstructured{}
+	hpa.SetAPIVersion("autoscaling/v2")
+	hpa.SetKind("HorizontalPodAutoscaler")
+	hpa.SetName("nodeinfo")
+	hpa.SetNamespace("openfaas-fn")
+	hpa.SetOwnerReferences(owner)
+	dynamic := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), hpa)
+
+	c := &Controller{
+		kubeclientset: kube,
+		faasclientset: client,
+		factory:       FunctionFactory{Factory: k8s.FunctionFactory{Dynamic: dynamic}},
+		recorder:      record.NewFakeRecorder(10),
+		workqueue:     workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "Functions"),
+	}
+	defer c.workqueue.ShutDown()
+
+	if err := c.finalizeFunction("openfaas-fn/nodeinfo", function); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	ctx := context.TODO()
+	if _, err := kube.AppsV1().Deployments("openfaas-fn").Get(ctx, "nodeinfo", metav1.GetOptions{}); err == nil {
+		t.Errorf("want deployment to be deleted")
+	}
+	if _, err := kube.CoreV1().Services("openfaas-fn").Get(ctx, "nodeinfo", metav1.GetOptions{}); err == nil {
+		t.Errorf("want service to be deleted")
+	}
+	if _, err := kube.CoreV1().Services("openfaas-fn").Get(ctx, "unmanaged", metav1.GetOptions{}); err != nil {
+		t.Errorf("want unmanaged service to be kept, got: %s", err)
+	}
+	if _, err := dynamic.Resource(k8s.HorizontalPodAutoscalerResource).Namespace("openfaas-fn").Get(ctx, "nodeinfo", metav1.GetOptions{}); err == nil {
+		t.Errorf("want HorizontalPodAutoscaler to be deleted")
+	}
+
+	// the fake clientset deletes objects immediately, so the first pass finds
+	// them and requeues, the second pass releases the finalizer
+	if c.workqueue.Len() != 0 {
+		t.Fatalf("want no requeue before the delay, got %d", c.workqueue.Len())
+	}
+	if err := c.finalizeFunction("openfaas-fn/nodeinfo", function); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	got, err := client.OpenfaasV1().Functions("openfaas-fn").Get(ctx, "nodeinfo", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if hasFinalizer(got, FunctionFinalizer) {
+		t.Errorf("want finalizer %s to be removed, got %v", FunctionFinalizer, got.Finalizers)
+	}
+}
+
+func Test_ensureFinalizer(t *testing.T) {
+	function := &faasv1.Function{
+		ObjectMeta: metav1.ObjectMeta{Name: "nodeinfo", Namespace: "openfaas-fn"},
+		Spec:       faasv1.FunctionSpec{Name: "nodeinfo"},
+	}
+	c := &Controller{faasclientset: fake.NewSimpleClientset(function)}
+
+	got, err := c.ensureFinalizer(function)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !hasFinalizer(got, FunctionFinalizer) {
+		t.Errorf("want finalizer %s, got %v", FunctionFinalizer, got.Finalizers)
+	}
+
+	again, err := c.ensureFinalizer(got)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(again.Finalizers) != 1 {
+		t.Errorf("want 1 finalizer, got %v", again.Finalizers)
+	}
+}
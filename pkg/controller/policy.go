@@ -0,0 +1,89 @@
+package controller
+
+import (
+	"fmt"
+	"strings"
+
+	faasv1 "github.com/openfaas/faas-netes/pkg/apis/openfaas/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// FailurePolicyAnnotation sets what the operator does when a Secret or
+	// Profile used by a Function can not be resolved
+	FailurePolicyAnnotation = "com.openfaas.config.failurePolicy"
+	// FailurePolicyFail stops the rollout of the Function until all of its
+	// Secrets and Profiles are found, this is the default
+	FailurePolicyFail = "fail"
+	// FailurePolicyDegrade rolls out the Function without the Secrets and
+	// Profiles that can not be found
+	FailurePolicyDegrade = "degrade"
+)
+
+// FunctionFailurePolicy returns the failure policy of the Function, an
+// invalid value returns an error along with the default policy
+func FunctionFailurePolicy(function *faasv1.Function) (string, error) {
+	if function.Spec.Annotations == nil {
+		return FailurePolicyFail, nil
+	}
+
+	value, ok := (*function.Spec.Annotations)[FailurePolicyAnnotation]
+	if !ok {
+		return FailurePolicyFail, nil
+	}
+
+	switch strings.ToLower(value) {
+	case FailurePolicyFail:
+		return FailurePolicyFail, nil
+	case FailurePolicyDegrade:
+		return FailurePolicyDegrade, nil
+	}
+
+	return FailurePolicyFail, fmt.Errorf("invalid %s %q, must be one of %s or %s",
+		FailurePolicyAnnotation, value, FailurePolicyFail, FailurePolicyDegrade)
+}
+
+// conditionErrors is returned when more than one Function condition failed
+type conditionErrors []*conditionError
+
+func (e conditionErrors) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, err := range e {
+		messages = append(messages, err.Error())
+	}
+	return strings.Join(messages, ", ")
+}
+
+// degraded returns true when the Function was rolled out without some of its
+// Secrets or Profiles, the rollout is still retried until they are found
+func (e conditionErrors) degraded() bool {
+	for _, err := range e {
+		if !err.degraded {
+			return false
+		}
+	}
+	return len(e) > 0
+}
+
+// asConditionErrors returns the condition errors wrapped by err
+func asConditionErrors(err error) conditionErrors {
+	switch e := err.(type) {
+	case *conditionError:
+		return conditionErrors{e}
+	case conditionErrors:
+		return e
+	}
+	return nil
+}
+
+// recordConditionErrors emits a Warning Event on the Function for each of
+// the condition errors, so that they are shown by kubectl describe
+func (c *Controller) recordConditionErrors(function *faasv1.Function, err error) {
+	for _, condErr := range asConditionErrors(err) {
+		msg := condErr.Error()
+		if condErr.degraded {
+			msg = fmt.Sprintf("%s, rolling out without it as the %s is %s", msg, FailurePolicyAnnotation, FailurePolicyDegrade)
+		}
+		c.recorder.Event(function, corev1.EventTypeWarning, condErr.reason, msg)
+	}
+}
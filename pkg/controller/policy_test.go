@@ -0,0 +1,75 @@
+package controller
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	faasv1 "github.com/openfaas/faas-netes/pkg/apis/openfaas/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func Test_FunctionFailurePolicy(t *testing.T) {
+	cases := []struct {
+		name        string
+		annotations *map[string]string
+		want        string
+		wantErr     bool
+	}{
+		{name: "no annotations", want: FailurePolicyFail},
+		{name: "no policy", annotations: &map[string]string{}, want: FailurePolicyFail},
+		{name: "fail", annotations: &map[string]string{FailurePolicyAnnotation: "fail"}, want: FailurePolicyFail},
+		{name: "degrade", annotations: &map[string]string{FailurePolicyAnnotation: "Degrade"}, want: FailurePolicyDegrade},
+		{name: "invalid", annotations: &map[string]string{FailurePolicyAnnotation: "ignore"}, want: FailurePolicyFail, wantErr: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			function := &faasv1.Function{Spec: faasv1.FunctionSpec{Annotations: tc.annotations}}
+
+			got, err := FunctionFailurePolicy(function)
+			if got != tc.want {
+				t.Errorf("want policy %s, got %s", tc.want, got)
+			}
+			if (err != nil) != tc.wantErr {
+				t.Errorf("want error %v, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func Test_recordConditionErrors(t *testing.T) {
+	recorder := record.NewFakeRecorder(10)
+	c := &Controller{recorder: recorder}
+
+	function := &faasv1.Function{ObjectMeta: metav1.ObjectMeta{Name: "nodeinfo", Namespace: "openfaas-fn"}}
+	c.recordConditionErrors(function, conditionErrors{
+		{
+			condition: faasv1.FunctionProfilesApplied,
+			reason:    ReasonProfileNotFound,
+			err:       fmt.Errorf("required Profiles gpu can not be retrieved from openfaas"),
+		},
+		{
+			condition: faasv1.FunctionSecretsResolved,
+			reason:    ReasonSecretNotFound,
+			err:       fmt.Errorf("required secrets 'api-key' were not found in the cluster"),
+			degraded:  true,
+		},
+	})
+
+	want := []string{
+		"Warning ProfileNotFound required Profiles gpu can not be retrieved from openfaas",
+		"Warning SecretNotFound required secrets 'api-key' were not found in the cluster, rolling out without it",
+	}
+	for _, w := range want {
+		select {
+		case event := <-recorder.Events:
+			if !strings.HasPrefix(event, w) {
+				t.Errorf("want event %q, got %q", w, event)
+			}
+		default:
+			t.Fatalf("want event %q, got none", w)
+		}
+	}
+}
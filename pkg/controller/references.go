@@ -0,0 +1,110 @@
+package controller
+
+import (
+	"fmt"
+
+	faasv1 "github.com/openfaas/faas-netes/pkg/apis/openfaas/v1"
+	"github.com/openfaas/faas-netes/pkg/k8s"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/tools/cache"
+	glog "k8s.io/klog"
+)
+
+const (
+	// ConfigChanged is used as part of the Event 'reason' when a Function is
+	// rolled because a Secret or Profile it uses has changed
+	ConfigChanged = "ConfigChanged"
+	// MessageConfigChanged is the message used for Events when a Function is
+	// rolled because a Secret or Profile it uses has changed
+	MessageConfigChanged = "Rolling Deployment %q after a change to its Secrets or Profiles"
+
+	// secretsIndex indexes Functions by the namespace/name of the Secrets they use
+	secretsIndex = "secrets"
+	// profilesIndex indexes Functions by the names of the Profiles they use,
+	// Profiles are all kept in the same namespace
+	profilesIndex = "profiles"
+)
+
+// functionIndexers returns the indexes used to find the Functions that
+// reference a Secret or a Profile
+func functionIndexers() cache.Indexers {
+	return cache.Indexers{
+		secretsIndex:  secretsIndexFunc,
+		profilesIndex: profilesIndexFunc,
+	}
+}
+
+func secretsIndexFunc(obj interface{}) ([]string, error) {
+	function, ok := obj.(*faasv1.Function)
+	if !ok {
+		return nil, fmt.Errorf("expected Function but got %T", obj)
+	}
+
+	keys := make([]string, 0, len(function.Spec.Secrets))
+	for _, secret := range function.Spec.Secrets {
+		keys = append(keys, function.Namespace+"/"+secret)
+	}
+	return keys, nil
+}
+
+func profilesIndexFunc(obj interface{}) ([]string, error) {
+	function, ok := obj.(*faasv1.Function)
+	if !ok {
+		return nil, fmt.Errorf("expected Function but got %T", obj)
+	}
+
+	if function.Spec.Annotations == nil {
+		return nil, nil
+	}
+	return k8s.ParseProfileNames(*function.Spec.Annotations), nil
+}
+
+// referenceHandler returns an event handler for Secrets or Profiles which
+// enqueues the Functions found in the index under the key of the object.
+// The Functions then get a new config hash and are rolled by syncFunction.
+func (c *Controller) referenceHandler(indexName string, keyFunc cache.KeyFunc) cache.ResourceEventHandlerFuncs {
+	enqueue := func(obj interface{}) {
+		if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+			obj = tombstone.Obj
+		}
+
+		key, err := keyFunc(obj)
+		if err != nil {
+			runtime.HandleError(err)
+			return
+		}
+
+		functions, err := c.functionsIndexer.ByIndex(indexName, key)
+		if err != nil {
+			runtime.HandleError(err)
+			return
+		}
+
+		for _, function := range functions {
+			glog.V(2).Infof("Enqueueing Function '%s' after a change to %s '%s'",
+				function.(*faasv1.Function).Name, indexName, key)
+			c.enqueueFunction(function)
+		}
+	}
+
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: enqueue,
+		UpdateFunc: func(old, new interface{}) {
+			if old.(metav1.Object).GetResourceVersion() == new.(metav1.Object).GetResourceVersion() {
+				return
+			}
+			enqueue(new)
+		},
+		DeleteFunc: enqueue,
+	}
+}
+
+// profileKeyFunc keys Profiles by name only, Functions refer to them by name
+func profileKeyFunc(obj interface{}) (string, error) {
+	object, ok := obj.(metav1.Object)
+	if !ok {
+		return "", fmt.Errorf("expected Profile but got %T", obj)
+	}
+	return object.GetName(), nil
+}
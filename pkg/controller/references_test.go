@@ -0,0 +1,80 @@
+package controller
+
+import (
+	"testing"
+
+	faasv1 "github.com/openfaas/faas-netes/pkg/apis/openfaas/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+func Test_referenceHandler_EnqueuesFunctionsUsingObject(t *testing.T) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, functionIndexers())
+	functions := []*faasv1.Function{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "nodeinfo", Namespace: "openfaas-fn"},
+			Spec: faasv1.FunctionSpec{
+				Name:        "nodeinfo",
+				Secrets:     []string{"api-key"},
+				Annotations: &map[string]string{"com.openfaas.profile": "gpu"},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "figlet", Namespace: "openfaas-fn"},
+			Spec:       faasv1.FunctionSpec{Name: "figlet"},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "nodeinfo", Namespace: "staging"},
+			Spec: faasv1.FunctionSpec{
+				Name:    "nodeinfo",
+				Secrets: []string{"api-key"},
+			},
+		},
+	}
+	for _, function := range functions {
+		indexer.Add(function)
+	}
+
+	cases := []struct {
+		name      string
+		indexName string
+		keyFunc   cache.KeyFunc
+		obj       interface{}
+		want      string
+	}{
+		{
+			name:      "secret",
+			indexName: secretsIndex,
+			keyFunc:   cache.MetaNamespaceKeyFunc,
+			obj:       &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "api-key", Namespace: "openfaas-fn"}},
+			want:      "openfaas-fn/nodeinfo",
+		},
+		{
+			name:      "profile",
+			indexName: profilesIndex,
+			keyFunc:   profileKeyFunc,
+			obj:       &faasv1.Profile{ObjectMeta: metav1.ObjectMeta{Name: "gpu", Namespace: "openfaas"}},
+			want:      "openfaas-fn/nodeinfo",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &Controller{
+				functionsIndexer: indexer,
+				workqueue:        workqueue.NewRateLimitingQueue(workqueue.NewItemExponentialFailureRateLimiter(0, 0)),
+			}
+			c.referenceHandler(tc.indexName, tc.keyFunc).OnAdd(tc.obj)
+
+			if c.workqueue.Len() != 1 {
+				t.Fatalf("want 1 Function enqueued, got %d", c.workqueue.Len())
+			}
+			key, _ := c.workqueue.Get()
+			if key != tc.want {
+				t.Errorf("want %s enqueued, got %s", tc.want, key)
+			}
+		})
+	}
+}
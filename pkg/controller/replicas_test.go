@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes/fake"
 
 	faasv1 "github.com/openfaas/faas-netes/pkg/apis/openfaas/v1"
@@ -11,6 +12,9 @@ import (
 )
 
 func Test_Replicas(t *testing.T) {
+	minLabels := &map[string]string{LabelMinReplicas: "2"}
+	hpaLabels := &map[string]string{LabelMinReplicas: "2", k8s.ScaleTypeLabel: k8s.ScaleTypeCPU}
+
 	scenarios := []struct {
 		name     string
 		function *faasv1.Function
@@ -18,46 +22,70 @@ func Test_Replicas(t *testing.T) {
 		expected *int32
 	}{
 		{
-			"return nil replicas when label is missing and deployment does not exist",
+			"return the initial replicas when label is missing and deployment does not exist",
 			&faasv1.Function{},
 			nil,
-			nil,
+			int32p(1),
 		},
 		{
-			"return nil replicas when label is missing and deployment has no replicas",
-			&faasv1.Function{},
-			&appsv1.Deployment{},
+			"return min replicas when label is present and deployment does not exist",
+			&faasv1.Function{Spec: faasv1.FunctionSpec{Labels: minLabels}},
 			nil,
+			int32p(2),
 		},
 		{
 			"return min replicas when label is present and deployment has nil replicas",
-			&faasv1.Function{Spec: faasv1.FunctionSpec{Labels: &map[string]string{LabelMinReplicas: "2"}}},
+			&faasv1.Function{Spec: faasv1.FunctionSpec{Labels: minLabels}},
 			&appsv1.Deployment{Spec: appsv1.DeploymentSpec{Replicas: nil}},
 			int32p(2),
 		},
 		{
 			"return min replicas when label is present and deployment has replicas less than min",
-			&faasv1.Function{Spec: faasv1.FunctionSpec{Labels: &map[string]string{LabelMinReplicas: "2"}}},
+			&faasv1.Function{Spec: faasv1.FunctionSpec{Labels: minLabels}},
 			&appsv1.Deployment{Spec: appsv1.DeploymentSpec{Replicas: int32p(1)}},
 			int32p(2),
 		},
 		{
-			"return existing replicas when label is present and deployment has more replicas than min",
-			&faasv1.Function{Spec: faasv1.FunctionSpec{Labels: &map[string]string{LabelMinReplicas: "2"}}},
+			"return nil replicas when deployment has more replicas than min set by another manager",
+			&faasv1.Function{Spec: faasv1.FunctionSpec{Labels: minLabels}},
 			&appsv1.Deployment{Spec: appsv1.DeploymentSpec{Replicas: int32p(3)}},
+			nil,
+		},
+		{
+			"return nil replicas when deployment is scaled to zero",
+			&faasv1.Function{Spec: faasv1.FunctionSpec{Labels: minLabels}},
+			&appsv1.Deployment{Spec: appsv1.DeploymentSpec{Replicas: int32p(0)}},
+			nil,
+		},
+		{
+			"return existing replicas while they are owned by the faas-netes applier",
+			&faasv1.Function{Spec: faasv1.FunctionSpec{}},
+			replicasDeployment(3, metav1.ManagedFieldsOperationApply, replicasFields),
 			int32p(3),
 		},
 		{
-			"return existing replicas when label is missing and deployment has replicas set by HPA",
+			"return existing replicas while they are owned by the faas-netes Update entry",
 			&faasv1.Function{Spec: faasv1.FunctionSpec{}},
-			&appsv1.Deployment{Spec: appsv1.DeploymentSpec{Replicas: int32p(3)}},
+			replicasDeployment(3, metav1.ManagedFieldsOperationUpdate, replicasFields),
 			int32p(3),
 		},
 		{
-			"return zero replicas when label is present and deployment has zero replicas",
-			&faasv1.Function{Spec: faasv1.FunctionSpec{Labels: &map[string]string{LabelMinReplicas: "2"}}},
-			&appsv1.Deployment{Spec: appsv1.DeploymentSpec{Replicas: int32p(0)}},
-			int32p(0),
+			"return nil replicas when the faas-netes applier no longer owns them",
+			&faasv1.Function{Spec: faasv1.FunctionSpec{}},
+			replicasDeployment(3, metav1.ManagedFieldsOperationApply, `{"f:spec":{"f:template":{}}}`),
+			nil,
+		},
+		{
+			"return nil replicas when function uses an HPA and deployment does not exist",
+			&faasv1.Function{Spec: faasv1.FunctionSpec{Labels: hpaLabels}},
+			nil,
+			nil,
+		},
+		{
+			"return nil replicas when function uses an HPA and deployment has replicas less than min",
+			&faasv1.Function{Spec: faasv1.FunctionSpec{Labels: hpaLabels}},
+			replicasDeployment(1, metav1.ManagedFieldsOperationApply, replicasFields),
+			nil,
 		},
 	}
 
@@ -69,7 +97,10 @@ func Test_Replicas(t *testing.T) {
 
 	for _, s := range scenarios {
 		t.Run(s.name, func(t *testing.T) {
-			deploy := newDeployment(s.function, s.deploy, nil, factory)
+			deploy, _, err := newDeployment(s.function, s.deploy, nil, factory)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
 			value := deploy.Spec.Replicas
 
 			if s.expected != nil && value != nil {
@@ -82,3 +113,20 @@ func Test_Replicas(t *testing.T) {
 		})
 	}
 }
+
+const replicasFields = `{"f:spec":{"f:replicas":{},"f:template":{}}}`
+
+func replicasDeployment(replicas int32, operation metav1.ManagedFieldsOperationType, fields string) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			ManagedFields: []metav1.ManagedFieldsEntry{
+				{
+					Manager:   k8s.FieldManager,
+					Operation: operation,
+					FieldsV1:  &metav1.FieldsV1{Raw: []byte(fields)},
+				},
+			},
+		},
+		Spec: appsv1.DeploymentSpec{Replicas: int32p(replicas)},
+	}
+}
@@ -4,7 +4,6 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
-	"k8s.io/apimachinery/pkg/util/intstr"
 
 	faasv1 "github.com/openfaas/faas-netes/pkg/apis/openfaas/v1"
 )
@@ -12,34 +11,20 @@ import (
 // newService creates a new ClusterIP Service for a Function resource. It also sets
 // the appropriate OwnerReferences on the resource so handleObject can discover
 // the Function resource that 'owns' it.
-func newService(function *faasv1.Function) *corev1.Service {
-	return &corev1.Service{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:        function.Spec.Name,
-			Namespace:   function.Namespace,
-			Annotations: map[string]string{"prometheus.io.scrape": "false"},
-			OwnerReferences: []metav1.OwnerReference{
-				*metav1.NewControllerRef(function, schema.GroupVersionKind{
-					Group:   faasv1.SchemeGroupVersion.Group,
-					Version: faasv1.SchemeGroupVersion.Version,
-					Kind:    faasKind,
-				}),
-			},
-		},
-		Spec: corev1.ServiceSpec{
-			Type:     corev1.ServiceTypeClusterIP,
-			Selector: map[string]string{"faas_function": function.Spec.Name},
-			Ports: []corev1.ServicePort{
-				{
-					Name:     "http",
-					Protocol: corev1.ProtocolTCP,
-					Port:     functionPort,
-					TargetPort: intstr.IntOrString{
-						Type:   intstr.Int,
-						IntVal: int32(functionPort),
-					},
-				},
-			},
-		},
+func newService(function *faasv1.Function, factory FunctionFactory) *corev1.Service {
+	service := factory.Factory.MakeService(functionToFunctionRequest(function))
+	service.OwnerReferences = []metav1.OwnerReference{
+		*newFunctionOwnerReference(function),
 	}
+	return service
+}
+
+// newFunctionOwnerReference returns the controller OwnerReference that links
+// the objects created for a Function back to it
+func newFunctionOwnerReference(function *faasv1.Function) *metav1.OwnerReference {
+	return metav1.NewControllerRef(function, schema.GroupVersionKind{
+		Group:   faasv1.SchemeGroupVersion.Group,
+		Version: faasv1.SchemeGroupVersion.Version,
+		Kind:    faasKind,
+	})
 }
@@ -0,0 +1,247 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	faasv1 "github.com/openfaas/faas-netes/pkg/apis/openfaas/v1"
+	"github.com/openfaas/faas-netes/pkg/k8s"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/tools/cache"
+	glog "k8s.io/klog"
+)
+
+const (
+	// ReasonSecretNotFound is used as the condition reason when a Function
+	// references a secret that does not exist
+	ReasonSecretNotFound = "SecretNotFound"
+	// ReasonProfileNotFound is used as the condition reason when a Function
+	// references a profile that can not be retrieved
+	ReasonProfileNotFound = "ProfileNotFound"
+	// ReasonSyncFailed is used as the condition reason when the Deployment or
+	// Service of a Function could not be created or updated
+	ReasonSyncFailed = "SyncFailed"
+	// ReasonInvalidSpec is used as the condition reason when the Deployment
+	// can not be built from the Function spec
+	ReasonInvalidSpec = "InvalidSpec"
+	// ReasonDegraded is used as the condition reason when a Function was
+	// deployed without some of its Secrets or Profiles, see FailurePolicyDegrade
+	ReasonDegraded = "Degraded"
+)
+
+// conditionError is returned from the reconcile steps to record which
+// Function condition caused the reconcile to fail
+type conditionError struct {
+	condition faasv1.FunctionConditionType
+	reason    string
+	err       error
+	// degraded is set when the Function was still deployed
+	degraded bool
+}
+
+func (e *conditionError) Error() string {
+	return e.err.Error()
+}
+
+// profileResult is the outcome of resolving the Profiles of a Function while
+// its Deployment is built, err names the Profiles which could not be found
+type profileResult struct {
+	err error
+}
+
+// syncStatus computes the Function status from the Deployment and the outcome
+// of the reconcile, the status is only written when it has changed so that
+// status updates do not cause a reconcile loop. The ProfilesApplied condition
+// is kept as it is when the reconcile stopped before the Profiles were
+// resolved, profiles is nil then.
+func (c *Controller) syncStatus(function *faasv1.Function, deployment *appsv1.Deployment, profiles *profileResult, syncErr error) error {
+	status := function.Status.DeepCopy()
+	status.ObservedGeneration = function.Generation
+
+	failed := map[faasv1.FunctionConditionType]*conditionError{}
+	condErrs := asConditionErrors(syncErr)
+	for _, condErr := range condErrs {
+		failed[condErr.condition] = condErr
+	}
+
+	if condErr, ok := failed[faasv1.FunctionSecretsResolved]; ok {
+		setFunctionCondition(status, faasv1.FunctionSecretsResolved, corev1.ConditionFalse, condErr.reason, condErr.Error())
+	} else {
+		setFunctionCondition(status, faasv1.FunctionSecretsResolved, corev1.ConditionTrue, "SecretsFound", "")
+	}
+
+	if profiles != nil {
+		if profiles.err != nil {
+			setFunctionCondition(status, faasv1.FunctionProfilesApplied, corev1.ConditionFalse, ReasonProfileNotFound, profiles.err.Error())
+		} else {
+			setFunctionCondition(status, faasv1.FunctionProfilesApplied, corev1.ConditionTrue, "ProfilesFound", "")
+		}
+	}
+
+	if condErrs.degraded() {
+		setFunctionCondition(status, faasv1.FunctionDeployed, corev1.ConditionTrue, ReasonDegraded, syncErr.Error())
+	} else if syncErr != nil {
+		reason := ReasonSyncFailed
+		if len(condErrs) > 0 {
+			reason = condErrs[0].reason
+		}
+		setFunctionCondition(status, faasv1.FunctionDeployed, corev1.ConditionFalse, reason, syncErr.Error())
+	} else {
+		setFunctionCondition(status, faasv1.FunctionDeployed, corev1.ConditionTrue, SuccessSynced, MessageResourceSynced)
+	}
+
+	c.setDeploymentStatus(function, status, deployment)
+	return c.writeStatus(function, status)
+}
+
+// syncStatusHandler updates the replicas and the Ready condition of the
+// Function from its Deployment, without a reconcile. It is used when only the
+// status of the Deployment changed.
+func (c *Controller) syncStatusHandler(key string) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		runtime.HandleError(fmt.Errorf("invalid resource key: %s", key))
+		return nil
+	}
+
+	function, err := c.functionsLister.Functions(namespace).Get(name)
+	if errors.IsNotFound(err) || (err == nil && function.DeletionTimestamp != nil) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	deployment, err := c.deploymentsLister.Deployments(namespace).Get(function.Spec.Name)
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	// the reconcile reports Deployments which the Function does not control
+	if !metav1.IsControlledBy(deployment, function) {
+		return nil
+	}
+
+	status := function.Status.DeepCopy()
+	c.setDeploymentStatus(function, status, deployment)
+	return c.writeStatus(function, status)
+}
+
+// setDeploymentStatus sets the replicas, the image digest, the scaling window
+// and the Ready condition of the status from the Deployment
+func (c *Controller) setDeploymentStatus(function *faasv1.Function, status *faasv1.FunctionStatus, deployment *appsv1.Deployment) {
+	if deployment == nil || len(deployment.Name) == 0 {
+		status.Replicas = 0
+		status.AvailableReplicas = 0
+		setFunctionCondition(status, faasv1.FunctionReady, corev1.ConditionFalse, "DeploymentNotFound", "")
+	} else {
+		status.Replicas = deployment.Status.Replicas
+		status.AvailableReplicas = deployment.Status.AvailableReplicas
+		status.ImageDigest = c.getImageDigest(function)
+		status.ScalingWindow = deployment.Annotations[k8s.ActiveScalingWindowAnnotation]
+
+		switch {
+		case deployment.Spec.Replicas != nil && *deployment.Spec.Replicas == 0:
+			setFunctionCondition(status, faasv1.FunctionReady, corev1.ConditionTrue, "ScaledToZero", "")
+		case deployment.Status.AvailableReplicas > 0:
+			setFunctionCondition(status, faasv1.FunctionReady, corev1.ConditionTrue, "MinimumReplicasAvailable", "")
+		default:
+			setFunctionCondition(status, faasv1.FunctionReady, corev1.ConditionFalse, "NoReplicasAvailable",
+				fmt.Sprintf("%d/%d replicas available", deployment.Status.AvailableReplicas, deployment.Status.Replicas))
+		}
+	}
+}
+
+// writeStatus updates the status of the Function when it differs from the
+// status that was read
+func (c *Controller) writeStatus(function *faasv1.Function, status *faasv1.FunctionStatus) error {
+	if equality.Semantic.DeepEqual(&function.Status, status) {
+		return nil
+	}
+
+	updated := function.DeepCopy()
+	updated.Status = *status
+
+	glog.V(2).Infof("Updating status for '%s'", function.Spec.Name)
+	_, err := c.faasclientset.OpenfaasV1().Functions(function.Namespace).UpdateStatus(context.TODO(), updated, metav1.UpdateOptions{})
+	return err
+}
+
+// getImageDigest returns the image reference including the digest of the
+// function container in one of the ready function Pods
+func (c *Controller) getImageDigest(function *faasv1.Function) string {
+	if c.podsLister == nil {
+		return ""
+	}
+
+	selector := labels.SelectorFromSet(map[string]string{"faas_function": function.Spec.Name})
+	pods, err := c.podsLister.Pods(function.Namespace).List(selector)
+	if err != nil {
+		glog.Warningf("Function %s pods listing failed: %v", function.Spec.Name, err)
+		return ""
+	}
+
+	for _, pod := range pods {
+		for _, container := range pod.Status.ContainerStatuses {
+			if container.Name != function.Spec.Name || !container.Ready || len(container.ImageID) == 0 {
+				continue
+			}
+
+			// the container runtime may prefix the reference with a scheme
+			// such as docker-pullable://
+			imageID := container.ImageID
+			if i := strings.Index(imageID, "://"); i >= 0 {
+				imageID = imageID[i+3:]
+			}
+			return imageID
+		}
+	}
+
+	return ""
+}
+
+// setFunctionCondition adds or updates the condition of the given type, the
+// LastTransitionTime is only changed when the condition status changes
+func setFunctionCondition(status *faasv1.FunctionStatus, conditionType faasv1.FunctionConditionType, conditionStatus corev1.ConditionStatus, reason, message string) {
+	condition := faasv1.FunctionCondition{
+		Type:               conditionType,
+		Status:             conditionStatus,
+		LastTransitionTime: metav1.Now(),
+		Reason:             reason,
+		Message:            message,
+	}
+
+	for i, existing := range status.Conditions {
+		if existing.Type != conditionType {
+			continue
+		}
+
+		if existing.Status == conditionStatus {
+			condition.LastTransitionTime = existing.LastTransitionTime
+		}
+		status.Conditions[i] = condition
+		return
+	}
+
+	status.Conditions = append(status.Conditions, condition)
+}
+
+// getFunctionCondition returns the condition of the given type or nil when
+// the condition has not been set
+func getFunctionCondition(status faasv1.FunctionStatus, conditionType faasv1.FunctionConditionType) *faasv1.FunctionCondition {
+	for i := range status.Conditions {
+		if status.Conditions[i].Type == conditionType {
+			return &status.Conditions[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,230 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	faasv1 "github.com/openfaas/faas-netes/pkg/apis/openfaas/v1"
+	"github.com/openfaas/faas-netes/pkg/client/clientset/versioned/fake"
+	listers "github.com/openfaas/faas-netes/pkg/client/listers/openfaas/v1"
+	"github.com/openfaas/faas-netes/pkg/k8s"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func Test_setFunctionCondition_KeepsTransitionTimeWhenStatusIsUnchanged(t *testing.T) {
+	transition := metav1.NewTime(time.Now().Add(-time.Hour))
+	status := &faasv1.FunctionStatus{
+		Conditions: []faasv1.FunctionCondition{
+			{Type: faasv1.FunctionReady, Status: corev1.ConditionTrue, LastTransitionTime: transition},
+		},
+	}
+
+	setFunctionCondition(status, faasv1.FunctionReady, corev1.ConditionTrue, "MinimumReplicasAvailable", "")
+
+	got := getFunctionCondition(*status, faasv1.FunctionReady)
+	if got == nil {
+		t.Fatal("expected Ready condition to be set")
+	}
+	if !got.LastTransitionTime.Equal(&transition) {
+		t.Errorf("want LastTransitionTime %s, got %s", transition, got.LastTransitionTime)
+	}
+	if got.Reason != "MinimumReplicasAvailable" {
+		t.Errorf("want reason %s, got %s", "MinimumReplicasAvailable", got.Reason)
+	}
+
+	setFunctionCondition(status, faasv1.FunctionReady, corev1.ConditionFalse, "NoReplicasAvailable", "")
+
+	got = getFunctionCondition(*status, faasv1.FunctionReady)
+	if got.LastTransitionTime.Equal(&transition) {
+		t.Errorf("want LastTransitionTime to change when status changes")
+	}
+	if len(status.Conditions) != 1 {
+		t.Errorf("want 1 condition, got %d", len(status.Conditions))
+	}
+}
+
+func Test_syncStatus(t *testing.T) {
+	function := &faasv1.Function{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       "nodeinfo",
+			Namespace:  "openfaas-fn",
+			Generation: 2,
+		},
+		Spec: faasv1.FunctionSpec{
+			Name:  "nodeinfo",
+			Image: "functions/nodeinfo",
+		},
+	}
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "nodeinfo", Namespace: "openfaas-fn"},
+		Spec:       appsv1.DeploymentSpec{Replicas: int32p(2)},
+		Status:     appsv1.DeploymentStatus{Replicas: 2, AvailableReplicas: 1},
+	}
+
+	cases := []struct {
+		name         string
+		profiles     *profileResult
+		syncErr      error
+		wantDeploy   corev1.ConditionStatus
+		wantSecrets  corev1.ConditionStatus
+		wantProfiles corev1.ConditionStatus
+	}{
+		{
+			name:         "successful sync",
+			profiles:     &profileResult{},
+			wantDeploy:   corev1.ConditionTrue,
+			wantSecrets:  corev1.ConditionTrue,
+			wantProfiles: corev1.ConditionTrue,
+		},
+		{
+			name:     "missing profile",
+			profiles: &profileResult{err: fmt.Errorf("profile not found")},
+			syncErr: &conditionError{
+				condition: faasv1.FunctionProfilesApplied,
+				reason:    ReasonProfileNotFound,
+				err:       fmt.Errorf("profile not found"),
+			},
+			wantDeploy:   corev1.ConditionFalse,
+			wantSecrets:  corev1.ConditionTrue,
+			wantProfiles: corev1.ConditionFalse,
+		},
+		{
+			name:        "profiles not resolved",
+			syncErr:     fmt.Errorf("transient error"),
+			wantDeploy:  corev1.ConditionFalse,
+			wantSecrets: corev1.ConditionTrue,
+		},
+		{
+			name: "missing secret",
+			syncErr: &conditionError{
+				condition: faasv1.FunctionSecretsResolved,
+				reason:    ReasonSecretNotFound,
+				err:       fmt.Errorf("secret not found"),
+			},
+			wantDeploy:  corev1.ConditionFalse,
+			wantSecrets: corev1.ConditionFalse,
+		},
+		{
+			name: "deployed without a missing secret",
+			syncErr: conditionErrors{{
+				condition: faasv1.FunctionSecretsResolved,
+				reason:    ReasonSecretNotFound,
+				err:       fmt.Errorf("secret not found"),
+				degraded:  true,
+			}},
+			wantDeploy:  corev1.ConditionTrue,
+			wantSecrets: corev1.ConditionFalse,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset(function)
+			c := &Controller{
+				faasclientset: client,
+				factory:       NewFunctionFactory(kubefake.NewSimpleClientset(), k8s.DeploymentConfig{}),
+			}
+
+			if err := c.syncStatus(function, deployment, tc.profiles, tc.syncErr); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			got, err := client.OpenfaasV1().Functions(function.Namespace).Get(context.TODO(), function.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if got.Status.ObservedGeneration != 2 {
+				t.Errorf("want observedGeneration 2, got %d", got.Status.ObservedGeneration)
+			}
+			if got.Status.Replicas != 2 || got.Status.AvailableReplicas != 1 {
+				t.Errorf("want 1/2 replicas, got %d/%d", got.Status.AvailableReplicas, got.Status.Replicas)
+			}
+			if cond := getFunctionCondition(got.Status, faasv1.FunctionDeployed); cond == nil || cond.Status != tc.wantDeploy {
+				t.Errorf("want Deployed condition %s, got %+v", tc.wantDeploy, cond)
+			}
+			if cond := getFunctionCondition(got.Status, faasv1.FunctionSecretsResolved); cond == nil || cond.Status != tc.wantSecrets {
+				t.Errorf("want SecretsResolved condition %s, got %+v", tc.wantSecrets, cond)
+			}
+			if cond := getFunctionCondition(got.Status, faasv1.FunctionProfilesApplied); tc.wantProfiles == "" && cond != nil {
+				t.Errorf("want no ProfilesApplied condition when the profiles were not resolved, got %+v", cond)
+			} else if tc.wantProfiles != "" && (cond == nil || cond.Status != tc.wantProfiles) {
+				t.Errorf("want ProfilesApplied condition %s, got %+v", tc.wantProfiles, cond)
+			}
+			if cond := getFunctionCondition(got.Status, faasv1.FunctionReady); cond == nil || cond.Status != corev1.ConditionTrue {
+				t.Errorf("want Ready condition %s, got %+v", corev1.ConditionTrue, cond)
+			}
+
+			// a second sync with the same outcome must not write the status again
+			client.ClearActions()
+			if err := c.syncStatus(got, deployment, tc.profiles, tc.syncErr); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if len(client.Actions()) != 0 {
+				t.Errorf("want no status update when status is unchanged, got %d actions", len(client.Actions()))
+			}
+		})
+	}
+}
+
+func Test_syncStatusHandler_UpdatesReplicasOnly(t *testing.T) {
+	deployed := faasv1.FunctionCondition{Type: faasv1.FunctionDeployed, Status: corev1.ConditionTrue, Reason: SuccessSynced}
+	function := &faasv1.Function{
+		ObjectMeta: metav1.ObjectMeta{Name: "nodeinfo", Namespace: "openfaas-fn", UID: "function-uid", Generation: 3},
+		Spec:       faasv1.FunctionSpec{Name: "nodeinfo", Image: "functions/nodeinfo"},
+		Status: faasv1.FunctionStatus{
+			ObservedGeneration: 2,
+			Conditions:         []faasv1.FunctionCondition{deployed},
+		},
+	}
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "nodeinfo",
+			Namespace:       "openfaas-fn",
+			OwnerReferences: []metav1.OwnerReference{*newFunctionOwnerReference(function)},
+		},
+		Spec:   appsv1.DeploymentSpec{Replicas: int32p(2)},
+		Status: appsv1.DeploymentStatus{Replicas: 2, AvailableReplicas: 2},
+	}
+
+	functions := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	functions.Add(function)
+	deployments := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	deployments.Add(deployment)
+
+	client := fake.NewSimpleClientset(function)
+	c := &Controller{
+		faasclientset:     client,
+		functionsLister:   listers.NewFunctionLister(functions),
+		deploymentsLister: appslisters.NewDeploymentLister(deployments),
+	}
+
+	if err := c.syncStatusHandler("openfaas-fn/nodeinfo"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	got, err := client.OpenfaasV1().Functions(function.Namespace).Get(context.TODO(), function.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if got.Status.AvailableReplicas != 2 {
+		t.Errorf("want 2 available replicas, got %d", got.Status.AvailableReplicas)
+	}
+	if cond := getFunctionCondition(got.Status, faasv1.FunctionReady); cond == nil || cond.Status != corev1.ConditionTrue {
+		t.Errorf("want Ready condition %s, got %+v", corev1.ConditionTrue, cond)
+	}
+	if got.Status.ObservedGeneration != 2 {
+		t.Errorf("want the observedGeneration of the last reconcile, got %d", got.Status.ObservedGeneration)
+	}
+	if cond := getFunctionCondition(got.Status, faasv1.FunctionDeployed); cond == nil || cond.Reason != SuccessSynced {
+		t.Errorf("want the Deployed condition to be kept, got %+v", cond)
+	}
+}
@@ -0,0 +1,24 @@
+// Copyright 2020 OpenFaaS Author(s)
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package handlers
+
+import (
+	"github.com/openfaas/faas-provider/auth"
+	"github.com/openfaas/faas-provider/types"
+)
+
+// ReadBasicAuth reads the credentials of the API when basic auth is enabled,
+// like the provider does for its FaaSHandlers. They protect the routes which
+// are added to the router of the provider. nil is returned when basic auth is
+// disabled.
+func ReadBasicAuth(config types.FaaSConfig) (*auth.BasicAuthCredentials, error) {
+	if !config.EnableBasicAuth {
+		return nil, nil
+	}
+
+	reader := auth.ReadBasicAuthFromDisk{
+		SecretMountPath: config.SecretMountPath,
+	}
+	return reader.Read()
+}
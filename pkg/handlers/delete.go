@@ -11,6 +11,7 @@ import (
 	"io/ioutil"
 	"net/http"
 
+	"github.com/openfaas/faas-netes/pkg/k8s"
 	"github.com/openfaas/faas/gateway/requests"
 	appsv1 "k8s.io/api/apps/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
@@ -51,12 +52,17 @@ func MakeDeleteHandler(defaultNamespace string, clientset *kubernetes.Clientset)
 			return
 		}
 
+		// revisions are deleted as payments@v2 or by the name of their Deployment
+		if function, revision, err := k8s.ParseRevision(request.FunctionName); err == nil && len(revision) > 0 {
+			request.FunctionName = k8s.RevisionName(function, revision)
+		}
+
 		getOpts := metav1.GetOptions{}
 
 		// This makes sure we don't delete non-labelled deployments
 		deployment, findDeployErr := clientset.AppsV1().
 			Deployments(lookupNamespace).
-			Get(context.TODO(), request.FunctionName, getOpts)
+			Get(r.Context(), request.FunctionName, getOpts)
 
 		if findDeployErr != nil {
 			if errors.IsNotFound(findDeployErr) {
@@ -70,7 +76,7 @@ func MakeDeleteHandler(defaultNamespace string, clientset *kubernetes.Clientset)
 		}
 
 		if isFunction(deployment) {
-			err := deleteFunction(lookupNamespace, clientset, request, w)
+			err := deleteFunction(r.Context(), lookupNamespace, clientset, request, w)
 			if err != nil {
 				return
 			}
@@ -95,12 +101,12 @@ func isFunction(deployment *appsv1.Deployment) bool {
 	return false
 }
 
-func deleteFunction(functionNamespace string, clientset *kubernetes.Clientset, request requests.DeleteFunctionRequest, w http.ResponseWriter) error {
+func deleteFunction(ctx context.Context, functionNamespace string, clientset *kubernetes.Clientset, request requests.DeleteFunctionRequest, w http.ResponseWriter) error {
 	foregroundPolicy := metav1.DeletePropagationForeground
 	opts := &metav1.DeleteOptions{PropagationPolicy: &foregroundPolicy}
 
 	if deployErr := clientset.AppsV1().Deployments(functionNamespace).
-		Delete(context.TODO(), request.FunctionName, *opts); deployErr != nil {
+		Delete(ctx, request.FunctionName, *opts); deployErr != nil {
 
 		if errors.IsNotFound(deployErr) {
 			w.WriteHeader(http.StatusNotFound)
@@ -113,7 +119,7 @@ func deleteFunction(functionNamespace string, clientset *kubernetes.Clientset, r
 
 	if svcErr := clientset.CoreV1().
 		Services(functionNamespace).
-		Delete(context.TODO(), request.FunctionName, *opts); svcErr != nil {
+		Delete(ctx, request.FunctionName, *opts); svcErr != nil {
 
 		if errors.IsNotFound(svcErr) {
 			w.WriteHeader(http.StatusNotFound)
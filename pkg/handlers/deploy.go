@@ -5,30 +5,19 @@
 package handlers
 
 import (
-	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
-	"sort"
-	"strconv"
-	"strings"
+	"time"
 
 	"github.com/openfaas/faas-netes/pkg/k8s"
 
 	types "github.com/openfaas/faas-provider/types"
-	appsv1 "k8s.io/api/apps/v1"
-	apiv1 "k8s.io/api/core/v1"
-	corev1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
-// initialReplicasCount how many replicas to start of creating for a function
-const initialReplicasCount = 1
-
 // MakeDeployHandler creates a handler to create new functions in the cluster
 func MakeDeployHandler(functionNamespace string, factory k8s.FunctionFactory) http.HandlerFunc {
 	secrets := k8s.NewSecretsClient(factory.Client)
@@ -50,6 +39,12 @@ func MakeDeployHandler(functionNamespace string, factory k8s.FunctionFactory) ht
 			return
 		}
 
+		if err := k8s.SetRevision(&request); err != nil {
+			wrappedErr := fmt.Errorf("validation failed: %s", err.Error())
+			http.Error(w, wrappedErr.Error(), http.StatusBadRequest)
+			return
+		}
+
 		if err := ValidateDeployRequest(&request); err != nil {
 			wrappedErr := fmt.Errorf("validation failed: %s", err.Error())
 			http.Error(w, wrappedErr.Error(), http.StatusBadRequest)
@@ -61,6 +56,23 @@ func MakeDeployHandler(functionNamespace string, factory k8s.FunctionFactory) ht
 			namespace = request.Namespace
 		}
 
+		labels := map[string]string{}
+		if request.Labels != nil {
+			labels = *request.Labels
+		}
+		if _, err := k8s.MakeHorizontalPodAutoscaler(request.Service, namespace, labels); err != nil {
+			wrappedErr := fmt.Errorf("validation failed: %s", err.Error())
+			http.Error(w, wrappedErr.Error(), http.StatusBadRequest)
+			return
+		}
+		if request.Annotations != nil {
+			if _, err := k8s.ParseScalingSchedule(*request.Annotations, time.UTC); err != nil {
+				wrappedErr := fmt.Errorf("validation failed: %s", err.Error())
+				http.Error(w, wrappedErr.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
 		existingSecrets, err := secrets.GetSecrets(namespace, request.Secrets)
 		if err != nil {
 			wrappedErr := fmt.Errorf("unable to fetch secrets: %s", err.Error())
@@ -68,7 +80,14 @@ func MakeDeployHandler(functionNamespace string, factory k8s.FunctionFactory) ht
 			return
 		}
 
-		deploymentSpec, specErr := makeDeploymentSpec(request, existingSecrets, factory)
+		request.Namespace = namespace
+		deploymentSpec, err := factory.MakeDeployment(request, existingSecrets)
+		if err != nil {
+			wrappedErr := fmt.Errorf("failed create Deployment spec: %s", err.Error())
+			log.Println(wrappedErr)
+			http.Error(w, wrappedErr.Error(), http.StatusBadRequest)
+			return
+		}
 
 		var profileList []k8s.Profile
 		if request.Annotations != nil {
@@ -85,16 +104,11 @@ func MakeDeployHandler(functionNamespace string, factory k8s.FunctionFactory) ht
 			factory.ApplyProfile(profile, deploymentSpec)
 		}
 
-		if specErr != nil {
-			wrappedErr := fmt.Errorf("failed create Deployment spec: %s", specErr.Error())
-			log.Println(wrappedErr)
-			http.Error(w, wrappedErr.Error(), http.StatusBadRequest)
-			return
-		}
+		k8s.SetConfigHash(deploymentSpec, k8s.ConfigHash(existingSecrets, profileList))
 
 		deploy := factory.Client.AppsV1().Deployments(namespace)
 
-		_, err = deploy.Create(context.TODO(), deploymentSpec, metav1.CreateOptions{})
+		created, err := deploy.Create(ctx, deploymentSpec, metav1.CreateOptions{})
 		if err != nil {
 			wrappedErr := fmt.Errorf("unable create Deployment: %s", err.Error())
 			log.Println(wrappedErr)
@@ -104,9 +118,18 @@ func MakeDeployHandler(functionNamespace string, factory k8s.FunctionFactory) ht
 
 		log.Printf("Deployment created: %s.%s\n", request.Service, namespace)
 
+		// the HPA is owned by the Deployment, so it is deleted with the function
+		owner := k8s.NewDeploymentOwnerReference(created)
+		if err := k8s.SyncHorizontalPodAutoscaler(ctx, factory.Dynamic, request.Service, namespace, labels, owner); err != nil {
+			wrappedErr := fmt.Errorf("failed create HorizontalPodAutoscaler: %s", err.Error())
+			log.Println(wrappedErr)
+			http.Error(w, wrappedErr.Error(), http.StatusInternalServerError)
+			return
+		}
+
 		service := factory.Client.CoreV1().Services(namespace)
-		serviceSpec := makeServiceSpec(request, factory)
-		_, err = service.Create(context.TODO(), serviceSpec, metav1.CreateOptions{})
+		serviceSpec := factory.MakeService(request)
+		_, err = service.Create(ctx, serviceSpec, metav1.CreateOptions{})
 
 		if err != nil {
 			wrappedErr := fmt.Errorf("failed create Service: %s", err.Error())
@@ -120,284 +143,3 @@ func MakeDeployHandler(functionNamespace string, factory k8s.FunctionFactory) ht
 		w.WriteHeader(http.StatusAccepted)
 	}
 }
-
-func makeDeploymentSpec(request types.FunctionDeployment, existingSecrets map[string]*apiv1.Secret, factory k8s.FunctionFactory) (*appsv1.Deployment, error) {
-	envVars := buildEnvVars(&request)
-
-	initialReplicas := int32p(initialReplicasCount)
-	labels := map[string]string{
-		"faas_function": request.Service,
-	}
-
-	if request.Labels != nil {
-		if min := getMinReplicaCount(*request.Labels); min != nil {
-			initialReplicas = min
-		}
-		for k, v := range *request.Labels {
-			labels[k] = v
-		}
-	}
-
-	nodeSelector := createSelector(request.Constraints)
-
-	resources, resourceErr := createResources(request)
-
-	if resourceErr != nil {
-		return nil, resourceErr
-	}
-
-	var imagePullPolicy apiv1.PullPolicy
-	switch factory.Config.ImagePullPolicy {
-	case "Never":
-		imagePullPolicy = apiv1.PullNever
-	case "IfNotPresent":
-		imagePullPolicy = apiv1.PullIfNotPresent
-	default:
-		imagePullPolicy = apiv1.PullAlways
-	}
-
-	annotations := buildAnnotations(request)
-
-	var serviceAccount string
-
-	if request.Annotations != nil {
-		annotations := *request.Annotations
-		if val, ok := annotations["com.openfaas.serviceaccount"]; ok && len(val) > 0 {
-			serviceAccount = val
-		}
-	}
-
-	probes, err := factory.MakeProbes(request)
-	if err != nil {
-		return nil, err
-	}
-
-	enableServiceLinks := false
-	allowPrivilegeEscalation := false
-
-	deploymentSpec := &appsv1.Deployment{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:        request.Service,
-			Annotations: annotations,
-			Labels: map[string]string{
-				"faas_function": request.Service,
-			},
-		},
-		Spec: appsv1.DeploymentSpec{
-			Selector: &metav1.LabelSelector{
-				MatchLabels: map[string]string{
-					"faas_function": request.Service,
-				},
-			},
-			Replicas: initialReplicas,
-			Strategy: appsv1.DeploymentStrategy{
-				Type: appsv1.RollingUpdateDeploymentStrategyType,
-				RollingUpdate: &appsv1.RollingUpdateDeployment{
-					MaxUnavailable: &intstr.IntOrString{
-						Type:   intstr.Int,
-						IntVal: int32(0),
-					},
-					MaxSurge: &intstr.IntOrString{
-						Type:   intstr.Int,
-						IntVal: int32(1),
-					},
-				},
-			},
-			RevisionHistoryLimit: int32p(10),
-			Template: apiv1.PodTemplateSpec{
-				ObjectMeta: metav1.ObjectMeta{
-					Name:        request.Service,
-					Labels:      labels,
-					Annotations: annotations,
-				},
-				Spec: apiv1.PodSpec{
-					NodeSelector: nodeSelector,
-					Containers: []apiv1.Container{
-						{
-							Name:  request.Service,
-							Image: request.Image,
-							Ports: []apiv1.ContainerPort{
-								{
-									Name:          "http",
-									ContainerPort: factory.Config.RuntimeHTTPPort,
-									Protocol:      corev1.ProtocolTCP,
-								},
-							},
-							Env:             envVars,
-							Resources:       *resources,
-							ImagePullPolicy: imagePullPolicy,
-							LivenessProbe:   probes.Liveness,
-							ReadinessProbe:  probes.Readiness,
-							SecurityContext: &corev1.SecurityContext{
-								ReadOnlyRootFilesystem:   &request.ReadOnlyRootFilesystem,
-								AllowPrivilegeEscalation: &allowPrivilegeEscalation,
-							},
-						},
-					},
-					ServiceAccountName: serviceAccount,
-					RestartPolicy:      corev1.RestartPolicyAlways,
-					DNSPolicy:          corev1.DNSClusterFirst,
-					// EnableServiceLinks injects ENV vars about every other service within
-					// the namespace.
-					EnableServiceLinks: &enableServiceLinks,
-				},
-			},
-		},
-	}
-
-	factory.ConfigureReadOnlyRootFilesystem(request, deploymentSpec)
-	factory.ConfigureContainerUserID(deploymentSpec)
-
-	if err := factory.ConfigureSecrets(request, deploymentSpec, existingSecrets); err != nil {
-		return nil, err
-	}
-
-	return deploymentSpec, nil
-}
-
-func makeServiceSpec(request types.FunctionDeployment, factory k8s.FunctionFactory) *corev1.Service {
-
-	serviceSpec := &corev1.Service{
-		TypeMeta: metav1.TypeMeta{
-			Kind:       "Service",
-			APIVersion: "v1",
-		},
-		ObjectMeta: metav1.ObjectMeta{
-			Name:        request.Service,
-			Annotations: buildAnnotations(request),
-		},
-		Spec: corev1.ServiceSpec{
-			Type: corev1.ServiceTypeClusterIP,
-			Selector: map[string]string{
-				"faas_function": request.Service,
-			},
-			Ports: []corev1.ServicePort{
-				{
-					Name:     "http",
-					Protocol: corev1.ProtocolTCP,
-					Port:     factory.Config.RuntimeHTTPPort,
-					TargetPort: intstr.IntOrString{
-						Type:   intstr.Int,
-						IntVal: factory.Config.RuntimeHTTPPort,
-					},
-				},
-			},
-		},
-	}
-
-	return serviceSpec
-}
-
-func buildAnnotations(request types.FunctionDeployment) map[string]string {
-	var annotations map[string]string
-	if request.Annotations != nil {
-		annotations = *request.Annotations
-	} else {
-		annotations = map[string]string{}
-	}
-
-	if _, ok := annotations["prometheus.io.scrape"]; !ok {
-		annotations["prometheus.io.scrape"] = "false"
-	}
-	return annotations
-}
-
-func buildEnvVars(request *types.FunctionDeployment) []corev1.EnvVar {
-	envVars := []corev1.EnvVar{}
-
-	if len(request.EnvProcess) > 0 {
-		envVars = append(envVars, corev1.EnvVar{
-			Name:  k8s.EnvProcessName,
-			Value: request.EnvProcess,
-		})
-	}
-
-	for k, v := range request.EnvVars {
-		envVars = append(envVars, corev1.EnvVar{
-			Name:  k,
-			Value: v,
-		})
-	}
-
-	sort.SliceStable(envVars, func(i, j int) bool {
-		return strings.Compare(envVars[i].Name, envVars[j].Name) == -1
-	})
-
-	return envVars
-}
-
-func int32p(i int32) *int32 {
-	return &i
-}
-
-func createSelector(constraints []string) map[string]string {
-	selector := make(map[string]string)
-
-	if len(constraints) > 0 {
-		for _, constraint := range constraints {
-			parts := strings.Split(constraint, "=")
-
-			if len(parts) == 2 {
-				selector[parts[0]] = parts[1]
-			}
-		}
-	}
-
-	return selector
-}
-
-func createResources(request types.FunctionDeployment) (*apiv1.ResourceRequirements, error) {
-	resources := &apiv1.ResourceRequirements{
-		Limits:   apiv1.ResourceList{},
-		Requests: apiv1.ResourceList{},
-	}
-
-	// Set Memory limits
-	if request.Limits != nil && len(request.Limits.Memory) > 0 {
-		qty, err := resource.ParseQuantity(request.Limits.Memory)
-		if err != nil {
-			return resources, err
-		}
-		resources.Limits[apiv1.ResourceMemory] = qty
-	}
-
-	if request.Requests != nil && len(request.Requests.Memory) > 0 {
-		qty, err := resource.ParseQuantity(request.Requests.Memory)
-		if err != nil {
-			return resources, err
-		}
-		resources.Requests[apiv1.ResourceMemory] = qty
-	}
-
-	// Set CPU limits
-	if request.Limits != nil && len(request.Limits.CPU) > 0 {
-		qty, err := resource.ParseQuantity(request.Limits.CPU)
-		if err != nil {
-			return resources, err
-		}
-		resources.Limits[apiv1.ResourceCPU] = qty
-	}
-
-	if request.Requests != nil && len(request.Requests.CPU) > 0 {
-		qty, err := resource.ParseQuantity(request.Requests.CPU)
-		if err != nil {
-			return resources, err
-		}
-		resources.Requests[apiv1.ResourceCPU] = qty
-	}
-
-	return resources, nil
-}
-
-func getMinReplicaCount(labels map[string]string) *int32 {
-	if value, exists := labels["com.openfaas.scale.min"]; exists {
-		minReplicas, err := strconv.Atoi(value)
-		if err == nil && minReplicas > 0 {
-			return int32p(int32(minReplicas))
-		}
-
-		log.Println(err)
-	}
-
-	return nil
-}
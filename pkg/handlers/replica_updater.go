@@ -5,20 +5,23 @@
 package handlers
 
 import (
-	"context"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"log"
+	"math"
 	"net/http"
 
 	"github.com/gorilla/mux"
+	"github.com/openfaas/faas-netes/pkg/k8s"
 	"github.com/openfaas/faas-provider/types"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 )
 
-// MakeReplicaUpdater updates desired count of replicas
-func MakeReplicaUpdater(defaultNamespace string, clientset *kubernetes.Clientset) http.HandlerFunc {
+// MakeReplicaUpdater updates desired count of replicas, within the range of
+// the function and the resource quotas of its namespace. The response is a
+// k8s.ScaleResult with the previous and the new replicas.
+func MakeReplicaUpdater(defaultNamespace string, clientset kubernetes.Interface) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		log.Println("Update replicas")
 
@@ -49,38 +52,31 @@ func MakeReplicaUpdater(defaultNamespace string, clientset *kubernetes.Clientset
 			}
 		}
 
-		options := metav1.GetOptions{
-			TypeMeta: metav1.TypeMeta{
-				Kind:       "Deployment",
-				APIVersion: "apps/v1",
-			},
-		}
-
-		deployment, err := clientset.AppsV1().Deployments(lookupNamespace).Get(context.TODO(), functionName, options)
-
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte("Unable to lookup function deployment " + functionName))
-			log.Println(err)
+		if req.Replicas > math.MaxInt32 {
+			http.Error(w, fmt.Sprintf("replicas must be at most %d", math.MaxInt32), http.StatusBadRequest)
 			return
 		}
 
-		oldReplicas := *deployment.Spec.Replicas
-		replicas := int32(req.Replicas)
-
-		log.Printf("Set replicas - %s %s, %d/%d\n", functionName, lookupNamespace, replicas, oldReplicas)
-
-		deployment.Spec.Replicas = &replicas
-
-		_, err = clientset.AppsV1().Deployments(lookupNamespace).Update(context.TODO(), deployment, metav1.UpdateOptions{})
-
+		result, err := k8s.ScaleFunction(r.Context(), clientset, functionName, lookupNamespace, int32(req.Replicas))
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte("Unable to update function deployment " + functionName))
+			status, _ := ProcessErrorReasons(err)
+			switch {
+			case k8s.IsNotFound(err):
+				http.Error(w, "Unable to lookup function deployment "+functionName, status)
+			case status == http.StatusForbidden:
+				http.Error(w, err.Error(), status)
+			default:
+				http.Error(w, "Unable to update function deployment "+functionName, status)
+			}
 			log.Println(err)
 			return
 		}
 
+		log.Printf("Set replicas - %s %s, %d/%d (requested %d)\n", functionName, lookupNamespace, result.Replicas, result.PreviousReplicas, result.RequestedReplicas)
+
+		body, _ := json.Marshal(result)
+		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusAccepted)
+		w.Write(body)
 	}
 }
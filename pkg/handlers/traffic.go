@@ -0,0 +1,367 @@
+// Copyright 2020 OpenFaaS Author(s)
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+
+	"github.com/gorilla/mux"
+	"github.com/openfaas/faas-netes/pkg/k8s"
+	"github.com/openfaas/faas-provider/auth"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	appslister "k8s.io/client-go/listers/apps/v1"
+)
+
+// trafficFieldManager owns the TrafficAnnotation of the function Deployments,
+// so that it is kept when faas-netes applies the Deployment of the function
+const trafficFieldManager = "faas-netes-traffic"
+
+// TrafficSplit is the share of the requests of a function, in percent, which
+// is served by the function and by each of its revisions
+type TrafficSplit struct {
+	Function  string            `json:"function"`
+	Namespace string            `json:"namespace"`
+	Weight    int               `json:"weight"`
+	Revisions []RevisionTraffic `json:"revisions"`
+}
+
+// RevisionTraffic is a deployed revision of a function and its share of the
+// requests
+type RevisionTraffic struct {
+	Revision   string `json:"revision"`
+	Deployment string `json:"deployment"`
+	Image      string `json:"image"`
+	Weight     int    `json:"weight"`
+}
+
+// TrafficRequest sets the weights of the revisions of a function, the
+// revisions which are left out get no traffic
+type TrafficRequest struct {
+	Weights map[string]int `json:"weights"`
+}
+
+// RevisionRequest names the revision to roll back
+type RevisionRequest struct {
+	Revision string `json:"revision"`
+}
+
+// PromoteRequest names the revision which replaces the function. The
+// Deployment and Service of the revision are kept unless Delete is set.
+type PromoteRequest struct {
+	Revision string `json:"revision"`
+	Delete   bool   `json:"delete"`
+}
+
+// HandleTraffic registers the traffic splitting routes on the router:
+//
+//	GET  /system/traffic/{name}          returns the TrafficSplit
+//	POST /system/traffic/{name}          sets the weights of a TrafficRequest
+//	POST /system/traffic/{name}/promote  replaces the function with a revision
+//	POST /system/traffic/{name}/rollback sends the requests of a revision, or
+//	                                     of all of them, back to the function
+//
+// The routes are not part of the FaaSHandlers of the provider, so they are
+// protected here with the credentials, which are nil when basic auth is off.
+func HandleTraffic(router *mux.Router,
+	nameExpression string,
+	defaultNamespace string,
+	clientset kubernetes.Interface,
+	deploymentLister appslister.DeploymentLister,
+	credentials *auth.BasicAuthCredentials) {
+
+	handler := MakeTrafficHandler(defaultNamespace, clientset, deploymentLister)
+	if credentials != nil {
+		handler = auth.DecorateWithBasicAuth(handler, credentials)
+	}
+
+	router.HandleFunc("/system/traffic/{name:["+nameExpression+"]+}", handler).Methods(http.MethodGet, http.MethodPost)
+	router.HandleFunc("/system/traffic/{name:["+nameExpression+"]+}/{action:promote|rollback}", handler).Methods(http.MethodPost)
+}
+
+// MakeTrafficHandler creates the handler which reads and shifts the traffic
+// between a function and its revisions. The weights are written to the
+// TrafficAnnotation of the function Deployment and read by the FunctionLookup.
+// A promoted revision is also applied to the function Deployment, see
+// k8s.MakePromotedDeployment. The function Deployment is read from the API,
+// as it is updated, and the revisions from the lister.
+func MakeTrafficHandler(defaultNamespace string, clientset kubernetes.Interface, deploymentLister appslister.DeploymentLister) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Body != nil {
+			defer r.Body.Close()
+		}
+
+		vars := mux.Vars(r)
+		functionName := vars["name"]
+
+		lookupNamespace := defaultNamespace
+		if namespace := r.URL.Query().Get("namespace"); len(namespace) > 0 {
+			lookupNamespace = namespace
+		}
+
+		if lookupNamespace == "kube-system" {
+			http.Error(w, "unable to list within the kube-system namespace", http.StatusUnauthorized)
+			return
+		}
+
+		ctx := r.Context()
+		deployment, err := clientset.AppsV1().Deployments(lookupNamespace).Get(ctx, functionName, metav1.GetOptions{})
+		if err != nil {
+			status, _ := ProcessErrorReasons(err)
+			http.Error(w, fmt.Sprintf("unable to find function: %s.%s", functionName, lookupNamespace), status)
+			return
+		}
+		if !isFunction(deployment) {
+			http.Error(w, "Not a function: "+functionName, http.StatusBadRequest)
+			return
+		}
+
+		revisions, err := listRevisions(deploymentLister, functionName, lookupNamespace)
+		if err != nil {
+			log.Printf("Unable to list the revisions of %s.%s: %s\n", functionName, lookupNamespace, err)
+			http.Error(w, fmt.Sprintf("unable to list the revisions of: %s.%s", functionName, lookupNamespace), http.StatusInternalServerError)
+			return
+		}
+
+		if r.Method == http.MethodGet {
+			writeTrafficSplit(w, deployment, revisions)
+			return
+		}
+
+		body, _ := ioutil.ReadAll(r.Body)
+
+		if vars["action"] == "promote" {
+			promote(w, r, clientset, deployment, revisions, body)
+			return
+		}
+
+		current, err := k8s.ParseTrafficWeights(deployment.Annotations)
+		if err != nil {
+			log.Printf("Replacing the traffic split of %s.%s: %s\n", functionName, lookupNamespace, err)
+		}
+
+		weights, err := nextWeights(vars["action"], body, current)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		for _, weight := range weights {
+			if _, ok := revisions[weight.Revision]; !ok {
+				http.Error(w, fmt.Sprintf("revision %s of %s is not deployed", weight.Revision, functionName), http.StatusBadRequest)
+				return
+			}
+		}
+
+		updated, err := patchTraffic(ctx, clientset, deployment, weights)
+		if err != nil {
+			log.Printf("Unable to update the traffic split of %s.%s: %s\n", functionName, lookupNamespace, err)
+			status, _ := ProcessErrorReasons(err)
+			http.Error(w, fmt.Sprintf("unable to update the traffic split of: %s.%s", functionName, lookupNamespace), status)
+			return
+		}
+
+		log.Printf("Traffic split of %s.%s set to %q\n", functionName, lookupNamespace, k8s.FormatTrafficWeights(weights))
+
+		writeTrafficSplit(w, updated, revisions)
+	}
+}
+
+// nextWeights returns the weights after the action, which is empty when the
+// weights of a TrafficRequest are set
+func nextWeights(action string, body []byte, current []k8s.RevisionWeight) ([]k8s.RevisionWeight, error) {
+	switch action {
+	case "":
+		req := TrafficRequest{}
+		if err := json.Unmarshal(body, &req); err != nil {
+			return nil, fmt.Errorf("unable to unmarshal request: %s", err.Error())
+		}
+		return k8s.NormalizeTrafficWeights(req.Weights)
+
+	default:
+		// a rollback with no revision sends all the requests to the function
+		req := RevisionRequest{}
+		if len(body) > 0 {
+			if err := json.Unmarshal(body, &req); err != nil {
+				return nil, fmt.Errorf("unable to unmarshal request: %s", err.Error())
+			}
+		}
+
+		weights := []k8s.RevisionWeight{}
+		for _, weight := range current {
+			if len(req.Revision) > 0 && weight.Revision != req.Revision {
+				weights = append(weights, weight)
+			}
+		}
+		return weights, nil
+	}
+}
+
+// promote applies the pod template of the revision to the function
+// Deployment, then sends all the requests back to the function. Until the
+// function has rolled, its requests are served by both versions.
+func promote(w http.ResponseWriter, r *http.Request, clientset kubernetes.Interface, deployment *appsv1.Deployment, revisions map[string]*appsv1.Deployment, body []byte) {
+	req := PromoteRequest{}
+	if err := json.Unmarshal(body, &req); err != nil || len(req.Revision) == 0 {
+		http.Error(w, "provide the revision to promote", http.StatusBadRequest)
+		return
+	}
+
+	revision, ok := revisions[req.Revision]
+	if !ok {
+		http.Error(w, fmt.Sprintf("revision %s of %s is not deployed", req.Revision, deployment.Name), http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	desired := k8s.MakePromotedDeployment(deployment, revision)
+	if _, err := k8s.ApplyDeployment(ctx, clientset, deployment, desired); err != nil {
+		log.Printf("Unable to promote %s of %s.%s: %s\n", req.Revision, deployment.Name, deployment.Namespace, err)
+		status, _ := ProcessErrorReasons(err)
+		http.Error(w, fmt.Sprintf("unable to promote %s of: %s.%s", req.Revision, deployment.Name, deployment.Namespace), status)
+		return
+	}
+
+	updated, err := patchTraffic(ctx, clientset, deployment, nil)
+	if err != nil {
+		log.Printf("Unable to update the traffic split of %s.%s: %s\n", deployment.Name, deployment.Namespace, err)
+		status, _ := ProcessErrorReasons(err)
+		http.Error(w, fmt.Sprintf("unable to update the traffic split of: %s.%s", deployment.Name, deployment.Namespace), status)
+		return
+	}
+
+	if req.Delete {
+		if err := deleteRevision(ctx, clientset, revision); err != nil {
+			log.Printf("Unable to delete the promoted revision %s: %s\n", revision.Name, err)
+			status, _ := ProcessErrorReasons(err)
+			http.Error(w, fmt.Sprintf("unable to delete the promoted revision: %s.%s", revision.Name, revision.Namespace), status)
+			return
+		}
+		delete(revisions, req.Revision)
+	}
+
+	log.Printf("Promoted %s of %s.%s, deleted: %v\n", req.Revision, deployment.Name, deployment.Namespace, req.Delete)
+
+	writeTrafficSplit(w, updated, revisions)
+}
+
+// deleteRevision deletes the Deployment and Service of a revision
+func deleteRevision(ctx context.Context, clientset kubernetes.Interface, revision *appsv1.Deployment) error {
+	foregroundPolicy := metav1.DeletePropagationForeground
+	opts := metav1.DeleteOptions{PropagationPolicy: &foregroundPolicy}
+
+	err := clientset.AppsV1().Deployments(revision.Namespace).Delete(ctx, revision.Name, opts)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+
+	err = clientset.CoreV1().Services(revision.Namespace).Delete(ctx, revision.Name, opts)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// listRevisions returns the Deployments of the revisions of the function by
+// revision
+func listRevisions(deploymentLister appslister.DeploymentLister, functionName, namespace string) (map[string]*appsv1.Deployment, error) {
+	deployments, err := deploymentLister.Deployments(namespace).List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+
+	revisions := map[string]*appsv1.Deployment{}
+	for _, deployment := range deployments {
+		revision := deployment.Spec.Template.Labels[k8s.RevisionLabel]
+		if len(revision) == 0 || !isFunction(deployment) {
+			continue
+		}
+		if deployment.Name == k8s.RevisionName(functionName, revision) && k8s.IsRevisionOf(deployment, functionName, revision) {
+			revisions[revision] = deployment
+		}
+	}
+	return revisions, nil
+}
+
+// patchTraffic writes the weights to the TrafficAnnotation of the function
+// Deployment, the annotation is removed when there are none
+func patchTraffic(ctx context.Context, clientset kubernetes.Interface, deployment *appsv1.Deployment, weights []k8s.RevisionWeight) (*appsv1.Deployment, error) {
+	var value interface{}
+	if len(weights) > 0 {
+		value = k8s.FormatTrafficWeights(weights)
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{
+				k8s.TrafficAnnotation: value,
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return clientset.AppsV1().Deployments(deployment.Namespace).
+		Patch(ctx, deployment.Name, types.MergePatchType, patch, metav1.PatchOptions{FieldManager: trafficFieldManager})
+}
+
+func writeTrafficSplit(w http.ResponseWriter, deployment *appsv1.Deployment, revisions map[string]*appsv1.Deployment) {
+	weights, err := k8s.ParseTrafficWeights(deployment.Annotations)
+	if err != nil {
+		// the FunctionLookup sends all the requests to the function
+		weights = nil
+	}
+
+	split := TrafficSplit{
+		Function:  deployment.Name,
+		Namespace: deployment.Namespace,
+		Weight:    100,
+		Revisions: []RevisionTraffic{},
+	}
+
+	byRevision := map[string]int{}
+	for _, weight := range weights {
+		if _, ok := revisions[weight.Revision]; ok {
+			byRevision[weight.Revision] = weight.Weight
+			split.Weight -= weight.Weight
+		}
+	}
+
+	for revision, revisionDeployment := range revisions {
+		image := ""
+		if containers := revisionDeployment.Spec.Template.Spec.Containers; len(containers) > 0 {
+			image = containers[0].Image
+		}
+		split.Revisions = append(split.Revisions, RevisionTraffic{
+			Revision:   revision,
+			Deployment: revisionDeployment.Name,
+			Image:      image,
+			Weight:     byRevision[revision],
+		})
+	}
+	sort.Slice(split.Revisions, func(i, j int) bool {
+		return split.Revisions[i].Revision < split.Revisions[j].Revision
+	})
+
+	body, err := json.Marshal(split)
+	if err != nil {
+		http.Error(w, "unable to marshal the traffic split", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
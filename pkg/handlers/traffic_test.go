@@ -0,0 +1,282 @@
+// Copyright 2020 OpenFaaS Author(s)
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/openfaas/faas-netes/pkg/k8s"
+	"github.com/openfaas/faas-provider/auth"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
+	testclient "k8s.io/client-go/kubernetes/fake"
+	appslister "k8s.io/client-go/listers/apps/v1"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func trafficFunction(name, namespace string, labels map[string]string) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    map[string]string{"faas_function": name},
+		},
+		Spec: appsv1.DeploymentSpec{
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{Name: name, Image: "payments:" + name}},
+				},
+			},
+		},
+	}
+}
+
+// newTrafficClient returns a client and a lister of the Deployments
+func newTrafficClient(deployments ...*appsv1.Deployment) (*testclient.Clientset, appslister.DeploymentLister) {
+	objects := []runtime.Object{}
+	informer := informers.NewSharedInformerFactory(testclient.NewSimpleClientset(), 0).Apps().V1().Deployments()
+	for _, deployment := range deployments {
+		objects = append(objects, deployment)
+		informer.Informer().GetIndexer().Add(deployment)
+	}
+
+	client := testclient.NewSimpleClientset(objects...)
+
+	// the fake client only supports the other patch types, so the applied
+	// Deployments are stored as they are
+	client.PrependReactor("patch", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		patch := action.(k8stesting.PatchAction)
+		if patch.GetPatchType() != types.ApplyPatchType {
+			return false, nil, nil
+		}
+
+		applied := &appsv1.Deployment{}
+		if err := json.Unmarshal(patch.GetPatch(), applied); err != nil {
+			return true, nil, err
+		}
+		if err := client.Tracker().Update(appsv1.SchemeGroupVersion.WithResource("deployments"), applied, applied.Namespace); err != nil {
+			return true, nil, err
+		}
+		return true, applied, nil
+	})
+
+	return client, informer.Lister()
+}
+
+func Test_TrafficHandler(t *testing.T) {
+	namespace := "openfaas-fn"
+	kube, lister := newTrafficClient(
+		trafficFunction("payments", namespace, nil),
+		trafficFunction("payments-v2", namespace, k8s.RevisionLabels("payments", "v2")),
+		trafficFunction("payments-v3", namespace, k8s.RevisionLabels("payments", "v3")),
+	)
+
+	router := mux.NewRouter()
+	HandleTraffic(router, "-a-zA-Z_0-9.", namespace, kube, lister, nil)
+
+	call := func(method, path, body string) (int, TrafficSplit) {
+		req := httptest.NewRequest(method, "http://example.com"+path, strings.NewReader(body))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		split := TrafficSplit{}
+		if w.Code == http.StatusOK {
+			if err := json.Unmarshal(w.Body.Bytes(), &split); err != nil {
+				t.Fatalf("unable to unmarshal the traffic split: %s", err)
+			}
+		}
+		return w.Code, split
+	}
+
+	annotation := func() string {
+		deployment, err := kube.AppsV1().Deployments(namespace).Get(context.TODO(), "payments", metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		return deployment.Annotations[k8s.TrafficAnnotation]
+	}
+
+	t.Run("lists the revisions", func(t *testing.T) {
+		status, split := call(http.MethodGet, "/system/traffic/payments", "")
+		if status != http.StatusOK {
+			t.Fatalf("want status %d, got %d", http.StatusOK, status)
+		}
+		if split.Weight != 100 || len(split.Revisions) != 2 {
+			t.Fatalf("want two revisions with no traffic, got %+v", split)
+		}
+		if split.Revisions[0].Revision != "v2" || split.Revisions[0].Image != "payments:payments-v2" {
+			t.Errorf("want the revision v2 first, got %+v", split.Revisions[0])
+		}
+	})
+
+	t.Run("shifts the weights", func(t *testing.T) {
+		status, split := call(http.MethodPost, "/system/traffic/payments", `{"weights": {"v2": 10, "v3": 5}}`)
+		if status != http.StatusOK {
+			t.Fatalf("want status %d, got %d", http.StatusOK, status)
+		}
+		if split.Weight != 85 {
+			t.Errorf("want 85 for the function, got %d", split.Weight)
+		}
+		if got := annotation(); got != "v2=10,v3=5" {
+			t.Errorf("want the annotation v2=10,v3=5, got %q", got)
+		}
+	})
+
+	t.Run("rejects a revision which is not deployed", func(t *testing.T) {
+		status, _ := call(http.MethodPost, "/system/traffic/payments", `{"weights": {"v4": 10}}`)
+		if status != http.StatusBadRequest {
+			t.Errorf("want status %d, got %d", http.StatusBadRequest, status)
+		}
+	})
+
+	t.Run("rejects weights over 100", func(t *testing.T) {
+		status, _ := call(http.MethodPost, "/system/traffic/payments", `{"weights": {"v2": 60, "v3": 50}}`)
+		if status != http.StatusBadRequest {
+			t.Errorf("want status %d, got %d", http.StatusBadRequest, status)
+		}
+	})
+
+	t.Run("rolls back a revision", func(t *testing.T) {
+		status, _ := call(http.MethodPost, "/system/traffic/payments/rollback", `{"revision": "v3"}`)
+		if status != http.StatusOK {
+			t.Fatalf("want status %d, got %d", http.StatusOK, status)
+		}
+		if got := annotation(); got != "v2=10" {
+			t.Errorf("want the annotation v2=10, got %q", got)
+		}
+	})
+
+	t.Run("rolls back all the revisions", func(t *testing.T) {
+		status, split := call(http.MethodPost, "/system/traffic/payments/rollback", "")
+		if status != http.StatusOK {
+			t.Fatalf("want status %d, got %d", http.StatusOK, status)
+		}
+		if split.Weight != 100 {
+			t.Errorf("want all of the traffic on the function, got %d", split.Weight)
+		}
+		if got := annotation(); got != "" {
+			t.Errorf("want the annotation to be removed, got %q", got)
+		}
+	})
+
+	function := func() *appsv1.Deployment {
+		deployment, err := kube.AppsV1().Deployments(namespace).Get(context.TODO(), "payments", metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		return deployment
+	}
+
+	t.Run("promotes a revision", func(t *testing.T) {
+		call(http.MethodPost, "/system/traffic/payments", `{"weights": {"v2": 10}}`)
+
+		status, split := call(http.MethodPost, "/system/traffic/payments/promote", `{"revision": "v2"}`)
+		if status != http.StatusOK {
+			t.Fatalf("want status %d, got %d", http.StatusOK, status)
+		}
+		if split.Weight != 100 {
+			t.Errorf("want all of the traffic on the function, got %+v", split)
+		}
+		if got := annotation(); got != "" {
+			t.Errorf("want the annotation to be removed, got %q", got)
+		}
+
+		// the function now serves the code of the revision
+		promoted := function()
+		container := promoted.Spec.Template.Spec.Containers[0]
+		if container.Image != "payments:payments-v2" {
+			t.Errorf("want the image of v2, got %s", container.Image)
+		}
+		if container.Name != "payments" {
+			t.Errorf("want the container to be named after the function, got %s", container.Name)
+		}
+		labels := promoted.Spec.Template.Labels
+		if labels[k8s.FunctionLabel] != "payments" || len(labels[k8s.RevisionLabel]) > 0 {
+			t.Errorf("want the labels of the function, got %v", labels)
+		}
+
+		if _, err := kube.AppsV1().Deployments(namespace).Get(context.TODO(), "payments-v2", metav1.GetOptions{}); err != nil {
+			t.Errorf("want the revision to be kept, got: %s", err)
+		}
+	})
+
+	t.Run("promotes and deletes a revision", func(t *testing.T) {
+		status, split := call(http.MethodPost, "/system/traffic/payments/promote", `{"revision": "v3", "delete": true}`)
+		if status != http.StatusOK {
+			t.Fatalf("want status %d, got %d", http.StatusOK, status)
+		}
+		if image := function().Spec.Template.Spec.Containers[0].Image; image != "payments:payments-v3" {
+			t.Errorf("want the image of v3, got %s", image)
+		}
+		if _, err := kube.AppsV1().Deployments(namespace).Get(context.TODO(), "payments-v3", metav1.GetOptions{}); err == nil {
+			t.Errorf("want the revision to be deleted")
+		}
+		for _, revision := range split.Revisions {
+			if revision.Revision == "v3" {
+				t.Errorf("want the deleted revision to be left out, got %+v", split.Revisions)
+			}
+		}
+	})
+
+	t.Run("unknown function", func(t *testing.T) {
+		status, _ := call(http.MethodGet, "/system/traffic/orders", "")
+		if status != http.StatusNotFound {
+			t.Errorf("want status %d, got %d", http.StatusNotFound, status)
+		}
+	})
+}
+
+func Test_TrafficHandler_BasicAuth(t *testing.T) {
+	namespace := "openfaas-fn"
+	kube, lister := newTrafficClient(
+		trafficFunction("payments", namespace, nil),
+		trafficFunction("payments-v2", namespace, k8s.RevisionLabels("payments", "v2")),
+	)
+
+	router := mux.NewRouter()
+	credentials := &auth.BasicAuthCredentials{User: "admin", Password: "secret"}
+	HandleTraffic(router, "-a-zA-Z_0-9.", namespace, kube, lister, credentials)
+
+	paths := []string{
+		"/system/traffic/payments",
+		"/system/traffic/payments/promote",
+		"/system/traffic/payments/rollback",
+	}
+	for _, path := range paths {
+		req := httptest.NewRequest(http.MethodPost, "http://example.com"+path, strings.NewReader(`{"revision": "v2"}`))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%s want status %d, got %d", path, http.StatusUnauthorized, w.Code)
+		}
+	}
+
+	deployment, err := kube.AppsV1().Deployments(namespace).Get(context.TODO(), "payments", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got := deployment.Annotations[k8s.TrafficAnnotation]; got != "" {
+		t.Errorf("want the traffic split to be kept, got %q", got)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "http://example.com/system/traffic/payments/promote", strings.NewReader(`{"revision": "v2"}`))
+	req.SetBasicAuth("admin", "secret")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("want status %d with the credentials, got %d", http.StatusOK, w.Code)
+	}
+}
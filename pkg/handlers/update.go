@@ -36,6 +36,12 @@ func MakeUpdateHandler(defaultNamespace string, factory k8s.FunctionFactory) htt
 			return
 		}
 
+		if err := k8s.SetRevision(&request); err != nil {
+			wrappedErr := fmt.Errorf("validation failed: %s", err.Error())
+			http.Error(w, wrappedErr.Error(), http.StatusBadRequest)
+			return
+		}
+
 		lookupNamespace := defaultNamespace
 		if len(request.Namespace) > 0 {
 			lookupNamespace = request.Namespace
@@ -46,7 +52,7 @@ func MakeUpdateHandler(defaultNamespace string, factory k8s.FunctionFactory) htt
 			return
 		}
 
-		annotations := buildAnnotations(request)
+		annotations := k8s.BuildAnnotations(request)
 		if err, status := updateDeploymentSpec(ctx, lookupNamespace, factory, request, annotations); err != nil {
 			if !k8s.IsNotFound(err) {
 				log.Printf("error updating deployment: %s.%s, error: %s\n", request.Service, lookupNamespace, err)
@@ -59,7 +65,7 @@ func MakeUpdateHandler(defaultNamespace string, factory k8s.FunctionFactory) htt
 			return
 		}
 
-		if err, status := updateService(lookupNamespace, factory, request, annotations); err != nil {
+		if err, status := updateService(ctx, lookupNamespace, factory, request, annotations); err != nil {
 			if !k8s.IsNotFound(err) {
 				log.Printf("error updating service: %s.%s, error: %s\n", request.Service, lookupNamespace, err)
 			}
@@ -84,122 +90,72 @@ func updateDeploymentSpec(
 
 	deployment, findDeployErr := factory.Client.AppsV1().
 		Deployments(functionNamespace).
-		Get(context.TODO(), request.Service, getOpts)
+		Get(ctx, request.Service, getOpts)
 
 	if findDeployErr != nil {
 		return findDeployErr, http.StatusNotFound
 	}
 
-	if len(deployment.Spec.Template.Spec.Containers) > 0 {
-		deployment.Spec.Template.Spec.Containers[0].Image = request.Image
-
-		// Disabling update support to prevent unexpected mutations of deployed functions,
-		// since imagePullPolicy is now configurable. This could be reconsidered later depending
-		// on desired behavior, but will need to be updated to take config.
-		//deployment.Spec.Template.Spec.Containers[0].ImagePullPolicy = v1.PullAlways
-
-		deployment.Spec.Template.Spec.Containers[0].Env = buildEnvVars(&request)
-
-		factory.ConfigureReadOnlyRootFilesystem(request, deployment)
-		factory.ConfigureContainerUserID(deployment)
-
-		deployment.Spec.Template.Spec.NodeSelector = createSelector(request.Constraints)
-
-		labels := map[string]string{
-			"faas_function": request.Service,
-			"uid":           fmt.Sprintf("%d", time.Now().Nanosecond()),
-		}
-
-		if request.Labels != nil {
-			if min := getMinReplicaCount(*request.Labels); min != nil {
-				deployment.Spec.Replicas = min
-			}
-
-			for k, v := range *request.Labels {
-				labels[k] = v
-			}
-		}
-
-		// deployment.Labels = labels
-		deployment.Spec.Template.ObjectMeta.Labels = labels
-
-		// store the current annotations so that we can diff the annotations
-		// and determine which profiles need to be removed
-		currentAnnotations := deployment.Annotations
-		deployment.Annotations = annotations
-		deployment.Spec.Template.Annotations = annotations
-		deployment.Spec.Template.ObjectMeta.Annotations = annotations
-
-		resources, resourceErr := createResources(request)
-		if resourceErr != nil {
-			return resourceErr, http.StatusBadRequest
-		}
-
-		deployment.Spec.Template.Spec.Containers[0].Resources = *resources
-
-		var serviceAccount string
-
-		if request.Annotations != nil {
-			annotations := *request.Annotations
-			if val, ok := annotations["com.openfaas.serviceaccount"]; ok && len(val) > 0 {
-				serviceAccount = val
-			}
-		}
-
-		deployment.Spec.Template.Spec.ServiceAccountName = serviceAccount
-
-		secrets := k8s.NewSecretsClient(factory.Client)
-		existingSecrets, err := secrets.GetSecrets(functionNamespace, request.Secrets)
-		if err != nil {
-			return err, http.StatusBadRequest
-		}
-
-		err = factory.ConfigureSecrets(request, deployment, existingSecrets)
-		if err != nil {
-			log.Println(err)
-			return err, http.StatusBadRequest
-		}
+	secrets := k8s.NewSecretsClient(factory.Client)
+	existingSecrets, err := secrets.GetSecrets(functionNamespace, request.Secrets)
+	if err != nil {
+		return err, http.StatusBadRequest
+	}
 
-		probes, err := factory.MakeProbes(request)
-		if err != nil {
-			return err, http.StatusBadRequest
-		}
+	// The Deployment is built from the request alone and applied with
+	// server-side apply, so fields set by other controllers are left in
+	// place and fields no longer in the request are removed.
+	labels := map[string]string{}
+	if request.Labels != nil {
+		labels = *request.Labels
+	}
+	if _, err := k8s.MakeHorizontalPodAutoscaler(request.Service, functionNamespace, labels); err != nil {
+		return err, http.StatusBadRequest
+	}
+	if _, err := k8s.ParseScalingSchedule(annotations, time.UTC); err != nil {
+		return err, http.StatusBadRequest
+	}
 
-		deployment.Spec.Template.Spec.Containers[0].LivenessProbe = probes.Liveness
-		deployment.Spec.Template.Spec.Containers[0].ReadinessProbe = probes.Readiness
+	request.Namespace = functionNamespace
+	desired, err := factory.MakeDeployment(request, existingSecrets)
+	if err != nil {
+		log.Println(err)
+		return err, http.StatusBadRequest
+	}
+	k8s.KeepSelector(desired, deployment)
+
+	// the replicas are left to the HPA, the autoscaler and the idler, unless
+	// they are below the minimum of the function
+	desired.Spec.Replicas = k8s.ApplyReplicas(deployment, labels)
+
+	// profiles that are no longer requested are removed by the apply, because
+	// their fields are no longer part of the applied configuration
+	profileNamespace := factory.Config.ProfilesNamespace
+	profileList, err := factory.GetProfiles(ctx, profileNamespace, annotations)
+	if err != nil {
+		return err, http.StatusBadRequest
+	}
+	for _, profile := range profileList {
+		factory.ApplyProfile(profile, desired)
+	}
 
-		// compare the annotations from args to the cache copy of the deployment annotations
-		// at this point we have already updated the annotations to the new value, if we
-		// compare to that it will produce an empty list
-		profileNamespace := factory.Config.ProfilesNamespace
-		profileList, err := factory.GetProfilesToRemove(ctx, profileNamespace, annotations, currentAnnotations)
-		if err != nil {
-			return err, http.StatusBadRequest
-		}
-		for _, profile := range profileList {
-			factory.RemoveProfile(profile, deployment)
-		}
+	k8s.SetConfigHash(desired, k8s.ConfigHash(existingSecrets, profileList))
 
-		profileList, err = factory.GetProfiles(ctx, profileNamespace, annotations)
-		if err != nil {
-			return err, http.StatusBadRequest
-		}
-		for _, profile := range profileList {
-			factory.ApplyProfile(profile, deployment)
-		}
+	applied, applyErr := k8s.ApplyDeployment(ctx, factory.Client, deployment, desired)
+	if applyErr != nil {
+		return applyErr, http.StatusInternalServerError
 	}
 
-	if _, updateErr := factory.Client.AppsV1().
-		Deployments(functionNamespace).
-		Update(context.TODO(), deployment, metav1.UpdateOptions{}); updateErr != nil {
-
-		return updateErr, http.StatusInternalServerError
+	owner := k8s.NewDeploymentOwnerReference(applied)
+	if err := k8s.SyncHorizontalPodAutoscaler(ctx, factory.Dynamic, request.Service, functionNamespace, labels, owner); err != nil {
+		return err, http.StatusInternalServerError
 	}
 
 	return nil, http.StatusAccepted
 }
 
 func updateService(
+	ctx context.Context,
 	functionNamespace string,
 	factory k8s.FunctionFactory,
 	request types.FunctionDeployment,
@@ -209,19 +165,18 @@ func updateService(
 
 	service, findServiceErr := factory.Client.CoreV1().
 		Services(functionNamespace).
-		Get(context.TODO(), request.Service, getOpts)
+		Get(ctx, request.Service, getOpts)
 
 	if findServiceErr != nil {
 		return findServiceErr, http.StatusNotFound
 	}
 
-	service.Annotations = annotations
-
-	if _, updateErr := factory.Client.CoreV1().
-		Services(functionNamespace).
-		Update(context.TODO(), service, metav1.UpdateOptions{}); updateErr != nil {
+	request.Namespace = functionNamespace
+	desired := factory.MakeService(request)
+	desired.Annotations = annotations
 
-		return updateErr, http.StatusInternalServerError
+	if _, applyErr := k8s.ApplyService(ctx, factory.Client, service, desired); applyErr != nil {
+		return applyErr, http.StatusInternalServerError
 	}
 
 	return nil, http.StatusAccepted
@@ -0,0 +1,210 @@
+// Copyright 2020 OpenFaaS Author(s)
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package k8s
+
+import (
+	"context"
+	"encoding/json"
+
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2beta2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+// FieldManager is the field manager used by faas-netes for server-side apply.
+// It owns only the fields that faas-netes sets on the function Deployment
+// and Service, so fields set by other controllers, such as the replicas of
+// an HPA or the annotations of other tools, are left in place.
+//
+// It matches the name the API server derives from the faas-netes user agent,
+// so that fields set by earlier versions with Create and Update calls can be
+// handed over to the applier, see upgradeManagedFields.
+const FieldManager = "faas-netes"
+
+// ApplyDeployment creates or updates the Deployment with server-side apply.
+// The desired Deployment must only contain the fields owned by faas-netes,
+// live is the current Deployment or nil when it does not exist yet.
+func ApplyDeployment(ctx context.Context, client kubernetes.Interface, live, desired *appsv1.Deployment) (*appsv1.Deployment, error) {
+	deployments := client.AppsV1().Deployments(desired.Namespace)
+
+	if live != nil {
+		upgraded := live.DeepCopy()
+		if upgradeManagedFields(upgraded) {
+			if _, err := deployments.Update(ctx, upgraded, metav1.UpdateOptions{FieldManager: FieldManager}); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	applied := desired.DeepCopy()
+	applied.TypeMeta = metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"}
+	applied.Status = appsv1.DeploymentStatus{}
+	clearServerFields(&applied.ObjectMeta)
+
+	data, err := json.Marshal(applied)
+	if err != nil {
+		return nil, err
+	}
+
+	return deployments.Patch(ctx, applied.Name, types.ApplyPatchType, data, applyOptions())
+}
+
+// ApplyService creates or updates the Service with server-side apply, see ApplyDeployment
+func ApplyService(ctx context.Context, client kubernetes.Interface, live, desired *corev1.Service) (*corev1.Service, error) {
+	services := client.CoreV1().Services(desired.Namespace)
+
+	if live != nil {
+		upgraded := live.DeepCopy()
+		if upgradeManagedFields(upgraded) {
+			if _, err := services.Update(ctx, upgraded, metav1.UpdateOptions{FieldManager: FieldManager}); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	applied := desired.DeepCopy()
+	applied.TypeMeta = metav1.TypeMeta{APIVersion: "v1", Kind: "Service"}
+	applied.Status = corev1.ServiceStatus{}
+	clearServerFields(&applied.ObjectMeta)
+
+	data, err := json.Marshal(applied)
+	if err != nil {
+		return nil, err
+	}
+
+	return services.Patch(ctx, applied.Name, types.ApplyPatchType, data, applyOptions())
+}
+
+// ApplyHorizontalPodAutoscaler creates or updates the HPA of a function with
+// server-side apply, as an autoscaling/v2 object. The HPA was only ever
+// applied by faas-netes, so there are no managed fields to hand over.
+func ApplyHorizontalPodAutoscaler(ctx context.Context, client dynamic.Interface, desired *autoscalingv2.HorizontalPodAutoscaler) (*unstructured.Unstructured, error) {
+	applied := desired.DeepCopy()
+	applied.TypeMeta = metav1.TypeMeta{
+		APIVersion: HorizontalPodAutoscalerResource.GroupVersion().String(),
+		Kind:       "HorizontalPodAutoscaler",
+	}
+	applied.Status = autoscalingv2.HorizontalPodAutoscalerStatus{}
+	clearServerFields(&applied.ObjectMeta)
+
+	data, err := json.Marshal(applied)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.Resource(HorizontalPodAutoscalerResource).Namespace(applied.Namespace).
+		Patch(ctx, applied.Name, types.ApplyPatchType, data, applyOptions())
+}
+
+// ApplyReplicas returns the replicas to apply for the Deployment of a
+// function, live is the current Deployment or nil when it does not exist yet.
+// The replicas belong to whoever scales the function, so they are only set on
+// create and to raise them to the minimum, and never for functions with an
+// HPA. A function scaled to zero is left for the idler to wake up.
+//
+// Otherwise the live value is kept only while faas-netes still owns the
+// field, because leaving out a field it owns would remove it and reset the
+// Deployment to a single replica.
+func ApplyReplicas(live *appsv1.Deployment, labels map[string]string) *int32 {
+	if UsesHorizontalPodAutoscaler(labels) {
+		return nil
+	}
+
+	min := GetMinReplicaCount(labels)
+	if live == nil || live.Spec.Replicas == nil {
+		if min != nil {
+			return min
+		}
+		return int32p(initialReplicasCount)
+	}
+
+	replicas := *live.Spec.Replicas
+	if min != nil && replicas > 0 && *min > replicas {
+		return min
+	}
+
+	if ownsReplicas(live) {
+		return int32p(replicas)
+	}
+	return nil
+}
+
+// ownsReplicas returns true when the replicas of the Deployment are owned by
+// the apply field manager of faas-netes, or by its Update entry which is
+// handed over to the applier by upgradeManagedFields
+func ownsReplicas(deployment *appsv1.Deployment) bool {
+	var owner *metav1.ManagedFieldsEntry
+	for i, entry := range deployment.ManagedFields {
+		if entry.Manager != FieldManager {
+			continue
+		}
+		if entry.Operation == metav1.ManagedFieldsOperationApply {
+			owner = &deployment.ManagedFields[i]
+			break
+		}
+		if entry.Operation == metav1.ManagedFieldsOperationUpdate && owner == nil {
+			owner = &deployment.ManagedFields[i]
+		}
+	}
+	if owner == nil || owner.FieldsV1 == nil {
+		return false
+	}
+
+	fields := map[string]map[string]json.RawMessage{}
+	if err := json.Unmarshal(owner.FieldsV1.Raw, &fields); err != nil {
+		return false
+	}
+	_, ok := fields["f:spec"]["f:replicas"]
+	return ok
+}
+
+func applyOptions() metav1.PatchOptions {
+	// faas-netes is the source of truth for the fields it applies, so
+	// conflicts with other managers are resolved in its favour
+	force := true
+	return metav1.PatchOptions{
+		FieldManager: FieldManager,
+		Force:        &force,
+	}
+}
+
+// clearServerFields removes the metadata set by the API server, which must
+// not be part of an apply request
+func clearServerFields(meta *metav1.ObjectMeta) {
+	meta.ResourceVersion = ""
+	meta.UID = ""
+	meta.SelfLink = ""
+	meta.Generation = 0
+	meta.CreationTimestamp = metav1.Time{}
+	meta.ManagedFields = nil
+}
+
+// upgradeManagedFields hands the fields that faas-netes set with Create and
+// Update calls over to its apply field manager. Without this, fields that are
+// no longer applied, such as a removed environment variable, would stay
+// owned by the old Update entry and would never be removed. It returns true
+// when the managed fields were changed and need to be written.
+func upgradeManagedFields(obj metav1.Object) bool {
+	entries := obj.GetManagedFields()
+	for _, entry := range entries {
+		if entry.Manager == FieldManager && entry.Operation == metav1.ManagedFieldsOperationApply {
+			return false
+		}
+	}
+
+	for i, entry := range entries {
+		if entry.Manager == FieldManager && entry.Operation == metav1.ManagedFieldsOperationUpdate {
+			entries[i].Operation = metav1.ManagedFieldsOperationApply
+			obj.SetManagedFields(entries)
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,93 @@
+package k8s
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func Test_ApplyDeployment(t *testing.T) {
+	live := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "nodeinfo",
+			Namespace:       "openfaas-fn",
+			ResourceVersion: "10",
+			ManagedFields: []metav1.ManagedFieldsEntry{
+				{Manager: "kube-controller-manager", Operation: metav1.ManagedFieldsOperationUpdate},
+				{Manager: FieldManager, Operation: metav1.ManagedFieldsOperationUpdate},
+			},
+		},
+	}
+
+	desired := live.DeepCopy()
+	desired.Spec.Template.Spec.ServiceAccountName = "nodeinfo"
+
+	client := fake.NewSimpleClientset(live)
+
+	var patch k8stesting.PatchAction
+	client.PrependReactor("patch", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		patch = action.(k8stesting.PatchAction)
+		return true, desired, nil
+	})
+
+	if _, err := ApplyDeployment(context.TODO(), client, live, desired); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if patch == nil {
+		t.Fatal("want the Deployment to be applied")
+	}
+	if patch.GetPatchType() != types.ApplyPatchType {
+		t.Errorf("want patch type %s, got %s", types.ApplyPatchType, patch.GetPatchType())
+	}
+
+	applied := map[string]interface{}{}
+	if err := json.Unmarshal(patch.GetPatch(), &applied); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if applied["apiVersion"] != "apps/v1" || applied["kind"] != "Deployment" {
+		t.Errorf("want apiVersion and kind to be set, got %v %v", applied["apiVersion"], applied["kind"])
+	}
+	metadata := applied["metadata"].(map[string]interface{})
+	if _, ok := metadata["resourceVersion"]; ok {
+		t.Errorf("want resourceVersion to be removed from the applied object")
+	}
+	if _, ok := metadata["managedFields"]; ok {
+		t.Errorf("want managedFields to be removed from the applied object")
+	}
+
+	// the fields set by the Update entry are handed over to the applier first
+	upgraded, err := client.AppsV1().Deployments("openfaas-fn").Get(context.TODO(), "nodeinfo", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	entry := upgraded.ManagedFields[1]
+	if entry.Manager != FieldManager || entry.Operation != metav1.ManagedFieldsOperationApply {
+		t.Errorf("want %s to manage the fields with Apply, got %s with %s", FieldManager, entry.Manager, entry.Operation)
+	}
+	if upgraded.ManagedFields[0].Operation != metav1.ManagedFieldsOperationUpdate {
+		t.Errorf("want other field managers to be unchanged")
+	}
+}
+
+func Test_upgradeManagedFields_OnlyOnce(t *testing.T) {
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			ManagedFields: []metav1.ManagedFieldsEntry{
+				{Manager: FieldManager, Operation: metav1.ManagedFieldsOperationApply},
+				{Manager: FieldManager, Operation: metav1.ManagedFieldsOperationUpdate},
+			},
+		},
+	}
+
+	if upgradeManagedFields(deployment) {
+		t.Errorf("want no upgrade when %s already applies the object", FieldManager)
+	}
+}
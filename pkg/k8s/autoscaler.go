@@ -0,0 +1,367 @@
+// Copyright 2020 OpenFaaS Author(s)
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	appslister "k8s.io/client-go/listers/apps/v1"
+)
+
+const (
+	// MaxReplicasLabel sets the maximum number of replicas of a function
+	MaxReplicasLabel = "com.openfaas.scale.max"
+	// ScaleTypeLabel selects the metric that the Autoscaler scales a
+	// function on, one of ScaleTypeRPS, ScaleTypeInflight or ScaleTypeLatency.
+	// ScaleTypeCPU, ScaleTypeMemory and ScaleTypeCustom create a
+	// HorizontalPodAutoscaler instead.
+	ScaleTypeLabel = "com.openfaas.scale.type"
+	// ScaleTargetLabel is the value of the metric that each replica of a
+	// function should serve
+	ScaleTargetLabel = "com.openfaas.scale.target"
+
+	// ScaleTypeRPS scales on the requests per second to each replica
+	ScaleTypeRPS = "rps"
+	// ScaleTypeInflight scales on the average requests in flight to each
+	// replica
+	ScaleTypeInflight = "inflight"
+	// ScaleTypeLatency scales on the average latency of the requests in
+	// milliseconds, the replicas are added or removed in proportion to the
+	// difference with the target
+	ScaleTypeLatency = "latency"
+
+	defaultMaxReplicas = 20
+
+	// scalingTolerance is the relative difference between the metric and
+	// its target under which the replicas are not changed
+	scalingTolerance = 0.1
+)
+
+var defaultScaleTargets = map[string]float64{
+	ScaleTypeRPS:      50,
+	ScaleTypeInflight: 10,
+	ScaleTypeLatency:  500,
+}
+
+// ScalingPolicy of a function, read from the labels of its Pod template
+type ScalingPolicy struct {
+	MinReplicas int32
+	MaxReplicas int32
+	Type        string
+	Target      float64
+}
+
+// ParseScalingPolicy reads the scaling policy from the labels of a function,
+// the labels which are not set use the defaults
+func ParseScalingPolicy(labels map[string]string) (ScalingPolicy, error) {
+	policy := ScalingPolicy{
+		MinReplicas: initialReplicasCount,
+		MaxReplicas: defaultMaxReplicas,
+		Type:        ScaleTypeRPS,
+	}
+
+	if min := GetMinReplicaCount(labels); min != nil {
+		policy.MinReplicas = *min
+	}
+
+	if raw, ok := labels[MaxReplicasLabel]; ok {
+		max, err := strconv.Atoi(raw)
+		if err != nil || max < 1 {
+			return ScalingPolicy{}, fmt.Errorf("invalid %s %q, must be a positive number", MaxReplicasLabel, raw)
+		}
+		policy.MaxReplicas = int32(max)
+	}
+	if policy.MaxReplicas < policy.MinReplicas {
+		return ScalingPolicy{}, fmt.Errorf("%s %d is lower than %s %d", MaxReplicasLabel, policy.MaxReplicas, MinReplicasLabel, policy.MinReplicas)
+	}
+
+	if raw, ok := labels[ScaleTypeLabel]; ok {
+		if _, known := defaultScaleTargets[raw]; !known {
+			return ScalingPolicy{}, fmt.Errorf("invalid %s %q, must be one of %s, %s or %s", ScaleTypeLabel, raw, ScaleTypeRPS, ScaleTypeInflight, ScaleTypeLatency)
+		}
+		policy.Type = raw
+	}
+	policy.Target = defaultScaleTargets[policy.Type]
+
+	if raw, ok := labels[ScaleTargetLabel]; ok {
+		target, err := strconv.ParseFloat(raw, 64)
+		if err != nil || target <= 0 {
+			return ScalingPolicy{}, fmt.Errorf("invalid %s %q, must be a positive number", ScaleTargetLabel, raw)
+		}
+		policy.Target = target
+	}
+
+	return policy, nil
+}
+
+// Within returns the policy with the replicas of an active scaling window
+func (p ScalingPolicy) Within(window *ScalingWindow) ScalingPolicy {
+	p.MinReplicas = window.MinReplicas
+	if window.MaxReplicas > 0 {
+		p.MaxReplicas = window.MaxReplicas
+	}
+	if p.MaxReplicas < p.MinReplicas {
+		p.MaxReplicas = p.MinReplicas
+	}
+	return p
+}
+
+// Usage returns the value of the metric of the policy for each of the
+// replicas, from the change in the counters of the function between two
+// snapshots
+func (p ScalingPolicy) Usage(replicas int32, previous, current InvocationSnapshot) float64 {
+	elapsed := current.At.Sub(previous.At).Seconds()
+	if elapsed <= 0 || replicas == 0 {
+		return 0
+	}
+	requests := float64(current.Requests - previous.Requests)
+
+	switch p.Type {
+	case ScaleTypeInflight:
+		return (current.Busy - previous.Busy).Seconds() / elapsed / float64(replicas)
+	case ScaleTypeLatency:
+		if requests == 0 {
+			return 0
+		}
+		latency := current.Latency - previous.Latency
+		return float64(latency) / float64(time.Millisecond) / requests
+	default:
+		return requests / elapsed / float64(replicas)
+	}
+}
+
+// DesiredReplicas returns the replicas which bring the usage of each replica
+// to the target, within the minimum and maximum of the policy
+func (p ScalingPolicy) DesiredReplicas(replicas int32, usage float64) int32 {
+	ratio := usage / p.Target
+
+	desired := replicas
+	if math.Abs(ratio-1) > scalingTolerance {
+		desired = int32(math.Ceil(float64(replicas) * ratio))
+	}
+
+	if desired < p.MinReplicas {
+		return p.MinReplicas
+	}
+	if desired > p.MaxReplicas {
+		return p.MaxReplicas
+	}
+	return desired
+}
+
+// AutoscalerConfig sets how often the functions are scaled and how long a
+// recommendation is kept before the replicas follow a change in the traffic
+type AutoscalerConfig struct {
+	// Interval between two scaling decisions, the metrics are averaged over it
+	Interval time.Duration
+	// ScaleUpWindow is how long the traffic must stay high before replicas
+	// are added, the lowest recommendation of the window is used
+	ScaleUpWindow time.Duration
+	// ScaleDownWindow is how long the traffic must stay low before replicas
+	// are removed, the highest recommendation of the window is used
+	ScaleDownWindow time.Duration
+}
+
+// Autoscaler scales functions between their minimum and maximum replicas
+// with the requests measured by the proxy of faas-netes, so that neither
+// Prometheus nor the gateway alerts are needed. Functions with zero replicas
+// are left to the ColdStart. Only the requests proxied by this process are
+// measured, so it must run on a single replica which proxies all of the
+// requests. For this reason faas-netes refuses to start it with leader
+// election.
+type Autoscaler struct {
+	client      kubernetes.Interface
+	deployments appslister.DeploymentLister
+	stats       *InvocationStats
+	config      AutoscalerConfig
+	now         func() time.Time
+
+	// functions is only used by the loop of Run
+	functions map[string]*scalingState
+}
+
+// scalingState is the last snapshot of a function and its recent
+// recommendations
+type scalingState struct {
+	name            string
+	namespace       string
+	last            InvocationSnapshot
+	recommendations []recommendation
+}
+
+type recommendation struct {
+	at       time.Time
+	replicas int32
+}
+
+// NewAutoscaler creates an Autoscaler for the functions of the lister, with
+// the requests recorded in stats
+func NewAutoscaler(client kubernetes.Interface,
+	deployments appslister.DeploymentLister,
+	stats *InvocationStats,
+	config AutoscalerConfig) *Autoscaler {
+
+	return &Autoscaler{
+		client:      client,
+		deployments: deployments,
+		stats:       stats,
+		config:      config,
+		now:         time.Now,
+		functions:   map[string]*scalingState{},
+	}
+}
+
+// Run scales the functions every interval until stopCh is closed
+func (a *Autoscaler) Run(stopCh <-chan struct{}) {
+	log.Printf("Autoscaling functions every %s\n", a.config.Interval)
+	wait.Until(a.scaleAll, a.config.Interval, stopCh)
+}
+
+func (a *Autoscaler) scaleAll() {
+	deployments, err := a.deployments.List(labels.Everything())
+	if err != nil {
+		log.Printf("Unable to list functions to autoscale: %s\n", err)
+		return
+	}
+
+	seen := map[string]bool{}
+	for _, deployment := range deployments {
+		if _, ok := deployment.Labels[FunctionLabel]; !ok {
+			continue
+		}
+
+		key := deployment.Name + "." + deployment.Namespace
+		seen[key] = true
+
+		if err := a.scale(key, deployment); err != nil {
+			log.Printf("Unable to autoscale %s: %s\n", key, err)
+		}
+	}
+
+	for key, state := range a.functions {
+		if !seen[key] {
+			delete(a.functions, key)
+			a.stats.Forget(state.name, state.namespace)
+		}
+	}
+}
+
+// scale sets the replicas recommended for the function since the last
+// interval, the first interval of a function only records its counters
+func (a *Autoscaler) scale(key string, deployment *appsv1.Deployment) error {
+	if UsesHorizontalPodAutoscaler(deployment.Spec.Template.Labels) {
+		delete(a.functions, key)
+		return nil
+	}
+
+	policy, err := ParseScalingPolicy(deployment.Spec.Template.Labels)
+	if err != nil {
+		return err
+	}
+	if window := ActiveScalingWindow(deployment); window != nil {
+		policy = policy.Within(window)
+	}
+
+	snapshot := a.stats.Snapshot(deployment.Name, deployment.Namespace)
+
+	state, ok := a.functions[key]
+	if !ok {
+		a.functions[key] = &scalingState{
+			name:      deployment.Name,
+			namespace: deployment.Namespace,
+			last:      snapshot,
+		}
+		return nil
+	}
+	previous := state.last
+	state.last = snapshot
+
+	replicas := int32(0)
+	if deployment.Spec.Replicas != nil {
+		replicas = *deployment.Spec.Replicas
+	}
+	if replicas == 0 {
+		state.recommendations = nil
+		return nil
+	}
+
+	usage := policy.Usage(replicas, previous, snapshot)
+	desired := state.stabilize(a.now(), replicas, policy.DesiredReplicas(replicas, usage), a.config)
+	if desired == replicas {
+		return nil
+	}
+
+	if err := a.setReplicas(deployment.Name, deployment.Namespace, replicas, desired); err != nil {
+		return err
+	}
+
+	log.Printf("Autoscaling %s from %d to %d replicas, %s %.2f for a target of %.2f\n",
+		key, replicas, desired, policy.Type, usage, policy.Target)
+	return nil
+}
+
+// stabilize records the recommendation and returns the replicas to set. The
+// replicas are only raised to the lowest recommendation of the scale up
+// window and only lowered to the highest recommendation of the scale down
+// window, so that a short change in the traffic does not flap the replicas.
+func (s *scalingState) stabilize(now time.Time, replicas, desired int32, config AutoscalerConfig) int32 {
+	s.recommendations = append(s.recommendations, recommendation{at: now, replicas: desired})
+
+	window := config.ScaleUpWindow
+	if config.ScaleDownWindow > window {
+		window = config.ScaleDownWindow
+	}
+	kept := s.recommendations[:0]
+	for _, r := range s.recommendations {
+		if now.Sub(r.at) < window {
+			kept = append(kept, r)
+		}
+	}
+	s.recommendations = kept
+
+	up, down := desired, desired
+	for _, r := range s.recommendations {
+		age := now.Sub(r.at)
+		if age < config.ScaleUpWindow && r.replicas < up {
+			up = r.replicas
+		}
+		if age < config.ScaleDownWindow && r.replicas > down {
+			down = r.replicas
+		}
+	}
+
+	if up > replicas {
+		return up
+	}
+	if down < replicas {
+		return down
+	}
+	return replicas
+}
+
+// setReplicas changes the replicas of the Deployment, unless they were
+// changed by someone else since they were read
+func (a *Autoscaler) setReplicas(functionName, namespace string, from, to int32) error {
+	patch := fmt.Sprintf(`[{"op":"test","path":"/spec/replicas","value":%d},{"op":"replace","path":"/spec/replicas","value":%d}]`, from, to)
+
+	_, err := a.client.AppsV1().Deployments(namespace).
+		Patch(context.TODO(), functionName, k8stypes.JSONPatchType, []byte(patch), metav1.PatchOptions{})
+	if status, ok := err.(errors.APIStatus); ok && status.Status().Code == http.StatusUnprocessableEntity {
+		return fmt.Errorf("the replicas were changed from %d while scaling", from)
+	}
+	return err
+}
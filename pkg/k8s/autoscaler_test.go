@@ -0,0 +1,287 @@
+// Copyright 2020 OpenFaaS Author(s)
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package k8s
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+func Test_ParseScalingPolicy(t *testing.T) {
+	cases := []struct {
+		name    string
+		labels  map[string]string
+		want    ScalingPolicy
+		wantErr bool
+	}{
+		{
+			name:   "defaults",
+			labels: map[string]string{},
+			want:   ScalingPolicy{MinReplicas: 1, MaxReplicas: 20, Type: ScaleTypeRPS, Target: 50},
+		},
+		{
+			name:   "inflight with a target",
+			labels: map[string]string{MinReplicasLabel: "2", MaxReplicasLabel: "5", ScaleTypeLabel: "inflight", ScaleTargetLabel: "4"},
+			want:   ScalingPolicy{MinReplicas: 2, MaxReplicas: 5, Type: ScaleTypeInflight, Target: 4},
+		},
+		{
+			name:   "default target of the type",
+			labels: map[string]string{ScaleTypeLabel: "latency"},
+			want:   ScalingPolicy{MinReplicas: 1, MaxReplicas: 20, Type: ScaleTypeLatency, Target: 500},
+		},
+		{
+			name:    "max lower than min",
+			labels:  map[string]string{MinReplicasLabel: "3", MaxReplicasLabel: "2"},
+			wantErr: true,
+		},
+		{
+			name:    "unknown type",
+			labels:  map[string]string{ScaleTypeLabel: "cpu"},
+			wantErr: true,
+		},
+		{
+			name:    "zero target",
+			labels:  map[string]string{ScaleTargetLabel: "0"},
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ParseScalingPolicy(tc.labels)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("want an error for %v", tc.labels)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if got != tc.want {
+				t.Errorf("want %+v, got %+v", tc.want, got)
+			}
+		})
+	}
+}
+
+func Test_ScalingPolicy_Usage(t *testing.T) {
+	start := time.Unix(0, 0)
+	previous := InvocationSnapshot{At: start}
+	current := InvocationSnapshot{
+		At:       start.Add(10 * time.Second),
+		Requests: 200,
+		Latency:  200 * 50 * time.Millisecond,
+		Busy:     80 * time.Second,
+	}
+
+	cases := []struct {
+		policy string
+		want   float64
+	}{
+		{ScaleTypeRPS, 10},
+		{ScaleTypeInflight, 4},
+		{ScaleTypeLatency, 50},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.policy, func(t *testing.T) {
+			got := ScalingPolicy{Type: tc.policy}.Usage(2, previous, current)
+			if got != tc.want {
+				t.Errorf("want %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func Test_ScalingPolicy_DesiredReplicas(t *testing.T) {
+	policy := ScalingPolicy{MinReplicas: 1, MaxReplicas: 10, Type: ScaleTypeRPS, Target: 10}
+
+	cases := []struct {
+		name     string
+		replicas int32
+		usage    float64
+		want     int32
+	}{
+		{"scales up to the target", 2, 25, 5},
+		{"within the tolerance", 4, 10.5, 4},
+		{"scales down to the target", 4, 4, 2},
+		{"no traffic keeps the minimum", 4, 0, 1},
+		{"limited to the maximum", 4, 100, 10},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := policy.DesiredReplicas(tc.replicas, tc.usage); got != tc.want {
+				t.Errorf("want %d replicas, got %d", tc.want, got)
+			}
+		})
+	}
+}
+
+func Test_InvocationStats_CountsBusyTime(t *testing.T) {
+	now := time.Unix(0, 0)
+	stats := NewInvocationStats()
+	stats.now = func() time.Time { return now }
+
+	first := stats.Start("nodeinfo", "openfaas-fn")
+	second := stats.Start("nodeinfo", "openfaas-fn")
+
+	now = now.Add(time.Second)
+	first()
+	first()
+
+	now = now.Add(time.Second)
+	snapshot := stats.Snapshot("nodeinfo", "openfaas-fn")
+
+	if snapshot.Requests != 1 || snapshot.InFlight != 1 {
+		t.Errorf("want 1 request completed and 1 in flight, got %+v", snapshot)
+	}
+	if snapshot.Latency != time.Second {
+		t.Errorf("want a latency of 1s, got %s", snapshot.Latency)
+	}
+	if snapshot.Busy != 3*time.Second {
+		t.Errorf("want 3s in flight, got %s", snapshot.Busy)
+	}
+
+	stats.Forget("nodeinfo", "openfaas-fn")
+	second()
+	stats.Forget("nodeinfo", "openfaas-fn")
+	if got := stats.Snapshot("nodeinfo", "openfaas-fn"); got.Requests != 0 {
+		t.Errorf("want the counters to be removed, got %+v", got)
+	}
+}
+
+type autoscalerTest struct {
+	autoscaler *Autoscaler
+	client     *fake.Clientset
+	indexer    cache.Indexer
+	stats      *InvocationStats
+	now        time.Time
+}
+
+func newAutoscalerTest(t *testing.T, replicas int32, config AutoscalerConfig) *autoscalerTest {
+	t.Helper()
+
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "nodeinfo",
+			Namespace: "openfaas-fn",
+			Labels:    map[string]string{FunctionLabel: "nodeinfo"},
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: int32p(replicas),
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{
+					MaxReplicasLabel: "10",
+					ScaleTargetLabel: "10",
+				}},
+			},
+		},
+	}
+
+	client := fake.NewSimpleClientset(deployment)
+	deployments := informers.NewSharedInformerFactory(client, 0).Apps().V1().Deployments()
+	deployments.Informer().GetIndexer().Add(deployment)
+
+	test := &autoscalerTest{
+		client:  client,
+		indexer: deployments.Informer().GetIndexer(),
+		stats:   NewInvocationStats(),
+		now:     time.Unix(0, 0),
+	}
+	test.stats.now = func() time.Time { return test.now }
+	test.autoscaler = NewAutoscaler(client, deployments.Lister(), test.stats, config)
+	test.autoscaler.now = test.stats.now
+	return test
+}
+
+// tick sends rps requests per second for the interval and scales, the
+// Deployment of the lister is then updated from the client
+func (a *autoscalerTest) tick(t *testing.T, interval time.Duration, rps int) int32 {
+	t.Helper()
+
+	for i := 0; i < rps*int(interval/time.Second); i++ {
+		a.stats.Start("nodeinfo", "openfaas-fn")()
+	}
+	a.now = a.now.Add(interval)
+	a.autoscaler.scaleAll()
+
+	deployment, err := a.client.AppsV1().Deployments("openfaas-fn").Get(context.TODO(), "nodeinfo", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	a.indexer.Update(deployment)
+	return *deployment.Spec.Replicas
+}
+
+func Test_Autoscaler_ScalesOnTraffic(t *testing.T) {
+	test := newAutoscalerTest(t, 1, AutoscalerConfig{Interval: 15 * time.Second})
+
+	if got := test.tick(t, 15*time.Second, 0); got != 1 {
+		t.Fatalf("want the first interval to only record the counters, got %d replicas", got)
+	}
+	if got := test.tick(t, 15*time.Second, 40); got != 4 {
+		t.Errorf("want 4 replicas for 40 rps with a target of 10, got %d", got)
+	}
+	if got := test.tick(t, 15*time.Second, 500); got != 10 {
+		t.Errorf("want the maximum of 10 replicas, got %d", got)
+	}
+	if got := test.tick(t, 15*time.Second, 0); got != 1 {
+		t.Errorf("want the minimum of 1 replica without traffic, got %d", got)
+	}
+}
+
+func Test_Autoscaler_StabilizesScaleDown(t *testing.T) {
+	test := newAutoscalerTest(t, 1, AutoscalerConfig{Interval: 15 * time.Second, ScaleDownWindow: time.Minute})
+
+	test.tick(t, 15*time.Second, 0)
+	if got := test.tick(t, 15*time.Second, 60); got != 6 {
+		t.Fatalf("want 6 replicas, got %d", got)
+	}
+
+	// the traffic drops, the replicas are kept for the window
+	for i := 0; i < 3; i++ {
+		if got := test.tick(t, 15*time.Second, 20); got != 6 {
+			t.Fatalf("want 6 replicas during the window, got %d", got)
+		}
+	}
+
+	if got := test.tick(t, 15*time.Second, 20); got != 2 {
+		t.Errorf("want 2 replicas once the window passed, got %d", got)
+	}
+}
+
+func Test_Autoscaler_LeavesScaledToZero(t *testing.T) {
+	test := newAutoscalerTest(t, 0, AutoscalerConfig{Interval: 15 * time.Second})
+
+	test.tick(t, 15*time.Second, 0)
+	if got := test.tick(t, 15*time.Second, 0); got != 0 {
+		t.Errorf("want a function with zero replicas to be left to the cold start, got %d", got)
+	}
+}
+
+func Test_Autoscaler_ForgetsDeletedFunctions(t *testing.T) {
+	test := newAutoscalerTest(t, 1, AutoscalerConfig{Interval: 15 * time.Second})
+
+	test.tick(t, 15*time.Second, 1)
+	deployment, _ := test.client.AppsV1().Deployments("openfaas-fn").Get(context.TODO(), "nodeinfo", metav1.GetOptions{})
+	test.indexer.Delete(deployment)
+	test.autoscaler.scaleAll()
+
+	if len(test.autoscaler.functions) != 0 {
+		t.Errorf("want the state of the deleted function to be removed")
+	}
+	if got := test.stats.Snapshot("nodeinfo", "openfaas-fn"); got.Requests != 0 {
+		t.Errorf("want the counters of the deleted function to be removed, got %+v", got)
+	}
+}
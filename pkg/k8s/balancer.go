@@ -0,0 +1,173 @@
+// Copyright 2020 OpenFaaS Author(s)
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package k8s
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// LoadBalancerAnnotation selects the strategy used to pick the endpoint
+	// of a function for each request
+	LoadBalancerAnnotation = "com.openfaas.loadbalancer"
+	// LoadBalancerHeaderAnnotation names the request header hashed by the
+	// consistent-hash strategy
+	LoadBalancerHeaderAnnotation = "com.openfaas.loadbalancer.header"
+
+	// LoadBalancerRandom picks a random endpoint, this is the default
+	LoadBalancerRandom = "random"
+	// LoadBalancerRoundRobin picks the endpoints in turn
+	LoadBalancerRoundRobin = "round-robin"
+	// LoadBalancerLeastRequests picks the endpoint with the fewest requests
+	// in flight through the proxy
+	LoadBalancerLeastRequests = "least-requests"
+	// LoadBalancerConsistentHash picks the same endpoint for the same value
+	// of the request header, as long as that endpoint is available
+	LoadBalancerConsistentHash = "consistent-hash"
+
+	// defaultHashHeader is hashed by the consistent-hash strategy when the
+	// function does not set LoadBalancerHeaderAnnotation
+	defaultHashHeader = "X-Affinity-Key"
+)
+
+// Balancer picks one of the addresses of a function for a request
+type Balancer interface {
+	// Pick returns the index of the address to send the request to, the
+	// addresses are sorted and not empty. The request may be nil.
+	Pick(function string, addresses []string, r *http.Request) int
+}
+
+// NewBalancer returns the Balancer for the strategy, an unknown strategy
+// returns nil. The header is only used by the consistent-hash strategy.
+func NewBalancer(strategy, header string, requests *RequestTracker) Balancer {
+	switch strings.ToLower(strategy) {
+	case LoadBalancerRandom:
+		return randomBalancer{}
+	case LoadBalancerRoundRobin:
+		return &roundRobinBalancer{}
+	case LoadBalancerLeastRequests:
+		return &leastRequestsBalancer{requests: requests}
+	case LoadBalancerConsistentHash:
+		if len(header) == 0 {
+			header = defaultHashHeader
+		}
+		return &hashBalancer{header: header}
+	}
+	return nil
+}
+
+type randomBalancer struct{}
+
+func (randomBalancer) Pick(function string, addresses []string, r *http.Request) int {
+	return rand.Intn(len(addresses))
+}
+
+// roundRobinBalancer keeps a counter per function
+type roundRobinBalancer struct {
+	counters sync.Map
+}
+
+func (b *roundRobinBalancer) next(function string) uint64 {
+	counter, _ := b.counters.LoadOrStore(function, new(uint64))
+	return atomic.AddUint64(counter.(*uint64), 1) - 1
+}
+
+func (b *roundRobinBalancer) Pick(function string, addresses []string, r *http.Request) int {
+	return int(b.next(function) % uint64(len(addresses)))
+}
+
+// leastRequestsBalancer picks the address with the fewest requests in
+// flight, ties are broken in turn so that idle endpoints share the load
+type leastRequestsBalancer struct {
+	roundRobinBalancer
+	requests *RequestTracker
+}
+
+func (b *leastRequestsBalancer) Pick(function string, addresses []string, r *http.Request) int {
+	start := int(b.next(function) % uint64(len(addresses)))
+
+	picked := start
+	least := b.requests.InFlight(addresses[start])
+	for i := 1; i < len(addresses) && least > 0; i++ {
+		index := (start + i) % len(addresses)
+		if inFlight := b.requests.InFlight(addresses[index]); inFlight < least {
+			picked, least = index, inFlight
+		}
+	}
+	return picked
+}
+
+// hashBalancer uses rendezvous hashing of the header value, so that only
+// the keys of an endpoint which was added or removed move to another one.
+// Requests without the header are sent to a random endpoint.
+type hashBalancer struct {
+	header string
+}
+
+func (b *hashBalancer) Pick(function string, addresses []string, r *http.Request) int {
+	if r == nil {
+		return rand.Intn(len(addresses))
+	}
+
+	key := r.Header.Get(b.header)
+	if len(key) == 0 {
+		return rand.Intn(len(addresses))
+	}
+
+	picked := 0
+	var highest uint64
+	for i, address := range addresses {
+		h := fnv.New64a()
+		h.Write([]byte(key))
+		h.Write([]byte(address))
+		if score := h.Sum64(); i == 0 || score > highest {
+			picked, highest = i, score
+		}
+	}
+	return picked
+}
+
+// RequestTracker counts the requests in flight to each endpoint address, the
+// proxy starts and ends the requests it sends to the functions
+type RequestTracker struct {
+	lock     sync.Mutex
+	inFlight map[string]int64
+}
+
+// NewRequestTracker creates an empty RequestTracker
+func NewRequestTracker() *RequestTracker {
+	return &RequestTracker{inFlight: map[string]int64{}}
+}
+
+// Start records a request to the address, the returned func ends it
+func (t *RequestTracker) Start(address string) func() {
+	t.lock.Lock()
+	t.inFlight[address]++
+	t.lock.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			t.lock.Lock()
+			defer t.lock.Unlock()
+
+			t.inFlight[address]--
+			if t.inFlight[address] <= 0 {
+				delete(t.inFlight, address)
+			}
+		})
+	}
+}
+
+// InFlight returns the number of requests in flight to the address
+func (t *RequestTracker) InFlight(address string) int64 {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.inFlight[address]
+}
@@ -0,0 +1,163 @@
+// Copyright 2020 OpenFaaS Author(s)
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package k8s
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func Test_NewBalancer_UnknownStrategy(t *testing.T) {
+	if b := NewBalancer("fastest", "", NewRequestTracker()); b != nil {
+		t.Errorf("want no balancer for an unknown strategy, got %T", b)
+	}
+}
+
+func Test_RoundRobinBalancer(t *testing.T) {
+	b := NewBalancer(LoadBalancerRoundRobin, "", NewRequestTracker())
+	addresses := []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}
+
+	for i := 0; i < 6; i++ {
+		if got := b.Pick("nodeinfo", addresses, nil); got != i%3 {
+			t.Errorf("pick %d: want %d, got %d", i, i%3, got)
+		}
+	}
+
+	// each function has its own counter
+	if got := b.Pick("figlet", addresses, nil); got != 0 {
+		t.Errorf("want the first address for another function, got %d", got)
+	}
+}
+
+func Test_LeastRequestsBalancer(t *testing.T) {
+	requests := NewRequestTracker()
+	b := NewBalancer(LoadBalancerLeastRequests, "", requests)
+	addresses := []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}
+
+	done1 := requests.Start("10.0.0.1")
+	requests.Start("10.0.0.1")
+	requests.Start("10.0.0.2")
+
+	if got := b.Pick("nodeinfo", addresses, nil); got != 2 {
+		t.Errorf("want the idle address, got %s", addresses[got])
+	}
+
+	requests.Start("10.0.0.3")
+	requests.Start("10.0.0.3")
+	done1()
+
+	if got := b.Pick("nodeinfo", addresses, nil); got == 2 {
+		t.Errorf("want one of the least busy addresses, got %s", addresses[got])
+	}
+
+	// ending a request twice has no effect
+	done1()
+	if got := requests.InFlight("10.0.0.1"); got != 1 {
+		t.Errorf("want 1 request in flight, got %d", got)
+	}
+}
+
+func Test_HashBalancer(t *testing.T) {
+	b := NewBalancer(LoadBalancerConsistentHash, "X-Tenant", NewRequestTracker())
+	addresses := []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4"}
+
+	picks := map[string]string{}
+	for _, tenant := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("X-Tenant", tenant)
+
+		first := addresses[b.Pick("nodeinfo", addresses, r)]
+		if again := addresses[b.Pick("nodeinfo", addresses, r)]; again != first {
+			t.Fatalf("want the same address for tenant %s, got %s and %s", tenant, first, again)
+		}
+		picks[tenant] = first
+	}
+
+	// removing an address only moves the keys which were sent to it
+	remaining := []string{"10.0.0.1", "10.0.0.2", "10.0.0.4"}
+	for tenant, previous := range picks {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("X-Tenant", tenant)
+
+		got := remaining[b.Pick("nodeinfo", remaining, r)]
+		if previous != "10.0.0.3" && got != previous {
+			t.Errorf("want tenant %s to stay on %s, got %s", tenant, previous, got)
+		}
+	}
+}
+
+func Test_FunctionLookup_ResolveRequest(t *testing.T) {
+	factory := informers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0)
+	endpoints := factory.Core().V1().Endpoints()
+	deployments := factory.Apps().V1().Deployments()
+
+	endpoints.Informer().GetIndexer().Add(&corev1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{Name: "nodeinfo", Namespace: "openfaas-fn"},
+		Subsets: []corev1.EndpointSubset{
+			{
+				Addresses:         []corev1.EndpointAddress{{IP: "10.0.0.2"}},
+				NotReadyAddresses: []corev1.EndpointAddress{{IP: "10.0.0.9"}},
+				Ports: []corev1.EndpointPort{
+					{Name: "metrics", Port: 8081},
+					{Name: FunctionPortName, Port: 3000},
+				},
+			},
+			{Addresses: []corev1.EndpointAddress{{IP: "10.0.0.1"}}},
+		},
+	})
+	endpoints.Informer().GetIndexer().Add(&corev1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{Name: "starting", Namespace: "openfaas-fn"},
+		Subsets: []corev1.EndpointSubset{
+			{NotReadyAddresses: []corev1.EndpointAddress{{IP: "10.0.0.5"}}},
+		},
+	})
+	deployments.Informer().GetIndexer().Add(&appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "nodeinfo",
+			Namespace:   "openfaas-fn",
+			Annotations: map[string]string{LoadBalancerAnnotation: "Least-Requests"},
+		},
+	})
+
+	lookup := NewFunctionLookup("openfaas-fn", endpoints.Lister(), deployments.Lister())
+
+	first, done, err := lookup.ResolveRequest("nodeinfo", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	second, _, err := lookup.ResolveRequest("nodeinfo", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if first.Host == second.Host {
+		t.Errorf("want the addresses of both subsets to be used, got %s twice", first.Host)
+	}
+	for _, u := range []string{first.Host, second.Host} {
+		if u != "10.0.0.1:8080" && u != "10.0.0.2:3000" {
+			t.Errorf("want the named port of the subset, or the watchdog port without one, got %s", u)
+		}
+	}
+
+	done()
+	if got := lookup.Requests.InFlight(first.Host); got != 0 {
+		t.Errorf("want no requests in flight to %s, got %d", first.Host, got)
+	}
+	if got := lookup.Requests.InFlight(second.Host); got != 1 {
+		t.Errorf("want 1 request in flight to %s, got %d", second.Host, got)
+	}
+
+	_, _, err = lookup.ResolveRequest("starting", nil)
+	if err == nil || !strings.Contains(err.Error(), "1 not ready") {
+		t.Errorf("want an error naming the not ready addresses, got %v", err)
+	}
+}
@@ -0,0 +1,192 @@
+// Copyright 2020 OpenFaaS Author(s)
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	coreinformer "k8s.io/client-go/informers/core/v1"
+	"k8s.io/client-go/kubernetes"
+	appslister "k8s.io/client-go/listers/apps/v1"
+	corelister "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// ColdStart scales functions with zero replicas up for the proxy and waits
+// for one of their endpoints to become ready. Requests which arrive while a
+// function is being scaled up share the same scale up.
+type ColdStart struct {
+	client      kubernetes.Interface
+	deployments appslister.DeploymentLister
+	endpoints   corelister.EndpointsLister
+	timeout     time.Duration
+
+	lock    sync.Mutex
+	pending map[string]*pendingStart
+}
+
+// pendingStart is closed when the function has a ready endpoint, or when the
+// scale up failed or timed out
+type pendingStart struct {
+	ready chan struct{}
+	err   error
+}
+
+// NewColdStart creates a ColdStart which waits at most timeout for a function
+// to become ready. It watches the Endpoints with the informer, so it can be
+// created before or after the informer is started.
+func NewColdStart(client kubernetes.Interface,
+	deployments appslister.DeploymentLister,
+	endpoints coreinformer.EndpointsInformer,
+	timeout time.Duration) *ColdStart {
+
+	c := &ColdStart{
+		client:      client,
+		deployments: deployments,
+		endpoints:   endpoints.Lister(),
+		timeout:     timeout,
+		pending:     map[string]*pendingStart{},
+	}
+
+	endpoints.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: c.endpointsChanged,
+		UpdateFunc: func(old, new interface{}) {
+			c.endpointsChanged(new)
+		},
+	})
+
+	return c
+}
+
+// Wait scales the function up when it has zero replicas and blocks until it
+// has a ready endpoint, the timeout is reached or the context is done
+func (c *ColdStart) Wait(ctx context.Context, functionName, namespace string) error {
+	deployment, err := c.deployments.Deployments(namespace).Get(functionName)
+	if err != nil {
+		return err
+	}
+
+	key := namespace + "/" + functionName
+
+	c.lock.Lock()
+	p, ok := c.pending[key]
+	if !ok {
+		p = &pendingStart{ready: make(chan struct{})}
+		c.pending[key] = p
+
+		replicas := int32(1)
+		if min := GetMinReplicaCount(deployment.Spec.Template.Labels); min != nil {
+			replicas = *min
+		}
+
+		go c.start(key, functionName, namespace, replicas, p)
+	}
+	c.lock.Unlock()
+
+	// the endpoints may have become ready before the wait was registered
+	if c.isReady(functionName, namespace) {
+		c.finish(key, p, nil)
+	}
+
+	select {
+	case <-p.ready:
+		return p.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// start scales the Deployment up if it has no replicas and ends the pending
+// start when the timeout is reached first
+func (c *ColdStart) start(key, functionName, namespace string, replicas int32, p *pendingStart) {
+	if err := c.scaleUp(functionName, namespace, replicas); err != nil {
+		c.finish(key, p, fmt.Errorf("unable to scale up %s.%s: %s", functionName, namespace, err))
+		return
+	}
+
+	timer := time.NewTimer(c.timeout)
+	defer timer.Stop()
+
+	select {
+	case <-p.ready:
+	case <-timer.C:
+		c.finish(key, p, fmt.Errorf("%s.%s was not ready after %s", functionName, namespace, c.timeout))
+	}
+}
+
+// scaleUp sets the replicas of the Deployment, unless it was scaled by
+// someone else in the meantime
+func (c *ColdStart) scaleUp(functionName, namespace string, replicas int32) error {
+	patch := fmt.Sprintf(`[{"op":"test","path":"/spec/replicas","value":0},{"op":"replace","path":"/spec/replicas","value":%d}]`, replicas)
+
+	_, err := c.client.AppsV1().Deployments(namespace).
+		Patch(context.TODO(), functionName, k8stypes.JSONPatchType, []byte(patch), metav1.PatchOptions{})
+	if status, ok := err.(errors.APIStatus); ok && status.Status().Code == http.StatusUnprocessableEntity {
+		// the test failed, the Deployment already has replicas
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Scaling %s.%s from zero to %d replicas\n", functionName, namespace, replicas)
+	return nil
+}
+
+func (c *ColdStart) isReady(functionName, namespace string) bool {
+	endpoints, err := c.endpoints.Endpoints(namespace).Get(functionName)
+	if err != nil {
+		return false
+	}
+	return hasReadyAddress(endpoints)
+}
+
+func (c *ColdStart) endpointsChanged(obj interface{}) {
+	endpoints, ok := obj.(*corev1.Endpoints)
+	if !ok || !hasReadyAddress(endpoints) {
+		return
+	}
+
+	key := endpoints.Namespace + "/" + endpoints.Name
+
+	c.lock.Lock()
+	p, ok := c.pending[key]
+	c.lock.Unlock()
+
+	if ok {
+		c.finish(key, p, nil)
+	}
+}
+
+// finish ends the pending start with the error, the first call wins
+func (c *ColdStart) finish(key string, p *pendingStart, err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.pending[key] != p {
+		return
+	}
+	delete(c.pending, key)
+
+	p.err = err
+	close(p.ready)
+}
+
+func hasReadyAddress(endpoints *corev1.Endpoints) bool {
+	for _, subset := range endpoints.Subsets {
+		if len(subset.Addresses) > 0 {
+			return true
+		}
+	}
+	return false
+}
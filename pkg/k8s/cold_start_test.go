@@ -0,0 +1,114 @@
+// Copyright 2020 OpenFaaS Author(s)
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package k8s
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newColdStartTest(t *testing.T, timeout time.Duration) (*ColdStart, *fake.Clientset) {
+	t.Helper()
+
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "nodeinfo", Namespace: "openfaas-fn"},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: int32p(0),
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{MinReplicasLabel: "2"}},
+			},
+		},
+	}
+
+	client := fake.NewSimpleClientset(deployment)
+	factory := informers.NewSharedInformerFactory(client, 0)
+	deployments := factory.Apps().V1().Deployments()
+	endpoints := factory.Core().V1().Endpoints()
+
+	deployments.Informer().GetIndexer().Add(deployment)
+	endpoints.Informer().GetIndexer().Add(&corev1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{Name: "nodeinfo", Namespace: "openfaas-fn"},
+	})
+
+	return NewColdStart(client, deployments.Lister(), endpoints, timeout), client
+}
+
+func Test_ColdStart_SharesScaleUp(t *testing.T) {
+	coldStart, client := newColdStartTest(t, time.Minute)
+
+	errs := make(chan error, 3)
+	wg := sync.WaitGroup{}
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- coldStart.Wait(context.Background(), "nodeinfo", "openfaas-fn")
+		}()
+	}
+
+	// wait for the scale up before the endpoints become ready
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		deployment, err := client.AppsV1().Deployments("openfaas-fn").Get(context.TODO(), "nodeinfo", metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if *deployment.Spec.Replicas == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("want the Deployment to be scaled to the minimum of 2 replicas, got %d", *deployment.Spec.Replicas)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	coldStart.endpointsChanged(&corev1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{Name: "nodeinfo", Namespace: "openfaas-fn"},
+		Subsets:    []corev1.EndpointSubset{{Addresses: []corev1.EndpointAddress{{IP: "10.0.0.1"}}}},
+	})
+
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+	}
+
+	patches := 0
+	for _, action := range client.Actions() {
+		if action.GetVerb() == "patch" {
+			patches++
+		}
+	}
+	if patches != 1 {
+		t.Errorf("want one scale up for the concurrent requests, got %d", patches)
+	}
+}
+
+func Test_ColdStart_Timeout(t *testing.T) {
+	coldStart, _ := newColdStartTest(t, 50*time.Millisecond)
+
+	err := coldStart.Wait(context.Background(), "nodeinfo", "openfaas-fn")
+	if err == nil || !strings.Contains(err.Error(), "was not ready after 50ms") {
+		t.Errorf("want a timeout error, got %v", err)
+	}
+}
+
+func Test_ColdStart_UnknownFunction(t *testing.T) {
+	coldStart, _ := newColdStartTest(t, time.Minute)
+
+	if err := coldStart.Wait(context.Background(), "figlet", "openfaas-fn"); err == nil {
+		t.Errorf("want an error for a function without a Deployment")
+	}
+}
@@ -0,0 +1,214 @@
+// Copyright 2020 OpenFaaS Author(s)
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/openfaas/faas-netes/pkg/metrics"
+)
+
+const (
+	// MaxInflightAnnotation limits the requests in flight to all the
+	// replicas of a function
+	MaxInflightAnnotation = "com.openfaas.max_inflight"
+	// MaxInflightPodAnnotation limits the requests in flight to each ready
+	// replica of a function
+	MaxInflightPodAnnotation = "com.openfaas.max_inflight.pod"
+	// MaxInflightQueueAnnotation is the number of requests over the limit
+	// which wait for a slot, further requests are rejected
+	MaxInflightQueueAnnotation = "com.openfaas.max_inflight.queue"
+	// MaxInflightTimeoutAnnotation is how long a request waits for a slot
+	// before it is rejected
+	MaxInflightTimeoutAnnotation = "com.openfaas.max_inflight.timeout"
+
+	defaultQueueTimeout = 10 * time.Second
+	retryAfter          = time.Second
+)
+
+// ConcurrencyLimits of a function, read from the annotations of its
+// Deployment. A zero limit means that the requests are not limited.
+type ConcurrencyLimits struct {
+	MaxInflight       int
+	MaxInflightPerPod int
+	QueueSize         int
+	QueueTimeout      time.Duration
+}
+
+// ParseConcurrencyLimits reads the limits from the annotations of a function
+func ParseConcurrencyLimits(annotations map[string]string) (ConcurrencyLimits, error) {
+	limits := ConcurrencyLimits{QueueTimeout: defaultQueueTimeout}
+
+	for key, value := range map[string]*int{
+		MaxInflightAnnotation:      &limits.MaxInflight,
+		MaxInflightPodAnnotation:   &limits.MaxInflightPerPod,
+		MaxInflightQueueAnnotation: &limits.QueueSize,
+	} {
+		raw, ok := annotations[key]
+		if !ok {
+			continue
+		}
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			return ConcurrencyLimits{}, fmt.Errorf("invalid %s %q, must be a positive number", key, raw)
+		}
+		*value = n
+	}
+
+	if raw, ok := annotations[MaxInflightTimeoutAnnotation]; ok {
+		timeout, err := time.ParseDuration(raw)
+		if err != nil || timeout < 0 {
+			return ConcurrencyLimits{}, fmt.Errorf("invalid %s %q, must be a duration like 5s", MaxInflightTimeoutAnnotation, raw)
+		}
+		limits.QueueTimeout = timeout
+	}
+
+	return limits, nil
+}
+
+// TooManyRequestsError is returned when a function is at its concurrency
+// limit and the request could not be queued or waited too long
+type TooManyRequestsError struct {
+	Function string
+	Reason   string
+}
+
+func (e *TooManyRequestsError) Error() string {
+	return fmt.Sprintf("too many requests for %s, %s", e.Function, e.Reason)
+}
+
+// RetryAfter is how long the client should wait before retrying
+func (e *TooManyRequestsError) RetryAfter() time.Duration {
+	return retryAfter
+}
+
+// ConcurrencyLimiter counts the requests in flight to each function, the
+// requests over the limit of a function wait in a bounded queue and are
+// given a slot in the order that they arrived.
+type ConcurrencyLimiter struct {
+	lock      sync.Mutex
+	functions map[string]*functionSlots
+}
+
+type functionSlots struct {
+	name      string
+	namespace string
+	capacity  int
+	inFlight  int
+	waiting   []chan struct{}
+}
+
+// NewConcurrencyLimiter creates an empty ConcurrencyLimiter
+func NewConcurrencyLimiter() *ConcurrencyLimiter {
+	return &ConcurrencyLimiter{functions: map[string]*functionSlots{}}
+}
+
+// Acquire waits for one of the capacity slots of the function, zero is no
+// limit. The returned func gives the slot back and must be called once the
+// request is complete.
+func (c *ConcurrencyLimiter) Acquire(ctx context.Context, functionName, namespace string, capacity int, limits ConcurrencyLimits) (func(), error) {
+	key := functionName + "." + namespace
+
+	c.lock.Lock()
+	slots, ok := c.functions[key]
+	if !ok {
+		slots = &functionSlots{name: functionName, namespace: namespace}
+		c.functions[key] = slots
+	}
+	slots.capacity = capacity
+
+	if capacity == 0 || (slots.inFlight < capacity && len(slots.waiting) == 0) {
+		slots.inFlight++
+		slots.record()
+		c.lock.Unlock()
+		return c.releaseFunc(key, slots), nil
+	}
+
+	if len(slots.waiting) >= limits.QueueSize {
+		c.lock.Unlock()
+		return nil, &TooManyRequestsError{Function: key, Reason: fmt.Sprintf("%d requests in flight", capacity)}
+	}
+
+	ready := make(chan struct{})
+	slots.waiting = append(slots.waiting, ready)
+	slots.record()
+	c.lock.Unlock()
+
+	timer := time.NewTimer(limits.QueueTimeout)
+	defer timer.Stop()
+
+	var err error
+	select {
+	case <-ready:
+		return c.releaseFunc(key, slots), nil
+	case <-timer.C:
+		err = &TooManyRequestsError{Function: key, Reason: fmt.Sprintf("no slot after waiting %s", limits.QueueTimeout)}
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for i, waiting := range slots.waiting {
+		if waiting == ready {
+			slots.waiting = append(slots.waiting[:i], slots.waiting[i+1:]...)
+			slots.record()
+			if slots.inFlight == 0 && len(slots.waiting) == 0 {
+				delete(c.functions, key)
+			}
+			return nil, err
+		}
+	}
+
+	// the slot was given to the request while it stopped waiting
+	return c.releaseFunc(key, slots), nil
+}
+
+// InFlight returns the requests in flight to the function
+func (c *ConcurrencyLimiter) InFlight(functionName, namespace string) int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if slots, ok := c.functions[functionName+"."+namespace]; ok {
+		return slots.inFlight
+	}
+	return 0
+}
+
+func (c *ConcurrencyLimiter) releaseFunc(key string, slots *functionSlots) func() {
+	once := sync.Once{}
+	return func() {
+		once.Do(func() {
+			c.release(key, slots)
+		})
+	}
+}
+
+// release gives the slot to the first waiting request, when the function is
+// still under its capacity
+func (c *ConcurrencyLimiter) release(key string, slots *functionSlots) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	slots.inFlight--
+	for len(slots.waiting) > 0 && (slots.capacity == 0 || slots.inFlight < slots.capacity) {
+		close(slots.waiting[0])
+		slots.waiting = slots.waiting[1:]
+		slots.inFlight++
+	}
+	slots.record()
+
+	if slots.inFlight == 0 && len(slots.waiting) == 0 {
+		delete(c.functions, key)
+	}
+}
+
+func (s *functionSlots) record() {
+	metrics.FunctionInFlight.WithLabelValues(s.name, s.namespace).Set(float64(s.inFlight))
+	metrics.FunctionQueued.WithLabelValues(s.name, s.namespace).Set(float64(len(s.waiting)))
+}
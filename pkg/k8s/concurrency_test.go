@@ -0,0 +1,159 @@
+// Copyright 2020 OpenFaaS Author(s)
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package k8s
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/openfaas/faas-netes/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func Test_ParseConcurrencyLimits(t *testing.T) {
+	limits, err := ParseConcurrencyLimits(map[string]string{
+		MaxInflightAnnotation:        "10",
+		MaxInflightPodAnnotation:     "2",
+		MaxInflightQueueAnnotation:   "5",
+		MaxInflightTimeoutAnnotation: "3s",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	want := ConcurrencyLimits{MaxInflight: 10, MaxInflightPerPod: 2, QueueSize: 5, QueueTimeout: 3 * time.Second}
+	if limits != want {
+		t.Errorf("want %+v, got %+v", want, limits)
+	}
+
+	for _, annotations := range []map[string]string{
+		{MaxInflightAnnotation: "-1"},
+		{MaxInflightQueueAnnotation: "ten"},
+		{MaxInflightTimeoutAnnotation: "3"},
+	} {
+		if _, err := ParseConcurrencyLimits(annotations); err == nil {
+			t.Errorf("want an error for %v", annotations)
+		}
+	}
+}
+
+func Test_ConcurrencyLimiter_QueuesOverTheLimit(t *testing.T) {
+	limiter := NewConcurrencyLimiter()
+	limits := ConcurrencyLimits{MaxInflight: 1, QueueSize: 1, QueueTimeout: 5 * time.Second}
+
+	release, err := limiter.Acquire(context.Background(), "nodeinfo", "openfaas-fn", 1, limits)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	acquired := make(chan func())
+	go func() {
+		queued, err := limiter.Acquire(context.Background(), "nodeinfo", "openfaas-fn", 1, limits)
+		if err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+		acquired <- queued
+	}()
+
+	// wait for the second request to be queued
+	deadline := time.Now().Add(5 * time.Second)
+	for testutil.ToFloat64(metrics.FunctionQueued.WithLabelValues("nodeinfo", "openfaas-fn")) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("want the second request to be queued")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	_, err = limiter.Acquire(context.Background(), "nodeinfo", "openfaas-fn", 1, limits)
+	if _, ok := err.(*TooManyRequestsError); !ok {
+		t.Errorf("want a TooManyRequestsError when the queue is full, got %v", err)
+	}
+
+	release()
+	queued := <-acquired
+	if got := limiter.InFlight("nodeinfo", "openfaas-fn"); got != 1 {
+		t.Errorf("want the slot to be given to the queued request, got %d in flight", got)
+	}
+
+	queued()
+	queued()
+	if got := limiter.InFlight("nodeinfo", "openfaas-fn"); got != 0 {
+		t.Errorf("want no requests in flight, got %d", got)
+	}
+}
+
+func Test_ConcurrencyLimiter_QueueTimeout(t *testing.T) {
+	limiter := NewConcurrencyLimiter()
+	limits := ConcurrencyLimits{MaxInflight: 1, QueueSize: 1, QueueTimeout: 20 * time.Millisecond}
+
+	release, err := limiter.Acquire(context.Background(), "nodeinfo", "openfaas-fn", 1, limits)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer release()
+
+	_, err = limiter.Acquire(context.Background(), "nodeinfo", "openfaas-fn", 1, limits)
+	limitErr, ok := err.(*TooManyRequestsError)
+	if !ok {
+		t.Fatalf("want a TooManyRequestsError after the timeout, got %v", err)
+	}
+	if limitErr.RetryAfter() <= 0 {
+		t.Errorf("want a positive RetryAfter")
+	}
+	if got := testutil.ToFloat64(metrics.FunctionQueued.WithLabelValues("nodeinfo", "openfaas-fn")); got != 0 {
+		t.Errorf("want the request to leave the queue, got %v queued", got)
+	}
+}
+
+func Test_FunctionLookup_MaxInflightPerPod(t *testing.T) {
+	factory := informers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0)
+	endpoints := factory.Core().V1().Endpoints()
+	deployments := factory.Apps().V1().Deployments()
+
+	endpoints.Informer().GetIndexer().Add(&corev1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{Name: "figlet", Namespace: "openfaas-fn"},
+		Subsets: []corev1.EndpointSubset{
+			{Addresses: []corev1.EndpointAddress{{IP: "10.0.0.1"}, {IP: "10.0.0.2"}}},
+		},
+	})
+	deployments.Informer().GetIndexer().Add(&appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "figlet",
+			Namespace:   "openfaas-fn",
+			Annotations: map[string]string{MaxInflightPodAnnotation: "1"},
+		},
+	})
+
+	lookup := NewFunctionLookup("openfaas-fn", endpoints.Lister(), deployments.Lister())
+
+	first, done, err := lookup.ResolveRequest("figlet", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	second, _, err := lookup.ResolveRequest("figlet", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if first.Host == second.Host {
+		t.Errorf("want each pod to get one request, got %s twice", first.Host)
+	}
+
+	if _, _, err := lookup.ResolveRequest("figlet", nil); err == nil {
+		t.Errorf("want an error when both pods are at their limit")
+	}
+
+	done()
+	third, _, err := lookup.ResolveRequest("figlet", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if third.Host != first.Host {
+		t.Errorf("want the pod with a free slot %s, got %s", first.Host, third.Host)
+	}
+}
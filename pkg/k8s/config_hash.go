@@ -0,0 +1,66 @@
+package k8s
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// ConfigHashAnnotation is set on the Pod template of a function with a hash of
+// the Secrets and Profiles used by the function. A change to the Secrets or
+// Profiles changes the hash, which triggers a rolling restart of the function.
+const ConfigHashAnnotation = "com.openfaas.config.hash"
+
+// ConfigHash returns a hash of the data of the given Secrets and of the specs
+// of the given Profiles, the order of the Secrets does not change the hash.
+func ConfigHash(secrets map[string]*corev1.Secret, profiles []Profile) string {
+	hash := sha256.New()
+
+	names := make([]string, 0, len(secrets))
+	for name := range secrets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		secret := secrets[name]
+		fmt.Fprintf(hash, "secret:%s:%s\n", name, secret.Type)
+
+		keys := make([]string, 0, len(secret.Data))
+		for key := range secret.Data {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			fmt.Fprintf(hash, "%s=", key)
+			hash.Write(secret.Data[key])
+			hash.Write([]byte("\n"))
+		}
+	}
+
+	for _, profile := range profiles {
+		// json.Marshal sorts map keys, so the output is stable
+		spec, _ := json.Marshal(profile)
+		fmt.Fprintf(hash, "profile:%s\n", spec)
+	}
+
+	return fmt.Sprintf("%x", hash.Sum(nil))
+}
+
+// SetConfigHash stamps the hash on the Pod template of the Deployment. The
+// template annotations are copied first because callers often share the same
+// map between the Deployment and its template.
+func SetConfigHash(deployment *appsv1.Deployment, hash string) {
+	annotations := make(map[string]string, len(deployment.Spec.Template.Annotations)+1)
+	for k, v := range deployment.Spec.Template.Annotations {
+		annotations[k] = v
+	}
+	annotations[ConfigHashAnnotation] = hash
+
+	deployment.Spec.Template.Annotations = annotations
+}
@@ -0,0 +1,54 @@
+package k8s
+
+import (
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func Test_ConfigHash(t *testing.T) {
+	secrets := map[string]*corev1.Secret{
+		"db-password": {Type: corev1.SecretTypeOpaque, Data: map[string][]byte{"db-password": []byte("s3cr3t")}},
+		"api-key":     {Type: corev1.SecretTypeOpaque, Data: map[string][]byte{"api-key": []byte("key")}},
+	}
+	profiles := []Profile{
+		{Tolerations: []corev1.Toleration{{Key: "gpu", Operator: corev1.TolerationOpExists}}},
+	}
+
+	hash := ConfigHash(secrets, profiles)
+	if hash != ConfigHash(secrets, profiles) {
+		t.Fatalf("want the same hash for the same Secrets and Profiles")
+	}
+
+	changedSecrets := map[string]*corev1.Secret{
+		"db-password": {Type: corev1.SecretTypeOpaque, Data: map[string][]byte{"db-password": []byte("changed")}},
+		"api-key":     secrets["api-key"],
+	}
+	if hash == ConfigHash(changedSecrets, profiles) {
+		t.Errorf("want the hash to change when Secret data changes")
+	}
+
+	changedProfiles := []Profile{
+		{Tolerations: []corev1.Toleration{{Key: "arm64", Operator: corev1.TolerationOpExists}}},
+	}
+	if hash == ConfigHash(secrets, changedProfiles) {
+		t.Errorf("want the hash to change when a Profile changes")
+	}
+}
+
+func Test_SetConfigHash_DoesNotChangeDeploymentAnnotations(t *testing.T) {
+	annotations := map[string]string{"prometheus.io.scrape": "false"}
+	deployment := &appsv1.Deployment{}
+	deployment.Annotations = annotations
+	deployment.Spec.Template.Annotations = annotations
+
+	SetConfigHash(deployment, "abc")
+
+	if got := deployment.Spec.Template.Annotations[ConfigHashAnnotation]; got != "abc" {
+		t.Errorf("want template annotation %s, got %q", "abc", got)
+	}
+	if _, ok := deployment.Annotations[ConfigHashAnnotation]; ok {
+		t.Errorf("want the Deployment annotations to be unchanged")
+	}
+}
@@ -0,0 +1,309 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"reflect"
+
+	faasv1 "github.com/openfaas/faas-netes/pkg/apis/openfaas/v1"
+	profileinformers "github.com/openfaas/faas-netes/pkg/client/informers/externalversions/openfaas/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	appsinformers "k8s.io/client-go/informers/apps/v1"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	// secretsIndex indexes function Deployments by the namespace/name of the Secrets they use
+	secretsIndex = "secrets"
+	// profilesIndex indexes function Deployments by the names of the Profiles they use
+	profilesIndex = "profiles"
+)
+
+// ConfigWatcher rolls the function Deployments created by the faas-netes
+// controller when a Secret or Profile used by the function changes. The
+// operator does the same from its reconcile loop.
+type ConfigWatcher struct {
+	factory     FunctionFactory
+	deployments cache.Indexer
+	lister      appslisters.DeploymentLister
+	secrets     corelisters.SecretLister
+
+	// initialSecrets and initialProfiles are the objects which existed when
+	// the watcher was created, the informers replay them as Adds
+	initialSecrets  initialObjects
+	initialProfiles initialObjects
+}
+
+// AddConfigIndexers registers the indexes of the ConfigWatcher on the
+// Deployment informer, it must be called before the informer is started.
+func AddConfigIndexers(deployments appsinformers.DeploymentInformer) error {
+	return deployments.Informer().AddIndexers(cache.Indexers{
+		secretsIndex:  deploymentSecretsIndexFunc,
+		profilesIndex: deploymentProfilesIndexFunc,
+	})
+}
+
+// NewConfigWatcher registers the event handlers of the ConfigWatcher on
+// informers which have synced and have the indexes of AddConfigIndexers.
+// Only one replica should create it, the leader, so that a change rolls the
+// functions once.
+func NewConfigWatcher(
+	factory FunctionFactory,
+	deployments appsinformers.DeploymentInformer,
+	secrets coreinformers.SecretInformer,
+	profiles profileinformers.ProfileInformer) *ConfigWatcher {
+
+	w := &ConfigWatcher{
+		factory:         factory,
+		deployments:     deployments.Informer().GetIndexer(),
+		lister:          deployments.Lister(),
+		secrets:         secrets.Lister(),
+		initialSecrets:  newInitialObjects(secrets.Informer().GetStore()),
+		initialProfiles: newInitialObjects(profiles.Informer().GetStore()),
+	}
+
+	secrets.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: w.secretAdded,
+		UpdateFunc: func(old, new interface{}) {
+			if old.(metav1.Object).GetResourceVersion() == new.(metav1.Object).GetResourceVersion() {
+				return
+			}
+			w.secretChanged(new)
+		},
+	})
+
+	profiles.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    w.profileAdded,
+		UpdateFunc: w.profileChanged,
+		DeleteFunc: w.profileDeleted,
+	})
+
+	return w
+}
+
+// secretAdded rolls the functions which use a new Secret, the Secrets which
+// already existed are not changed and would only roll every function
+func (w *ConfigWatcher) secretAdded(obj interface{}) {
+	if w.initialSecrets.replayed(obj) {
+		return
+	}
+	w.secretChanged(obj)
+}
+
+func (w *ConfigWatcher) secretChanged(obj interface{}) {
+	secret, ok := obj.(*corev1.Secret)
+	if !ok {
+		return
+	}
+
+	key := secret.Namespace + "/" + secret.Name
+	w.rollDeployments(secretsIndex, key, nil)
+}
+
+func (w *ConfigWatcher) profileChanged(old, new interface{}) {
+	oldProfile, ok := old.(*faasv1.Profile)
+	if !ok {
+		return
+	}
+	newProfile, ok := new.(*faasv1.Profile)
+	if !ok {
+		return
+	}
+
+	if reflect.DeepEqual(oldProfile.Spec, newProfile.Spec) {
+		return
+	}
+
+	// the Deployment was mutated by the old Profile when it was deployed, so
+	// the old values have to be removed before the new ones are applied
+	w.rollDeployments(profilesIndex, newProfile.Name, func(deployment *appsv1.Deployment) {
+		w.factory.RemoveProfile(Profile(oldProfile.Spec), deployment)
+		w.factory.ApplyProfile(Profile(newProfile.Spec), deployment)
+	})
+}
+
+// profileAdded applies a new Profile to the functions which were deployed
+// before it was created
+func (w *ConfigWatcher) profileAdded(obj interface{}) {
+	profile, ok := obj.(*faasv1.Profile)
+	if !ok || w.initialProfiles.replayed(obj) {
+		return
+	}
+
+	w.rollDeployments(profilesIndex, profile.Name, func(deployment *appsv1.Deployment) {
+		w.factory.ApplyProfile(Profile(profile.Spec), deployment)
+	})
+}
+
+// profileDeleted removes the last known values of a deleted Profile from the
+// functions which use it
+func (w *ConfigWatcher) profileDeleted(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	profile, ok := obj.(*faasv1.Profile)
+	if !ok {
+		return
+	}
+
+	w.rollDeployments(profilesIndex, profile.Name, func(deployment *appsv1.Deployment) {
+		w.factory.RemoveProfile(Profile(profile.Spec), deployment)
+	})
+}
+
+// rollDeployments updates the Deployments found in the index under key
+func (w *ConfigWatcher) rollDeployments(indexName, key string, mutate func(*appsv1.Deployment)) {
+	objs, err := w.deployments.ByIndex(indexName, key)
+	if err != nil {
+		log.Printf("Error looking up functions using %s %s: %s\n", indexName, key, err)
+		return
+	}
+
+	for _, obj := range objs {
+		deployment := obj.(*appsv1.Deployment)
+		if err := w.rollDeployment(deployment.Namespace, deployment.Name, mutate); err != nil {
+			log.Printf("Error rolling function %s.%s after a change to %s %s: %s\n",
+				deployment.Name, deployment.Namespace, indexName, key, err)
+		}
+	}
+}
+
+// rollDeployment applies mutate to the Deployment and stamps the new config
+// hash, which rolls the Pods when it changes. Deployments created before the
+// hash was recorded are left as they are, so that upgrading faas-netes does
+// not restart every function.
+//
+// The change is written with server-side apply under the FieldManager of
+// faas-netes, like the other writes of the Deployment, so that the config
+// hash and the fields of the Profiles keep a single owner.
+func (w *ConfigWatcher) rollDeployment(namespace, name string, mutate func(*appsv1.Deployment)) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		deployment, err := w.lister.Deployments(namespace).Get(name)
+		if err != nil {
+			return err
+		}
+
+		if _, ok := deployment.Spec.Template.Annotations[ConfigHashAnnotation]; !ok {
+			return nil
+		}
+
+		desired := deployment.DeepCopy()
+		if mutate != nil {
+			mutate(desired)
+		}
+
+		hash, err := w.configHash(desired)
+		if err != nil {
+			return err
+		}
+		SetConfigHash(desired, hash)
+
+		if equality.Semantic.DeepEqual(deployment, desired) {
+			return nil
+		}
+
+		// the replicas belong to whoever scales the function, see ApplyReplicas
+		desired.Spec.Replicas = ApplyReplicas(deployment, deployment.Spec.Template.Labels)
+
+		log.Printf("Rolling function %s.%s after a change to its Secrets or Profiles\n", name, namespace)
+		_, err = ApplyDeployment(context.TODO(), w.factory.Client, deployment, desired)
+		return err
+	})
+}
+
+// configHash computes the hash of the Secrets and Profiles used by the Deployment
+func (w *ConfigWatcher) configHash(deployment *appsv1.Deployment) (string, error) {
+	secrets := map[string]*corev1.Secret{}
+	for _, name := range ReadFunctionSecretsSpec(*deployment) {
+		secret, err := w.secrets.Secrets(deployment.Namespace).Get(name)
+		if err != nil {
+			return "", fmt.Errorf("unable to fetch secret %s: %s", name, err)
+		}
+		secrets[name] = secret
+	}
+
+	// a deleted Profile is no longer part of the config of the function
+	profiles := []Profile{}
+	for _, name := range ParseProfileNames(deployment.Annotations) {
+		profile, err := w.factory.Profiler.Profiles(w.factory.Config.ProfilesNamespace).Get(name)
+		if IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("unable to fetch profile %s: %s", name, err)
+		}
+		profiles = append(profiles, Profile(profile.Spec))
+	}
+
+	return ConfigHash(secrets, profiles), nil
+}
+
+// initialObjects holds the resource versions of the objects of a synced
+// informer by their key. An event handler added to the informer is first
+// given these objects as Adds, which are not changes.
+type initialObjects map[string]string
+
+func newInitialObjects(store cache.Store) initialObjects {
+	objects := initialObjects{}
+	for _, obj := range store.List() {
+		if meta, ok := obj.(metav1.Object); ok {
+			objects[meta.GetNamespace()+"/"+meta.GetName()] = meta.GetResourceVersion()
+		}
+	}
+	return objects
+}
+
+// replayed returns true when obj is one of the initial objects, unchanged.
+// Each object is replayed once, so it is forgotten once seen. It must only
+// be called from the event handler of the informer.
+func (o initialObjects) replayed(obj interface{}) bool {
+	meta, ok := obj.(metav1.Object)
+	if !ok {
+		return false
+	}
+
+	key := meta.GetNamespace() + "/" + meta.GetName()
+	resourceVersion, ok := o[key]
+	if !ok {
+		return false
+	}
+	delete(o, key)
+
+	return resourceVersion == meta.GetResourceVersion()
+}
+
+func deploymentSecretsIndexFunc(obj interface{}) ([]string, error) {
+	deployment, ok := obj.(*appsv1.Deployment)
+	if !ok {
+		return nil, fmt.Errorf("expected Deployment but got %T", obj)
+	}
+	if _, ok := deployment.Spec.Template.Labels["faas_function"]; !ok {
+		return nil, nil
+	}
+
+	keys := []string{}
+	for _, name := range ReadFunctionSecretsSpec(*deployment) {
+		keys = append(keys, deployment.Namespace+"/"+name)
+	}
+	return keys, nil
+}
+
+func deploymentProfilesIndexFunc(obj interface{}) ([]string, error) {
+	deployment, ok := obj.(*appsv1.Deployment)
+	if !ok {
+		return nil, fmt.Errorf("expected Deployment but got %T", obj)
+	}
+	if _, ok := deployment.Spec.Template.Labels["faas_function"]; !ok {
+		return nil, nil
+	}
+
+	return ParseProfileNames(deployment.Annotations), nil
+}
@@ -0,0 +1,312 @@
+package k8s
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	faasv1 "github.com/openfaas/faas-netes/pkg/apis/openfaas/v1"
+	faasfake "github.com/openfaas/faas-netes/pkg/client/clientset/versioned/fake"
+	faasinformers "github.com/openfaas/faas-netes/pkg/client/informers/externalversions"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	kubeinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+)
+
+func Test_ConfigWatcher_RollsDeploymentsOnChange(t *testing.T) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "api-key", Namespace: "openfaas-fn", ResourceVersion: "1"},
+		Type:       corev1.SecretTypeOpaque,
+		Data:       map[string][]byte{"api-key": []byte("v1")},
+	}
+	oldProfile := &faasv1.Profile{
+		ObjectMeta: metav1.ObjectMeta{Name: "gpu", Namespace: "openfaas"},
+		Spec: faasv1.ProfileSpec{
+			Tolerations: []corev1.Toleration{{Key: "gpu", Operator: corev1.TolerationOpExists}},
+		},
+	}
+
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "nodeinfo",
+			Namespace:   "openfaas-fn",
+			Annotations: map[string]string{ProfileAnnotationKey: "gpu"},
+		},
+		Spec: appsv1.DeploymentSpec{
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: map[string]string{"faas_function": "nodeinfo"},
+				},
+				Spec: corev1.PodSpec{
+					Containers:  []corev1.Container{{Name: "nodeinfo", Image: "functions/nodeinfo"}},
+					Tolerations: oldProfile.Spec.Tolerations,
+					Volumes: []corev1.Volume{{
+						Name: "nodeinfo-projected-secrets",
+						VolumeSource: corev1.VolumeSource{
+							Projected: &corev1.ProjectedVolumeSource{
+								Sources: []corev1.VolumeProjection{{
+									Secret: &corev1.SecretProjection{LocalObjectReference: corev1.LocalObjectReference{Name: "api-key"}},
+								}},
+							},
+						},
+					}},
+				},
+			},
+		},
+	}
+	SetConfigHash(deployment, ConfigHash(map[string]*corev1.Secret{"api-key": secret}, []Profile{Profile(oldProfile.Spec)}))
+
+	kube := fake.NewSimpleClientset(deployment)
+	applyDeployments(t, kube)
+	kubeInformers := kubeinformers.NewSharedInformerFactory(kube, 0)
+	profileInformers := faasinformers.NewSharedInformerFactory(faasfake.NewSimpleClientset(), 0)
+
+	deployments := kubeInformers.Apps().V1().Deployments()
+	secrets := kubeInformers.Core().V1().Secrets()
+	profiles := profileInformers.Openfaas().V1().Profiles()
+
+	factory := NewFunctionFactory(kube, DeploymentConfig{ProfilesNamespace: "openfaas"}, profiles.Lister())
+	if err := AddConfigIndexers(deployments); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	deployments.Informer().GetIndexer().Add(deployment)
+	secrets.Informer().GetIndexer().Add(secret)
+	profiles.Informer().GetIndexer().Add(oldProfile)
+
+	w := NewConfigWatcher(factory, deployments, secrets, profiles)
+
+	// get reads the applied Deployment and stores it in the informer, as the
+	// watch of a running informer would
+	get := func() *appsv1.Deployment {
+		d, err := kube.AppsV1().Deployments("openfaas-fn").Get(context.TODO(), "nodeinfo", metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		deployments.Informer().GetIndexer().Update(d)
+		return d
+	}
+
+	// an unchanged secret does not roll the function
+	w.secretChanged(secret)
+	if got := get(); got.Spec.Template.Annotations[ConfigHashAnnotation] != deployment.Spec.Template.Annotations[ConfigHashAnnotation] {
+		t.Errorf("want the config hash to be unchanged")
+	}
+
+	updatedSecret := secret.DeepCopy()
+	updatedSecret.ResourceVersion = "2"
+	updatedSecret.Data["api-key"] = []byte("v2")
+	secrets.Informer().GetIndexer().Update(updatedSecret)
+	w.secretChanged(updatedSecret)
+
+	afterSecret := get()
+	if afterSecret.Spec.Template.Annotations[ConfigHashAnnotation] == deployment.Spec.Template.Annotations[ConfigHashAnnotation] {
+		t.Errorf("want the config hash to change after the secret changed")
+	}
+
+	newProfile := oldProfile.DeepCopy()
+	newProfile.Spec.Tolerations = []corev1.Toleration{{Key: "arm64", Operator: corev1.TolerationOpExists}}
+	profiles.Informer().GetIndexer().Update(newProfile)
+	w.profileChanged(oldProfile, newProfile)
+
+	afterProfile := get()
+	if afterProfile.Spec.Template.Annotations[ConfigHashAnnotation] == afterSecret.Spec.Template.Annotations[ConfigHashAnnotation] {
+		t.Errorf("want the config hash to change after the profile changed")
+	}
+	tolerations := afterProfile.Spec.Template.Spec.Tolerations
+	if len(tolerations) != 1 || tolerations[0].Key != "arm64" {
+		t.Errorf("want the new profile tolerations, got %+v", tolerations)
+	}
+
+	// the Deployment is only written with server-side apply, so that the
+	// fields keep the faas-netes field manager as their owner
+	for _, action := range kube.Actions() {
+		if action.Matches("update", "deployments") {
+			t.Errorf("want the Deployment to be applied, got an update")
+		}
+	}
+}
+
+func Test_ConfigWatcher_IgnoresInitialSecrets(t *testing.T) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "api-key", Namespace: "openfaas-fn", ResourceVersion: "1"},
+		Data:       map[string][]byte{"api-key": []byte("v1")},
+	}
+
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "nodeinfo", Namespace: "openfaas-fn"},
+		Spec: appsv1.DeploymentSpec{
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: map[string]string{"faas_function": "nodeinfo"},
+				},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{Name: "nodeinfo", Image: "functions/nodeinfo"}},
+					Volumes: []corev1.Volume{{
+						Name: "nodeinfo-projected-secrets",
+						VolumeSource: corev1.VolumeSource{
+							Projected: &corev1.ProjectedVolumeSource{
+								Sources: []corev1.VolumeProjection{{
+									Secret: &corev1.SecretProjection{LocalObjectReference: corev1.LocalObjectReference{Name: "api-key"}},
+								}},
+							},
+						},
+					}},
+				},
+			},
+		},
+	}
+	// the hash is stale, so that any roll would change the Deployment
+	SetConfigHash(deployment, "stale")
+
+	kube := fake.NewSimpleClientset(deployment)
+	applyDeployments(t, kube)
+	kubeInformers := kubeinformers.NewSharedInformerFactory(kube, 0)
+	profileInformers := faasinformers.NewSharedInformerFactory(faasfake.NewSimpleClientset(), 0)
+
+	deployments := kubeInformers.Apps().V1().Deployments()
+	secrets := kubeInformers.Core().V1().Secrets()
+	profiles := profileInformers.Openfaas().V1().Profiles()
+
+	factory := NewFunctionFactory(kube, DeploymentConfig{ProfilesNamespace: "openfaas"}, profiles.Lister())
+	if err := AddConfigIndexers(deployments); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	deployments.Informer().GetIndexer().Add(deployment)
+	secrets.Informer().GetIndexer().Add(secret)
+
+	w := NewConfigWatcher(factory, deployments, secrets, profiles)
+	kube.ClearActions()
+
+	// the informer replays the Secrets which existed as Adds
+	w.secretAdded(secret)
+	if len(kube.Actions()) > 0 {
+		t.Errorf("want no calls for the initial Secrets, got %v", kube.Actions())
+	}
+
+	// a Secret which is created again after it was replayed rolls the function
+	recreated := secret.DeepCopy()
+	recreated.ResourceVersion = "2"
+	secrets.Informer().GetIndexer().Update(recreated)
+	w.secretAdded(recreated)
+
+	applied := 0
+	for _, action := range kube.Actions() {
+		if action.Matches("patch", "deployments") {
+			applied++
+		}
+		if action.Matches("get", "deployments") {
+			t.Errorf("want the Deployment to be read from the lister, got a get")
+		}
+	}
+	if applied != 1 {
+		t.Errorf("want the function to be rolled once, got %d applies", applied)
+	}
+}
+
+func Test_ConfigWatcher_ProfileCreatedAndDeleted(t *testing.T) {
+	profile := &faasv1.Profile{
+		ObjectMeta: metav1.ObjectMeta{Name: "gpu", Namespace: "openfaas", ResourceVersion: "1"},
+		Spec: faasv1.ProfileSpec{
+			Tolerations: []corev1.Toleration{{Key: "gpu", Operator: corev1.TolerationOpExists}},
+		},
+	}
+
+	// the function was deployed before the Profile was created
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "nodeinfo",
+			Namespace:   "openfaas-fn",
+			Annotations: map[string]string{ProfileAnnotationKey: "gpu"},
+		},
+		Spec: appsv1.DeploymentSpec{
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: map[string]string{"faas_function": "nodeinfo"},
+				},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{Name: "nodeinfo", Image: "functions/nodeinfo"}},
+				},
+			},
+		},
+	}
+	SetConfigHash(deployment, ConfigHash(nil, []Profile{}))
+
+	kube := fake.NewSimpleClientset(deployment)
+	applyDeployments(t, kube)
+	kubeInformers := kubeinformers.NewSharedInformerFactory(kube, 0)
+	profileInformers := faasinformers.NewSharedInformerFactory(faasfake.NewSimpleClientset(), 0)
+
+	deployments := kubeInformers.Apps().V1().Deployments()
+	secrets := kubeInformers.Core().V1().Secrets()
+	profiles := profileInformers.Openfaas().V1().Profiles()
+
+	factory := NewFunctionFactory(kube, DeploymentConfig{ProfilesNamespace: "openfaas"}, profiles.Lister())
+	if err := AddConfigIndexers(deployments); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	deployments.Informer().GetIndexer().Add(deployment)
+
+	w := NewConfigWatcher(factory, deployments, secrets, profiles)
+
+	get := func() *appsv1.Deployment {
+		d, err := kube.AppsV1().Deployments("openfaas-fn").Get(context.TODO(), "nodeinfo", metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		deployments.Informer().GetIndexer().Update(d)
+		return d
+	}
+
+	profiles.Informer().GetIndexer().Add(profile)
+	w.profileAdded(profile)
+
+	created := get()
+	tolerations := created.Spec.Template.Spec.Tolerations
+	if len(tolerations) != 1 || tolerations[0].Key != "gpu" {
+		t.Errorf("want the tolerations of the new profile, got %+v", tolerations)
+	}
+	if created.Spec.Template.Annotations[ConfigHashAnnotation] == deployment.Spec.Template.Annotations[ConfigHashAnnotation] {
+		t.Errorf("want the config hash to change after the profile was created")
+	}
+
+	// the informer may only know the last state of the deleted Profile
+	profiles.Informer().GetIndexer().Delete(profile)
+	w.profileDeleted(cache.DeletedFinalStateUnknown{Key: "openfaas/gpu", Obj: profile})
+
+	deleted := get()
+	if len(deleted.Spec.Template.Spec.Tolerations) != 0 {
+		t.Errorf("want the tolerations of the deleted profile to be removed, got %+v", deleted.Spec.Template.Spec.Tolerations)
+	}
+	if deleted.Spec.Template.Annotations[ConfigHashAnnotation] != deployment.Spec.Template.Annotations[ConfigHashAnnotation] {
+		t.Errorf("want the config hash without the profile after it was deleted")
+	}
+}
+
+// applyDeployments stores the Deployments applied with server-side apply in
+// the fake client, which only supports the other patch types
+func applyDeployments(t *testing.T, kube *fake.Clientset) {
+	kube.PrependReactor("patch", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		patch := action.(k8stesting.PatchAction)
+		if patch.GetPatchType() != types.ApplyPatchType {
+			t.Errorf("want patch type %s, got %s", types.ApplyPatchType, patch.GetPatchType())
+			return false, nil, nil
+		}
+
+		applied := &appsv1.Deployment{}
+		if err := json.Unmarshal(patch.GetPatch(), applied); err != nil {
+			return true, nil, err
+		}
+		if err := kube.Tracker().Update(appsv1.SchemeGroupVersion.WithResource("deployments"), applied, applied.Namespace); err != nil {
+			return true, nil, err
+		}
+		return true, applied, nil
+	})
+}
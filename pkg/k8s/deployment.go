@@ -0,0 +1,374 @@
+// Copyright 2020 OpenFaaS Author(s)
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package k8s
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	types "github.com/openfaas/faas-provider/types"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+const (
+	// FunctionLabel is set on the Pods of a function and selects them for its
+	// Deployment and Service
+	FunctionLabel = "faas_function"
+
+	// MinReplicasLabel sets the minimum number of replicas of a function
+	MinReplicasLabel = "com.openfaas.scale.min"
+
+	// PortAnnotation sets the port that the function container listens on,
+	// for images which do not use the watchdog on the RuntimeHTTPPort
+	PortAnnotation = "com.openfaas.port"
+
+	// FunctionPortName is the name of the function port on the container,
+	// the Service and the Endpoints
+	FunctionPortName = "http"
+
+	// initialReplicasCount how many replicas to start of creating for a function
+	initialReplicasCount = 1
+)
+
+// MakeDeployment builds the Deployment for a function. Both the REST API and
+// the operator use it, so that a function gets the same workload whichever
+// mode deployed it. Secrets are looked up in existingSecrets and a missing
+// secret is an error, Profiles and the config hash are applied by the caller.
+func (f *FunctionFactory) MakeDeployment(request types.FunctionDeployment, existingSecrets map[string]*corev1.Secret) (*appsv1.Deployment, error) {
+	envVars := buildEnvVars(&request)
+
+	labels := map[string]string{
+		FunctionLabel: request.Service,
+	}
+
+	if request.Labels != nil {
+		for k, v := range *request.Labels {
+			labels[k] = v
+		}
+	}
+
+	nodeSelector := createSelector(request.Constraints)
+
+	resources, err := createResources(request)
+	if err != nil {
+		return nil, err
+	}
+
+	var imagePullPolicy corev1.PullPolicy
+	switch f.Config.ImagePullPolicy {
+	case "Never":
+		imagePullPolicy = corev1.PullNever
+	case "IfNotPresent":
+		imagePullPolicy = corev1.PullIfNotPresent
+	default:
+		imagePullPolicy = corev1.PullAlways
+	}
+
+	annotations := BuildAnnotations(request)
+
+	var serviceAccount string
+
+	if request.Annotations != nil {
+		annotations := *request.Annotations
+		if val, ok := annotations["com.openfaas.serviceaccount"]; ok && len(val) > 0 {
+			serviceAccount = val
+		}
+	}
+
+	port, err := f.FunctionPort(request)
+	if err != nil {
+		return nil, err
+	}
+
+	probes, err := f.MakeProbes(request)
+	if err != nil {
+		return nil, err
+	}
+
+	enableServiceLinks := false
+	allowPrivilegeEscalation := false
+	readOnlyRootFilesystem := request.ReadOnlyRootFilesystem
+
+	deploymentSpec := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        request.Service,
+			Namespace:   request.Namespace,
+			Annotations: annotations,
+			Labels: map[string]string{
+				FunctionLabel: request.Service,
+			},
+		},
+		Spec: appsv1.DeploymentSpec{
+			Selector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{
+					FunctionLabel: request.Service,
+				},
+			},
+			Replicas: ApplyReplicas(nil, labels),
+			Strategy: appsv1.DeploymentStrategy{
+				Type: appsv1.RollingUpdateDeploymentStrategyType,
+				RollingUpdate: &appsv1.RollingUpdateDeployment{
+					MaxUnavailable: &intstr.IntOrString{
+						Type:   intstr.Int,
+						IntVal: int32(0),
+					},
+					MaxSurge: &intstr.IntOrString{
+						Type:   intstr.Int,
+						IntVal: int32(1),
+					},
+				},
+			},
+			RevisionHistoryLimit: int32p(10),
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        request.Service,
+					Labels:      labels,
+					Annotations: annotations,
+				},
+				Spec: corev1.PodSpec{
+					NodeSelector: nodeSelector,
+					Containers: []corev1.Container{
+						{
+							Name:  request.Service,
+							Image: request.Image,
+							Ports: []corev1.ContainerPort{
+								{
+									Name:          FunctionPortName,
+									ContainerPort: port,
+									Protocol:      corev1.ProtocolTCP,
+								},
+							},
+							Env:             envVars,
+							Resources:       *resources,
+							ImagePullPolicy: imagePullPolicy,
+							LivenessProbe:   probes.Liveness,
+							ReadinessProbe:  probes.Readiness,
+							SecurityContext: &corev1.SecurityContext{
+								ReadOnlyRootFilesystem:   &readOnlyRootFilesystem,
+								AllowPrivilegeEscalation: &allowPrivilegeEscalation,
+							},
+						},
+					},
+					ServiceAccountName: serviceAccount,
+					RestartPolicy:      corev1.RestartPolicyAlways,
+					DNSPolicy:          corev1.DNSClusterFirst,
+					// EnableServiceLinks injects ENV vars about every other service within
+					// the namespace.
+					EnableServiceLinks: &enableServiceLinks,
+				},
+			},
+		},
+	}
+
+	f.ConfigureReadOnlyRootFilesystem(request, deploymentSpec)
+	f.ConfigureContainerUserID(deploymentSpec)
+
+	if err := f.ConfigureSecrets(request, deploymentSpec, existingSecrets); err != nil {
+		return nil, err
+	}
+
+	return deploymentSpec, nil
+}
+
+// MakeService builds the ClusterIP Service for a function. The Service
+// listens on the RuntimeHTTPPort for all functions, so that they can be
+// invoked the same way, and targets the port of the function.
+func (f *FunctionFactory) MakeService(request types.FunctionDeployment) *corev1.Service {
+	port, err := f.FunctionPort(request)
+	if err != nil {
+		// the Deployment is rejected with the same error
+		port = f.Config.RuntimeHTTPPort
+	}
+
+	return &corev1.Service{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Service",
+			APIVersion: "v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        request.Service,
+			Namespace:   request.Namespace,
+			Annotations: BuildAnnotations(request),
+		},
+		Spec: corev1.ServiceSpec{
+			Type: corev1.ServiceTypeClusterIP,
+			Selector: map[string]string{
+				FunctionLabel: request.Service,
+			},
+			Ports: []corev1.ServicePort{
+				{
+					Name:     FunctionPortName,
+					Protocol: corev1.ProtocolTCP,
+					Port:     f.Config.RuntimeHTTPPort,
+					TargetPort: intstr.IntOrString{
+						Type:   intstr.Int,
+						IntVal: port,
+					},
+				},
+			},
+		},
+	}
+}
+
+// FunctionPort returns the port that the function container listens on, the
+// PortAnnotation overrides the RuntimeHTTPPort
+func (f *FunctionFactory) FunctionPort(request types.FunctionDeployment) (int32, error) {
+	if request.Annotations == nil {
+		return f.Config.RuntimeHTTPPort, nil
+	}
+
+	value, ok := (*request.Annotations)[PortAnnotation]
+	if !ok {
+		return f.Config.RuntimeHTTPPort, nil
+	}
+
+	port, err := strconv.Atoi(value)
+	if err != nil || port < 1 || port > 65535 {
+		return 0, fmt.Errorf("invalid %s %q, must be a port number between 1 and 65535", PortAnnotation, value)
+	}
+	return int32(port), nil
+}
+
+// KeepSelector sets the selector of the live Deployment on the desired one.
+// The selector of a Deployment can not be changed, Deployments which were
+// created with another selector keep it and their Pod template keeps the
+// labels it matches.
+func KeepSelector(desired, live *appsv1.Deployment) {
+	if live == nil || live.Spec.Selector == nil {
+		return
+	}
+
+	desired.Spec.Selector = live.Spec.Selector.DeepCopy()
+	if desired.Spec.Template.Labels == nil {
+		desired.Spec.Template.Labels = map[string]string{}
+	}
+	for k, v := range live.Spec.Selector.MatchLabels {
+		desired.Spec.Template.Labels[k] = v
+	}
+}
+
+// BuildAnnotations returns a copy of the request annotations, scraping by
+// Prometheus is disabled unless it is set in the request
+func BuildAnnotations(request types.FunctionDeployment) map[string]string {
+	annotations := map[string]string{}
+	if request.Annotations != nil {
+		for k, v := range *request.Annotations {
+			annotations[k] = v
+		}
+	}
+
+	if _, ok := annotations["prometheus.io.scrape"]; !ok {
+		annotations["prometheus.io.scrape"] = "false"
+	}
+	return annotations
+}
+
+// GetMinReplicaCount returns the minimum replicas set with the
+// MinReplicasLabel, or nil when it is not set or invalid
+func GetMinReplicaCount(labels map[string]string) *int32 {
+	if value, exists := labels[MinReplicasLabel]; exists {
+		minReplicas, err := strconv.Atoi(value)
+		if err == nil && minReplicas > 0 {
+			return int32p(int32(minReplicas))
+		}
+
+		log.Println(err)
+	}
+
+	return nil
+}
+
+func buildEnvVars(request *types.FunctionDeployment) []corev1.EnvVar {
+	envVars := []corev1.EnvVar{}
+
+	if len(request.EnvProcess) > 0 {
+		envVars = append(envVars, corev1.EnvVar{
+			Name:  EnvProcessName,
+			Value: request.EnvProcess,
+		})
+	}
+
+	for k, v := range request.EnvVars {
+		envVars = append(envVars, corev1.EnvVar{
+			Name:  k,
+			Value: v,
+		})
+	}
+
+	sort.SliceStable(envVars, func(i, j int) bool {
+		return strings.Compare(envVars[i].Name, envVars[j].Name) == -1
+	})
+
+	return envVars
+}
+
+func createSelector(constraints []string) map[string]string {
+	selector := make(map[string]string)
+
+	if len(constraints) > 0 {
+		for _, constraint := range constraints {
+			parts := strings.Split(constraint, "=")
+
+			if len(parts) == 2 {
+				selector[parts[0]] = parts[1]
+			}
+		}
+	}
+
+	return selector
+}
+
+func createResources(request types.FunctionDeployment) (*corev1.ResourceRequirements, error) {
+	resources := &corev1.ResourceRequirements{
+		Limits:   corev1.ResourceList{},
+		Requests: corev1.ResourceList{},
+	}
+
+	// Set Memory limits
+	if request.Limits != nil && len(request.Limits.Memory) > 0 {
+		qty, err := resource.ParseQuantity(request.Limits.Memory)
+		if err != nil {
+			return resources, err
+		}
+		resources.Limits[corev1.ResourceMemory] = qty
+	}
+
+	if request.Requests != nil && len(request.Requests.Memory) > 0 {
+		qty, err := resource.ParseQuantity(request.Requests.Memory)
+		if err != nil {
+			return resources, err
+		}
+		resources.Requests[corev1.ResourceMemory] = qty
+	}
+
+	// Set CPU limits
+	if request.Limits != nil && len(request.Limits.CPU) > 0 {
+		qty, err := resource.ParseQuantity(request.Limits.CPU)
+		if err != nil {
+			return resources, err
+		}
+		resources.Limits[corev1.ResourceCPU] = qty
+	}
+
+	if request.Requests != nil && len(request.Requests.CPU) > 0 {
+		qty, err := resource.ParseQuantity(request.Requests.CPU)
+		if err != nil {
+			return resources, err
+		}
+		resources.Requests[corev1.ResourceCPU] = qty
+	}
+
+	return resources, nil
+}
+
+func int32p(i int32) *int32 {
+	return &i
+}
@@ -0,0 +1,388 @@
+// Copyright 2020 OpenFaaS Author(s)
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package k8s
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	types "github.com/openfaas/faas-provider/types"
+	"k8s.io/client-go/kubernetes/fake"
+
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+// Test_MakeDeployment_Golden compares the objects built for a function with
+// the files in testdata, run the tests with -update to write them after a
+// deliberate change to the builder
+func Test_MakeDeployment_Golden(t *testing.T) {
+	existingSecrets := map[string]*apiv1.Secret{
+		"db-password": {
+			ObjectMeta: metav1.ObjectMeta{Name: "db-password", Namespace: "openfaas-fn"},
+			Type:       apiv1.SecretTypeOpaque,
+			Data:       map[string][]byte{"username": []byte("admin"), "password": []byte("secret")},
+		},
+		"registry": {
+			ObjectMeta: metav1.ObjectMeta{Name: "registry", Namespace: "openfaas-fn"},
+			Type:       apiv1.SecretTypeDockerConfigJson,
+		},
+	}
+
+	cases := []struct {
+		name    string
+		config  DeploymentConfig
+		request types.FunctionDeployment
+	}{
+		{
+			name: "minimal",
+			config: DeploymentConfig{
+				RuntimeHTTPPort: 8080,
+				LivenessProbe:   &ProbeConfig{},
+				ReadinessProbe:  &ProbeConfig{},
+			},
+			request: types.FunctionDeployment{
+				Service:   "nodeinfo",
+				Namespace: "openfaas-fn",
+				Image:     "functions/nodeinfo:latest",
+			},
+		},
+		{
+			name: "full",
+			config: DeploymentConfig{
+				RuntimeHTTPPort: 8080,
+				HTTPProbe:       true,
+				LivenessProbe:   &ProbeConfig{InitialDelaySeconds: 2, TimeoutSeconds: 1, PeriodSeconds: 2},
+				ReadinessProbe:  &ProbeConfig{InitialDelaySeconds: 2, TimeoutSeconds: 1, PeriodSeconds: 2},
+				ImagePullPolicy: "IfNotPresent",
+				SetNonRootUser:  true,
+			},
+			request: types.FunctionDeployment{
+				Service:    "nodeinfo",
+				Namespace:  "openfaas-fn",
+				Image:      "functions/nodeinfo:latest",
+				EnvProcess: "node main.js",
+				EnvVars:    map[string]string{"write_debug": "true", "read_timeout": "10s"},
+				Constraints: []string{
+					"kubernetes.io/arch=arm64",
+					"invalid",
+				},
+				Secrets: []string{"db-password", "registry"},
+				Labels: &map[string]string{
+					MinReplicasLabel: "2",
+					"team":           "payments",
+				},
+				Annotations: &map[string]string{
+					"com.openfaas.serviceaccount": "nodeinfo",
+					"topic":                       "orders",
+				},
+				Limits:                 &types.FunctionResources{Memory: "128Mi", CPU: "200m"},
+				Requests:               &types.FunctionResources{Memory: "64Mi", CPU: "100m"},
+				ReadOnlyRootFilesystem: true,
+			},
+		},
+		{
+			name: "port",
+			config: DeploymentConfig{
+				RuntimeHTTPPort: 8080,
+				HTTPProbe:       true,
+				LivenessProbe:   &ProbeConfig{},
+				ReadinessProbe:  &ProbeConfig{},
+			},
+			request: types.FunctionDeployment{
+				Service:   "webapp",
+				Namespace: "openfaas-fn",
+				Image:     "nginx:alpine",
+				Annotations: &map[string]string{
+					PortAnnotation: "3000",
+				},
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			factory := NewFunctionFactory(fake.NewSimpleClientset(), tc.config, nil)
+
+			deployment, err := factory.MakeDeployment(tc.request, existingSecrets)
+			if err != nil {
+				t.Fatalf("unexpected MakeDeployment error: %s", err)
+			}
+			service := factory.MakeService(tc.request)
+
+			got := []byte{}
+			for _, obj := range []interface{}{deployment, service} {
+				out, err := yaml.Marshal(obj)
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				got = append(got, []byte("---\n")...)
+				got = append(got, out...)
+			}
+
+			golden := filepath.Join("testdata", tc.name+".golden.yaml")
+			if *update {
+				if err := ioutil.WriteFile(golden, got, 0644); err != nil {
+					t.Fatalf("unable to update %s: %s", golden, err)
+				}
+			}
+
+			want, err := ioutil.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("unable to read %s: %s", golden, err)
+			}
+			if !bytes.Equal(want, got) {
+				t.Errorf("%s does not match, run the tests with -update if the change is expected\nwant:\n%s\ngot:\n%s", golden, want, got)
+			}
+		})
+	}
+}
+
+func Test_MakeDeployment_InvalidPort(t *testing.T) {
+	factory := NewFunctionFactory(fake.NewSimpleClientset(), DeploymentConfig{
+		RuntimeHTTPPort: 8080,
+		LivenessProbe:   &ProbeConfig{},
+		ReadinessProbe:  &ProbeConfig{},
+	}, nil)
+
+	for _, port := range []string{"0", "65536", "http"} {
+		request := types.FunctionDeployment{
+			Service:     "webapp",
+			Image:       "nginx:alpine",
+			Annotations: &map[string]string{PortAnnotation: port},
+		}
+		if _, err := factory.MakeDeployment(request, nil); err == nil {
+			t.Errorf("want an error for the port %q", port)
+		}
+	}
+}
+
+func Test_MakeDeployment_MissingSecret(t *testing.T) {
+	factory := NewFunctionFactory(fake.NewSimpleClientset(), DeploymentConfig{
+		LivenessProbe:  &ProbeConfig{},
+		ReadinessProbe: &ProbeConfig{},
+	}, nil)
+
+	request := types.FunctionDeployment{Service: "nodeinfo", Image: "functions/nodeinfo", Secrets: []string{"db-password"}}
+	if _, err := factory.MakeDeployment(request, map[string]*apiv1.Secret{}); err == nil {
+		t.Errorf("want an error for the missing secret")
+	}
+}
+
+func Test_KeepSelector(t *testing.T) {
+	factory := NewFunctionFactory(fake.NewSimpleClientset(), DeploymentConfig{
+		LivenessProbe:  &ProbeConfig{},
+		ReadinessProbe: &ProbeConfig{},
+	}, nil)
+
+	desired, err := factory.MakeDeployment(types.FunctionDeployment{Service: "nodeinfo", Image: "functions/nodeinfo"}, nil)
+	if err != nil {
+		t.Fatalf("unexpected MakeDeployment error: %s", err)
+	}
+
+	live := desired.DeepCopy()
+	live.Spec.Selector = &metav1.LabelSelector{
+		MatchLabels: map[string]string{"app": "nodeinfo", "controller": "nodeinfo"},
+	}
+
+	KeepSelector(desired, live)
+
+	if got := desired.Spec.Selector.MatchLabels; len(got) != 2 || got["app"] != "nodeinfo" || got["controller"] != "nodeinfo" {
+		t.Errorf("want the live selector to be kept, got %v", got)
+	}
+	for k, v := range live.Spec.Selector.MatchLabels {
+		if desired.Spec.Template.Labels[k] != v {
+			t.Errorf("want template label %s=%s, got %q", k, v, desired.Spec.Template.Labels[k])
+		}
+	}
+	if desired.Spec.Template.Labels[FunctionLabel] != "nodeinfo" {
+		t.Errorf("want the %s label to be kept in the template", FunctionLabel)
+	}
+}
+
+func Test_BuildAnnotations_Empty_In_CreateRequest(t *testing.T) {
+	request := types.FunctionDeployment{}
+
+	annotations := BuildAnnotations(request)
+
+	if len(annotations) != 1 {
+		t.Errorf("want: %d annotations got: %d", 1, len(annotations))
+	}
+
+	v, ok := annotations["prometheus.io.scrape"]
+	if !ok {
+		t.Errorf("missing prometheus.io.scrape key")
+	}
+
+	want := "false"
+	if v != want {
+		t.Errorf("want: %s for annotation prometheus.io.scrape got: %s", want, v)
+	}
+}
+
+func Test_BuildAnnotations_Premetheus_NotOverridden(t *testing.T) {
+	request := types.FunctionDeployment{Annotations: &map[string]string{"prometheus.io.scrape": "true"}}
+
+	annotations := BuildAnnotations(request)
+
+	if len(annotations) != 1 {
+		t.Errorf("want: %d annotations got: %d", 1, len(annotations))
+	}
+
+	v, ok := annotations["prometheus.io.scrape"]
+	if !ok {
+		t.Errorf("missing prometheus.io.scrape key")
+	}
+	want := "true"
+	if v != want {
+		t.Errorf("want: %s for annotation prometheus.io.scrape got: %s", want, v)
+	}
+}
+
+func Test_BuildAnnotations_From_CreateRequest(t *testing.T) {
+	request := types.FunctionDeployment{
+		Annotations: &map[string]string{
+			"date-created": "Wed 25 Jul 21:26:22 BST 2018",
+			"foo":          "bar",
+		},
+	}
+
+	annotations := BuildAnnotations(request)
+
+	if len(annotations) != 3 {
+		t.Errorf("want: %d annotations got: %d", 1, len(annotations))
+	}
+
+	v, ok := annotations["date-created"]
+	if !ok {
+		t.Errorf("missing date-created key")
+	}
+
+	if v != "Wed 25 Jul 21:26:22 BST 2018" {
+		t.Errorf("want: %s for annotation date-created got: %s", "Wed 25 Jul 21:26:22 BST 2018", v)
+	}
+}
+
+func Test_SetNonRootUser(t *testing.T) {
+
+	scenarios := []struct {
+		name       string
+		setNonRoot bool
+	}{
+		{"does not set userid value when SetNonRootUser is false", false},
+		{"does set userid to constant value when SetNonRootUser is true", true},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			request := types.FunctionDeployment{Service: "testfunc", Image: "alpine:latest"}
+			factory := NewFunctionFactory(fake.NewSimpleClientset(), DeploymentConfig{
+				LivenessProbe:  &ProbeConfig{},
+				ReadinessProbe: &ProbeConfig{},
+				SetNonRootUser: s.setNonRoot,
+			}, nil)
+			deployment, err := factory.MakeDeployment(request, map[string]*apiv1.Secret{})
+			if err != nil {
+				t.Errorf("unexpected MakeDeployment error: %s", err.Error())
+			}
+
+			functionContainer := deployment.Spec.Template.Spec.Containers[0]
+			if functionContainer.SecurityContext == nil {
+				t.Errorf("expected container %s to have a non-nil security context", functionContainer.Name)
+			}
+
+			if !s.setNonRoot && functionContainer.SecurityContext.RunAsUser != nil {
+				t.Errorf("expected RunAsUser to be nil, got %d", functionContainer.SecurityContext.RunAsUser)
+			}
+
+			if s.setNonRoot && *functionContainer.SecurityContext.RunAsUser != SecurityContextUserID {
+				t.Errorf("expected RunAsUser to be %d, got %d", SecurityContextUserID, functionContainer.SecurityContext.RunAsUser)
+			}
+		})
+	}
+
+}
+
+func Test_buildEnvVars_NoSortedKeys(t *testing.T) {
+
+	inputEnvs := map[string]string{}
+
+	function := types.FunctionDeployment{
+		EnvVars: inputEnvs,
+	}
+
+	coreEnvs := buildEnvVars(&function)
+
+	if len(coreEnvs) != 0 {
+		t.Errorf("want: %d env-vars, got: %d", 0, len(coreEnvs))
+		t.Fail()
+	}
+}
+
+func Test_buildEnvVars_TwoSortedKeys(t *testing.T) {
+	firstKey := "first"
+	lastKey := "last"
+
+	inputEnvs := map[string]string{
+		lastKey:  "",
+		firstKey: "",
+	}
+
+	function := types.FunctionDeployment{
+		EnvVars: inputEnvs,
+	}
+
+	coreEnvs := buildEnvVars(&function)
+
+	if coreEnvs[0].Name != firstKey {
+		t.Errorf("first want: %s, got: %s", firstKey, coreEnvs[0].Name)
+		t.Fail()
+	}
+}
+
+func Test_buildEnvVars_FourSortedKeys(t *testing.T) {
+	firstKey := "alex"
+	secondKey := "elliot"
+	thirdKey := "stefan"
+	lastKey := "zane"
+
+	inputEnvs := map[string]string{
+		lastKey:   "",
+		firstKey:  "",
+		thirdKey:  "",
+		secondKey: "",
+	}
+
+	function := types.FunctionDeployment{
+		EnvVars: inputEnvs,
+	}
+
+	coreEnvs := buildEnvVars(&function)
+
+	if coreEnvs[0].Name != firstKey {
+		t.Errorf("first want: %s, got: %s", firstKey, coreEnvs[0].Name)
+		t.Fail()
+	}
+
+	if coreEnvs[1].Name != secondKey {
+		t.Errorf("second want: %s, got: %s", secondKey, coreEnvs[1].Name)
+		t.Fail()
+	}
+
+	if coreEnvs[2].Name != thirdKey {
+		t.Errorf("third want: %s, got: %s", thirdKey, coreEnvs[2].Name)
+		t.Fail()
+	}
+
+	if coreEnvs[3].Name != lastKey {
+		t.Errorf("last want: %s, got: %s", lastKey, coreEnvs[3].Name)
+		t.Fail()
+	}
+}
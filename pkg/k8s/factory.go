@@ -5,6 +5,7 @@ package k8s
 
 import (
 	v1 "github.com/openfaas/faas-netes/pkg/client/listers/openfaas/v1"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 )
 
@@ -16,7 +17,10 @@ type NamespacedProfiler interface {
 
 // FunctionFactory is handling Kubernetes operations to materialise functions into deployments and services
 type FunctionFactory struct {
-	Client   kubernetes.Interface
+	Client kubernetes.Interface
+	// Dynamic applies the objects whose API has no client in Client, such as
+	// the autoscaling/v2 HorizontalPodAutoscalers
+	Dynamic  dynamic.Interface
 	Config   DeploymentConfig
 	Profiler NamespacedProfiler
 }
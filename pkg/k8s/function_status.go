@@ -22,6 +22,15 @@ func AsFunctionStatus(item appsv1.Deployment) *types.FunctionStatus {
 	functionContainer := item.Spec.Template.Spec.Containers[0]
 
 	labels := item.Spec.Template.Labels
+	annotations := item.Spec.Template.Annotations
+	if window, ok := item.Annotations[ActiveScalingWindowAnnotation]; ok {
+		annotations = make(map[string]string, len(item.Spec.Template.Annotations)+1)
+		for k, v := range item.Spec.Template.Annotations {
+			annotations[k] = v
+		}
+		annotations[ActiveScalingWindowAnnotation] = window
+	}
+
 	function := types.FunctionStatus{
 		Name:              item.Name,
 		Replicas:          replicas,
@@ -29,7 +38,7 @@ func AsFunctionStatus(item appsv1.Deployment) *types.FunctionStatus {
 		AvailableReplicas: uint64(item.Status.AvailableReplicas),
 		InvocationCount:   0,
 		Labels:            &labels,
-		Annotations:       &item.Spec.Template.Annotations,
+		Annotations:       &annotations,
 		Namespace:         item.Namespace,
 		Secrets:           ReadFunctionSecretsSpec(item),
 		CreatedAt:         item.CreationTimestamp.Time,
@@ -0,0 +1,251 @@
+// Copyright 2020 OpenFaaS Author(s)
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2beta2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+const (
+	// ScaleTypeCPU scales a function with a HorizontalPodAutoscaler on the
+	// average CPU utilization of its Pods, in percent of their requests
+	ScaleTypeCPU = "cpu"
+	// ScaleTypeMemory scales a function with a HorizontalPodAutoscaler on
+	// the average memory utilization of its Pods, in percent of their requests
+	ScaleTypeMemory = "memory"
+	// ScaleTypeCustom scales a function with a HorizontalPodAutoscaler on the
+	// average value of the Pods metric named by ScaleMetricLabel
+	ScaleTypeCustom = "custom"
+
+	// ScaleMetricLabel is the name of the Pods metric of ScaleTypeCustom, it
+	// must be served by a custom metrics adapter
+	ScaleMetricLabel = "com.openfaas.scale.metric"
+
+	defaultUtilizationTarget = 80
+)
+
+// HorizontalPodAutoscalerResource is the autoscaling/v2 API of the
+// HorizontalPodAutoscalers of the functions, which is served by Kubernetes
+// 1.23 and newer. The client-go version of faas-netes has no client for it,
+// so the HPAs are read and applied with the dynamic client.
+var HorizontalPodAutoscalerResource = schema.GroupVersionResource{
+	Group:    "autoscaling",
+	Version:  "v2",
+	Resource: "horizontalpodautoscalers",
+}
+
+// UsesHorizontalPodAutoscaler returns true when the labels of a function ask
+// for a HorizontalPodAutoscaler, the Autoscaler leaves these functions alone
+func UsesHorizontalPodAutoscaler(labels map[string]string) bool {
+	switch labels[ScaleTypeLabel] {
+	case ScaleTypeCPU, ScaleTypeMemory, ScaleTypeCustom:
+		return true
+	}
+	return false
+}
+
+// MakeHorizontalPodAutoscaler builds the HorizontalPodAutoscaler of a
+// function from its scale labels, nil is returned when the function does not
+// use one. The HPA has the name of the function and targets its Deployment.
+//
+// The HPA is built with the autoscaling/v2beta2 types, the newest ones known
+// to the client-go version of faas-netes. Their schema is the same as
+// autoscaling/v2 for the fields set here, and the HPA is applied as
+// autoscaling/v2, see ApplyHorizontalPodAutoscaler.
+func MakeHorizontalPodAutoscaler(name, namespace string, labels map[string]string) (*autoscalingv2.HorizontalPodAutoscaler, error) {
+	if !UsesHorizontalPodAutoscaler(labels) {
+		return nil, nil
+	}
+
+	minReplicas := int32(initialReplicasCount)
+	if min := GetMinReplicaCount(labels); min != nil {
+		minReplicas = *min
+	}
+
+	maxReplicas := int32(defaultMaxReplicas)
+	if raw, ok := labels[MaxReplicasLabel]; ok {
+		max, err := strconv.Atoi(raw)
+		if err != nil || max < 1 {
+			return nil, fmt.Errorf("invalid %s %q, must be a positive number", MaxReplicasLabel, raw)
+		}
+		maxReplicas = int32(max)
+	}
+	if maxReplicas < minReplicas {
+		return nil, fmt.Errorf("%s %d is lower than %s %d", MaxReplicasLabel, maxReplicas, MinReplicasLabel, minReplicas)
+	}
+
+	metric, err := makeMetricSpec(labels)
+	if err != nil {
+		return nil, err
+	}
+
+	return &autoscalingv2.HorizontalPodAutoscaler{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels: map[string]string{
+				FunctionLabel: name,
+			},
+		},
+		Spec: autoscalingv2.HorizontalPodAutoscalerSpec{
+			ScaleTargetRef: autoscalingv2.CrossVersionObjectReference{
+				APIVersion: "apps/v1",
+				Kind:       "Deployment",
+				Name:       name,
+			},
+			MinReplicas: &minReplicas,
+			MaxReplicas: maxReplicas,
+			Metrics:     []autoscalingv2.MetricSpec{metric},
+		},
+	}, nil
+}
+
+func makeMetricSpec(labels map[string]string) (autoscalingv2.MetricSpec, error) {
+	scaleType := labels[ScaleTypeLabel]
+	raw, hasTarget := labels[ScaleTargetLabel]
+
+	if scaleType == ScaleTypeCustom {
+		metricName := labels[ScaleMetricLabel]
+		if len(metricName) == 0 {
+			return autoscalingv2.MetricSpec{}, fmt.Errorf("%s is required for the %s scale type", ScaleMetricLabel, ScaleTypeCustom)
+		}
+		if !hasTarget {
+			return autoscalingv2.MetricSpec{}, fmt.Errorf("%s is required for the %s scale type", ScaleTargetLabel, ScaleTypeCustom)
+		}
+		target, err := resource.ParseQuantity(raw)
+		if err != nil || target.Sign() <= 0 {
+			return autoscalingv2.MetricSpec{}, fmt.Errorf("invalid %s %q, must be a positive quantity", ScaleTargetLabel, raw)
+		}
+
+		return autoscalingv2.MetricSpec{
+			Type: autoscalingv2.PodsMetricSourceType,
+			Pods: &autoscalingv2.PodsMetricSource{
+				Metric: autoscalingv2.MetricIdentifier{Name: metricName},
+				Target: autoscalingv2.MetricTarget{
+					Type:         autoscalingv2.AverageValueMetricType,
+					AverageValue: &target,
+				},
+			},
+		}, nil
+	}
+
+	utilization := int32(defaultUtilizationTarget)
+	if hasTarget {
+		target, err := strconv.Atoi(raw)
+		if err != nil || target < 1 {
+			return autoscalingv2.MetricSpec{}, fmt.Errorf("invalid %s %q, must be a utilization in percent", ScaleTargetLabel, raw)
+		}
+		utilization = int32(target)
+	}
+
+	resourceName := corev1.ResourceCPU
+	if scaleType == ScaleTypeMemory {
+		resourceName = corev1.ResourceMemory
+	}
+
+	return autoscalingv2.MetricSpec{
+		Type: autoscalingv2.ResourceMetricSourceType,
+		Resource: &autoscalingv2.ResourceMetricSource{
+			Name: resourceName,
+			Target: autoscalingv2.MetricTarget{
+				Type:               autoscalingv2.UtilizationMetricType,
+				AverageUtilization: &utilization,
+			},
+		},
+	}, nil
+}
+
+// SyncHorizontalPodAutoscaler creates or updates the HorizontalPodAutoscaler
+// of a function to match its labels, and deletes it when the labels no
+// longer ask for one. The owner is set as its controller, so that it is
+// garbage collected with the function. An HPA with the name of the function
+// which is controlled by another object is left in place.
+func SyncHorizontalPodAutoscaler(ctx context.Context, client dynamic.Interface, name, namespace string, labels map[string]string, owner metav1.OwnerReference) error {
+	desired, err := MakeHorizontalPodAutoscaler(name, namespace, labels)
+	if err != nil {
+		return err
+	}
+
+	hpas := client.Resource(HorizontalPodAutoscalerResource).Namespace(namespace)
+	var live *autoscalingv2.HorizontalPodAutoscaler
+	object, err := hpas.Get(ctx, name, metav1.GetOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	if err == nil {
+		live = &autoscalingv2.HorizontalPodAutoscaler{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(object.UnstructuredContent(), live); err != nil {
+			return fmt.Errorf("unable to read HorizontalPodAutoscaler %s.%s: %s", name, namespace, err)
+		}
+	}
+
+	if live != nil {
+		if ref := metav1.GetControllerOf(live); ref == nil || ref.UID != owner.UID {
+			if desired == nil {
+				return nil
+			}
+			return fmt.Errorf("HorizontalPodAutoscaler %s.%s already exists and is not managed by faas-netes", name, namespace)
+		}
+	}
+
+	if desired == nil {
+		if live == nil {
+			return nil
+		}
+		if err := hpas.Delete(ctx, name, metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		log.Printf("HorizontalPodAutoscaler deleted: %s.%s\n", name, namespace)
+		return nil
+	}
+
+	desired.OwnerReferences = []metav1.OwnerReference{owner}
+	if live != nil && !horizontalPodAutoscalerChanged(desired, live) {
+		return nil
+	}
+
+	if _, err := ApplyHorizontalPodAutoscaler(ctx, client, desired); err != nil {
+		return err
+	}
+	log.Printf("HorizontalPodAutoscaler applied: %s.%s\n", name, namespace)
+	return nil
+}
+
+// horizontalPodAutoscalerChanged compares the fields set by faas-netes, the
+// fields defaulted by the API server are left out
+func horizontalPodAutoscalerChanged(desired, live *autoscalingv2.HorizontalPodAutoscaler) bool {
+	return !equality.Semantic.DeepEqual(desired.Spec.ScaleTargetRef, live.Spec.ScaleTargetRef) ||
+		!equality.Semantic.DeepEqual(desired.Spec.MinReplicas, live.Spec.MinReplicas) ||
+		desired.Spec.MaxReplicas != live.Spec.MaxReplicas ||
+		!equality.Semantic.DeepEqual(desired.Spec.Metrics, live.Spec.Metrics) ||
+		!equality.Semantic.DeepEqual(desired.Labels, live.Labels) ||
+		!equality.Semantic.DeepEqual(desired.OwnerReferences, live.OwnerReferences)
+}
+
+// NewDeploymentOwnerReference makes the Deployment of a function the
+// controller of the objects that are created alongside it in controller mode
+func NewDeploymentOwnerReference(deployment *appsv1.Deployment) metav1.OwnerReference {
+	controller := true
+	return metav1.OwnerReference{
+		APIVersion: "apps/v1",
+		Kind:       "Deployment",
+		Name:       deployment.Name,
+		UID:        deployment.UID,
+		Controller: &controller,
+	}
+}
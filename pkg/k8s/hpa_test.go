@@ -0,0 +1,244 @@
+// Copyright 2020 OpenFaaS Author(s)
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package k8s
+
+import (
+	"context"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2beta2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func Test_MakeHorizontalPodAutoscaler(t *testing.T) {
+	cases := []struct {
+		name    string
+		labels  map[string]string
+		wantNil bool
+		wantErr bool
+		check   func(t *testing.T, hpa *autoscalingv2.HorizontalPodAutoscaler)
+	}{
+		{
+			name:    "no scale type",
+			labels:  map[string]string{MinReplicasLabel: "2"},
+			wantNil: true,
+		},
+		{
+			name:    "scale type of the Autoscaler",
+			labels:  map[string]string{ScaleTypeLabel: ScaleTypeRPS},
+			wantNil: true,
+		},
+		{
+			name:   "cpu with the defaults",
+			labels: map[string]string{ScaleTypeLabel: ScaleTypeCPU},
+			check: func(t *testing.T, hpa *autoscalingv2.HorizontalPodAutoscaler) {
+				if *hpa.Spec.MinReplicas != 1 || hpa.Spec.MaxReplicas != defaultMaxReplicas {
+					t.Errorf("want 1 to %d replicas, got %d to %d", defaultMaxReplicas, *hpa.Spec.MinReplicas, hpa.Spec.MaxReplicas)
+				}
+				resource := hpa.Spec.Metrics[0].Resource
+				if resource.Name != corev1.ResourceCPU || *resource.Target.AverageUtilization != defaultUtilizationTarget {
+					t.Errorf("want %d%% cpu, got %d%% %s", defaultUtilizationTarget, *resource.Target.AverageUtilization, resource.Name)
+				}
+				if hpa.Spec.ScaleTargetRef.Kind != "Deployment" || hpa.Spec.ScaleTargetRef.Name != "nodeinfo" {
+					t.Errorf("want the Deployment of the function as target, got %+v", hpa.Spec.ScaleTargetRef)
+				}
+			},
+		},
+		{
+			name:   "memory with replicas and a target",
+			labels: map[string]string{ScaleTypeLabel: ScaleTypeMemory, ScaleTargetLabel: "60", MinReplicasLabel: "2", MaxReplicasLabel: "5"},
+			check: func(t *testing.T, hpa *autoscalingv2.HorizontalPodAutoscaler) {
+				if *hpa.Spec.MinReplicas != 2 || hpa.Spec.MaxReplicas != 5 {
+					t.Errorf("want 2 to 5 replicas, got %d to %d", *hpa.Spec.MinReplicas, hpa.Spec.MaxReplicas)
+				}
+				resource := hpa.Spec.Metrics[0].Resource
+				if resource.Name != corev1.ResourceMemory || *resource.Target.AverageUtilization != 60 {
+					t.Errorf("want 60%% memory, got %d%% %s", *resource.Target.AverageUtilization, resource.Name)
+				}
+			},
+		},
+		{
+			name:   "custom metric",
+			labels: map[string]string{ScaleTypeLabel: ScaleTypeCustom, ScaleMetricLabel: "queue_depth", ScaleTargetLabel: "500m"},
+			check: func(t *testing.T, hpa *autoscalingv2.HorizontalPodAutoscaler) {
+				pods := hpa.Spec.Metrics[0].Pods
+				if pods == nil || pods.Metric.Name != "queue_depth" || pods.Target.AverageValue.String() != "500m" {
+					t.Errorf("want an average of 500m queue_depth, got %+v", hpa.Spec.Metrics[0])
+				}
+			},
+		},
+		{
+			name:    "custom metric without a name",
+			labels:  map[string]string{ScaleTypeLabel: ScaleTypeCustom, ScaleTargetLabel: "10"},
+			wantErr: true,
+		},
+		{
+			name:    "custom metric without a target",
+			labels:  map[string]string{ScaleTypeLabel: ScaleTypeCustom, ScaleMetricLabel: "queue_depth"},
+			wantErr: true,
+		},
+		{
+			name:    "utilization which is not a percent",
+			labels:  map[string]string{ScaleTypeLabel: ScaleTypeCPU, ScaleTargetLabel: "0.5"},
+			wantErr: true,
+		},
+		{
+			name:    "max lower than min",
+			labels:  map[string]string{ScaleTypeLabel: ScaleTypeCPU, MinReplicasLabel: "4", MaxReplicasLabel: "2"},
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			hpa, err := MakeHorizontalPodAutoscaler("nodeinfo", "openfaas-fn", tc.labels)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if tc.wantNil {
+				if hpa != nil {
+					t.Fatalf("want no HorizontalPodAutoscaler, got %+v", hpa)
+				}
+				return
+			}
+			tc.check(t, hpa)
+		})
+	}
+}
+
+// newHPAClient returns a fake dynamic client which stores the applied HPAs,
+// as the fake client does not support server-side apply
+func newHPAClient(objects ...runtime.Object) (*dynamicfake.FakeDynamicClient, *int) {
+	scheme := runtime.NewScheme()
+	tracker := k8stesting.NewObjectTracker(scheme, serializer.NewCodecFactory(scheme).UniversalDecoder())
+	for _, object := range objects {
+		if err := tracker.Add(object); err != nil {
+			panic(err)
+		}
+	}
+
+	client := dynamicfake.NewSimpleDynamicClient(scheme)
+	applies := 0
+
+	client.PrependReactor("*", "*", k8stesting.ObjectReaction(tracker))
+	client.PrependReactor("patch", "horizontalpodautoscalers", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		patch := action.(k8stesting.PatchAction)
+		hpa := &unstructured.Unstructured{}
+		if err := hpa.UnmarshalJSON(patch.GetPatch()); err != nil {
+			return true, nil, err
+		}
+		applies++
+
+		gvr := action.GetResource()
+		if _, err := tracker.Get(gvr, hpa.GetNamespace(), hpa.GetName()); errors.IsNotFound(err) {
+			return true, hpa, tracker.Create(gvr, hpa, hpa.GetNamespace())
+		}
+		return true, hpa, tracker.Update(gvr, hpa, hpa.GetNamespace())
+	})
+
+	return client, &applies
+}
+
+// getHPA reads an HPA of the fake client as the autoscaling/v2beta2 type
+func getHPA(client *dynamicfake.FakeDynamicClient, namespace, name string) (*autoscalingv2.HorizontalPodAutoscaler, error) {
+	object, err := client.Resource(HorizontalPodAutoscalerResource).Namespace(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	hpa := &autoscalingv2.HorizontalPodAutoscaler{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(object.Object, hpa); err != nil {
+		return nil, err
+	}
+	return hpa, nil
+}
+
+func Test_SyncHorizontalPodAutoscaler(t *testing.T) {
+	ctx := context.TODO()
+	client, applies := newHPAClient()
+	owner := NewDeploymentOwnerReference(&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "nodeinfo", UID: "1234"}})
+	labels := map[string]string{ScaleTypeLabel: ScaleTypeCPU, MaxReplicasLabel: "5"}
+
+	get := func() (*autoscalingv2.HorizontalPodAutoscaler, error) {
+		return getHPA(client, "openfaas-fn", "nodeinfo")
+	}
+
+	if err := SyncHorizontalPodAutoscaler(ctx, client, "nodeinfo", "openfaas-fn", labels, owner); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	hpa, err := get()
+	if err != nil {
+		t.Fatalf("want the HorizontalPodAutoscaler to be created: %s", err)
+	}
+	if hpa.APIVersion != "autoscaling/v2" {
+		t.Errorf("want an autoscaling/v2 HorizontalPodAutoscaler, got %s", hpa.APIVersion)
+	}
+	if !metav1.IsControlledBy(hpa, &metav1.ObjectMeta{UID: owner.UID}) {
+		t.Errorf("want the HorizontalPodAutoscaler to be controlled by its owner, got %+v", hpa.OwnerReferences)
+	}
+
+	// an unchanged HPA is not applied again
+	if err := SyncHorizontalPodAutoscaler(ctx, client, "nodeinfo", "openfaas-fn", labels, owner); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if *applies != 1 {
+		t.Errorf("want 1 apply, got %d", *applies)
+	}
+
+	labels[MaxReplicasLabel] = "10"
+	if err := SyncHorizontalPodAutoscaler(ctx, client, "nodeinfo", "openfaas-fn", labels, owner); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if hpa, _ := get(); hpa.Spec.MaxReplicas != 10 {
+		t.Errorf("want the HorizontalPodAutoscaler to be updated to 10 replicas, got %d", hpa.Spec.MaxReplicas)
+	}
+
+	delete(labels, ScaleTypeLabel)
+	if err := SyncHorizontalPodAutoscaler(ctx, client, "nodeinfo", "openfaas-fn", labels, owner); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := get(); !errors.IsNotFound(err) {
+		t.Errorf("want the HorizontalPodAutoscaler to be deleted, got %v", err)
+	}
+}
+
+func Test_SyncHorizontalPodAutoscaler_LeavesOtherHPAs(t *testing.T) {
+	ctx := context.TODO()
+	other := &unstructured.Unstructured{}
+	other.SetAPIVersion("autoscaling/v2")
+	other.SetKind("HorizontalPodAutoscaler")
+	other.SetName("nodeinfo")
+	other.SetNamespace("openfaas-fn")
+	client, applies := newHPAClient(other)
+	owner := NewDeploymentOwnerReference(&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "nodeinfo", UID: "1234"}})
+
+	err := SyncHorizontalPodAutoscaler(ctx, client, "nodeinfo", "openfaas-fn", map[string]string{ScaleTypeLabel: ScaleTypeCPU}, owner)
+	if err == nil {
+		t.Errorf("want an error for an HorizontalPodAutoscaler which is not owned by the function")
+	}
+	if *applies != 0 {
+		t.Errorf("want no apply, got %d", *applies)
+	}
+
+	if err := SyncHorizontalPodAutoscaler(ctx, client, "nodeinfo", "openfaas-fn", map[string]string{}, owner); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := getHPA(client, "openfaas-fn", "nodeinfo"); err != nil {
+		t.Errorf("want the HorizontalPodAutoscaler to be kept, got %v", err)
+	}
+}
@@ -0,0 +1,237 @@
+// Copyright 2020 OpenFaaS Author(s)
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	appslister "k8s.io/client-go/listers/apps/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	// ScaleToZeroLabel opts a function in to be scaled to zero by the Idler
+	// when it is not invoked, it is read from the labels or the annotations
+	ScaleToZeroLabel = "com.openfaas.scale.zero"
+	// ScaleToZeroDurationAnnotation is how long a function must go without
+	// invocations before it is scaled to zero, such as 15m
+	ScaleToZeroDurationAnnotation = "com.openfaas.scale.zero-duration"
+
+	// ReasonScaledToZero is the reason of the Event recorded on the
+	// Deployment of a function scaled to zero
+	ReasonScaledToZero = "ScaledToZero"
+
+	eventComponent = "faas-netes"
+)
+
+// ScaleToZeroPolicy of a function, read from its labels and annotations
+type ScaleToZeroPolicy struct {
+	Enabled bool
+	// Duration without invocations before the function is scaled to zero,
+	// zero uses the default of the Idler
+	Duration time.Duration
+}
+
+// ParseScaleToZeroPolicy reads the scale to zero policy of a function from
+// the annotations of its Deployment or the labels of its Pod template, the
+// annotations take precedence
+func ParseScaleToZeroPolicy(deployment *appsv1.Deployment) (ScaleToZeroPolicy, error) {
+	policy := ScaleToZeroPolicy{}
+
+	value := func(key string) (string, bool) {
+		if v, ok := deployment.Annotations[key]; ok {
+			return v, true
+		}
+		v, ok := deployment.Spec.Template.Labels[key]
+		return v, ok
+	}
+
+	if raw, ok := value(ScaleToZeroLabel); ok {
+		switch raw {
+		case "true":
+			policy.Enabled = true
+		case "false":
+		default:
+			return ScaleToZeroPolicy{}, fmt.Errorf("invalid %s %q, must be true or false", ScaleToZeroLabel, raw)
+		}
+	}
+
+	if raw, ok := value(ScaleToZeroDurationAnnotation); ok {
+		duration, err := time.ParseDuration(raw)
+		if err != nil || duration <= 0 {
+			return ScaleToZeroPolicy{}, fmt.Errorf("invalid %s %q, must be a duration like 15m", ScaleToZeroDurationAnnotation, raw)
+		}
+		policy.Duration = duration
+	}
+
+	return policy, nil
+}
+
+// IdlerConfig sets how often the functions are checked and how long they
+// are kept when their duration is not set
+type IdlerConfig struct {
+	// Interval between two checks of the functions
+	Interval time.Duration
+	// DefaultDuration without invocations before a function is scaled to
+	// zero, when it has no ScaleToZeroDurationAnnotation
+	DefaultDuration time.Duration
+}
+
+// Idler scales the functions which opted in with ScaleToZeroLabel to zero
+// replicas once they have not been invoked through the proxy of faas-netes
+// for their duration. The ColdStart of the proxy scales them up again on
+// the next invocation. Only the requests proxied by this process are seen,
+// so it must run on a single replica which proxies all of the requests, or a
+// function invoked through another replica would be scaled to zero. For this
+// reason faas-netes refuses to start it with leader election.
+type Idler struct {
+	client      kubernetes.Interface
+	deployments appslister.DeploymentLister
+	stats       *InvocationStats
+	recorder    record.EventRecorder
+	config      IdlerConfig
+	now         func() time.Time
+
+	// since is when the Idler started to watch each function with replicas,
+	// it stands for the last invocation until there is one. It is only used
+	// by the loop of Run.
+	since map[string]time.Time
+}
+
+// NewIdler creates an Idler for the functions of the lister, with the
+// invocations recorded in stats
+func NewIdler(client kubernetes.Interface,
+	deployments appslister.DeploymentLister,
+	stats *InvocationStats,
+	recorder record.EventRecorder,
+	config IdlerConfig) *Idler {
+
+	return &Idler{
+		client:      client,
+		deployments: deployments,
+		stats:       stats,
+		recorder:    recorder,
+		config:      config,
+		now:         time.Now,
+		since:       map[string]time.Time{},
+	}
+}
+
+// NewEventRecorder creates a recorder which writes Events to the namespace
+// of the object that they are about
+func NewEventRecorder(client kubernetes.Interface) record.EventRecorder {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: client.CoreV1().Events("")})
+	return broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: eventComponent})
+}
+
+// Run checks the functions every interval until stopCh is closed
+func (i *Idler) Run(stopCh <-chan struct{}) {
+	log.Printf("Scaling idle functions to zero, checking every %s\n", i.config.Interval)
+	wait.Until(i.scaleIdle, i.config.Interval, stopCh)
+}
+
+func (i *Idler) scaleIdle() {
+	deployments, err := i.deployments.List(labels.Everything())
+	if err != nil {
+		log.Printf("Unable to list functions to scale to zero: %s\n", err)
+		return
+	}
+
+	now := i.now()
+	seen := map[string]bool{}
+	for _, deployment := range deployments {
+		if _, ok := deployment.Labels[FunctionLabel]; !ok {
+			continue
+		}
+
+		key := deployment.Name + "." + deployment.Namespace
+
+		// a function at zero is watched again from when it is scaled up
+		if deployment.Spec.Replicas == nil || *deployment.Spec.Replicas == 0 {
+			continue
+		}
+		seen[key] = true
+		if _, ok := i.since[key]; !ok {
+			i.since[key] = now
+		}
+
+		if err := i.scale(key, deployment, now); err != nil {
+			log.Printf("Unable to scale %s to zero: %s\n", key, err)
+		}
+	}
+
+	for key := range i.since {
+		if !seen[key] {
+			delete(i.since, key)
+		}
+	}
+}
+
+// scale sets the replicas of the function to zero when it was idle for
+// its duration
+func (i *Idler) scale(key string, deployment *appsv1.Deployment, now time.Time) error {
+	policy, err := ParseScaleToZeroPolicy(deployment)
+	if err != nil || !policy.Enabled {
+		return err
+	}
+
+	// the warm replicas of a scaling window are kept
+	if window := ActiveScalingWindow(deployment); window != nil && window.MinReplicas > 0 {
+		return nil
+	}
+
+	duration := policy.Duration
+	if duration == 0 {
+		duration = i.config.DefaultDuration
+	}
+
+	snapshot := i.stats.Snapshot(deployment.Name, deployment.Namespace)
+	if snapshot.InFlight > 0 {
+		return nil
+	}
+
+	last := i.since[key]
+	if snapshot.LastInvocation.After(last) {
+		last = snapshot.LastInvocation
+	}
+
+	idle := now.Sub(last)
+	if idle < duration {
+		return nil
+	}
+
+	replicas := *deployment.Spec.Replicas
+	patch := fmt.Sprintf(`[{"op":"test","path":"/spec/replicas","value":%d},{"op":"replace","path":"/spec/replicas","value":0}]`, replicas)
+
+	_, err = i.client.AppsV1().Deployments(deployment.Namespace).
+		Patch(context.TODO(), deployment.Name, k8stypes.JSONPatchType, []byte(patch), metav1.PatchOptions{})
+	if status, ok := err.(errors.APIStatus); ok && status.Status().Code == http.StatusUnprocessableEntity {
+		return fmt.Errorf("the replicas were changed from %d while scaling", replicas)
+	}
+	if err != nil {
+		return err
+	}
+
+	delete(i.since, key)
+
+	message := fmt.Sprintf("Scaled from %d to zero replicas after %s without invocations", replicas, idle.Round(time.Second))
+	log.Printf("%s %s\n", key, message)
+	i.recorder.Event(deployment, corev1.EventTypeNormal, ReasonScaledToZero, message)
+	return nil
+}
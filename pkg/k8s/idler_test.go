@@ -0,0 +1,193 @@
+// Copyright 2020 OpenFaaS Author(s)
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package k8s
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+)
+
+func Test_ParseScaleToZeroPolicy(t *testing.T) {
+	cases := []struct {
+		name        string
+		annotations map[string]string
+		labels      map[string]string
+		want        ScaleToZeroPolicy
+		wantErr     bool
+	}{
+		{
+			name: "not set",
+			want: ScaleToZeroPolicy{},
+		},
+		{
+			name:   "label with the default duration",
+			labels: map[string]string{ScaleToZeroLabel: "true"},
+			want:   ScaleToZeroPolicy{Enabled: true},
+		},
+		{
+			name:        "annotations take precedence",
+			annotations: map[string]string{ScaleToZeroLabel: "false", ScaleToZeroDurationAnnotation: "5m"},
+			labels:      map[string]string{ScaleToZeroLabel: "true"},
+			want:        ScaleToZeroPolicy{Duration: 5 * time.Minute},
+		},
+		{
+			name:        "invalid duration",
+			annotations: map[string]string{ScaleToZeroLabel: "true", ScaleToZeroDurationAnnotation: "15"},
+			wantErr:     true,
+		},
+		{
+			name:    "invalid flag",
+			labels:  map[string]string{ScaleToZeroLabel: "yes"},
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			deployment := &appsv1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{Annotations: tc.annotations},
+				Spec: appsv1.DeploymentSpec{
+					Template: corev1.PodTemplateSpec{ObjectMeta: metav1.ObjectMeta{Labels: tc.labels}},
+				},
+			}
+
+			got, err := ParseScaleToZeroPolicy(deployment)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if got != tc.want {
+				t.Errorf("want %+v, got %+v", tc.want, got)
+			}
+		})
+	}
+}
+
+type idlerTest struct {
+	idler    *Idler
+	client   *fake.Clientset
+	indexer  cache.Indexer
+	stats    *InvocationStats
+	recorder *record.FakeRecorder
+	now      time.Time
+}
+
+func newIdlerTest(t *testing.T, annotations map[string]string) *idlerTest {
+	t.Helper()
+
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "nodeinfo",
+			Namespace:   "openfaas-fn",
+			Labels:      map[string]string{FunctionLabel: "nodeinfo"},
+			Annotations: annotations,
+		},
+		Spec: appsv1.DeploymentSpec{Replicas: int32p(2)},
+	}
+
+	client := fake.NewSimpleClientset(deployment)
+	deployments := informers.NewSharedInformerFactory(client, 0).Apps().V1().Deployments()
+	deployments.Informer().GetIndexer().Add(deployment)
+
+	test := &idlerTest{
+		client:   client,
+		indexer:  deployments.Informer().GetIndexer(),
+		stats:    NewInvocationStats(),
+		recorder: record.NewFakeRecorder(10),
+		now:      time.Unix(0, 0),
+	}
+	test.stats.now = func() time.Time { return test.now }
+	test.idler = NewIdler(client, deployments.Lister(), test.stats, test.recorder, IdlerConfig{
+		Interval:        30 * time.Second,
+		DefaultDuration: 15 * time.Minute,
+	})
+	test.idler.now = test.stats.now
+	return test
+}
+
+// tick moves the clock on and checks the functions, the Deployment of the
+// lister is then updated from the client
+func (i *idlerTest) tick(t *testing.T, d time.Duration) int32 {
+	t.Helper()
+
+	i.now = i.now.Add(d)
+	i.idler.scaleIdle()
+
+	deployment, err := i.client.AppsV1().Deployments("openfaas-fn").Get(context.TODO(), "nodeinfo", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	i.indexer.Update(deployment)
+	return *deployment.Spec.Replicas
+}
+
+func Test_Idler_ScalesIdleFunctionToZero(t *testing.T) {
+	test := newIdlerTest(t, map[string]string{ScaleToZeroLabel: "true", ScaleToZeroDurationAnnotation: "5m"})
+
+	test.tick(t, 0)
+	if got := test.tick(t, 4*time.Minute); got != 2 {
+		t.Fatalf("want the replicas to be kept for the duration, got %d", got)
+	}
+
+	// an invocation resets the duration
+	test.stats.Start("nodeinfo", "openfaas-fn")()
+	if got := test.tick(t, 4*time.Minute); got != 2 {
+		t.Fatalf("want the replicas to be kept after an invocation, got %d", got)
+	}
+
+	if got := test.tick(t, time.Minute); got != 0 {
+		t.Fatalf("want the function to be scaled to zero, got %d replicas", got)
+	}
+
+	select {
+	case event := <-test.recorder.Events:
+		if !strings.Contains(event, ReasonScaledToZero) || !strings.Contains(event, "after 5m0s without invocations") {
+			t.Errorf("want a %s event, got %q", ReasonScaledToZero, event)
+		}
+	default:
+		t.Errorf("want an event to be recorded")
+	}
+}
+
+func Test_Idler_KeepsFunctionWithRequestsInFlight(t *testing.T) {
+	test := newIdlerTest(t, map[string]string{ScaleToZeroLabel: "true"})
+
+	test.tick(t, 0)
+	done := test.stats.Start("nodeinfo", "openfaas-fn")
+	if got := test.tick(t, time.Hour); got != 2 {
+		t.Fatalf("want a function with a request in flight to keep its replicas, got %d", got)
+	}
+
+	done()
+	if got := test.tick(t, 15*time.Minute); got != 0 {
+		t.Fatalf("want the function to be scaled to zero after the default duration, got %d replicas", got)
+	}
+}
+
+func Test_Idler_IgnoresFunctionsWhichDidNotOptIn(t *testing.T) {
+	test := newIdlerTest(t, nil)
+
+	test.tick(t, 0)
+	if got := test.tick(t, time.Hour); got != 2 {
+		t.Errorf("want the replicas to be kept, got %d", got)
+	}
+	if len(test.recorder.Events) != 0 {
+		t.Errorf("want no event")
+	}
+}
@@ -46,12 +46,17 @@ func (f *FunctionFactory) MakeProbes(r types.FunctionDeployment) (*FunctionProbe
 	}
 
 	if f.Config.HTTPProbe || httpPath != ProbePathValue {
+		port, err := f.FunctionPort(r)
+		if err != nil {
+			return nil, err
+		}
+
 		handler = corev1.Handler{
 			HTTPGet: &corev1.HTTPGetAction{
 				Path: httpPath,
 				Port: intstr.IntOrString{
 					Type:   intstr.Int,
-					IntVal: int32(f.Config.RuntimeHTTPPort),
+					IntVal: port,
 				},
 			},
 		}
@@ -5,33 +5,86 @@
 package k8s
 
 import (
+	"context"
 	"fmt"
-	"math/rand"
+	"log"
+	"net"
+	"net/http"
 	"net/url"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/openfaas/faas-netes/pkg/metrics"
+	"github.com/openfaas/faas-netes/pkg/proxy"
+	"github.com/openfaas/faas-netes/pkg/tracing"
+	"go.opentelemetry.io/otel/trace"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	appslister "k8s.io/client-go/listers/apps/v1"
 	corelister "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
 )
 
-// watchdogPort for the OpenFaaS function watchdog
+// watchdogPort for the OpenFaaS function watchdog, used when the Endpoints
+// of a function have no port named FunctionPortName
 const watchdogPort = 8080
 
-func NewFunctionLookup(ns string, lister corelister.EndpointsLister) *FunctionLookup {
+// NewFunctionLookup creates a FunctionLookup, the deploymentLister is used to
+// read the load balancing strategy of the functions and may be nil, in which
+// case all functions use the default strategy
+func NewFunctionLookup(ns string, lister corelister.EndpointsLister, deploymentLister appslister.DeploymentLister) *FunctionLookup {
 	return &FunctionLookup{
 		DefaultNamespace: ns,
 		EndpointLister:   lister,
+		DeploymentLister: deploymentLister,
 		Listers:          map[string]corelister.EndpointsNamespaceLister{},
+		Requests:         NewRequestTracker(),
+		Limiter:          NewConcurrencyLimiter(),
+		Stats:            NewInvocationStats(),
 		lock:             sync.RWMutex{},
+		balancers:        map[string]Balancer{},
+		settings:         map[string]functionSettings{},
 	}
 }
 
 type FunctionLookup struct {
 	DefaultNamespace string
 	EndpointLister   corelister.EndpointsLister
+	DeploymentLister appslister.DeploymentLister
 	Listers          map[string]corelister.EndpointsNamespaceLister
+	// Requests counts the requests in flight to each endpoint, for the
+	// least-requests strategy
+	Requests *RequestTracker
+	// ColdStart scales up functions with zero replicas when they are
+	// invoked, when nil the functions must be scaled up by the gateway
+	ColdStart *ColdStart
+	// Limiter enforces the concurrency limits set in the annotations of the
+	// function Deployments
+	Limiter *ConcurrencyLimiter
+	// Stats counts the requests to each function and their latency, for the
+	// Autoscaler
+	Stats *InvocationStats
+	// WriteTimeout is the write timeout of the server of the proxy, the
+	// timeout of a function is capped below it, see MaxFunctionTimeout. Zero
+	// leaves the timeouts of the functions as they are.
+	WriteTimeout time.Duration
 
-	lock sync.RWMutex
+	lock      sync.RWMutex
+	balancers map[string]Balancer
+	settings  map[string]functionSettings
+}
+
+// functionSettings are the parsed annotations of a version of a function
+// Deployment
+type functionSettings struct {
+	resourceVersion string
+	limits          ConcurrencyLimits
+	retry           proxy.RetryPolicy
+	timeout         time.Duration
+	traffic         []RevisionWeight
 }
 
 func (f *FunctionLookup) GetLister(ns string) corelister.EndpointsNamespaceLister {
@@ -54,17 +107,242 @@ func getNamespace(name, defaultNamespace string) string {
 	return namespace
 }
 
+// Resolve returns the URL of one of the ready endpoints of the function
 func (l *FunctionLookup) Resolve(name string) (url.URL, error) {
+	urlRes, done, err := l.ResolveRequest(name, nil)
+	if err != nil {
+		return url.URL{}, err
+	}
+	done()
+	return urlRes, nil
+}
+
+// ResolveRequest picks one of the ready endpoints of the function for the
+// request, with the load balancing strategy of the function. The returned
+// func must be called once the request to the function is complete.
+func (l *FunctionLookup) ResolveRequest(name string, r *http.Request) (url.URL, func(), error) {
+	return l.resolve(name, r, nil)
+}
+
+// ResolveRetry picks an endpoint of the function to retry the request, the
+// endpoints which were tried are only picked again when there are no others
+func (l *FunctionLookup) ResolveRetry(name string, r *http.Request, tried []string) (url.URL, func(), error) {
+	return l.resolve(name, r, tried)
+}
+
+// RetryPolicy returns the retry policy set in the annotations of the
+// function Deployment
+func (l *FunctionLookup) RetryPolicy(name string) proxy.RetryPolicy {
+	namespace := getNamespace(name, l.DefaultNamespace)
+	functionName := strings.TrimSuffix(name, "."+namespace)
+
+	return l.functionSettings(functionName, namespace).retry
+}
+
+// Timeout returns the timeout of the function, read from the annotations or
+// the environment of its Deployment. Zero is returned when it is not set.
+func (l *FunctionLookup) Timeout(name string) time.Duration {
+	namespace := getNamespace(name, l.DefaultNamespace)
+	functionName := strings.TrimSuffix(name, "."+namespace)
+
+	return l.functionSettings(functionName, namespace).timeout
+}
+
+func (l *FunctionLookup) resolve(name string, r *http.Request, tried []string) (url.URL, func(), error) {
 	functionName := name
 	namespace := getNamespace(name, l.DefaultNamespace)
 	if err := l.verifyNamespace(namespace); err != nil {
-		return url.URL{}, err
+		return url.URL{}, nil, err
 	}
 
 	if strings.Contains(name, ".") {
 		functionName = strings.TrimSuffix(name, "."+namespace)
 	}
 
+	// the revisions of a function are resolved as functions of their own,
+	// the invocation metrics are recorded for the requested function
+	requested := functionName
+	functionName = l.pickRevision(functionName, namespace, r)
+
+	ctx := context.Background()
+	if r != nil {
+		ctx = r.Context()
+	}
+	trace.SpanFromContext(ctx).SetAttributes(
+		tracing.NamespaceKey.String(namespace),
+		tracing.DeploymentKey.String(functionName),
+	)
+
+	addresses, err := l.readyAddresses(functionName, namespace)
+	if err != nil && l.ColdStart != nil {
+		// hold the request while a function with no ready endpoints is
+		// scaled up from zero
+		if waitErr := l.ColdStart.Wait(ctx, functionName, namespace); waitErr != nil {
+			return url.URL{}, nil, fmt.Errorf("%s, cold start failed: %s", err, waitErr)
+		}
+		addresses, err = l.readyAddresses(functionName, namespace)
+	}
+	if err != nil {
+		return url.URL{}, nil, err
+	}
+	metrics.SetFunction(ctx, requested, namespace)
+
+	limits := l.functionSettings(functionName, namespace).limits
+	capacity := limits.MaxInflight
+	if limits.MaxInflightPerPod > 0 {
+		if podCapacity := limits.MaxInflightPerPod * len(addresses); capacity == 0 || podCapacity < capacity {
+			capacity = podCapacity
+		}
+	}
+
+	release, err := l.Limiter.Acquire(ctx, functionName, namespace, capacity, limits)
+	if err != nil {
+		return url.URL{}, nil, err
+	}
+
+	if capacity > 0 {
+		// the endpoints may have changed while the request was queued
+		if addresses, err = l.readyAddresses(functionName, namespace); err != nil {
+			release()
+			return url.URL{}, nil, err
+		}
+	}
+	if limits.MaxInflightPerPod > 0 {
+		addresses = l.addressesUnder(addresses, limits.MaxInflightPerPod)
+	}
+	if len(tried) > 0 {
+		addresses = untriedAddresses(addresses, tried)
+	}
+
+	balancer := l.balancer(functionName, namespace)
+	address := addresses[balancer.Pick(functionName+"."+namespace, addresses, r)]
+
+	urlStr := fmt.Sprintf("http://%s", address)
+
+	urlRes, err := url.Parse(urlStr)
+	if err != nil {
+		release()
+		return url.URL{}, nil, err
+	}
+
+	done := l.Requests.Start(address)
+	stop := l.Stats.Start(functionName, namespace)
+	return *urlRes, func() {
+		stop()
+		done()
+		release()
+	}, nil
+}
+
+// addressesUnder returns the addresses with fewer than limit requests in
+// flight, or all of them when they are all at the limit
+func (l *FunctionLookup) addressesUnder(addresses []string, limit int) []string {
+	under := []string{}
+	for _, address := range addresses {
+		if l.Requests.InFlight(address) < int64(limit) {
+			under = append(under, address)
+		}
+	}
+	if len(under) == 0 {
+		return addresses
+	}
+	return under
+}
+
+// untriedAddresses returns the addresses which were not tried, or all of
+// them when they were all tried
+func untriedAddresses(addresses, tried []string) []string {
+	untried := []string{}
+	for _, address := range addresses {
+		found := false
+		for _, t := range tried {
+			if address == t {
+				found = true
+				break
+			}
+		}
+		if !found {
+			untried = append(untried, address)
+		}
+	}
+	if len(untried) == 0 {
+		return addresses
+	}
+	return untried
+}
+
+// functionSettings returns the concurrency limits, retry policy, timeout and
+// traffic split set in the annotations of the function Deployment, they are
+// parsed once for each version of it. Invalid values are logged and the defaults are used.
+func (l *FunctionLookup) functionSettings(functionName, namespace string) functionSettings {
+	defaults := functionSettings{retry: proxy.RetryPolicy{Attempts: 1}}
+	if l.DeploymentLister == nil {
+		return defaults
+	}
+
+	deployment, err := l.DeploymentLister.Deployments(namespace).Get(functionName)
+	if err != nil {
+		return defaults
+	}
+
+	key := functionName + "." + namespace
+
+	l.lock.RLock()
+	cached, ok := l.settings[key]
+	l.lock.RUnlock()
+	if ok && cached.resourceVersion == deployment.ResourceVersion {
+		return cached
+	}
+
+	settings := defaults
+	settings.resourceVersion = deployment.ResourceVersion
+
+	if settings.limits, err = ParseConcurrencyLimits(deployment.Annotations); err != nil {
+		log.Printf("Ignoring the concurrency limits of %s: %s\n", key, err)
+	}
+	if settings.retry, err = ParseRetryPolicy(deployment.Annotations); err != nil {
+		log.Printf("Ignoring the retry policy of %s: %s\n", key, err)
+		settings.retry = defaults.retry
+	}
+	if settings.timeout, err = FunctionTimeout(deployment); err != nil {
+		log.Printf("Ignoring the timeout of %s: %s\n", key, err)
+	}
+	if max := MaxFunctionTimeout(l.WriteTimeout); max > 0 && settings.timeout > max {
+		log.Printf("Capping the timeout of %s from %s to %s, the write timeout of faas-netes is %s\n", key, settings.timeout, max, l.WriteTimeout)
+		settings.timeout = max
+	}
+	if settings.traffic, err = ParseTrafficWeights(deployment.Annotations); err != nil {
+		log.Printf("Ignoring the traffic split of %s: %s\n", key, err)
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.settings[key] = settings
+	return settings
+}
+
+// DeploymentDeleted drops the settings of a deleted function Deployment, so
+// that they are not kept for the life of the process. It is the DeleteFunc
+// of the Deployment informer.
+func (l *FunctionLookup) DeploymentDeleted(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	deployment, ok := obj.(*appsv1.Deployment)
+	if !ok {
+		return
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	delete(l.settings, deployment.Name+"."+deployment.Namespace)
+}
+
+// readyAddresses returns the sorted host:port addresses of the ready
+// endpoints of the function, an error is returned when there are none. The
+// port is read from the port named FunctionPortName of each subset, so that
+// functions which do not listen on the watchdog port are resolved.
+func (l *FunctionLookup) readyAddresses(functionName, namespace string) ([]string, error) {
 	nsEndpointLister := l.GetLister(namespace)
 
 	if nsEndpointLister == nil {
@@ -75,30 +353,80 @@ func (l *FunctionLookup) Resolve(name string) (url.URL, error) {
 
 	svc, err := nsEndpointLister.Get(functionName)
 	if err != nil {
-		return url.URL{}, fmt.Errorf("error listing \"%s.%s\": %s", functionName, namespace, err.Error())
+		return nil, fmt.Errorf("error listing \"%s.%s\": %s", functionName, namespace, err.Error())
 	}
 
 	if len(svc.Subsets) == 0 {
-		return url.URL{}, fmt.Errorf("no subsets available for \"%s.%s\"", functionName, namespace)
+		return nil, fmt.Errorf("no subsets available for \"%s.%s\"", functionName, namespace)
 	}
 
-	all := len(svc.Subsets[0].Addresses)
-	if len(svc.Subsets[0].Addresses) == 0 {
-		return url.URL{}, fmt.Errorf("no addresses in subset for \"%s.%s\"", functionName, namespace)
+	// the Endpoints controller may split the addresses over several subsets
+	addresses := []string{}
+	notReady := 0
+	for _, subset := range svc.Subsets {
+		port := subsetPort(subset)
+		for _, address := range subset.Addresses {
+			addresses = append(addresses, net.JoinHostPort(address.IP, strconv.Itoa(int(port))))
+		}
+		notReady += len(subset.NotReadyAddresses)
 	}
 
-	target := rand.Intn(all)
+	if len(addresses) == 0 {
+		return nil, fmt.Errorf("no ready addresses for \"%s.%s\", %d not ready", functionName, namespace, notReady)
+	}
 
-	serviceIP := svc.Subsets[0].Addresses[target].IP
+	sort.Strings(addresses)
+	return addresses, nil
+}
 
-	urlStr := fmt.Sprintf("http://%s:%d", serviceIP, watchdogPort)
+// subsetPort returns the port named FunctionPortName of the subset, or the
+// watchdogPort for Services created without a named port
+func subsetPort(subset corev1.EndpointSubset) int32 {
+	for _, port := range subset.Ports {
+		if port.Name == FunctionPortName {
+			return port.Port
+		}
+	}
+	return watchdogPort
+}
 
-	urlRes, err := url.Parse(urlStr)
-	if err != nil {
-		return url.URL{}, err
+// balancer returns the Balancer for the strategy set in the annotations of
+// the function Deployment, the Balancers are shared by the functions so that
+// their state is kept between requests
+func (l *FunctionLookup) balancer(functionName, namespace string) Balancer {
+	strategy, header := LoadBalancerRandom, ""
+	if l.DeploymentLister != nil {
+		deployment, err := l.DeploymentLister.Deployments(namespace).Get(functionName)
+		if err == nil {
+			if value, ok := deployment.Annotations[LoadBalancerAnnotation]; ok {
+				strategy = strings.ToLower(value)
+			}
+			header = deployment.Annotations[LoadBalancerHeaderAnnotation]
+		}
+	}
+
+	key := strategy + "/" + header
+
+	l.lock.RLock()
+	balancer, ok := l.balancers[key]
+	l.lock.RUnlock()
+	if ok {
+		return balancer
 	}
 
-	return *urlRes, nil
+	balancer = NewBalancer(strategy, header, l.Requests)
+	if balancer == nil {
+		log.Printf("Unknown %s %q for %s.%s, using %s\n", LoadBalancerAnnotation, strategy, functionName, namespace, LoadBalancerRandom)
+		balancer = randomBalancer{}
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if existing, ok := l.balancers[key]; ok {
+		return existing
+	}
+	l.balancers[key] = balancer
+	return balancer
 }
 
 func (l *FunctionLookup) verifyNamespace(name string) error {
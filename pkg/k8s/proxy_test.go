@@ -2,9 +2,14 @@ package k8s
 
 import (
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
 
+	"github.com/gorilla/mux"
+	"github.com/openfaas/faas-netes/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	corelister "k8s.io/client-go/listers/core/v1"
 
 	corev1 "k8s.io/api/core/v1"
@@ -50,7 +55,7 @@ func Test_FunctionLookup(t *testing.T) {
 
 	lister := FakeLister{}
 
-	resolver := NewFunctionLookup("testDefault", lister)
+	resolver := NewFunctionLookup("testDefault", lister, nil)
 
 	cases := []struct {
 		name     string
@@ -92,3 +97,25 @@ func Test_FunctionLookup(t *testing.T) {
 		})
 	}
 }
+
+func Test_FunctionLookup_SetsInvocationMetricsFunction(t *testing.T) {
+	resolver := NewFunctionLookup("testDefault", FakeLister{}, nil)
+
+	handler := metrics.InstrumentProxy(func(w http.ResponseWriter, r *http.Request) {
+		if _, done, err := resolver.ResolveRequest(mux.Vars(r)["name"], r); err == nil {
+			done()
+		}
+	})
+	router := mux.NewRouter()
+	router.HandleFunc("/function/{name}", handler)
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/function/metricsfunc.othernamespace", nil))
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/function/metricsfunc.kube-system", nil))
+
+	if got := testutil.ToFloat64(metrics.FunctionInvocations.WithLabelValues("metricsfunc", "othernamespace", "200")); got != 1 {
+		t.Errorf("want 1 invocation of the resolved function, got %v", got)
+	}
+	if got := testutil.ToFloat64(metrics.FunctionInvocations.WithLabelValues("metricsfunc", "kube-system", "200")); got != 0 {
+		t.Errorf("want no series for a function which was not resolved, got %v", got)
+	}
+}
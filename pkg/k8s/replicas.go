@@ -0,0 +1,193 @@
+// Copyright 2020 OpenFaaS Author(s)
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+)
+
+// ScaleResult is the outcome of ScaleFunction, it is the body of the
+// response of the scale-function endpoint
+type ScaleResult struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	// RequestedReplicas is the number of replicas that were asked for
+	RequestedReplicas int32 `json:"requestedReplicas"`
+	// PreviousReplicas is the number of replicas before the scaling
+	PreviousReplicas int32 `json:"previousReplicas"`
+	// Replicas is the number of replicas that were set, within the range of
+	// the function
+	Replicas int32 `json:"replicas"`
+}
+
+// ScaleFunction sets the replicas of the Deployment of a function, it is the
+// code path of the scale-function endpoint and of the Scheduler.
+//
+// The replicas are clamped to the range of the function, see ReplicaRange.
+// Replicas are only added when the ResourceQuotas of the namespace have room
+// for the new Pods, otherwise a Forbidden error is returned. The Deployment
+// is read again and the update retried when it was changed concurrently.
+func ScaleFunction(ctx context.Context, client kubernetes.Interface, functionName, namespace string, replicas int32) (ScaleResult, error) {
+	deployments := client.AppsV1().Deployments(namespace)
+	result := ScaleResult{Name: functionName, Namespace: namespace, RequestedReplicas: replicas}
+
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		deployment, err := deployments.Get(ctx, functionName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		result.PreviousReplicas = 0
+		if deployment.Spec.Replicas != nil {
+			result.PreviousReplicas = *deployment.Spec.Replicas
+		}
+		result.Replicas = ClampReplicas(deployment, replicas)
+
+		if added := result.Replicas - result.PreviousReplicas; added > 0 {
+			if err := checkQuota(ctx, client, deployment, added); err != nil {
+				return err
+			}
+		}
+
+		deployment.Spec.Replicas = &result.Replicas
+		_, err = deployments.Update(ctx, deployment, metav1.UpdateOptions{})
+		return err
+	})
+
+	return result, err
+}
+
+// ReplicaRange returns the replicas that a function can be scaled to, from
+// its scale labels or from its active scaling window. A max of zero means no
+// maximum.
+func ReplicaRange(deployment *appsv1.Deployment) (min int32, max int32) {
+	labels := deployment.Spec.Template.Labels
+
+	min = initialReplicasCount
+	if labelMin := GetMinReplicaCount(labels); labelMin != nil {
+		min = *labelMin
+	}
+	if raw, ok := labels[MaxReplicasLabel]; ok {
+		if value, err := strconv.Atoi(raw); err == nil && value > 0 {
+			max = int32(value)
+		}
+	}
+
+	if window := ActiveScalingWindow(deployment); window != nil {
+		min = window.MinReplicas
+		if window.MaxReplicas > 0 {
+			max = window.MaxReplicas
+		}
+	}
+
+	if max > 0 && max < min {
+		max = min
+	}
+	return min, max
+}
+
+// ClampReplicas returns the replicas within the range of the function,
+// scaling to zero is allowed outside of a scaling window
+func ClampReplicas(deployment *appsv1.Deployment, replicas int32) int32 {
+	min, max := ReplicaRange(deployment)
+
+	if replicas == 0 && ActiveScalingWindow(deployment) == nil {
+		return 0
+	}
+	if replicas < min {
+		return min
+	}
+	if max > 0 && replicas > max {
+		return max
+	}
+	return replicas
+}
+
+// checkQuota returns a Forbidden error when a ResourceQuota of the namespace
+// has no room for the Pods which would be added. Quotas with scopes are not
+// evaluated, and the check is skipped when faas-netes may not read quotas.
+func checkQuota(ctx context.Context, client kubernetes.Interface, deployment *appsv1.Deployment, added int32) error {
+	quotas, err := client.CoreV1().ResourceQuotas(deployment.Namespace).List(ctx, metav1.ListOptions{})
+	if errors.IsForbidden(err) {
+		log.Printf("Unable to check the resource quotas of %s: %s\n", deployment.Namespace, err)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	usage := podUsage(deployment.Spec.Template.Spec, added)
+	for _, quota := range quotas.Items {
+		if len(quota.Spec.Scopes) > 0 || quota.Spec.ScopeSelector != nil {
+			continue
+		}
+
+		for name, hard := range quota.Spec.Hard {
+			needed, ok := usage[name]
+			if !ok {
+				continue
+			}
+
+			total := quota.Status.Used[name].DeepCopy()
+			total.Add(needed)
+			if total.Cmp(hard) > 0 {
+				used := quota.Status.Used[name]
+				return errors.NewForbidden(schema.GroupResource{Group: "apps", Resource: "deployments"}, deployment.Name,
+					fmt.Errorf("scaling up by %d replicas exceeds quota %s: requested %s=%s, used %s=%s, limited %s=%s",
+						added, quota.Name, name, needed.String(), name, used.String(), name, hard.String()))
+			}
+		}
+	}
+
+	return nil
+}
+
+// podUsage returns the resources counted by quotas for a number of Pods of
+// the template
+func podUsage(spec corev1.PodSpec, pods int32) corev1.ResourceList {
+	usage := corev1.ResourceList{
+		corev1.ResourcePods: *resource.NewQuantity(int64(pods), resource.DecimalSI),
+	}
+
+	add := func(name corev1.ResourceName, quantity resource.Quantity) {
+		for i := int32(0); i < pods; i++ {
+			total := usage[name]
+			total.Add(quantity)
+			usage[name] = total
+		}
+	}
+
+	for _, container := range spec.Containers {
+		for _, name := range []corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceMemory} {
+			limit, hasLimit := container.Resources.Limits[name]
+			if hasLimit {
+				add(corev1.ResourceName("limits."+string(name)), limit)
+			}
+
+			// the request defaults to the limit
+			request, hasRequest := container.Resources.Requests[name]
+			if !hasRequest {
+				request, hasRequest = limit, hasLimit
+			}
+			if hasRequest {
+				add(name, request)
+				add(corev1.ResourceName("requests."+string(name)), request)
+			}
+		}
+	}
+
+	return usage
+}
@@ -0,0 +1,163 @@
+// Copyright 2020 OpenFaaS Author(s)
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package k8s
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func newScaleDeployment(replicas int32, labels, annotations map[string]string) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "nodeinfo",
+			Namespace:   "openfaas-fn",
+			Annotations: annotations,
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: int32p(replicas),
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{
+						Name: "nodeinfo",
+						Resources: corev1.ResourceRequirements{
+							Limits: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("128Mi")},
+						},
+					}},
+				},
+			},
+		},
+	}
+}
+
+func Test_ClampReplicas(t *testing.T) {
+	labels := map[string]string{MinReplicasLabel: "2", MaxReplicasLabel: "5"}
+	window := map[string]string{
+		ScalingScheduleAnnotation:     `[{"name":"business-hours","start":"0 8 * * 1-5","duration":"10h","min":6}]`,
+		ActiveScalingWindowAnnotation: "business-hours",
+	}
+
+	cases := []struct {
+		name        string
+		labels      map[string]string
+		annotations map[string]string
+		requested   int32
+		want        int32
+	}{
+		{name: "within the range", labels: labels, requested: 3, want: 3},
+		{name: "over the max", labels: labels, requested: 10, want: 5},
+		{name: "under the min", labels: labels, requested: 1, want: 2},
+		{name: "scale to zero", labels: labels, requested: 0, want: 0},
+		{name: "no labels", requested: 40, want: 40},
+		{name: "invalid max is ignored", labels: map[string]string{MaxReplicasLabel: "ten"}, requested: 40, want: 40},
+		{name: "window min over the max", labels: labels, annotations: window, requested: 3, want: 6},
+		{name: "no scale to zero in a window", labels: labels, annotations: window, requested: 0, want: 6},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			deployment := newScaleDeployment(1, tc.labels, tc.annotations)
+			if got := ClampReplicas(deployment, tc.requested); got != tc.want {
+				t.Errorf("want %d replicas, got %d", tc.want, got)
+			}
+		})
+	}
+}
+
+func Test_ScaleFunction(t *testing.T) {
+	deployment := newScaleDeployment(1, map[string]string{MaxReplicasLabel: "5"}, nil)
+	client := fake.NewSimpleClientset(deployment)
+
+	result, err := ScaleFunction(context.TODO(), client, "nodeinfo", "openfaas-fn", 8)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	want := ScaleResult{Name: "nodeinfo", Namespace: "openfaas-fn", RequestedReplicas: 8, PreviousReplicas: 1, Replicas: 5}
+	if result != want {
+		t.Errorf("want %+v, got %+v", want, result)
+	}
+
+	updated, _ := client.AppsV1().Deployments("openfaas-fn").Get(context.TODO(), "nodeinfo", metav1.GetOptions{})
+	if *updated.Spec.Replicas != 5 {
+		t.Errorf("want 5 replicas, got %d", *updated.Spec.Replicas)
+	}
+}
+
+func Test_ScaleFunction_RetriesOnConflict(t *testing.T) {
+	client := fake.NewSimpleClientset(newScaleDeployment(1, nil, nil))
+
+	conflicts := 1
+	client.PrependReactor("update", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if conflicts > 0 {
+			conflicts--
+			return true, nil, errors.NewConflict(appsv1.Resource("deployments"), "nodeinfo", nil)
+		}
+		return false, nil, nil
+	})
+
+	result, err := ScaleFunction(context.TODO(), client, "nodeinfo", "openfaas-fn", 3)
+	if err != nil {
+		t.Fatalf("want the update to be retried, got: %s", err)
+	}
+	if result.Replicas != 3 {
+		t.Errorf("want 3 replicas, got %d", result.Replicas)
+	}
+}
+
+func Test_ScaleFunction_ChecksQuota(t *testing.T) {
+	quota := &corev1.ResourceQuota{
+		ObjectMeta: metav1.ObjectMeta{Name: "functions", Namespace: "openfaas-fn"},
+		Spec: corev1.ResourceQuotaSpec{
+			Hard: corev1.ResourceList{corev1.ResourceRequestsMemory: resource.MustParse("512Mi")},
+		},
+		Status: corev1.ResourceQuotaStatus{
+			Used: corev1.ResourceList{corev1.ResourceRequestsMemory: resource.MustParse("128Mi")},
+		},
+	}
+
+	cases := []struct {
+		name      string
+		replicas  int32
+		wantError bool
+	}{
+		// the memory limit is also the request of each Pod
+		{name: "within the quota", replicas: 4},
+		{name: "over the quota", replicas: 5, wantError: true},
+		{name: "scale down over the quota", replicas: 0},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset(newScaleDeployment(1, nil, nil), quota)
+
+			_, err := ScaleFunction(context.TODO(), client, "nodeinfo", "openfaas-fn", tc.replicas)
+			if !tc.wantError {
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				return
+			}
+
+			if !errors.IsForbidden(err) || !strings.Contains(err.Error(), "exceeds quota functions") {
+				t.Fatalf("want a forbidden error for the quota, got %v", err)
+			}
+			deployment, _ := client.AppsV1().Deployments("openfaas-fn").Get(context.TODO(), "nodeinfo", metav1.GetOptions{})
+			if *deployment.Spec.Replicas != 1 {
+				t.Errorf("want the replicas to be kept, got %d", *deployment.Spec.Replicas)
+			}
+		})
+	}
+}
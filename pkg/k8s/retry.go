@@ -0,0 +1,69 @@
+// Copyright 2020 OpenFaaS Author(s)
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package k8s
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/openfaas/faas-netes/pkg/proxy"
+)
+
+const (
+	// RetryAttemptsAnnotation is the total number of attempts of a request
+	// to a function, requests are not retried unless it is set
+	RetryAttemptsAnnotation = "com.openfaas.retry.attempts"
+	// RetryBackoffAnnotation is the wait before the first retry, it doubles
+	// for each following retry
+	RetryBackoffAnnotation = "com.openfaas.retry.backoff"
+	// RetryMaxBackoffAnnotation caps the wait between two attempts
+	RetryMaxBackoffAnnotation = "com.openfaas.retry.max_backoff"
+	// RetryIdempotencyHeaderAnnotation is the request header which marks a
+	// request as safe to retry after a 502 or 503 response
+	RetryIdempotencyHeaderAnnotation = "com.openfaas.retry.idempotency_header"
+
+	defaultRetryBackoff      = 100 * time.Millisecond
+	defaultRetryMaxBackoff   = 2 * time.Second
+	defaultIdempotencyHeader = "Idempotency-Key"
+)
+
+// ParseRetryPolicy reads the retry policy from the annotations of a function
+func ParseRetryPolicy(annotations map[string]string) (proxy.RetryPolicy, error) {
+	policy := proxy.RetryPolicy{
+		Attempts:          1,
+		Backoff:           defaultRetryBackoff,
+		MaxBackoff:        defaultRetryMaxBackoff,
+		IdempotencyHeader: defaultIdempotencyHeader,
+	}
+
+	if raw, ok := annotations[RetryAttemptsAnnotation]; ok {
+		attempts, err := strconv.Atoi(raw)
+		if err != nil || attempts < 1 {
+			return proxy.RetryPolicy{}, fmt.Errorf("invalid %s %q, must be at least 1", RetryAttemptsAnnotation, raw)
+		}
+		policy.Attempts = attempts
+	}
+
+	for key, value := range map[string]*time.Duration{
+		RetryBackoffAnnotation:    &policy.Backoff,
+		RetryMaxBackoffAnnotation: &policy.MaxBackoff,
+	} {
+		raw, ok := annotations[key]
+		if !ok {
+			continue
+		}
+		d, err := time.ParseDuration(raw)
+		if err != nil || d < 0 {
+			return proxy.RetryPolicy{}, fmt.Errorf("invalid %s %q, must be a duration like 100ms", key, raw)
+		}
+		*value = d
+	}
+
+	if header, ok := annotations[RetryIdempotencyHeaderAnnotation]; ok {
+		policy.IdempotencyHeader = header
+	}
+
+	return policy, nil
+}
@@ -0,0 +1,86 @@
+// Copyright 2020 OpenFaaS Author(s)
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package k8s
+
+import (
+	"testing"
+	"time"
+
+	"github.com/openfaas/faas-netes/pkg/proxy"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func Test_ParseRetryPolicy(t *testing.T) {
+	policy, err := ParseRetryPolicy(map[string]string{
+		RetryAttemptsAnnotation:          "3",
+		RetryBackoffAnnotation:           "50ms",
+		RetryIdempotencyHeaderAnnotation: "X-Request-Id",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	want := proxy.RetryPolicy{Attempts: 3, Backoff: 50 * time.Millisecond, MaxBackoff: defaultRetryMaxBackoff, IdempotencyHeader: "X-Request-Id"}
+	if policy != want {
+		t.Errorf("want %+v, got %+v", want, policy)
+	}
+
+	for _, annotations := range []map[string]string{
+		{RetryAttemptsAnnotation: "0"},
+		{RetryMaxBackoffAnnotation: "2"},
+	} {
+		if _, err := ParseRetryPolicy(annotations); err == nil {
+			t.Errorf("want an error for %v", annotations)
+		}
+	}
+}
+
+func Test_FunctionLookup_ResolveRetry(t *testing.T) {
+	factory := informers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0)
+	endpoints := factory.Core().V1().Endpoints()
+	deployments := factory.Apps().V1().Deployments()
+
+	endpoints.Informer().GetIndexer().Add(&corev1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{Name: "figlet", Namespace: "openfaas-fn"},
+		Subsets: []corev1.EndpointSubset{
+			{Addresses: []corev1.EndpointAddress{{IP: "10.0.0.1"}, {IP: "10.0.0.2"}}},
+		},
+	})
+	deployments.Informer().GetIndexer().Add(&appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "figlet",
+			Namespace:   "openfaas-fn",
+			Annotations: map[string]string{RetryAttemptsAnnotation: "2"},
+		},
+	})
+
+	lookup := NewFunctionLookup("openfaas-fn", endpoints.Lister(), deployments.Lister())
+
+	if got := lookup.RetryPolicy("figlet.openfaas-fn").Attempts; got != 2 {
+		t.Errorf("want 2 attempts from the annotation, got %d", got)
+	}
+
+	for i := 0; i < 5; i++ {
+		u, done, err := lookup.ResolveRetry("figlet", nil, []string{"10.0.0.1:8080"})
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		done()
+		if u.Host != "10.0.0.2:8080" {
+			t.Errorf("want the endpoint which was not tried, got %s", u.Host)
+		}
+	}
+
+	u, done, err := lookup.ResolveRetry("figlet", nil, []string{"10.0.0.1:8080", "10.0.0.2:8080"})
+	if err != nil {
+		t.Fatalf("want an endpoint when all of them were tried, got %s", err)
+	}
+	done()
+	if len(u.Host) == 0 {
+		t.Errorf("want an endpoint when all of them were tried")
+	}
+}
@@ -0,0 +1,335 @@
+// Copyright 2020 OpenFaaS Author(s)
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package k8s
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	appslister "k8s.io/client-go/listers/apps/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	// ScalingScheduleAnnotation holds the scaling windows of a function as a
+	// JSON list, for example:
+	// [{"name":"business-hours","start":"0 8 * * 1-5","duration":"10h","min":3,"max":10}]
+	ScalingScheduleAnnotation = "com.openfaas.scale.schedule"
+	// ScalingTimezoneAnnotation is the time zone of the start of the windows,
+	// such as Europe/London, it overrides the time zone of the Scheduler
+	ScalingTimezoneAnnotation = "com.openfaas.scale.schedule.timezone"
+	// ActiveScalingWindowAnnotation is set by the Scheduler on the Deployment
+	// of a function to the name of its active window
+	ActiveScalingWindowAnnotation = "com.openfaas.scale.schedule.active"
+
+	// ReasonScalingWindow is the reason of the Events recorded on the
+	// Deployment of a function when a window starts or ends
+	ReasonScalingWindow = "ScalingWindow"
+
+	schedulerFieldManager = "faas-netes-scheduler"
+)
+
+// ScalingWindow keeps the replicas of a function between MinReplicas and
+// MaxReplicas for Duration, from each time that Start fires
+type ScalingWindow struct {
+	Name        string
+	Start       cron.Schedule
+	Duration    time.Duration
+	MinReplicas int32
+	// MaxReplicas is not enforced when zero
+	MaxReplicas int32
+}
+
+type scalingWindowSpec struct {
+	Name     string `json:"name"`
+	Start    string `json:"start"`
+	Duration string `json:"duration"`
+	Min      int32  `json:"min"`
+	Max      int32  `json:"max,omitempty"`
+}
+
+// ScalingSchedule is the list of windows of a function, the first active
+// window applies
+type ScalingSchedule struct {
+	Windows  []ScalingWindow
+	Location *time.Location
+}
+
+// ParseScalingSchedule reads the scaling windows from the annotations of a
+// function, nil is returned when it has none. The start of the windows is a
+// cron expression with five fields, evaluated in the time zone of the
+// annotations or in defaultLocation.
+func ParseScalingSchedule(annotations map[string]string, defaultLocation *time.Location) (*ScalingSchedule, error) {
+	raw, ok := annotations[ScalingScheduleAnnotation]
+	if !ok {
+		return nil, nil
+	}
+
+	schedule := &ScalingSchedule{Location: defaultLocation}
+	if name, ok := annotations[ScalingTimezoneAnnotation]; ok {
+		location, err := time.LoadLocation(name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q: %s", ScalingTimezoneAnnotation, name, err)
+		}
+		schedule.Location = location
+	}
+
+	specs := []scalingWindowSpec{}
+	if err := json.Unmarshal([]byte(raw), &specs); err != nil {
+		return nil, fmt.Errorf("invalid %s: %s", ScalingScheduleAnnotation, err)
+	}
+
+	names := map[string]bool{}
+	for i, spec := range specs {
+		if len(spec.Name) == 0 {
+			return nil, fmt.Errorf("invalid %s: window %d has no name", ScalingScheduleAnnotation, i)
+		}
+		if names[spec.Name] {
+			return nil, fmt.Errorf("invalid %s: window %q is declared twice", ScalingScheduleAnnotation, spec.Name)
+		}
+		names[spec.Name] = true
+
+		start, err := cron.ParseStandard(spec.Start)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: window %q start %q: %s", ScalingScheduleAnnotation, spec.Name, spec.Start, err)
+		}
+		duration, err := time.ParseDuration(spec.Duration)
+		if err != nil || duration <= 0 {
+			return nil, fmt.Errorf("invalid %s: window %q duration %q, must be a duration like 10h", ScalingScheduleAnnotation, spec.Name, spec.Duration)
+		}
+		if spec.Min < 0 || spec.Max < 0 || (spec.Max > 0 && spec.Max < spec.Min) {
+			return nil, fmt.Errorf("invalid %s: window %q replicas %d to %d", ScalingScheduleAnnotation, spec.Name, spec.Min, spec.Max)
+		}
+
+		schedule.Windows = append(schedule.Windows, ScalingWindow{
+			Name:        spec.Name,
+			Start:       start,
+			Duration:    duration,
+			MinReplicas: spec.Min,
+			MaxReplicas: spec.Max,
+		})
+	}
+
+	return schedule, nil
+}
+
+// Active returns the first window which started less than its duration
+// before now, or nil
+func (s *ScalingSchedule) Active(now time.Time) *ScalingWindow {
+	now = now.In(s.Location)
+	for i, window := range s.Windows {
+		// the first start after the beginning of a window which would still
+		// be open now
+		if start := window.Start.Next(now.Add(-window.Duration)); !start.After(now) {
+			return &s.Windows[i]
+		}
+	}
+	return nil
+}
+
+// Window returns the window with the name, or nil
+func (s *ScalingSchedule) Window(name string) *ScalingWindow {
+	for i, window := range s.Windows {
+		if window.Name == name {
+			return &s.Windows[i]
+		}
+	}
+	return nil
+}
+
+// Clamp returns the replicas within the bounds of the window
+func (w *ScalingWindow) Clamp(replicas int32) int32 {
+	if replicas < w.MinReplicas {
+		return w.MinReplicas
+	}
+	if w.MaxReplicas > 0 && replicas > w.MaxReplicas {
+		return w.MaxReplicas
+	}
+	return replicas
+}
+
+// ActiveScalingWindow returns the window that the Scheduler marked as active
+// on the Deployment of a function, or nil. The Autoscaler, the Idler and
+// ScaleFunction use it so that they do not scale the function out of the
+// window.
+func ActiveScalingWindow(deployment *appsv1.Deployment) *ScalingWindow {
+	name, ok := deployment.Annotations[ActiveScalingWindowAnnotation]
+	if !ok {
+		return nil
+	}
+
+	schedule, err := ParseScalingSchedule(deployment.Annotations, time.UTC)
+	if err != nil || schedule == nil {
+		return nil
+	}
+	return schedule.Window(name)
+}
+
+// SchedulerConfig sets how often the windows are checked and their default
+// time zone
+type SchedulerConfig struct {
+	// Interval between two checks of the functions
+	Interval time.Duration
+	// Location is the time zone of the windows without ScalingTimezoneAnnotation
+	Location *time.Location
+}
+
+// Scheduler applies the scaling windows of the functions: while a window is
+// active the replicas are kept within its bounds, and when it ends they go
+// back to the minimum of the function. The replicas are set with
+// ScaleFunction, like a request to the scale-function endpoint. Functions
+// scaled by a HorizontalPodAutoscaler are left to it.
+type Scheduler struct {
+	client      kubernetes.Interface
+	deployments appslister.DeploymentLister
+	recorder    record.EventRecorder
+	config      SchedulerConfig
+	now         func() time.Time
+}
+
+// NewScheduler creates a Scheduler for the functions of the lister
+func NewScheduler(client kubernetes.Interface,
+	deployments appslister.DeploymentLister,
+	recorder record.EventRecorder,
+	config SchedulerConfig) *Scheduler {
+
+	return &Scheduler{
+		client:      client,
+		deployments: deployments,
+		recorder:    recorder,
+		config:      config,
+		now:         time.Now,
+	}
+}
+
+// Run checks the functions every interval until stopCh is closed
+func (s *Scheduler) Run(stopCh <-chan struct{}) {
+	log.Printf("Applying scaling schedules in %s, checking every %s\n", s.config.Location, s.config.Interval)
+	wait.Until(s.applyAll, s.config.Interval, stopCh)
+}
+
+func (s *Scheduler) applyAll() {
+	deployments, err := s.deployments.List(labels.Everything())
+	if err != nil {
+		log.Printf("Unable to list functions to schedule: %s\n", err)
+		return
+	}
+
+	now := s.now()
+	for _, deployment := range deployments {
+		if _, ok := deployment.Labels[FunctionLabel]; !ok {
+			continue
+		}
+
+		if err := s.apply(deployment, now); err != nil {
+			log.Printf("Unable to apply the scaling schedule of %s.%s: %s\n", deployment.Name, deployment.Namespace, err)
+		}
+	}
+}
+
+func (s *Scheduler) apply(deployment *appsv1.Deployment, now time.Time) error {
+	previous, wasActive := deployment.Annotations[ActiveScalingWindowAnnotation]
+
+	schedule, err := ParseScalingSchedule(deployment.Annotations, s.config.Location)
+	if err != nil {
+		return err
+	}
+	if schedule == nil || UsesHorizontalPodAutoscaler(deployment.Spec.Template.Labels) {
+		if wasActive {
+			return s.setActive(deployment, nil)
+		}
+		return nil
+	}
+
+	replicas := int32(0)
+	if deployment.Spec.Replicas != nil {
+		replicas = *deployment.Spec.Replicas
+	}
+
+	window := schedule.Active(now)
+
+	desired := replicas
+	if window != nil {
+		desired = window.Clamp(replicas)
+	} else if wasActive {
+		// the replicas of the window are released down to the minimum of
+		// the function, the Autoscaler takes over from there
+		min := int32(initialReplicasCount)
+		if labelMin := GetMinReplicaCount(deployment.Spec.Template.Labels); labelMin != nil {
+			min = *labelMin
+		}
+		if replicas > min {
+			desired = min
+		}
+	}
+
+	// the active window is recorded first, as ScaleFunction keeps the
+	// replicas within its bounds
+	started := window != nil && (!wasActive || previous != window.Name)
+	ended := window == nil && wasActive
+	if started || ended {
+		if err := s.setActive(deployment, window); err != nil {
+			return err
+		}
+	}
+
+	scaled := replicas
+	if desired != replicas {
+		result, err := ScaleFunction(context.TODO(), s.client, deployment.Name, deployment.Namespace, desired)
+		if err != nil {
+			return err
+		}
+		scaled = result.Replicas
+	}
+
+	var message string
+	switch {
+	case started:
+		message = fmt.Sprintf("Scaling window %s started, replicas %d to %d", window.Name, replicas, scaled)
+	case ended:
+		message = fmt.Sprintf("Scaling window %s ended, replicas %d to %d", previous, replicas, scaled)
+	case scaled != replicas:
+		log.Printf("%s.%s scaled from %d to %d replicas for the scaling window %s\n",
+			deployment.Name, deployment.Namespace, replicas, scaled, window.Name)
+		return nil
+	default:
+		return nil
+	}
+
+	log.Printf("%s.%s %s\n", deployment.Name, deployment.Namespace, message)
+	s.recorder.Event(deployment, corev1.EventTypeNormal, ReasonScalingWindow, message)
+	return nil
+}
+
+// setActive records the active window on the Deployment, nil removes it
+func (s *Scheduler) setActive(deployment *appsv1.Deployment, window *ScalingWindow) error {
+	var value interface{}
+	if window != nil {
+		value = window.Name
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{ActiveScalingWindowAnnotation: value},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = s.client.AppsV1().Deployments(deployment.Namespace).Patch(context.TODO(), deployment.Name,
+		k8stypes.MergePatchType, patch, metav1.PatchOptions{FieldManager: schedulerFieldManager})
+	return err
+}
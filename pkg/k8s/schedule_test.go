@@ -0,0 +1,235 @@
+// Copyright 2020 OpenFaaS Author(s)
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package k8s
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+)
+
+const businessHours = `[{"name":"business-hours","start":"0 8 * * 1-5","duration":"10h","min":3,"max":10}]`
+
+func Test_ParseScalingSchedule(t *testing.T) {
+	cases := []struct {
+		name        string
+		annotations map[string]string
+		wantNil     bool
+		wantErr     string
+	}{
+		{
+			name:    "not set",
+			wantNil: true,
+		},
+		{
+			name:        "valid",
+			annotations: map[string]string{ScalingScheduleAnnotation: businessHours},
+		},
+		{
+			name:        "not a list",
+			annotations: map[string]string{ScalingScheduleAnnotation: "0 8 * * 1-5"},
+			wantErr:     "invalid com.openfaas.scale.schedule",
+		},
+		{
+			name:        "invalid start",
+			annotations: map[string]string{ScalingScheduleAnnotation: `[{"name":"a","start":"8am","duration":"1h"}]`},
+			wantErr:     `window "a" start "8am"`,
+		},
+		{
+			name:        "missing duration",
+			annotations: map[string]string{ScalingScheduleAnnotation: `[{"name":"a","start":"0 8 * * *"}]`},
+			wantErr:     `window "a" duration ""`,
+		},
+		{
+			name:        "max lower than min",
+			annotations: map[string]string{ScalingScheduleAnnotation: `[{"name":"a","start":"0 8 * * *","duration":"1h","min":3,"max":2}]`},
+			wantErr:     `window "a" replicas 3 to 2`,
+		},
+		{
+			name:        "duplicate name",
+			annotations: map[string]string{ScalingScheduleAnnotation: `[{"name":"a","start":"0 8 * * *","duration":"1h"},{"name":"a","start":"0 9 * * *","duration":"1h"}]`},
+			wantErr:     `window "a" is declared twice`,
+		},
+		{
+			name:        "unknown time zone",
+			annotations: map[string]string{ScalingScheduleAnnotation: businessHours, ScalingTimezoneAnnotation: "Mars/Olympus"},
+			wantErr:     "invalid com.openfaas.scale.schedule.timezone",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			schedule, err := ParseScalingSchedule(tc.annotations, time.UTC)
+			if len(tc.wantErr) > 0 {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("want an error containing %q, got %v", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if tc.wantNil != (schedule == nil) {
+				t.Errorf("want nil %v, got %+v", tc.wantNil, schedule)
+			}
+		})
+	}
+}
+
+func Test_ScalingSchedule_Active(t *testing.T) {
+	schedule, err := ParseScalingSchedule(map[string]string{
+		ScalingScheduleAnnotation: businessHours,
+		ScalingTimezoneAnnotation: "America/New_York",
+	}, time.UTC)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	newYork, _ := time.LoadLocation("America/New_York")
+	cases := []struct {
+		at   time.Time
+		want bool
+	}{
+		// Monday 7 June 2021
+		{at: time.Date(2021, 6, 7, 7, 59, 0, 0, newYork), want: false},
+		{at: time.Date(2021, 6, 7, 8, 0, 0, 0, newYork), want: true},
+		{at: time.Date(2021, 6, 7, 17, 59, 0, 0, newYork), want: true},
+		{at: time.Date(2021, 6, 7, 18, 0, 0, 0, newYork), want: false},
+		// the time zone of the schedule applies to a time in UTC
+		{at: time.Date(2021, 6, 7, 12, 30, 0, 0, time.UTC), want: true},
+		{at: time.Date(2021, 6, 7, 11, 30, 0, 0, time.UTC), want: false},
+		// Saturday
+		{at: time.Date(2021, 6, 12, 10, 0, 0, 0, newYork), want: false},
+	}
+
+	for _, tc := range cases {
+		if got := schedule.Active(tc.at) != nil; got != tc.want {
+			t.Errorf("at %s want active %v, got %v", tc.at, tc.want, got)
+		}
+	}
+}
+
+func Test_ScalingWindow_Clamp(t *testing.T) {
+	window := ScalingWindow{MinReplicas: 3, MaxReplicas: 10}
+	for replicas, want := range map[int32]int32{0: 3, 5: 5, 12: 10} {
+		if got := window.Clamp(replicas); got != want {
+			t.Errorf("want %d replicas for %d, got %d", want, replicas, got)
+		}
+	}
+
+	unbounded := ScalingWindow{MinReplicas: 2}
+	if got := unbounded.Clamp(40); got != 40 {
+		t.Errorf("want 40 replicas without a maximum, got %d", got)
+	}
+}
+
+type schedulerTest struct {
+	scheduler *Scheduler
+	client    *fake.Clientset
+	indexer   cache.Indexer
+	recorder  *record.FakeRecorder
+}
+
+func newSchedulerTest(t *testing.T, replicas int32, annotations map[string]string) *schedulerTest {
+	t.Helper()
+
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "nodeinfo",
+			Namespace:   "openfaas-fn",
+			Labels:      map[string]string{FunctionLabel: "nodeinfo"},
+			Annotations: annotations,
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: int32p(replicas),
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{MinReplicasLabel: "2"}},
+			},
+		},
+	}
+
+	client := fake.NewSimpleClientset(deployment)
+	deployments := informers.NewSharedInformerFactory(client, 0).Apps().V1().Deployments()
+	deployments.Informer().GetIndexer().Add(deployment)
+
+	test := &schedulerTest{
+		client:   client,
+		indexer:  deployments.Informer().GetIndexer(),
+		recorder: record.NewFakeRecorder(10),
+	}
+	test.scheduler = NewScheduler(client, deployments.Lister(), test.recorder, SchedulerConfig{
+		Interval: time.Minute,
+		Location: time.UTC,
+	})
+	return test
+}
+
+// tick applies the schedule at the time, the Deployment of the lister is
+// then updated from the client
+func (s *schedulerTest) tick(t *testing.T, at time.Time) *appsv1.Deployment {
+	t.Helper()
+
+	s.scheduler.now = func() time.Time { return at }
+	s.scheduler.applyAll()
+
+	deployment, err := s.client.AppsV1().Deployments("openfaas-fn").Get(context.TODO(), "nodeinfo", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	s.indexer.Update(deployment)
+	return deployment
+}
+
+func Test_Scheduler_AppliesWindow(t *testing.T) {
+	test := newSchedulerTest(t, 0, map[string]string{ScalingScheduleAnnotation: businessHours})
+
+	// Monday 7 June 2021 in UTC
+	before := test.tick(t, time.Date(2021, 6, 7, 7, 0, 0, 0, time.UTC))
+	if *before.Spec.Replicas != 0 {
+		t.Fatalf("want the replicas to be kept before the window, got %d", *before.Spec.Replicas)
+	}
+
+	during := test.tick(t, time.Date(2021, 6, 7, 8, 0, 30, 0, time.UTC))
+	if *during.Spec.Replicas != 3 {
+		t.Errorf("want the minimum of the window, got %d replicas", *during.Spec.Replicas)
+	}
+	if got := during.Annotations[ActiveScalingWindowAnnotation]; got != "business-hours" {
+		t.Errorf("want the active window to be recorded, got %q", got)
+	}
+	if window := ActiveScalingWindow(during); window == nil || window.MinReplicas != 3 {
+		t.Errorf("want the active window to be found, got %+v", window)
+	}
+	if event := <-test.recorder.Events; !strings.Contains(event, "Scaling window business-hours started, replicas 0 to 3") {
+		t.Errorf("want a %s event, got %q", ReasonScalingWindow, event)
+	}
+
+	after := test.tick(t, time.Date(2021, 6, 7, 18, 0, 30, 0, time.UTC))
+	if *after.Spec.Replicas != 2 {
+		t.Errorf("want the minimum of the function after the window, got %d replicas", *after.Spec.Replicas)
+	}
+	if _, ok := after.Annotations[ActiveScalingWindowAnnotation]; ok {
+		t.Errorf("want the active window to be removed")
+	}
+	if event := <-test.recorder.Events; !strings.Contains(event, "Scaling window business-hours ended, replicas 3 to 2") {
+		t.Errorf("want a %s event, got %q", ReasonScalingWindow, event)
+	}
+}
+
+func Test_Scheduler_LimitsReplicasToWindowMaximum(t *testing.T) {
+	test := newSchedulerTest(t, 15, map[string]string{ScalingScheduleAnnotation: businessHours})
+
+	during := test.tick(t, time.Date(2021, 6, 7, 9, 0, 0, 0, time.UTC))
+	if *during.Spec.Replicas != 10 {
+		t.Errorf("want the maximum of the window, got %d replicas", *during.Spec.Replicas)
+	}
+}
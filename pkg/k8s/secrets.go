@@ -223,6 +223,11 @@ func (f *FunctionFactory) ConfigureSecrets(request types.FunctionDeployment, dep
 			for secretKey := range deployedSecret.Data {
 				projectedPaths = append(projectedPaths, apiv1.KeyToPath{Key: secretKey, Path: secretKey})
 			}
+			// keep the items in a stable order, so that the Deployment does
+			// not change each time it is built
+			sort.Slice(projectedPaths, func(i, j int) bool {
+				return projectedPaths[i].Key < projectedPaths[j].Key
+			})
 
 			projection := &apiv1.SecretProjection{Items: projectedPaths}
 			projection.Name = secretName
@@ -0,0 +1,130 @@
+// Copyright 2020 OpenFaaS Author(s)
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package k8s
+
+import (
+	"sync"
+	"time"
+)
+
+// InvocationStats counts the requests proxied to each function, with their
+// latency and the time that they were in flight, for the Autoscaler. The
+// counters only grow, the Autoscaler compares two snapshots to get the rate.
+// The Idler reads the time of the last invocation.
+type InvocationStats struct {
+	lock      sync.Mutex
+	functions map[string]*functionStats
+	now       func() time.Time
+}
+
+type functionStats struct {
+	inFlight int64
+	requests uint64
+	latency  time.Duration
+	busy     time.Duration
+	changed  time.Time
+	last     time.Time
+}
+
+// InvocationSnapshot is the state of the counters of a function at a point
+// in time
+type InvocationSnapshot struct {
+	At time.Time
+	// InFlight is the number of requests in flight
+	InFlight int64
+	// Requests is the number of requests completed
+	Requests uint64
+	// Latency is the sum of the latency of the completed requests
+	Latency time.Duration
+	// Busy is the sum of the time that each request was in flight, so that
+	// the change over an interval divided by the interval is the average
+	// number of requests in flight
+	Busy time.Duration
+	// LastInvocation is when the last request started or completed, it is
+	// zero for a function which was never invoked
+	LastInvocation time.Time
+}
+
+// NewInvocationStats creates an empty InvocationStats
+func NewInvocationStats() *InvocationStats {
+	return &InvocationStats{
+		functions: map[string]*functionStats{},
+		now:       time.Now,
+	}
+}
+
+// Start records a request to the function, the returned func ends it
+func (s *InvocationStats) Start(functionName, namespace string) func() {
+	key := functionName + "." + namespace
+
+	s.lock.Lock()
+	stats, ok := s.functions[key]
+	if !ok {
+		stats = &functionStats{}
+		s.functions[key] = stats
+	}
+	start := s.now()
+	stats.advance(start)
+	stats.inFlight++
+	stats.last = start
+	s.lock.Unlock()
+
+	once := sync.Once{}
+	return func() {
+		once.Do(func() {
+			s.lock.Lock()
+			defer s.lock.Unlock()
+
+			now := s.now()
+			stats.advance(now)
+			stats.inFlight--
+			stats.requests++
+			stats.latency += now.Sub(start)
+			stats.last = now
+		})
+	}
+}
+
+// Snapshot returns the counters of the function, they are all zero for a
+// function which was never invoked
+func (s *InvocationStats) Snapshot(functionName, namespace string) InvocationSnapshot {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	now := s.now()
+	stats, ok := s.functions[functionName+"."+namespace]
+	if !ok {
+		return InvocationSnapshot{At: now}
+	}
+	stats.advance(now)
+
+	return InvocationSnapshot{
+		At:             now,
+		InFlight:       stats.inFlight,
+		Requests:       stats.requests,
+		Latency:        stats.latency,
+		Busy:           stats.busy,
+		LastInvocation: stats.last,
+	}
+}
+
+// Forget removes the counters of a function which was deleted, they are kept
+// while it has requests in flight
+func (s *InvocationStats) Forget(functionName, namespace string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	key := functionName + "." + namespace
+	if stats, ok := s.functions[key]; ok && stats.inFlight == 0 {
+		delete(s.functions, key)
+	}
+}
+
+// advance adds the time since the last change for each request in flight
+func (f *functionStats) advance(now time.Time) {
+	if !f.changed.IsZero() {
+		f.busy += time.Duration(f.inFlight) * now.Sub(f.changed)
+	}
+	f.changed = now
+}
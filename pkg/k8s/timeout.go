@@ -0,0 +1,79 @@
+// Copyright 2020 OpenFaaS Author(s)
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package k8s
+
+import (
+	"fmt"
+	"time"
+
+	ftypes "github.com/openfaas/faas-provider/types"
+	appsv1 "k8s.io/api/apps/v1"
+)
+
+// TimeoutAnnotation sets how long the proxy waits for a function to respond,
+// it replaces the read timeout of the provider
+const TimeoutAnnotation = "com.openfaas.timeout"
+
+// timeoutResponseMargin is kept from the write timeout of the server, so that
+// the proxy still has time to write the 504 of a function which timed out
+const timeoutResponseMargin = 500 * time.Millisecond
+
+// timeoutEnvVars are the timeouts of the watchdog, the function is given the
+// longest of them
+var timeoutEnvVars = []string{"exec_timeout", "read_timeout", "write_timeout"}
+
+// FunctionTimeout returns how long the proxy waits for the function of the
+// Deployment. The TimeoutAnnotation is used when it is set, otherwise the
+// longest of the watchdog timeouts set in the environment of the function.
+// Zero is returned when none of them is set.
+func FunctionTimeout(deployment *appsv1.Deployment) (time.Duration, error) {
+	timeout, err := ParseTimeout(deployment.Annotations)
+	if err != nil || timeout > 0 {
+		return timeout, err
+	}
+
+	containers := deployment.Spec.Template.Spec.Containers
+	if len(containers) == 0 {
+		return 0, nil
+	}
+
+	for _, env := range containers[0].Env {
+		for _, name := range timeoutEnvVars {
+			if env.Name != name {
+				continue
+			}
+			// the watchdog reads a number as seconds
+			if value := ftypes.ParseIntOrDurationValue(env.Value, 0); value > timeout {
+				timeout = value
+			}
+		}
+	}
+	return timeout, nil
+}
+
+// MaxFunctionTimeout returns the longest timeout a function can be given when
+// the server of the proxy has the writeTimeout. The server closes the
+// connection once its write timeout is over, so a longer function timeout
+// would never produce a 504. Zero is returned when there is no write timeout.
+func MaxFunctionTimeout(writeTimeout time.Duration) time.Duration {
+	if writeTimeout <= 2*timeoutResponseMargin {
+		return writeTimeout / 2
+	}
+	return writeTimeout - timeoutResponseMargin
+}
+
+// ParseTimeout reads the timeout of a function from its annotations, zero is
+// returned when it is not set
+func ParseTimeout(annotations map[string]string) (time.Duration, error) {
+	raw, ok := annotations[TimeoutAnnotation]
+	if !ok {
+		return 0, nil
+	}
+
+	timeout, err := time.ParseDuration(raw)
+	if err != nil || timeout <= 0 {
+		return 0, fmt.Errorf("invalid %s %q, must be a duration like 30s", TimeoutAnnotation, raw)
+	}
+	return timeout, nil
+}
@@ -0,0 +1,137 @@
+// Copyright 2020 OpenFaaS Author(s)
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package k8s
+
+import (
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+func Test_ParseTimeout(t *testing.T) {
+	cases := []struct {
+		name        string
+		annotations map[string]string
+		want        time.Duration
+		wantErr     bool
+	}{
+		{"not set", map[string]string{}, 0, false},
+		{"duration", map[string]string{TimeoutAnnotation: "2m"}, 2 * time.Minute, false},
+		{"without a unit", map[string]string{TimeoutAnnotation: "120"}, 0, true},
+		{"zero", map[string]string{TimeoutAnnotation: "0s"}, 0, true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ParseTimeout(tc.annotations)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("want error %v, got %v", tc.wantErr, err)
+			}
+			if got != tc.want {
+				t.Errorf("want %s, got %s", tc.want, got)
+			}
+		})
+	}
+}
+
+func Test_FunctionLookup_TracksTimeout(t *testing.T) {
+	factory := informers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0)
+	endpoints := factory.Core().V1().Endpoints()
+	deployments := factory.Apps().V1().Deployments()
+
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "figlet", Namespace: "openfaas-fn", ResourceVersion: "1"},
+		Spec: appsv1.DeploymentSpec{
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{
+						Name: "figlet",
+						Env: []corev1.EnvVar{
+							{Name: "read_timeout", Value: "5s"},
+							{Name: "exec_timeout", Value: "90"},
+							{Name: "write_timeout", Value: "1m"},
+						},
+					}},
+				},
+			},
+		},
+	}
+	deployments.Informer().GetIndexer().Add(deployment)
+
+	lookup := NewFunctionLookup("openfaas-fn", endpoints.Lister(), deployments.Lister())
+
+	if got := lookup.Timeout("figlet"); got != 90*time.Second {
+		t.Errorf("want the longest watchdog timeout of 90s, got %s", got)
+	}
+
+	updated := deployment.DeepCopy()
+	updated.ResourceVersion = "2"
+	updated.Annotations = map[string]string{TimeoutAnnotation: "3m"}
+	deployments.Informer().GetIndexer().Update(updated)
+
+	if got := lookup.Timeout("figlet.openfaas-fn"); got != 3*time.Minute {
+		t.Errorf("want the annotation of the updated Deployment, got %s", got)
+	}
+
+	if got := lookup.Timeout("nodeinfo"); got != 0 {
+		t.Errorf("want no timeout for an unknown function, got %s", got)
+	}
+
+	capped := NewFunctionLookup("openfaas-fn", endpoints.Lister(), deployments.Lister())
+	capped.WriteTimeout = time.Minute
+
+	if got := capped.Timeout("figlet"); got != time.Minute-timeoutResponseMargin {
+		t.Errorf("want the timeout capped below the write timeout, got %s", got)
+	}
+}
+
+func Test_FunctionLookup_DeploymentDeleted(t *testing.T) {
+	factory := informers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0)
+	endpoints := factory.Core().V1().Endpoints()
+	deployments := factory.Apps().V1().Deployments()
+
+	canary := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "figlet-v2",
+			Namespace:   "openfaas-fn",
+			Annotations: map[string]string{TimeoutAnnotation: "2m"},
+		},
+	}
+	deployments.Informer().GetIndexer().Add(canary)
+
+	lookup := NewFunctionLookup("openfaas-fn", endpoints.Lister(), deployments.Lister())
+	if got := lookup.Timeout("figlet-v2"); got != 2*time.Minute {
+		t.Fatalf("want the timeout of the annotation, got %s", got)
+	}
+
+	deployments.Informer().GetIndexer().Delete(canary)
+	lookup.DeploymentDeleted(cache.DeletedFinalStateUnknown{Key: "openfaas-fn/figlet-v2", Obj: canary})
+
+	if len(lookup.settings) != 0 {
+		t.Errorf("want the settings of the deleted function to be dropped, got %v", lookup.settings)
+	}
+}
+
+func Test_MaxFunctionTimeout(t *testing.T) {
+	cases := []struct {
+		writeTimeout time.Duration
+		want         time.Duration
+	}{
+		{0, 0},
+		{time.Minute, time.Minute - timeoutResponseMargin},
+		{time.Second, 500 * time.Millisecond},
+	}
+
+	for _, tc := range cases {
+		if got := MaxFunctionTimeout(tc.writeTimeout); got != tc.want {
+			t.Errorf("write timeout %s: want %s, got %s", tc.writeTimeout, tc.want, got)
+		}
+	}
+}
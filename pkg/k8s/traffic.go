@@ -0,0 +1,269 @@
+// Copyright 2020 OpenFaaS Author(s)
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package k8s
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/openfaas/faas-provider/types"
+	appsv1 "k8s.io/api/apps/v1"
+)
+
+const (
+	// RevisionOfLabel is set on the revisions of a function to the name of
+	// the function, a revision is deployed as its own Deployment and Service
+	RevisionOfLabel = "com.openfaas.revision.of"
+	// RevisionLabel is the name of the revision, such as v2
+	RevisionLabel = "com.openfaas.revision"
+	// TrafficAnnotation is set on the Deployment of a function to the share
+	// of its requests, in percent, which is sent to each of its revisions,
+	// such as "v2=10,v3=5". The remainder is served by the function.
+	TrafficAnnotation = "com.openfaas.traffic"
+	// RevisionHeader sends a request to the named revision of the function
+	// whatever the weights, so that a revision can be tested before it is
+	// given any traffic
+	RevisionHeader = "X-Openfaas-Revision"
+
+	// revisionSeparator separates the function from the revision in the
+	// name of a deployment request, such as payments@v2
+	revisionSeparator = "@"
+)
+
+var validRevision = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+
+// RevisionWeight is the share of the requests of a function, in percent,
+// which is sent to one of its revisions
+type RevisionWeight struct {
+	Revision string `json:"revision"`
+	Weight   int    `json:"weight"`
+}
+
+// ParseRevision splits the name of a deployment request such as payments@v2
+// into the function and the revision, the revision is empty when the name
+// has none
+func ParseRevision(service string) (string, string, error) {
+	index := strings.Index(service, revisionSeparator)
+	if index < 0 {
+		return service, "", nil
+	}
+
+	function, revision := service[:index], service[index+1:]
+	if len(function) == 0 || !validRevision.MatchString(revision) {
+		return "", "", fmt.Errorf("invalid revision %q, must be function@revision where the revision is a DNS label", service)
+	}
+	return function, revision, nil
+}
+
+// RevisionName is the name of the Deployment and Service of a revision
+func RevisionName(function, revision string) string {
+	return function + "-" + revision
+}
+
+// SetRevision turns a deployment request for a revision, such as
+// payments@v2, into the request for the function payments-v2 labelled as the
+// revision v2 of payments. Other requests are left as they are.
+func SetRevision(request *types.FunctionDeployment) error {
+	function, revision, err := ParseRevision(request.Service)
+	if err != nil || len(revision) == 0 {
+		return err
+	}
+
+	labels := map[string]string{}
+	if request.Labels != nil {
+		for k, v := range *request.Labels {
+			labels[k] = v
+		}
+	}
+	for k, v := range RevisionLabels(function, revision) {
+		labels[k] = v
+	}
+
+	request.Service = RevisionName(function, revision)
+	request.Labels = &labels
+	return nil
+}
+
+// RevisionLabels returns the labels which mark a Deployment as a revision of
+// the function
+func RevisionLabels(function, revision string) map[string]string {
+	return map[string]string{
+		RevisionOfLabel: function,
+		RevisionLabel:   revision,
+	}
+}
+
+// IsRevisionOf reports whether the Deployment is the revision of the
+// function, the labels are read from the template as they are set from the
+// labels of the deployment request
+func IsRevisionOf(deployment *appsv1.Deployment, function, revision string) bool {
+	labels := deployment.Spec.Template.Labels
+	return labels[RevisionOfLabel] == function && labels[RevisionLabel] == revision
+}
+
+// MakePromotedDeployment returns the Deployment of the function to apply so
+// that it runs the pod template of its revision. The template is renamed
+// from the revision to the function, so that it still matches the selector
+// and the secrets of the function. The replicas are set as for any other
+// apply, see ApplyReplicas.
+func MakePromotedDeployment(function, revision *appsv1.Deployment) *appsv1.Deployment {
+	desired := function.DeepCopy()
+	template := revision.Spec.Template.DeepCopy()
+
+	labels := map[string]string{}
+	for k, v := range template.Labels {
+		if k != RevisionOfLabel && k != RevisionLabel {
+			labels[k] = v
+		}
+	}
+	labels[FunctionLabel] = function.Name
+	template.Labels = labels
+	template.Name = function.Name
+
+	revisionVolume := fmt.Sprintf(secretsProjectVolumeNameTmpl, revision.Name)
+	functionVolume := fmt.Sprintf(secretsProjectVolumeNameTmpl, function.Name)
+	for i := range template.Spec.Volumes {
+		if template.Spec.Volumes[i].Name == revisionVolume {
+			template.Spec.Volumes[i].Name = functionVolume
+		}
+	}
+	for i := range template.Spec.Containers {
+		container := &template.Spec.Containers[i]
+		if container.Name == revision.Name {
+			container.Name = function.Name
+		}
+		for j := range container.VolumeMounts {
+			if container.VolumeMounts[j].Name == revisionVolume {
+				container.VolumeMounts[j].Name = functionVolume
+			}
+		}
+	}
+
+	// the weights stay with the traffic field manager until they are cleared
+	annotations := map[string]string{}
+	for k, v := range revision.Annotations {
+		if k != TrafficAnnotation {
+			annotations[k] = v
+		}
+	}
+
+	desired.Annotations = annotations
+	desired.Spec.Template = *template
+	desired.Spec.Replicas = ApplyReplicas(function, labels)
+	return desired
+}
+
+// ParseTrafficWeights reads the weights of the revisions of a function from
+// its annotations, sorted by revision. The weights must add up to at most
+// 100, revisions with no weight are left out.
+func ParseTrafficWeights(annotations map[string]string) ([]RevisionWeight, error) {
+	raw, ok := annotations[TrafficAnnotation]
+	if !ok || len(strings.TrimSpace(raw)) == 0 {
+		return nil, nil
+	}
+
+	weights := map[string]int{}
+	for _, entry := range strings.Split(raw, ",") {
+		parts := strings.SplitN(strings.TrimSpace(entry), "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid %s %q, must be a list of revision=weight", TrafficAnnotation, raw)
+		}
+
+		weight, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q, the weight of %s must be a number", TrafficAnnotation, raw, parts[0])
+		}
+		weights[parts[0]] = weight
+	}
+
+	return NormalizeTrafficWeights(weights)
+}
+
+// NormalizeTrafficWeights validates the weights of the revisions of a
+// function and returns them sorted by revision, without those of zero
+func NormalizeTrafficWeights(weights map[string]int) ([]RevisionWeight, error) {
+	total := 0
+	normalized := []RevisionWeight{}
+	for revision, weight := range weights {
+		if !validRevision.MatchString(revision) {
+			return nil, fmt.Errorf("invalid revision %q, must be a DNS label", revision)
+		}
+		if weight < 0 || weight > 100 {
+			return nil, fmt.Errorf("invalid weight %d for %s, must be between 0 and 100", weight, revision)
+		}
+		total += weight
+		if weight > 0 {
+			normalized = append(normalized, RevisionWeight{Revision: revision, Weight: weight})
+		}
+	}
+
+	if total > 100 {
+		return nil, fmt.Errorf("the weights add up to %d, must be at most 100", total)
+	}
+
+	sort.Slice(normalized, func(i, j int) bool {
+		return normalized[i].Revision < normalized[j].Revision
+	})
+	return normalized, nil
+}
+
+// FormatTrafficWeights returns the value of the TrafficAnnotation for the
+// weights
+func FormatTrafficWeights(weights []RevisionWeight) string {
+	entries := make([]string, 0, len(weights))
+	for _, w := range weights {
+		entries = append(entries, fmt.Sprintf("%s=%d", w.Revision, w.Weight))
+	}
+	return strings.Join(entries, ",")
+}
+
+// pickRevision returns the name of the Deployment which serves the request
+// to the function, which is either the function itself or one of its
+// revisions. The RevisionHeader is followed when the revision exists,
+// otherwise a revision is picked at random by weight.
+func (l *FunctionLookup) pickRevision(functionName, namespace string, r *http.Request) string {
+	if l.DeploymentLister == nil {
+		return functionName
+	}
+
+	if r != nil {
+		if revision := r.Header.Get(RevisionHeader); len(revision) > 0 {
+			if l.revisionExists(functionName, namespace, revision) {
+				return RevisionName(functionName, revision)
+			}
+			// unknown revisions are served by the function
+			return functionName
+		}
+	}
+
+	weights := l.functionSettings(functionName, namespace).traffic
+	if len(weights) == 0 {
+		return functionName
+	}
+
+	n := rand.Intn(100)
+	for _, w := range weights {
+		if n < w.Weight {
+			if l.revisionExists(functionName, namespace, w.Revision) {
+				return RevisionName(functionName, w.Revision)
+			}
+			break
+		}
+		n -= w.Weight
+	}
+	return functionName
+}
+
+func (l *FunctionLookup) revisionExists(functionName, namespace, revision string) bool {
+	deployment, err := l.DeploymentLister.Deployments(namespace).Get(RevisionName(functionName, revision))
+	if err != nil {
+		return false
+	}
+	return IsRevisionOf(deployment, functionName, revision)
+}
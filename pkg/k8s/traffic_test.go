@@ -0,0 +1,172 @@
+// Copyright 2020 OpenFaaS Author(s)
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package k8s
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/openfaas/faas-provider/types"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func Test_ParseRevision(t *testing.T) {
+	cases := []struct {
+		service      string
+		wantFunction string
+		wantRevision string
+		wantErr      bool
+	}{
+		{"payments", "payments", "", false},
+		{"payments@v2", "payments", "v2", false},
+		{"payments@", "", "", true},
+		{"@v2", "", "", true},
+		{"payments@V2", "", "", true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.service, func(t *testing.T) {
+			function, revision, err := ParseRevision(tc.service)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("want error %v, got %v", tc.wantErr, err)
+			}
+			if function != tc.wantFunction || revision != tc.wantRevision {
+				t.Errorf("want %q and %q, got %q and %q", tc.wantFunction, tc.wantRevision, function, revision)
+			}
+		})
+	}
+}
+
+func Test_SetRevision(t *testing.T) {
+	request := types.FunctionDeployment{
+		Service: "payments@v2",
+		Labels:  &map[string]string{"team": "billing"},
+	}
+
+	if err := SetRevision(&request); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if request.Service != "payments-v2" {
+		t.Errorf("want the service payments-v2, got %s", request.Service)
+	}
+	want := map[string]string{"team": "billing", RevisionOfLabel: "payments", RevisionLabel: "v2"}
+	if !reflect.DeepEqual(*request.Labels, want) {
+		t.Errorf("want labels %v, got %v", want, *request.Labels)
+	}
+}
+
+func Test_ParseTrafficWeights(t *testing.T) {
+	cases := []struct {
+		name    string
+		value   string
+		want    []RevisionWeight
+		wantErr bool
+	}{
+		{"not set", "", nil, false},
+		{"sorted by revision", "v3=5, v2=10", []RevisionWeight{{"v2", 10}, {"v3", 5}}, false},
+		{"zero is left out", "v2=0,v3=100", []RevisionWeight{{"v3", 100}}, false},
+		{"over 100", "v2=60,v3=50", nil, true},
+		{"negative", "v2=-1", nil, true},
+		{"not a number", "v2=ten", nil, true},
+		{"no weight", "v2", nil, true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ParseTrafficWeights(map[string]string{TrafficAnnotation: tc.value})
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("want error %v, got %v", tc.wantErr, err)
+			}
+			if len(got) != len(tc.want) || (len(got) > 0 && !reflect.DeepEqual(got, tc.want)) {
+				t.Errorf("want %v, got %v", tc.want, got)
+			}
+			if err == nil && FormatTrafficWeights(got) != FormatTrafficWeights(tc.want) {
+				t.Errorf("want %q, got %q", FormatTrafficWeights(tc.want), FormatTrafficWeights(got))
+			}
+		})
+	}
+}
+
+func Test_FunctionLookup_SplitsTraffic(t *testing.T) {
+	factory := informers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0)
+	endpoints := factory.Core().V1().Endpoints()
+	deployments := factory.Apps().V1().Deployments()
+
+	for name, ip := range map[string]string{"payments": "10.0.0.1", "payments-v2": "10.0.0.2", "payments-v3": "10.0.0.3"} {
+		endpoints.Informer().GetIndexer().Add(&corev1.Endpoints{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "openfaas-fn"},
+			Subsets: []corev1.EndpointSubset{
+				{Addresses: []corev1.EndpointAddress{{IP: ip}}},
+			},
+		})
+	}
+
+	primary := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "payments", Namespace: "openfaas-fn", ResourceVersion: "1"},
+	}
+	deployments.Informer().GetIndexer().Add(primary)
+	deployments.Informer().GetIndexer().Add(&appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "payments-v2", Namespace: "openfaas-fn"},
+		Spec: appsv1.DeploymentSpec{
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: RevisionLabels("payments", "v2")},
+			},
+		},
+	})
+	// a function which happens to be named like a revision
+	deployments.Informer().GetIndexer().Add(&appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "payments-v3", Namespace: "openfaas-fn"},
+	})
+
+	lookup := NewFunctionLookup("openfaas-fn", endpoints.Lister(), deployments.Lister())
+
+	resolve := func(header string) string {
+		r, _ := http.NewRequest(http.MethodGet, "/", nil)
+		if len(header) > 0 {
+			r.Header.Set(RevisionHeader, header)
+		}
+		u, done, err := lookup.ResolveRequest("payments", r)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		done()
+		return u.Host
+	}
+
+	if got := resolve(""); got != "10.0.0.1:8080" {
+		t.Errorf("want the function with no traffic split, got %s", got)
+	}
+	if got := resolve("v2"); got != "10.0.0.2:8080" {
+		t.Errorf("want the revision of the header, got %s", got)
+	}
+	if got := resolve("v3"); got != "10.0.0.1:8080" {
+		t.Errorf("want the function for a header which is not one of its revisions, got %s", got)
+	}
+
+	updated := primary.DeepCopy()
+	updated.ResourceVersion = "2"
+	updated.Annotations = map[string]string{TrafficAnnotation: "v2=100"}
+	deployments.Informer().GetIndexer().Update(updated)
+
+	for i := 0; i < 5; i++ {
+		if got := resolve(""); got != "10.0.0.2:8080" {
+			t.Errorf("want the revision with all of the traffic, got %s", got)
+		}
+	}
+
+	updated = updated.DeepCopy()
+	updated.ResourceVersion = "3"
+	updated.Annotations = map[string]string{TrafficAnnotation: "v3=100"}
+	deployments.Informer().GetIndexer().Update(updated)
+
+	if got := resolve(""); got != "10.0.0.1:8080" {
+		t.Errorf("want the function when the revision is not deployed, got %s", got)
+	}
+}
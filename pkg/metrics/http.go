@@ -0,0 +1,87 @@
+// Copyright 2020 OpenFaaS Author(s)
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/openfaas/faas-netes/pkg/statuswriter"
+	"github.com/openfaas/faas-provider/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// InstrumentHandlers wraps the provider API handlers so that their latency and
+// status codes are recorded per route, and wraps the FunctionProxy with
+// InstrumentProxy. Handlers which are not set are left as nil.
+func InstrumentHandlers(handlers *types.FaaSHandlers) {
+	handlers.FunctionReader = instrumentRoute("list", handlers.FunctionReader)
+	handlers.DeployHandler = instrumentRoute("deploy", handlers.DeployHandler)
+	handlers.UpdateHandler = instrumentRoute("update", handlers.UpdateHandler)
+	handlers.DeleteHandler = instrumentRoute("delete", handlers.DeleteHandler)
+	handlers.ReplicaReader = instrumentRoute("replicas", handlers.ReplicaReader)
+	handlers.ReplicaUpdater = instrumentRoute("scale", handlers.ReplicaUpdater)
+	handlers.SecretHandler = instrumentRoute("secrets", handlers.SecretHandler)
+	handlers.LogHandler = instrumentRoute("logs", handlers.LogHandler)
+	handlers.ListNamespaceHandler = instrumentRoute("namespaces", handlers.ListNamespaceHandler)
+
+	if handlers.FunctionProxy != nil {
+		handlers.FunctionProxy = InstrumentProxy(handlers.FunctionProxy)
+	}
+}
+
+func instrumentRoute(route string, next http.HandlerFunc) http.HandlerFunc {
+	if next == nil {
+		return nil
+	}
+
+	labels := prometheus.Labels{"route": route}
+	handler := promhttp.InstrumentHandlerDuration(RequestDuration.MustCurryWith(labels),
+		promhttp.InstrumentHandlerCounter(RequestsTotal.MustCurryWith(labels), next))
+
+	return handler.ServeHTTP
+}
+
+// UnresolvedFunction is the function_name of the invocations of names which
+// were not resolved to a function. It is not a valid function name, and a
+// single series keeps requests for unknown names from adding new ones.
+const UnresolvedFunction = "_unresolved"
+
+type invocationKey struct{}
+
+// invocation is the function of a proxied request, as set by the resolver
+type invocation struct {
+	name      string
+	namespace string
+}
+
+// SetFunction labels the invocation of the request context with the function
+// and namespace that it was resolved to. It does nothing when the request is
+// not instrumented.
+func SetFunction(ctx context.Context, name, namespace string) {
+	if inv, ok := ctx.Value(invocationKey{}).(*invocation); ok {
+		inv.name = name
+		inv.namespace = namespace
+	}
+}
+
+// InstrumentProxy records the latency and status code of each function
+// invocation, labelled with the function and namespace that the resolver
+// set with SetFunction
+func InstrumentProxy(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		inv := &invocation{name: UnresolvedFunction}
+		r = r.WithContext(context.WithValue(r.Context(), invocationKey{}, inv))
+		start := time.Now()
+
+		sw := statuswriter.New(w)
+		next(sw, r)
+
+		FunctionDuration.WithLabelValues(inv.name, inv.namespace).Observe(time.Since(start).Seconds())
+		FunctionInvocations.WithLabelValues(inv.name, inv.namespace, strconv.Itoa(sw.Status())).Inc()
+	}
+}
@@ -0,0 +1,85 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/openfaas/faas-provider/types"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"k8s.io/client-go/util/workqueue"
+)
+
+func Test_InstrumentProxy_RecordsStatusPerFunction(t *testing.T) {
+	proxy := InstrumentProxy(func(w http.ResponseWriter, r *http.Request) {
+		name := mux.Vars(r)["name"]
+		if name != "nodeinfo.openfaas-fn" {
+			http.Error(w, "not found", http.StatusServiceUnavailable)
+			return
+		}
+		SetFunction(r.Context(), "nodeinfo", "openfaas-fn")
+
+		if r.URL.Query().Get("fail") == "1" {
+			http.Error(w, "upstream error", http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte("OK"))
+	})
+
+	router := mux.NewRouter()
+	router.HandleFunc("/function/{name}", proxy)
+
+	targets := []string{
+		"/function/nodeinfo.openfaas-fn",
+		"/function/nodeinfo.openfaas-fn",
+		"/function/nodeinfo.openfaas-fn?fail=1",
+		"/function/unknown-1",
+		"/function/unknown-2",
+	}
+	for _, target := range targets {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+	}
+
+	if got := testutil.ToFloat64(FunctionInvocations.WithLabelValues("nodeinfo", "openfaas-fn", "200")); got != 2 {
+		t.Errorf("want 2 successful invocations, got %v", got)
+	}
+	if got := testutil.ToFloat64(FunctionInvocations.WithLabelValues("nodeinfo", "openfaas-fn", "502")); got != 1 {
+		t.Errorf("want 1 failed invocation, got %v", got)
+	}
+	if got := testutil.ToFloat64(FunctionInvocations.WithLabelValues(UnresolvedFunction, "", "503")); got != 2 {
+		t.Errorf("want the unknown names in a single series, got %v", got)
+	}
+}
+
+func Test_InstrumentHandlers_RecordsRoute(t *testing.T) {
+	handlers := &types.FaaSHandlers{
+		DeployHandler: func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusAccepted)
+		},
+	}
+
+	InstrumentHandlers(handlers)
+
+	if handlers.UpdateHandler != nil {
+		t.Errorf("want unset handlers to stay nil")
+	}
+
+	handlers.DeployHandler(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/system/functions", nil))
+
+	if got := testutil.ToFloat64(RequestsTotal.WithLabelValues("deploy", "post", "202")); got != 1 {
+		t.Errorf("want 1 deploy request, got %v", got)
+	}
+}
+
+func Test_WorkqueueMetricsProvider(t *testing.T) {
+	queue := workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "Test")
+	defer queue.ShutDown()
+
+	queue.Add("openfaas-fn/nodeinfo")
+	queue.Add("openfaas-fn/figlet")
+
+	if got := testutil.ToFloat64(workqueueDepth.WithLabelValues("Test")); got != 2 {
+		t.Errorf("want depth 2, got %v", got)
+	}
+}
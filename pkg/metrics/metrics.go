@@ -0,0 +1,104 @@
+// Copyright 2020 OpenFaaS Author(s)
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+// Package metrics defines the Prometheus metrics recorded by faas-netes for
+// the operator reconcile loop, its workqueue, the provider API and the
+// function proxy. The metrics are registered with the default registry and
+// exposed on /metrics in both controller and operator mode.
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/client-go/util/workqueue"
+)
+
+const namespace = "faas_netes"
+
+var (
+	// ReconcileDuration observes the time taken by the operator to sync a Function
+	ReconcileDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "operator",
+		Name:      "reconcile_duration_seconds",
+		Help:      "Time taken to reconcile a Function.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"namespace", "result"})
+
+	// ReconcileTotal counts the reconciles of Functions by outcome
+	ReconcileTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "operator",
+		Name:      "reconcile_total",
+		Help:      "Reconciles of Functions by outcome.",
+	}, []string{"namespace", "result"})
+
+	// RequestDuration observes the latency of the provider API routes
+	RequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "Latency of the provider API by route, method and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "code"})
+
+	// RequestsTotal counts the requests to the provider API routes
+	RequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "requests_total",
+		Help:      "Requests to the provider API by route, method and status code.",
+	}, []string{"route", "method", "code"})
+
+	// FunctionDuration observes the latency of function invocations made
+	// through the proxy, invocations of functions which were not resolved
+	// share the UnresolvedFunction series
+	FunctionDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "function",
+		Name:      "invocation_duration_seconds",
+		Help:      "Latency of function invocations through the proxy.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"function_name", "namespace"})
+
+	// FunctionInvocations counts the function invocations made through the
+	// proxy by status code, errors returned by the proxy or the function have
+	// a 5xx code
+	FunctionInvocations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "function",
+		Name:      "invocation_total",
+		Help:      "Function invocations through the proxy by status code.",
+	}, []string{"function_name", "namespace", "code"})
+
+	// FunctionInFlight is the number of requests being proxied to a function
+	FunctionInFlight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "function",
+		Name:      "inflight_requests",
+		Help:      "Requests in flight to a function through the proxy.",
+	}, []string{"function_name", "namespace"})
+
+	// FunctionQueued is the number of requests waiting for the concurrency
+	// limit of a function
+	FunctionQueued = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "function",
+		Name:      "queued_requests",
+		Help:      "Requests waiting for the concurrency limit of a function.",
+	}, []string{"function_name", "namespace"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		ReconcileDuration,
+		ReconcileTotal,
+		RequestDuration,
+		RequestsTotal,
+		FunctionDuration,
+		FunctionInvocations,
+		FunctionInFlight,
+		FunctionQueued,
+	)
+
+	workqueue.SetProvider(workqueueMetricsProvider{})
+}
@@ -0,0 +1,304 @@
+// Copyright 2020 OpenFaaS Author(s)
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+// Package proxy is the function invocation proxy of faas-netes. It is based
+// on the proxy of faas-provider, but resolves the function for each request
+// so that the resolver can balance the load over the function endpoints and
+// track the requests in flight to each of them.
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/openfaas/faas-netes/pkg/tracing"
+	"github.com/openfaas/faas-provider/httputil"
+	fproxy "github.com/openfaas/faas-provider/proxy"
+	"github.com/openfaas/faas-provider/types"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const defaultContentType = "text/plain"
+
+// BaseURLResolver resolves the URL of a function for a request, including
+// the port that the function listens on. The func
+// returned with the URL is called once the request to the function is
+// complete.
+type BaseURLResolver interface {
+	ResolveRequest(functionName string, r *http.Request) (url.URL, func(), error)
+}
+
+// retryableError is returned by a resolver when the function is at its
+// concurrency limit, the client is told when to retry
+type retryableError interface {
+	error
+	RetryAfter() time.Duration
+}
+
+// TimeoutResolver is a BaseURLResolver which knows the timeout of each
+// function, it replaces the read timeout of the provider for its requests
+type TimeoutResolver interface {
+	BaseURLResolver
+
+	// Timeout returns the timeout of the function, zero when it has none
+	Timeout(functionName string) time.Duration
+}
+
+// NewHandlerFunc creates the http.HandlerFunc which proxies the requests to
+// the functions
+func NewHandlerFunc(config types.FaaSConfig, resolver BaseURLResolver) http.HandlerFunc {
+	if resolver == nil {
+		panic("NewHandlerFunc: empty proxy handler resolver, cannot be nil")
+	}
+
+	proxyClient := newProxyClient(config)
+	defaultTimeout := config.GetReadTimeout()
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Body != nil {
+			defer r.Body.Close()
+		}
+
+		switch r.Method {
+		case http.MethodPost,
+			http.MethodPut,
+			http.MethodPatch,
+			http.MethodDelete,
+			http.MethodGet,
+			http.MethodOptions,
+			http.MethodHead:
+			proxyRequest(w, r, proxyClient, resolver, defaultTimeout)
+
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// newProxyClient creates the client which calls the functions. It has no
+// overall timeout, so that responses can be streamed, the timeout of each
+// function is applied to its requests instead.
+func newProxyClient(config types.FaaSConfig) *http.Client {
+	client := fproxy.NewProxyClient(config.GetReadTimeout(), config.GetMaxIdleConns(), config.GetMaxIdleConnsPerHost())
+	client.Timeout = 0
+
+	if transport, ok := client.Transport.(*http.Transport); ok {
+		transport.IdleConnTimeout = 90 * time.Second
+	}
+	return client
+}
+
+// proxyRequest handles the actual resolution of and then request to the function service.
+func proxyRequest(w http.ResponseWriter, originalReq *http.Request, proxyClient *http.Client, resolver BaseURLResolver, defaultTimeout time.Duration) {
+	pathVars := mux.Vars(originalReq)
+	functionName := pathVars["name"]
+	if functionName == "" {
+		httputil.Errorf(w, http.StatusBadRequest, "Provide function name in the request path")
+		return
+	}
+
+	timeout := defaultTimeout
+	if timeoutResolver, ok := resolver.(TimeoutResolver); ok {
+		if functionTimeout := timeoutResolver.Timeout(functionName); functionTimeout > 0 {
+			timeout = functionTimeout
+		}
+	}
+
+	upgrade := isUpgrade(originalReq)
+
+	policy := RetryPolicy{Attempts: 1}
+	retryResolver, canRetry := resolver.(RetryResolver)
+	if canRetry && !upgrade {
+		policy = retryResolver.RetryPolicy(functionName)
+	}
+
+	// the body is kept so that it can be sent again
+	var body []byte
+	if policy.Attempts > 1 && originalReq.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(originalReq.Body)
+		if err != nil {
+			httputil.Errorf(w, http.StatusBadRequest, "Unable to read the request body: %s.", err.Error())
+			return
+		}
+	}
+
+	// the timeout covers all the attempts and the copy of the response
+	ctx, cancel := context.WithTimeout(originalReq.Context(), timeout)
+	defer cancel()
+
+	var response *http.Response
+	var done func()
+	defer func() {
+		if done != nil {
+			done()
+		}
+	}()
+
+	tried := []string{}
+	for attempt := 1; ; attempt++ {
+		var functionAddr url.URL
+		var resolveErr error
+		if attempt == 1 {
+			functionAddr, done, resolveErr = resolver.ResolveRequest(functionName, originalReq)
+		} else {
+			functionAddr, done, resolveErr = retryResolver.ResolveRetry(functionName, originalReq, tried)
+		}
+
+		if limitErr, ok := resolveErr.(retryableError); ok {
+			log.Printf("resolver error: %s\n", resolveErr.Error())
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(limitErr.RetryAfter().Seconds()))))
+			httputil.Errorf(w, http.StatusTooManyRequests, "Too many requests for: %s.", functionName)
+			return
+		}
+		if resolveErr != nil {
+			log.Printf("resolver error: no endpoints for %s: %s\n", functionName, resolveErr.Error())
+			httputil.Errorf(w, http.StatusServiceUnavailable, "No endpoints available for: %s.", functionName)
+			return
+		}
+		tried = append(tried, functionAddr.Host)
+
+		// the span of the invocation, when it is traced, records the last
+		// endpoint which was tried
+		span := trace.SpanFromContext(ctx)
+		span.SetAttributes(semconv.NetPeerIPKey.String(functionAddr.Hostname()))
+		if attempt > 1 {
+			span.AddEvent("retry", trace.WithAttributes(semconv.NetPeerIPKey.String(functionAddr.Hostname())))
+		}
+
+		proxyReq, err := buildProxyRequest(originalReq, functionAddr, pathVars["params"])
+		if err != nil {
+			httputil.Errorf(w, http.StatusInternalServerError, "Failed to resolve service: %s.", functionName)
+			return
+		}
+		if body != nil {
+			proxyReq.Body = ioutil.NopCloser(bytes.NewReader(body))
+			proxyReq.ContentLength = int64(len(body))
+		}
+		tracing.Inject(ctx, proxyReq.Header)
+
+		if upgrade {
+			proxyUpgrade(w, originalReq, proxyReq, functionName, timeout)
+			return
+		}
+
+		start := time.Now()
+		response, err = proxyClient.Do(proxyReq.WithContext(ctx))
+		seconds := time.Since(start)
+
+		if attempt < policy.Attempts && shouldRetry(err, response, policy.idempotent(originalReq)) {
+			wait := policy.backoff(attempt)
+			if err != nil {
+				log.Printf("retrying %s in %s after error with proxy request to: %s, %s\n", functionName, wait, proxyReq.URL.String(), err.Error())
+			} else {
+				log.Printf("retrying %s in %s after status %d from: %s\n", functionName, wait, response.StatusCode, proxyReq.URL.String())
+				io.Copy(ioutil.Discard, response.Body)
+				response.Body.Close()
+			}
+
+			// the endpoint is given back before waiting
+			done()
+			done = nil
+			if !sleep(ctx, wait) {
+				log.Printf("request to %s was cancelled while waiting to retry\n", functionName)
+				return
+			}
+			continue
+		}
+
+		if err != nil && ctx.Err() == context.DeadlineExceeded {
+			log.Printf("timeout with proxy request to: %s after %s\n", proxyReq.URL.String(), timeout)
+
+			httputil.Errorf(w, http.StatusGatewayTimeout, "Function %s did not respond within its timeout of %s.", functionName, timeout)
+			return
+		}
+		if err != nil {
+			log.Printf("error with proxy request to: %s, %s\n", proxyReq.URL.String(), err.Error())
+
+			httputil.Errorf(w, http.StatusInternalServerError, "Can't reach service for: %s.", functionName)
+			return
+		}
+
+		log.Printf("%s took %f seconds\n", functionName, seconds.Seconds())
+		break
+	}
+
+	if response.Body != nil {
+		defer response.Body.Close()
+	}
+
+	clientHeader := w.Header()
+	copyHeaders(clientHeader, &response.Header)
+	w.Header().Set("Content-Type", getContentType(originalReq.Header, response.Header))
+
+	w.WriteHeader(response.StatusCode)
+	if response.Body != nil {
+		copyResponse(w, response)
+	}
+}
+
+// buildProxyRequest creates a request object for the proxy request, it will ensure that
+// the original request headers are preserved as well as setting openfaas system headers
+func buildProxyRequest(originalReq *http.Request, baseURL url.URL, extraPath string) (*http.Request, error) {
+
+	url := url.URL{
+		Scheme:   baseURL.Scheme,
+		Host:     baseURL.Host,
+		Path:     extraPath,
+		RawQuery: originalReq.URL.RawQuery,
+	}
+
+	upstreamReq, err := http.NewRequest(originalReq.Method, url.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	copyHeaders(upstreamReq.Header, &originalReq.Header)
+
+	if len(originalReq.Host) > 0 && upstreamReq.Header.Get("X-Forwarded-Host") == "" {
+		upstreamReq.Header["X-Forwarded-Host"] = []string{originalReq.Host}
+	}
+	if upstreamReq.Header.Get("X-Forwarded-For") == "" {
+		upstreamReq.Header["X-Forwarded-For"] = []string{originalReq.RemoteAddr}
+	}
+
+	if originalReq.Body != nil {
+		upstreamReq.Body = originalReq.Body
+	}
+
+	return upstreamReq, nil
+}
+
+// copyHeaders clones the header values from the source into the destination.
+func copyHeaders(destination http.Header, source *http.Header) {
+	for k, v := range *source {
+		vClone := make([]string, len(v))
+		copy(vClone, v)
+		destination[k] = vClone
+	}
+}
+
+// getContentType resolves the correct Content-Type for a proxied function.
+func getContentType(request http.Header, proxyResponse http.Header) (headerContentType string) {
+	responseHeader := proxyResponse.Get("Content-Type")
+	requestHeader := request.Get("Content-Type")
+
+	if len(responseHeader) > 0 {
+		headerContentType = responseHeader
+	} else if len(requestHeader) > 0 {
+		headerContentType = requestHeader
+	} else {
+		headerContentType = defaultContentType
+	}
+
+	return headerContentType
+}
@@ -0,0 +1,150 @@
+// Copyright 2020 OpenFaaS Author(s)
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package proxy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/openfaas/faas-netes/pkg/tracing"
+	"github.com/openfaas/faas-provider/types"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+)
+
+type testResolver struct {
+	url      url.URL
+	err      error
+	header   string
+	resolved int
+	done     int
+}
+
+func (r *testResolver) ResolveRequest(functionName string, req *http.Request) (url.URL, func(), error) {
+	if r.err != nil {
+		return url.URL{}, nil, r.err
+	}
+	r.resolved++
+	r.header = req.Header.Get("X-Affinity-Key")
+	return r.url, func() { r.done++ }, nil
+}
+
+func newTestRouter(resolver BaseURLResolver) *mux.Router {
+	config := types.FaaSConfig{ReadTimeout: time.Second, WriteTimeout: time.Second}
+
+	router := mux.NewRouter()
+	router.HandleFunc("/function/{name:[-a-zA-Z_0-9.]+}", NewHandlerFunc(config, resolver))
+	router.HandleFunc("/function/{name:[-a-zA-Z_0-9.]+}/{params:.*}", NewHandlerFunc(config, resolver))
+	return router
+}
+
+func Test_ProxyRequest_EndsTrackedRequest(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "path=%s", r.URL.Path)
+	}))
+	defer upstream.Close()
+
+	u, _ := url.Parse(upstream.URL)
+	resolver := &testResolver{url: *u}
+
+	req := httptest.NewRequest(http.MethodGet, "/function/nodeinfo/info", nil)
+	req.Header.Set("X-Affinity-Key", "tenant-a")
+	rr := httptest.NewRecorder()
+	newTestRouter(resolver).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("want status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	body, _ := ioutil.ReadAll(rr.Body)
+	if string(body) != "path=/info" {
+		t.Errorf("want the sub-path to be proxied, got %q", body)
+	}
+	if resolver.header != "tenant-a" {
+		t.Errorf("want the request to be passed to the resolver, got header %q", resolver.header)
+	}
+	if resolver.resolved != 1 || resolver.done != 1 {
+		t.Errorf("want the request to be resolved and ended once, got %d and %d", resolver.resolved, resolver.done)
+	}
+}
+
+func Test_ProxyRequest_ResolveError(t *testing.T) {
+	resolver := &testResolver{err: fmt.Errorf("no subsets available")}
+
+	rr := httptest.NewRecorder()
+	newTestRouter(resolver).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/function/nodeinfo", nil))
+
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("want status %d, got %d", http.StatusServiceUnavailable, rr.Code)
+	}
+}
+
+type limitError struct{}
+
+func (limitError) Error() string             { return "too many requests" }
+func (limitError) RetryAfter() time.Duration { return 1500 * time.Millisecond }
+
+func Test_ProxyRequest_TooManyRequests(t *testing.T) {
+	resolver := &testResolver{err: limitError{}}
+
+	rr := httptest.NewRecorder()
+	newTestRouter(resolver).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/function/nodeinfo", nil))
+
+	if rr.Code != http.StatusTooManyRequests {
+		t.Errorf("want status %d, got %d", http.StatusTooManyRequests, rr.Code)
+	}
+	if got := rr.Header().Get("Retry-After"); got != "2" {
+		t.Errorf("want Retry-After rounded up to 2 seconds, got %q", got)
+	}
+}
+
+func Test_ProxyRequest_PropagatesTrace(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	traceparent := ""
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+	}))
+	defer upstream.Close()
+
+	u, _ := url.Parse(upstream.URL)
+	config := types.FaaSConfig{ReadTimeout: time.Second, WriteTimeout: time.Second}
+
+	router := mux.NewRouter()
+	router.HandleFunc("/function/{name:[-a-zA-Z_0-9.]+}", tracing.InstrumentProxy(NewHandlerFunc(config, &testResolver{url: *u})))
+
+	req := httptest.NewRequest(http.MethodGet, "/function/nodeinfo", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("want 1 span, got %d", len(spans))
+	}
+
+	want := fmt.Sprintf("00-%s-%s-01", spans[0].SpanContext().TraceID(), spans[0].SpanContext().SpanID())
+	if traceparent != want {
+		t.Errorf("want the traceparent %s, got %q", want, traceparent)
+	}
+
+	peer := ""
+	for _, kv := range spans[0].Attributes() {
+		if kv.Key == semconv.NetPeerIPKey {
+			peer = kv.Value.AsString()
+		}
+	}
+	if peer != u.Hostname() {
+		t.Errorf("want the peer %s, got %q", u.Hostname(), peer)
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright 2020 OpenFaaS Author(s)
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// RetryPolicy controls how a failed invocation of a function is retried.
+// Connection failures are retried for all requests, 502 and 503 responses
+// only for GET and HEAD requests or when the IdempotencyHeader is set.
+type RetryPolicy struct {
+	// Attempts is the total number of attempts, one disables the retries
+	Attempts int
+	// Backoff is the wait before the first retry, it doubles for each retry
+	Backoff time.Duration
+	// MaxBackoff caps the wait between two attempts
+	MaxBackoff time.Duration
+	// IdempotencyHeader marks a request which can be retried whatever its
+	// method
+	IdempotencyHeader string
+}
+
+// RetryResolver is a BaseURLResolver which can retry the requests to a
+// function on another of its endpoints
+type RetryResolver interface {
+	BaseURLResolver
+
+	// RetryPolicy returns the policy of the function
+	RetryPolicy(functionName string) RetryPolicy
+
+	// ResolveRetry resolves the function for a retry, avoiding the hosts
+	// which were already tried when there are others
+	ResolveRetry(functionName string, r *http.Request, tried []string) (url.URL, func(), error)
+}
+
+// backoff returns the wait before the retry which follows the attempt
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	wait := p.Backoff
+	for i := 1; i < attempt && wait < p.MaxBackoff; i++ {
+		wait *= 2
+	}
+	if p.MaxBackoff > 0 && wait > p.MaxBackoff {
+		wait = p.MaxBackoff
+	}
+	return wait
+}
+
+// idempotent reports whether the request can be sent again after the
+// function received it
+func (p RetryPolicy) idempotent(r *http.Request) bool {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return true
+	}
+	return len(p.IdempotencyHeader) > 0 && len(r.Header.Get(p.IdempotencyHeader)) > 0
+}
+
+// shouldRetry reports whether the result of an attempt can be retried. A
+// failure to connect means that the function did not receive the request.
+func shouldRetry(err error, response *http.Response, idempotent bool) bool {
+	if err != nil {
+		var opErr *net.OpError
+		if errors.As(err, &opErr) && opErr.Op == "dial" {
+			return true
+		}
+		// the function had the request for the whole timeout
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return false
+		}
+		return idempotent && !errors.Is(err, context.Canceled)
+	}
+
+	switch response.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable:
+		return idempotent
+	default:
+		return false
+	}
+}
+
+// sleep waits for d, it returns false when the context is done first
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright 2020 OpenFaaS Author(s)
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package proxy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testRetryResolver resolves to the hosts in order, skipping the ones tried
+type testRetryResolver struct {
+	hosts  []string
+	policy RetryPolicy
+	done   int
+}
+
+func (r *testRetryResolver) ResolveRequest(functionName string, req *http.Request) (url.URL, func(), error) {
+	return r.ResolveRetry(functionName, req, nil)
+}
+
+func (r *testRetryResolver) ResolveRetry(functionName string, req *http.Request, tried []string) (url.URL, func(), error) {
+	for _, host := range r.hosts {
+		found := false
+		for _, t := range tried {
+			found = found || t == host
+		}
+		if !found {
+			return url.URL{Scheme: "http", Host: host}, func() { r.done++ }, nil
+		}
+	}
+	return url.URL{}, nil, fmt.Errorf("no untried hosts")
+}
+
+func (r *testRetryResolver) RetryPolicy(functionName string) RetryPolicy {
+	return r.policy
+}
+
+func newUpstream(status int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.WriteHeader(status)
+		fmt.Fprintf(w, "status=%d body=%s", status, body)
+	}))
+}
+
+func hostOf(server *httptest.Server) string {
+	u, _ := url.Parse(server.URL)
+	return u.Host
+}
+
+func Test_ProxyRequest_Retries(t *testing.T) {
+	unavailable := newUpstream(http.StatusServiceUnavailable)
+	defer unavailable.Close()
+	ok := newUpstream(http.StatusOK)
+	defer ok.Close()
+
+	// a listener which was closed refuses connections
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closedHost := hostOf(closed)
+	closed.Close()
+
+	policy := RetryPolicy{Attempts: 3, Backoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond, IdempotencyHeader: "Idempotency-Key"}
+
+	cases := []struct {
+		name   string
+		method string
+		header string
+		hosts  []string
+		want   string
+	}{
+		{"GET is retried on 503", http.MethodGet, "", []string{hostOf(unavailable), hostOf(ok)}, "status=200"},
+		{"POST is not retried on 503", http.MethodPost, "", []string{hostOf(unavailable), hostOf(ok)}, "status=503"},
+		{"POST with an idempotency key is retried on 503", http.MethodPost, "abc", []string{hostOf(unavailable), hostOf(ok)}, "status=200 body=payload"},
+		{"POST is retried when the connection is refused", http.MethodPost, "", []string{closedHost, hostOf(ok)}, "status=200 body=payload"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			resolver := &testRetryResolver{hosts: tc.hosts, policy: policy}
+
+			req := httptest.NewRequest(tc.method, "/function/nodeinfo", strings.NewReader("payload"))
+			if len(tc.header) > 0 {
+				req.Header.Set("Idempotency-Key", tc.header)
+			}
+			rr := httptest.NewRecorder()
+			newTestRouter(resolver).ServeHTTP(rr, req)
+
+			if !strings.HasPrefix(rr.Body.String(), tc.want) {
+				t.Errorf("want a response starting with %q, got %q", tc.want, rr.Body.String())
+			}
+			attempts := 1
+			if strings.HasPrefix(tc.want, "status=200") {
+				attempts = 2
+			}
+			if resolver.done != attempts {
+				t.Errorf("want each of the %d attempts to be ended, got %d", attempts, resolver.done)
+			}
+		})
+	}
+}
+
+func Test_RetryPolicy_Backoff(t *testing.T) {
+	policy := RetryPolicy{Backoff: 100 * time.Millisecond, MaxBackoff: 300 * time.Millisecond}
+
+	for attempt, want := range map[int]time.Duration{
+		1: 100 * time.Millisecond,
+		2: 200 * time.Millisecond,
+		3: 300 * time.Millisecond,
+		8: 300 * time.Millisecond,
+	} {
+		if got := policy.backoff(attempt); got != want {
+			t.Errorf("attempt %d: want %s, got %s", attempt, want, got)
+		}
+	}
+}
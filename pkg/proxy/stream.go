@@ -0,0 +1,143 @@
+// Copyright 2020 OpenFaaS Author(s)
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package proxy
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/openfaas/faas-provider/httputil"
+)
+
+// isUpgrade reports whether the request asks to switch protocols, as the
+// handshake of a WebSocket does
+func isUpgrade(r *http.Request) bool {
+	if len(r.Header.Get("Upgrade")) == 0 {
+		return false
+	}
+
+	for _, value := range r.Header["Connection"] {
+		for _, token := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// proxyUpgrade sends the upgrade request to the function over its own
+// connection. When the function switches protocols the client connection is
+// hijacked and the two connections are spliced until either side closes.
+// The timeout only applies to the handshake.
+func proxyUpgrade(w http.ResponseWriter, originalReq *http.Request, proxyReq *http.Request, functionName string, timeout time.Duration) {
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		httputil.Errorf(w, http.StatusInternalServerError, "Protocol upgrades are not supported for: %s.", functionName)
+		return
+	}
+
+	dialer := net.Dialer{Timeout: timeout}
+	backend, err := dialer.DialContext(originalReq.Context(), "tcp", proxyReq.URL.Host)
+	if err != nil {
+		log.Printf("error with upgrade request to: %s, %s\n", proxyReq.URL.String(), err.Error())
+		httputil.Errorf(w, http.StatusInternalServerError, "Can't reach service for: %s.", functionName)
+		return
+	}
+	defer backend.Close()
+
+	backend.SetDeadline(time.Now().Add(timeout))
+	if err := proxyReq.Write(backend); err != nil {
+		log.Printf("error with upgrade request to: %s, %s\n", proxyReq.URL.String(), err.Error())
+		httputil.Errorf(w, http.StatusInternalServerError, "Can't reach service for: %s.", functionName)
+		return
+	}
+
+	backendReader := bufio.NewReader(backend)
+	response, err := http.ReadResponse(backendReader, proxyReq)
+	if err != nil {
+		log.Printf("error reading upgrade response from: %s, %s\n", proxyReq.URL.String(), err.Error())
+		httputil.Errorf(w, http.StatusBadGateway, "Invalid upgrade response from: %s.", functionName)
+		return
+	}
+	defer response.Body.Close()
+	backend.SetDeadline(time.Time{})
+
+	if response.StatusCode != http.StatusSwitchingProtocols {
+		copyHeaders(w.Header(), &response.Header)
+		w.WriteHeader(response.StatusCode)
+		io.Copy(w, response.Body)
+		return
+	}
+
+	client, clientReader, err := hijacker.Hijack()
+	if err != nil {
+		log.Printf("unable to hijack the connection for %s: %s\n", functionName, err.Error())
+		httputil.Errorf(w, http.StatusInternalServerError, "Protocol upgrades are not supported for: %s.", functionName)
+		return
+	}
+	defer client.Close()
+
+	// the deadlines of the server do not apply to the upgraded connection
+	client.SetDeadline(time.Time{})
+
+	if err := response.Write(client); err != nil {
+		log.Printf("unable to write upgrade response for %s: %s\n", functionName, err.Error())
+		return
+	}
+
+	log.Printf("%s switched protocols to %s\n", functionName, response.Header.Get("Upgrade"))
+
+	// both connections are closed as soon as one side is done
+	errc := make(chan error, 2)
+	go func() {
+		_, err := io.Copy(backend, clientReader.Reader)
+		errc <- err
+	}()
+	go func() {
+		_, err := io.Copy(client, backendReader)
+		errc <- err
+	}()
+	<-errc
+}
+
+// copyResponse copies the response body to the client. Responses of unknown
+// length and event streams are flushed as they are received, so that the
+// client does not wait for the function to finish.
+func copyResponse(w http.ResponseWriter, response *http.Response) {
+	flusher, ok := w.(http.Flusher)
+	if !ok || !isStreaming(response) {
+		io.Copy(w, response.Body)
+		return
+	}
+
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := response.Body.Read(buf)
+		if n > 0 {
+			if _, writeErr := w.Write(buf[:n]); writeErr != nil {
+				return
+			}
+			flusher.Flush()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func isStreaming(response *http.Response) bool {
+	if response.ContentLength < 0 {
+		return true
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(response.Header.Get("Content-Type"))
+	return mediaType == "text/event-stream"
+}
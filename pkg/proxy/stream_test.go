@@ -0,0 +1,164 @@
+// Copyright 2020 OpenFaaS Author(s)
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package proxy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newProxyServer serves the proxy on a real listener, so that responses can
+// be flushed and connections hijacked
+func newProxyServer(t *testing.T, upstream *httptest.Server, timeout time.Duration) *httptest.Server {
+	t.Helper()
+
+	u, _ := url.Parse(upstream.URL)
+	var resolver BaseURLResolver = &testResolver{url: *u}
+	if timeout > 0 {
+		resolver = &testTimeoutResolver{testResolver: testResolver{url: *u}, timeout: timeout}
+	}
+	return httptest.NewServer(newTestRouter(resolver))
+}
+
+type testTimeoutResolver struct {
+	testResolver
+	timeout time.Duration
+}
+
+func (r *testTimeoutResolver) Timeout(functionName string) time.Duration {
+	return r.timeout
+}
+
+func Test_ProxyRequest_FlushesEventStream(t *testing.T) {
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: first\n\n")
+		w.(http.Flusher).Flush()
+		<-release
+		fmt.Fprint(w, "data: second\n\n")
+	}))
+	defer upstream.Close()
+	defer close(release)
+
+	proxy := newProxyServer(t, upstream, 0)
+	defer proxy.Close()
+
+	res, err := http.Get(proxy.URL + "/function/events")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer res.Body.Close()
+
+	line := make(chan string, 1)
+	go func() {
+		got, _ := bufio.NewReader(res.Body).ReadString('\n')
+		line <- got
+	}()
+
+	select {
+	case got := <-line:
+		if got != "data: first\n" {
+			t.Errorf("want the first event, got %q", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("want the first event before the function finished")
+	}
+}
+
+func Test_ProxyRequest_UpgradesConnection(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "echo" {
+			http.Error(w, "upgrade required", http.StatusUpgradeRequired)
+			return
+		}
+
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		fmt.Fprint(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: echo\r\nConnection: Upgrade\r\n\r\n")
+		rw.Flush()
+
+		line, _ := rw.ReadString('\n')
+		fmt.Fprint(rw, "echo: "+line)
+		rw.Flush()
+	}))
+	defer upstream.Close()
+
+	// the timeout of the function does not end the upgraded connection
+	proxy := newProxyServer(t, upstream, 50*time.Millisecond)
+	defer proxy.Close()
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(proxy.URL, "http://"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer conn.Close()
+
+	fmt.Fprint(conn, "GET /function/echo HTTP/1.1\r\nHost: gateway\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+
+	reader := bufio.NewReader(conn)
+	res, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("want status %d, got %d", http.StatusSwitchingProtocols, res.StatusCode)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	fmt.Fprint(conn, "ping\n")
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	got, err := reader.ReadString('\n')
+	if err != nil && err != io.EOF {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got != "echo: ping\n" {
+		t.Errorf("want the echo of the function, got %q", got)
+	}
+}
+
+func Test_ProxyRequest_FunctionTimeout(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(2 * time.Second):
+		case <-r.Context().Done():
+		}
+	}))
+	defer upstream.Close()
+
+	proxy := newProxyServer(t, upstream, 50*time.Millisecond)
+	defer proxy.Close()
+
+	start := time.Now()
+	res, err := http.Get(proxy.URL + "/function/slow")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+
+	if !strings.Contains(string(body), "slow") {
+		t.Errorf("want the function name in the error, got %q", body)
+	}
+	if res.StatusCode != http.StatusGatewayTimeout {
+		t.Errorf("want status %d when the function times out, got %d", http.StatusGatewayTimeout, res.StatusCode)
+	}
+	if took := time.Since(start); took > time.Second {
+		t.Errorf("want the timeout of the function instead of the read timeout, took %s", took)
+	}
+}
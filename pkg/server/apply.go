@@ -8,6 +8,7 @@ import (
 
 	faasv1 "github.com/openfaas/faas-netes/pkg/apis/openfaas/v1"
 	clientset "github.com/openfaas/faas-netes/pkg/client/clientset/versioned"
+	"github.com/openfaas/faas-netes/pkg/k8s"
 	"github.com/openfaas/faas-provider/types"
 
 	"k8s.io/apimachinery/pkg/api/errors"
@@ -30,6 +31,11 @@ func makeApplyHandler(defaultNamespace string, client clientset.Interface) http.
 			w.Write([]byte(err.Error()))
 			return
 		}
+		if err := k8s.SetRevision(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
 		klog.Infof("Deployment request for: %s\n", req.Service)
 
 		namespace := defaultNamespace
@@ -116,7 +122,3 @@ func getResources(limits *types.FunctionResources) *faasv1.FunctionResources {
 		Memory: limits.Memory,
 	}
 }
-
-func int32p(i int32) *int32 {
-	return &i
-}
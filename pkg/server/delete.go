@@ -1,18 +1,31 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"time"
 
 	clientset "github.com/openfaas/faas-netes/pkg/client/clientset/versioned"
+	"github.com/openfaas/faas-netes/pkg/handlers"
+	"github.com/openfaas/faas-netes/pkg/k8s"
 	"github.com/openfaas/faas/gateway/requests"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
 	glog "k8s.io/klog"
 )
 
-func makeDeleteHandler(defaultNamespace string, client clientset.Interface) http.HandlerFunc {
+// deletePollInterval is how often the Function is checked while waiting for
+// the operator to complete its cleanup
+const deletePollInterval = 500 * time.Millisecond
+
+// makeDeleteHandler deletes the Function and waits up to timeout for the
+// operator to remove the objects it owns. A 200 is returned once the Function
+// is gone and a 202 when the cleanup is still in progress after the timeout.
+func makeDeleteHandler(defaultNamespace string, client clientset.Interface, timeout time.Duration) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		q := r.URL.Query()
@@ -47,15 +60,42 @@ func makeDeleteHandler(defaultNamespace string, client clientset.Interface) http
 			return
 		}
 
+		// revisions are deleted as payments@v2 or by the name of their Function
+		if function, revision, err := k8s.ParseRevision(request.FunctionName); err == nil && len(revision) > 0 {
+			request.FunctionName = k8s.RevisionName(function, revision)
+		}
+
 		err = client.OpenfaasV1().Functions(lookupNamespace).
 			Delete(r.Context(), request.FunctionName, metav1.DeleteOptions{})
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
+			status, _ := handlers.ProcessErrorReasons(err)
+			w.WriteHeader(status)
 			w.Write([]byte(err.Error()))
 			glog.Errorf("Function %s delete error: %v", request.FunctionName, err)
 			return
 		}
 
-		w.WriteHeader(http.StatusAccepted)
+		if err := waitForDeletion(r.Context(), client, lookupNamespace, request.FunctionName, timeout); err != nil {
+			glog.Warningf("Function %s cleanup still in progress: %v", request.FunctionName, err)
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
 	}
 }
+
+// waitForDeletion blocks until the Function no longer exists, the timeout
+// expires or the request is cancelled
+func waitForDeletion(ctx context.Context, client clientset.Interface, namespace, name string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	return wait.PollImmediateUntil(deletePollInterval, func() (bool, error) {
+		_, err := client.OpenfaasV1().Functions(namespace).Get(ctx, name, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			return true, nil
+		}
+		return false, nil
+	}, ctx.Done())
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	faasv1 "github.com/openfaas/faas-netes/pkg/apis/openfaas/v1"
+	clientset "github.com/openfaas/faas-netes/pkg/client/clientset/versioned/fake"
+	"github.com/openfaas/faas/gateway/requests"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_makeDeleteHandler(t *testing.T) {
+	namespace := "openfaas-fn"
+
+	cases := []struct {
+		name       string
+		function   string
+		wantStatus int
+	}{
+		{
+			name:       "existing function is deleted",
+			function:   "nodeinfo",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "missing function returns not found",
+			function:   "figlet",
+			wantStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := clientset.NewSimpleClientset(&faasv1.Function{
+				ObjectMeta: metav1.ObjectMeta{Name: "nodeinfo", Namespace: namespace},
+				Spec:       faasv1.FunctionSpec{Name: "nodeinfo"},
+			})
+			deleteHandler := makeDeleteHandler(namespace, client, time.Second).ServeHTTP
+
+			body, _ := json.Marshal(requests.DeleteFunctionRequest{FunctionName: tc.function})
+			req := httptest.NewRequest(http.MethodDelete, "http://system/functions", bytes.NewBuffer(body))
+			w := httptest.NewRecorder()
+
+			deleteHandler(w, req)
+
+			if w.Code != tc.wantStatus {
+				t.Fatalf("want status code %d, got %d", tc.wantStatus, w.Code)
+			}
+
+			if _, err := client.OpenfaasV1().Functions(namespace).Get(context.TODO(), tc.function, metav1.GetOptions{}); err == nil {
+				t.Errorf("want function %s to be deleted", tc.function)
+			}
+		})
+	}
+}
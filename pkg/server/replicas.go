@@ -2,12 +2,16 @@ package server
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
+	"math"
 	"net/http"
 
 	"github.com/gorilla/mux"
 	ofv1 "github.com/openfaas/faas-netes/pkg/apis/openfaas/v1"
 	clientset "github.com/openfaas/faas-netes/pkg/client/clientset/versioned"
+	"github.com/openfaas/faas-netes/pkg/handlers"
+	"github.com/openfaas/faas-netes/pkg/k8s"
 	"github.com/openfaas/faas-provider/types"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
@@ -102,26 +106,32 @@ func makeReplicaHandler(defaultNamespace string, kube kubernetes.Interface) http
 			}
 		}
 
-		opts := metav1.GetOptions{}
-		dep, err := kube.AppsV1().Deployments(lookupNamespace).Get(r.Context(), functionName, opts)
+		if req.Replicas > math.MaxInt32 {
+			http.Error(w, fmt.Sprintf("replicas must be at most %d", math.MaxInt32), http.StatusBadRequest)
+			return
+		}
+
+		result, err := k8s.ScaleFunction(r.Context(), kube, functionName, lookupNamespace, int32(req.Replicas))
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
+			status, _ := handlers.ProcessErrorReasons(err)
+			w.WriteHeader(status)
 			w.Write([]byte(err.Error()))
-			glog.Errorf("Function %s get error: %v", functionName, err)
+			glog.Errorf("Function %s update error: %v", functionName, err)
 			return
 		}
 
-		dep.Spec.Replicas = int32p(int32(req.Replicas))
-		_, err = kube.AppsV1().Deployments(lookupNamespace).Update(r.Context(), dep, metav1.UpdateOptions{})
+		glog.Infof("Function %v replica updated to %v, requested %v", functionName, result.Replicas, result.RequestedReplicas)
+
+		res, err := json.Marshal(result)
 		if err != nil {
+			glog.Errorf("Failed to marshal scale result: %s", err.Error())
 			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte(err.Error()))
-			glog.Errorf("Function %s update error: %v", functionName, err)
 			return
 		}
 
-		glog.Infof("Function %v replica updated to %v", functionName, req.Replicas)
+		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusAccepted)
+		w.Write(res)
 	}
 }
 
@@ -142,6 +152,17 @@ func toFunctionStatus(item ofv1.Function) types.FunctionStatus {
 		CreatedAt:              item.CreationTimestamp.Time,
 	}
 
+	if len(item.Status.ScalingWindow) > 0 {
+		annotations := map[string]string{}
+		if item.Spec.Annotations != nil {
+			for k, v := range *item.Spec.Annotations {
+				annotations[k] = v
+			}
+		}
+		annotations[k8s.ActiveScalingWindowAnnotation] = item.Status.ScalingWindow
+		status.Annotations = &annotations
+	}
+
 	if item.Spec.Environment != nil {
 		status.EnvVars = *item.Spec.Environment
 	}
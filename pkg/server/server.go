@@ -7,20 +7,24 @@ import (
 
 	"github.com/openfaas/faas-netes/pkg/config"
 
+	"github.com/openfaas/faas-netes/pkg/async"
 	clientset "github.com/openfaas/faas-netes/pkg/client/clientset/versioned"
 	"github.com/openfaas/faas-netes/pkg/handlers"
 	"github.com/openfaas/faas-netes/pkg/k8s"
 	faasnetesk8s "github.com/openfaas/faas-netes/pkg/k8s"
+	"github.com/openfaas/faas-netes/pkg/metrics"
 	bootstrap "github.com/openfaas/faas-provider"
-	v1apps "k8s.io/client-go/listers/apps/v1"
 
+	"github.com/openfaas/faas-netes/pkg/proxy"
+	"github.com/openfaas/faas-netes/pkg/tracing"
 	"github.com/openfaas/faas-provider/logs"
-	"github.com/openfaas/faas-provider/proxy"
 	"github.com/openfaas/faas-provider/types"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 
+	appsinformer "k8s.io/client-go/informers/apps/v1"
 	coreinformer "k8s.io/client-go/informers/core/v1"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
 	glog "k8s.io/klog"
 )
 
@@ -34,7 +38,7 @@ const defaultWriteTimeout = 8
 func New(client clientset.Interface,
 	kube kubernetes.Interface,
 	endpointsInformer coreinformer.EndpointsInformer,
-	deploymentLister v1apps.DeploymentLister,
+	deploymentInformer appsinformer.DeploymentInformer,
 	clusterRole bool,
 	cfg config.BootstrapConfig) *Server {
 
@@ -49,18 +53,58 @@ func New(client clientset.Interface,
 	}
 
 	lister := endpointsInformer.Lister()
-	functionLookup := k8s.NewFunctionLookup(functionNamespace, lister)
+	deploymentLister := deploymentInformer.Lister()
+	functionLookup := k8s.NewFunctionLookup(functionNamespace, lister, deploymentLister)
+	functionLookup.WriteTimeout = cfg.FaaSConfig.WriteTimeout
+	deploymentInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		DeleteFunc: functionLookup.DeploymentDeleted,
+	})
+	if cfg.ColdStartTimeout > 0 {
+		functionLookup.ColdStart = k8s.NewColdStart(kube, deploymentLister, endpointsInformer, cfg.ColdStartTimeout)
+	}
+
+	var autoscaler *k8s.Autoscaler
+	if cfg.Autoscaler {
+		autoscaler = k8s.NewAutoscaler(kube, deploymentLister, functionLookup.Stats, k8s.AutoscalerConfig{
+			Interval:        cfg.AutoscalerInterval,
+			ScaleUpWindow:   cfg.AutoscalerScaleUpWindow,
+			ScaleDownWindow: cfg.AutoscalerScaleDownWindow,
+		})
+	}
+
+	var idler *k8s.Idler
+	if cfg.ScaleToZero {
+		idler = k8s.NewIdler(kube, deploymentLister, functionLookup.Stats, k8s.NewEventRecorder(kube), k8s.IdlerConfig{
+			Interval:        cfg.ScaleToZeroInterval,
+			DefaultDuration: cfg.ScaleToZeroDuration,
+		})
+	}
+
+	var scheduler *k8s.Scheduler
+	if cfg.ScalingSchedule {
+		scheduler = k8s.NewScheduler(kube, deploymentLister, k8s.NewEventRecorder(kube), k8s.SchedulerConfig{
+			Interval: cfg.ScalingScheduleInterval,
+			Location: cfg.ScalingScheduleTimezone,
+		})
+	}
 
 	bootstrapConfig := types.FaaSConfig{
 		ReadTimeout:  cfg.FaaSConfig.ReadTimeout,
 		WriteTimeout: cfg.FaaSConfig.WriteTimeout,
 		TCPPort:      cfg.FaaSConfig.TCPPort,
 		EnableHealth: true,
+
+		EnableBasicAuth: cfg.FaaSConfig.EnableBasicAuth,
+		SecretMountPath: cfg.FaaSConfig.SecretMountPath,
 	}
 
+	// wait at most half of the write timeout for a deleted Function to be
+	// cleaned up, so that there is still time to write the response
+	deleteTimeout := bootstrapConfig.WriteTimeout / 2
+
 	bootstrapHandlers := types.FaaSHandlers{
 		FunctionProxy:        proxy.NewHandlerFunc(bootstrapConfig, functionLookup),
-		DeleteHandler:        makeDeleteHandler(functionNamespace, client),
+		DeleteHandler:        makeDeleteHandler(functionNamespace, client, deleteTimeout),
 		DeployHandler:        makeApplyHandler(functionNamespace, client),
 		FunctionReader:       makeListHandler(functionNamespace, client, deploymentLister),
 		ReplicaReader:        makeReplicaReader(functionNamespace, client, deploymentLister),
@@ -73,28 +117,82 @@ func New(client clientset.Interface,
 		ListNamespaceHandler: handlers.MakeNamespacesLister(functionNamespace, clusterRole, kube),
 	}
 
+	metrics.InstrumentHandlers(&bootstrapHandlers)
+	tracing.InstrumentHandlers(&bootstrapHandlers)
+
 	if pprof == "true" {
 		bootstrap.Router().PathPrefix("/debug/pprof/").Handler(http.DefaultServeMux)
 	}
 
 	bootstrap.Router().Path("/metrics").Handler(promhttp.Handler())
 
+	credentials, err := handlers.ReadBasicAuth(bootstrapConfig)
+	if err != nil {
+		glog.Fatalf("Error reading basic auth credentials: %s", err.Error())
+	}
+	handlers.HandleTraffic(bootstrap.Router(), bootstrap.NameExpression, functionNamespace, kube, deploymentLister, credentials)
+
+	var asyncWorkers *async.Workers
+	if cfg.AsyncQueue != async.QueueNone {
+		queue, err := async.NewQueue(cfg.AsyncQueue, cfg.AsyncQueueDir, cfg.AsyncQueueSize)
+		if err != nil {
+			glog.Fatalf("Error creating async queue: %s", err.Error())
+		}
+
+		async.HandleFuncs(bootstrap.Router(), bootstrap.NameExpression, queue, cfg.AsyncMaxBodySize, credentials)
+		asyncWorkers = async.NewWorkers(queue, functionLookup, bootstrapConfig, cfg.AsyncTimeout)
+	}
+
 	glog.Infof("Using namespace '%s'", functionNamespace)
 
 	return &Server{
 		BootstrapConfig:   &bootstrapConfig,
 		BootstrapHandlers: &bootstrapHandlers,
+		asyncWorkers:      asyncWorkers,
+		asyncWorkerCount:  cfg.AsyncWorkers,
+		autoscaler:        autoscaler,
+		idler:             idler,
+		scheduler:         scheduler,
 	}
 }
 
 type Server struct {
 	BootstrapHandlers *types.FaaSHandlers
 	BootstrapConfig   *types.FaaSConfig
+
+	asyncWorkers     *async.Workers
+	asyncWorkerCount int
+	autoscaler       *k8s.Autoscaler
+	idler            *k8s.Idler
+	scheduler        *k8s.Scheduler
 }
 
-// Start begins the server
-func (s *Server) Start() {
+// Start begins the server, the asynchronous workers stop taking requests
+// from the queue once stopCh is closed
+func (s *Server) Start(stopCh <-chan struct{}) {
+	if s.asyncWorkers != nil {
+		s.asyncWorkers.Start(s.asyncWorkerCount, stopCh)
+	}
+
 	glog.Infof("Starting HTTP server on port %d", *s.BootstrapConfig.TCPPort)
 
 	bootstrap.Serve(s.BootstrapHandlers, s.BootstrapConfig)
 }
+
+// StartScaling runs the loops which scale the functions until stopCh is
+// closed. Only one replica should run them, the leader, so that the replicas
+// do not overwrite each other's decisions.
+func (s *Server) StartScaling(stopCh <-chan struct{}) {
+	if s.autoscaler != nil {
+		// the autoscaler measures the requests proxied by this replica
+		go s.autoscaler.Run(stopCh)
+	}
+
+	if s.idler != nil {
+		go s.idler.Run(stopCh)
+	}
+
+	if s.scheduler != nil {
+		go s.scheduler.Run(stopCh)
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright 2020 OpenFaaS Author(s)
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+// Package statuswriter records the status code written by an http.Handler,
+// for the middleware which instruments the provider API and the proxy.
+package statuswriter
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+)
+
+// Writer records the status code written by a handler, it passes through
+// Flush and Hijack so that streamed responses keep working
+type Writer struct {
+	http.ResponseWriter
+	status int
+}
+
+// New wraps the ResponseWriter of a handler
+func New(w http.ResponseWriter) *Writer {
+	return &Writer{ResponseWriter: w}
+}
+
+func (w *Writer) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *Writer) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Status returns the status code written, or 200 if nothing was written
+func (w *Writer) Status() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+func (w *Writer) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *Writer) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("the ResponseWriter does not support hijacking")
+	}
+
+	// a hijacked connection is reported as switching protocols
+	w.status = http.StatusSwitchingProtocols
+	return hijacker.Hijack()
+}
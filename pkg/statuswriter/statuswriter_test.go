@@ -0,0 +1,43 @@
+package statuswriter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_Writer_Status(t *testing.T) {
+	cases := []struct {
+		name    string
+		handler http.HandlerFunc
+		want    int
+	}{
+		{"nothing written", func(w http.ResponseWriter, r *http.Request) {}, http.StatusOK},
+		{"body written", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("OK")) }, http.StatusOK},
+		{"first status is kept", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadGateway)
+			w.WriteHeader(http.StatusOK)
+		}, http.StatusBadGateway},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			sw := New(httptest.NewRecorder())
+			tc.handler(sw, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if got := sw.Status(); got != tc.want {
+				t.Errorf("want status %d, got %d", tc.want, got)
+			}
+		})
+	}
+}
+
+func Test_Writer_Flush(t *testing.T) {
+	rr := httptest.NewRecorder()
+	sw := New(rr)
+
+	sw.Flush()
+	if !rr.Flushed {
+		t.Errorf("want the flush passed through to the ResponseWriter")
+	}
+}
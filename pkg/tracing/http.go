@@ -0,0 +1,142 @@
+// Copyright 2020 OpenFaaS Author(s)
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package tracing
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/openfaas/faas-netes/pkg/statuswriter"
+	"github.com/openfaas/faas-provider/types"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// FunctionKey is the name of the invoked or reconciled function
+	FunctionKey = attribute.Key("faas.function")
+	// NamespaceKey is the namespace of the function
+	NamespaceKey = attribute.Key("faas.namespace")
+	// DeploymentKey is the Deployment which served an invocation, it differs
+	// from the function when one of its revisions is picked
+	DeploymentKey = attribute.Key("faas.deployment")
+)
+
+// InstrumentHandlers wraps the deploy, update and delete handlers with a
+// span each, the Kubernetes API calls made with the context of the request
+// are recorded in it by the Transport. The FunctionProxy is wrapped with
+// InstrumentProxy. Handlers which are not set are left as nil.
+func InstrumentHandlers(handlers *types.FaaSHandlers) {
+	handlers.DeployHandler = instrumentRoute("deploy", handlers.DeployHandler)
+	handlers.UpdateHandler = instrumentRoute("update", handlers.UpdateHandler)
+	handlers.DeleteHandler = instrumentRoute("delete", handlers.DeleteHandler)
+
+	if handlers.FunctionProxy != nil {
+		handlers.FunctionProxy = InstrumentProxy(handlers.FunctionProxy)
+	}
+}
+
+// InstrumentProxy records a span for each function invocation, continuing
+// the trace of the caller. The proxy adds the endpoint it picks to the span
+// of the request context and passes the trace on to the function.
+func InstrumentProxy(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := Extract(r.Context(), r.Header)
+		ctx, span := Tracer().Start(ctx, "invoke",
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(FunctionKey.String(mux.Vars(r)["name"])),
+			trace.WithAttributes(semconv.HTTPServerAttributesFromHTTPRequest("faas-netes", "/function/{name}", r)...),
+		)
+		defer span.End()
+
+		sw := statuswriter.New(w)
+		next(sw, r.WithContext(ctx))
+
+		SetStatus(span, sw.Status())
+	}
+}
+
+func instrumentRoute(route string, next http.HandlerFunc) http.HandlerFunc {
+	if next == nil {
+		return nil
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := Extract(r.Context(), r.Header)
+		ctx, span := Tracer().Start(ctx, route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(semconv.HTTPServerAttributesFromHTTPRequest("faas-netes", r.URL.Path, r)...),
+		)
+		defer span.End()
+
+		sw := statuswriter.New(w)
+		next(sw, r.WithContext(ctx))
+
+		SetStatus(span, sw.Status())
+	}
+}
+
+// Extract returns the context with the trace context of the headers as its
+// remote parent
+func Extract(ctx context.Context, header http.Header) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, propagation.HeaderCarrier(header))
+}
+
+// Inject sets the traceparent header of the span in the context, so that
+// the function continues the trace
+func Inject(ctx context.Context, header http.Header) {
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(header))
+}
+
+// SetStatus records the HTTP status of the response on the span
+func SetStatus(span trace.Span, status int) {
+	span.SetAttributes(semconv.HTTPAttributesFromHTTPStatusCode(status)...)
+	span.SetStatus(semconv.SpanStatusFromHTTPStatusCode(status))
+}
+
+// RecordError marks the span as failed with the error
+func RecordError(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// Transport records a client span for each request made with a context which
+// already has a span, such as the Kubernetes API calls of a handler or of a
+// reconcile. The requests of the informers have no span and are left out.
+func Transport(next http.RoundTripper) http.RoundTripper {
+	return &transport{next: next}
+}
+
+type transport struct {
+	next http.RoundTripper
+}
+
+func (t *transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if !trace.SpanFromContext(r.Context()).SpanContext().IsValid() {
+		return t.next.RoundTrip(r)
+	}
+
+	ctx, span := Tracer().Start(r.Context(), "kubernetes "+r.Method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.HTTPClientAttributesFromHTTPRequest(r)...),
+	)
+	defer span.End()
+
+	r = r.Clone(ctx)
+	Inject(ctx, r.Header)
+
+	response, err := t.next.RoundTrip(r)
+	if err != nil {
+		RecordError(span, err)
+		return nil, err
+	}
+
+	SetStatus(span, response.StatusCode)
+	return response, nil
+}
@@ -0,0 +1,176 @@
+// Copyright 2020 OpenFaaS Author(s)
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package tracing
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/openfaas/faas-provider/types"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func newRecorder() *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return recorder
+}
+
+func attributeValue(span sdktrace.ReadOnlySpan, key attribute.Key) string {
+	for _, kv := range span.Attributes() {
+		if kv.Key == key {
+			return kv.Value.Emit()
+		}
+	}
+	return ""
+}
+
+func Test_InstrumentProxy_ContinuesTheTrace(t *testing.T) {
+	recorder := newRecorder()
+
+	router := mux.NewRouter()
+	router.HandleFunc("/function/{name}", InstrumentProxy(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "upstream error", http.StatusBadGateway)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/function/figlet", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("want 1 span, got %d", len(spans))
+	}
+	span := spans[0]
+
+	if span.Name() != "invoke" {
+		t.Errorf("want the span invoke, got %s", span.Name())
+	}
+	if got := span.SpanContext().TraceID().String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("want the trace of the caller, got %s", got)
+	}
+	if got := attributeValue(span, FunctionKey); got != "figlet" {
+		t.Errorf("want the function figlet, got %q", got)
+	}
+	if got := attributeValue(span, "http.status_code"); got != "502" {
+		t.Errorf("want the status 502, got %q", got)
+	}
+	if span.Status().Code != codes.Error {
+		t.Errorf("want an error status, got %v", span.Status().Code)
+	}
+}
+
+func Test_InstrumentHandlers_RecordsRoute(t *testing.T) {
+	recorder := newRecorder()
+
+	handlers := &types.FaaSHandlers{
+		DeployHandler: func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusAccepted)
+		},
+	}
+
+	InstrumentHandlers(handlers)
+
+	if handlers.UpdateHandler != nil {
+		t.Errorf("want unset handlers to stay nil")
+	}
+
+	handlers.DeployHandler(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/system/functions", nil))
+
+	spans := recorder.Ended()
+	if len(spans) != 1 || spans[0].Name() != "deploy" {
+		t.Fatalf("want the span deploy, got %v", spans)
+	}
+	if got := attributeValue(spans[0], "http.status_code"); got != "202" {
+		t.Errorf("want the status 202, got %q", got)
+	}
+}
+
+func Test_Transport_RecordsCallsWithASpan(t *testing.T) {
+	recorder := newRecorder()
+
+	traceparent := make(chan string, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent <- r.Header.Get("traceparent")
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: Transport(http.DefaultTransport)}
+
+	// the calls of the informers are not traced
+	res, err := client.Get(server.URL + "/api/v1/endpoints")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	res.Body.Close()
+	if got := <-traceparent; len(got) > 0 {
+		t.Errorf("want no traceparent without a span, got %s", got)
+	}
+	if got := len(recorder.Ended()); got != 0 {
+		t.Errorf("want no span, got %d", got)
+	}
+
+	ctx, parent := Tracer().Start(context.Background(), "reconcile")
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/apis/apps/v1/deployments", nil)
+	res, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	res.Body.Close()
+	parent.End()
+
+	if got := <-traceparent; !strings.Contains(got, parent.SpanContext().TraceID().String()) {
+		t.Errorf("want the traceparent of the reconcile, got %q", got)
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 2 || spans[0].Name() != "kubernetes GET" {
+		t.Fatalf("want the span of the call and the reconcile, got %d", len(spans))
+	}
+	if spans[0].Parent().SpanID() != parent.SpanContext().SpanID() {
+		t.Errorf("want the call to be a child of the reconcile")
+	}
+}
+
+func Test_Init_WritesSpansToFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "spans.json")
+
+	shutdown, err := Init(context.Background(), Config{Exporter: ExporterStdout, File: file, SampleRatio: 1})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	_, span := Tracer().Start(context.Background(), "reconcile")
+	span.End()
+
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !strings.Contains(string(data), `"Name":"reconcile"`) {
+		t.Errorf("want the span in the file, got %s", string(data))
+	}
+}
+
+func Test_Init_UnknownExporter(t *testing.T) {
+	if _, err := Init(context.Background(), Config{Exporter: "zipkin"}); err == nil {
+		t.Errorf("want an error for an unknown exporter")
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright 2020 OpenFaaS Author(s)
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+// Package tracing sets up the optional OpenTelemetry instrumentation of
+// faas-netes. Spans are created for each function invocation, for the
+// deploy, update and delete handlers with their Kubernetes API calls and for
+// the reconciles of the operator. They are only recorded once Init has
+// installed an exporter, until then the tracer does nothing.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// ExporterNone disables the tracing, this is the default
+	ExporterNone = "none"
+	// ExporterOTLP sends the spans to an OTLP collector over gRPC, it is
+	// configured with the standard OTEL_EXPORTER_OTLP_* environment variables
+	ExporterOTLP = "otlp"
+	// ExporterStdout writes the spans as JSON to stdout or to a file, for
+	// testing without a collector
+	ExporterStdout = "stdout"
+
+	serviceName = "faas-netes"
+	tracerName  = "github.com/openfaas/faas-netes"
+)
+
+// Config selects the exporter of the spans
+type Config struct {
+	// Exporter is one of ExporterNone, ExporterOTLP or ExporterStdout
+	Exporter string
+	// File is written by the stdout exporter instead of stdout when set
+	File string
+	// SampleRatio is the share of the traces started by faas-netes which
+	// are recorded, traces started by the caller follow its decision
+	SampleRatio float64
+}
+
+// Init installs the TracerProvider of the exporter and the W3C trace context
+// propagator. The returned func flushes the spans and stops the exporter.
+func Init(ctx context.Context, config Config) (func(context.Context) error, error) {
+	if config.Exporter == ExporterNone || len(config.Exporter) == 0 {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	var exporter sdktrace.SpanExporter
+	var file io.Closer
+	switch config.Exporter {
+	case ExporterOTLP:
+		otlpExporter, err := otlptracegrpc.New(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("unable to create the OTLP exporter: %s", err)
+		}
+		exporter = otlpExporter
+
+	case ExporterStdout:
+		var writer io.Writer = os.Stdout
+		if len(config.File) > 0 {
+			f, err := os.OpenFile(config.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+			if err != nil {
+				return nil, fmt.Errorf("unable to open the trace file: %s", err)
+			}
+			writer, file = f, f
+		}
+
+		stdoutExporter, err := stdouttrace.New(stdouttrace.WithWriter(writer))
+		if err != nil {
+			return nil, fmt.Errorf("unable to create the stdout exporter: %s", err)
+		}
+		exporter = stdoutExporter
+
+	default:
+		return nil, fmt.Errorf("unknown trace exporter: %s", config.Exporter)
+	}
+
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES override the defaults
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceNameKey.String(serviceName)),
+		resource.WithFromEnv(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create the trace resource: %s", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(config.SampleRatio))),
+	)
+
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if file != nil {
+			file.Close()
+		}
+		return err
+	}, nil
+}
+
+// Tracer returns the tracer of faas-netes
+func Tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
@@ -0,0 +1,133 @@
+// Copyright 2020 OpenFaaS Author(s)
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/cert"
+	"k8s.io/client-go/util/retry"
+	glog "k8s.io/klog"
+)
+
+const (
+	certFile = "tls.crt"
+	keyFile  = "tls.key"
+)
+
+// certificate loads the mounted certificate from the CertDir. When there is
+// none, a self-signed certificate is generated for the webhook Service and
+// its CA is written to the webhook configurations, so that the API server
+// trusts it. The generated certificate is kept in memory only, each replica
+// generates its own, so a mounted certificate should be used when running
+// more than one replica.
+func (s *Server) certificate(ctx context.Context) (tls.Certificate, error) {
+	if len(s.config.CertDir) > 0 {
+		certPath := filepath.Join(s.config.CertDir, certFile)
+		keyPath := filepath.Join(s.config.CertDir, keyFile)
+
+		if fileExists(certPath) && fileExists(keyPath) {
+			glog.Infof("Using the webhook certificate from %s", s.config.CertDir)
+			return tls.LoadX509KeyPair(certPath, keyPath)
+		}
+	}
+
+	host := fmt.Sprintf("%s.%s.svc", s.config.ServiceName, s.config.ServiceNamespace)
+	alternateDNS := []string{
+		s.config.ServiceName,
+		fmt.Sprintf("%s.%s", s.config.ServiceName, s.config.ServiceNamespace),
+	}
+
+	certPEM, keyPEM, err := cert.GenerateSelfSignedCertKey(host, nil, alternateDNS)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("unable to generate the webhook certificate: %s", err)
+	}
+
+	keyPair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	glog.Infof("Generated a self-signed webhook certificate for %s", host)
+
+	// the generated PEM holds the serving certificate followed by its CA
+	if err := s.injectCABundle(ctx, certPEM); err != nil {
+		return tls.Certificate{}, err
+	}
+
+	return keyPair, nil
+}
+
+// injectCABundle sets the caBundle of every webhook in the webhook
+// configurations. A configuration which does not exist is skipped, as it may
+// be managed outside of faas-netes.
+func (s *Server) injectCABundle(ctx context.Context, caBundle []byte) error {
+	mutating := s.kube.AdmissionregistrationV1().MutatingWebhookConfigurations()
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		config, err := mutating.Get(ctx, s.config.ConfigurationName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		changed := false
+		for i := range config.Webhooks {
+			if !bytes.Equal(config.Webhooks[i].ClientConfig.CABundle, caBundle) {
+				config.Webhooks[i].ClientConfig.CABundle = caBundle
+				changed = true
+			}
+		}
+		if !changed {
+			return nil
+		}
+
+		_, err = mutating.Update(ctx, config, metav1.UpdateOptions{})
+		return err
+	})
+	if errors.IsNotFound(err) {
+		glog.Warningf("MutatingWebhookConfiguration %s not found, skipping its caBundle", s.config.ConfigurationName)
+	} else if err != nil {
+		return fmt.Errorf("unable to set the caBundle of MutatingWebhookConfiguration %s: %s", s.config.ConfigurationName, err)
+	}
+
+	validating := s.kube.AdmissionregistrationV1().ValidatingWebhookConfigurations()
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		config, err := validating.Get(ctx, s.config.ConfigurationName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		changed := false
+		for i := range config.Webhooks {
+			if !bytes.Equal(config.Webhooks[i].ClientConfig.CABundle, caBundle) {
+				config.Webhooks[i].ClientConfig.CABundle = caBundle
+				changed = true
+			}
+		}
+		if !changed {
+			return nil
+		}
+
+		_, err = validating.Update(ctx, config, metav1.UpdateOptions{})
+		return err
+	})
+	if errors.IsNotFound(err) {
+		glog.Warningf("ValidatingWebhookConfiguration %s not found, skipping its caBundle", s.config.ConfigurationName)
+	} else if err != nil {
+		return fmt.Errorf("unable to set the caBundle of ValidatingWebhookConfiguration %s: %s", s.config.ConfigurationName, err)
+	}
+
+	return nil
+}
+
+func fileExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
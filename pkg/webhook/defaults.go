@@ -0,0 +1,32 @@
+// Copyright 2020 OpenFaaS Author(s)
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package webhook
+
+import (
+	faasv1 "github.com/openfaas/faas-netes/pkg/apis/openfaas/v1"
+)
+
+// patchOperation is a single JSONPatch operation, see RFC 6902
+type patchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// defaultFunction returns the patch which sets the defaults of the Function.
+// The REST API names the Deployment after the function, so the name in the
+// spec defaults to the name of the Function.
+func defaultFunction(function *faasv1.Function) []patchOperation {
+	patch := []patchOperation{}
+
+	if len(function.Spec.Name) == 0 && len(function.Name) > 0 {
+		patch = append(patch, patchOperation{
+			Op:    "add",
+			Path:  "/spec/name",
+			Value: function.Name,
+		})
+	}
+
+	return patch
+}
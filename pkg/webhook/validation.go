@@ -0,0 +1,222 @@
+// Copyright 2020 OpenFaaS Author(s)
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	faasv1 "github.com/openfaas/faas-netes/pkg/apis/openfaas/v1"
+	"github.com/openfaas/faas-netes/pkg/controller"
+	"github.com/openfaas/faas-netes/pkg/handlers"
+	"github.com/openfaas/faas-netes/pkg/k8s"
+	"github.com/openfaas/faas-provider/types"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// validateFunction checks the Function with the same rules that the REST API
+// applies to a deployment request, so that a Function which can not be
+// deployed is rejected by the API server instead of failing in the operator
+func (s *Server) validateFunction(ctx context.Context, function *faasv1.Function) field.ErrorList {
+	allErrs := field.ErrorList{}
+	specPath := field.NewPath("spec")
+
+	request := types.FunctionDeployment{Service: function.Spec.Name}
+	if err := handlers.ValidateDeployRequest(&request); err != nil {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("name"), function.Spec.Name, err.Error()))
+	}
+
+	if len(function.Spec.Image) == 0 {
+		allErrs = append(allErrs, field.Required(specPath.Child("image"), "an image is required to deploy a function"))
+	}
+
+	allErrs = append(allErrs, validateResources(specPath.Child("limits"), function.Spec.Limits)...)
+	allErrs = append(allErrs, validateResources(specPath.Child("requests"), function.Spec.Requests)...)
+
+	annotations := map[string]string{}
+	if function.Spec.Annotations != nil {
+		annotations = *function.Spec.Annotations
+	}
+	annotationsPath := specPath.Child("annotations")
+
+	if _, err := s.factory.Factory.FunctionPort(types.FunctionDeployment{Annotations: &annotations}); err != nil {
+		allErrs = append(allErrs, field.Invalid(annotationsPath.Key(k8s.PortAnnotation),
+			annotations[k8s.PortAnnotation], err.Error()))
+	} else if _, err := s.factory.MakeProbes(function); err != nil {
+		allErrs = append(allErrs, field.Invalid(annotationsPath.Key(k8s.ProbeInitialDelay),
+			annotations[k8s.ProbeInitialDelay], err.Error()))
+	}
+
+	if _, err := k8s.ParseTimeout(annotations); err != nil {
+		allErrs = append(allErrs, field.Invalid(annotationsPath.Key(k8s.TimeoutAnnotation),
+			annotations[k8s.TimeoutAnnotation], err.Error()))
+	}
+
+	if _, err := k8s.ParseScalingSchedule(annotations, time.UTC); err != nil {
+		allErrs = append(allErrs, field.Invalid(annotationsPath.Key(k8s.ScalingScheduleAnnotation),
+			annotations[k8s.ScalingScheduleAnnotation], err.Error()))
+	}
+
+	labels := map[string]string{}
+	if function.Spec.Labels != nil {
+		labels = *function.Spec.Labels
+	}
+	if _, err := k8s.MakeHorizontalPodAutoscaler(function.Spec.Name, function.Namespace, labels); err != nil {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("labels").Key(k8s.ScaleTypeLabel),
+			labels[k8s.ScaleTypeLabel], err.Error()))
+	}
+
+	policy, err := controller.FunctionFailurePolicy(function)
+	if err != nil {
+		allErrs = append(allErrs, field.NotSupported(annotationsPath.Key(controller.FailurePolicyAnnotation),
+			annotations[controller.FailurePolicyAnnotation],
+			[]string{controller.FailurePolicyFail, controller.FailurePolicyDegrade}))
+	}
+
+	// with the degrade policy the Function is deployed without the missing
+	// Secrets and Profiles, so they are not a reason to reject it
+	mustExist := policy != controller.FailurePolicyDegrade
+
+	for i, name := range function.Spec.Secrets {
+		_, err := s.secrets.Secrets(function.Namespace).Get(name)
+		if errors.IsNotFound(err) {
+			if mustExist {
+				allErrs = append(allErrs, field.NotFound(specPath.Child("secrets").Index(i), name))
+			}
+		} else if err != nil {
+			allErrs = append(allErrs, field.InternalError(specPath.Child("secrets").Index(i), err))
+		}
+	}
+
+	profiles := s.factory.Factory.NewProfileClient()
+	for _, name := range k8s.ParseProfileNames(annotations) {
+		_, err := profiles.Get(ctx, s.factory.Factory.Config.ProfilesNamespace, name)
+		if errors.IsNotFound(err) {
+			if mustExist {
+				allErrs = append(allErrs, field.NotFound(annotationsPath.Key(k8s.ProfileAnnotationKey), name))
+			}
+		} else if err != nil {
+			allErrs = append(allErrs, field.InternalError(annotationsPath.Key(k8s.ProfileAnnotationKey), err))
+		}
+	}
+
+	return allErrs
+}
+
+// validateResources checks that the quantities can be parsed, as they are
+// when the Deployment is created
+func validateResources(path *field.Path, resources *faasv1.FunctionResources) field.ErrorList {
+	allErrs := field.ErrorList{}
+	if resources == nil {
+		return allErrs
+	}
+
+	if len(resources.Memory) > 0 {
+		if _, err := resource.ParseQuantity(resources.Memory); err != nil {
+			allErrs = append(allErrs, field.Invalid(path.Child("memory"), resources.Memory, err.Error()))
+		}
+	}
+
+	if len(resources.CPU) > 0 {
+		if _, err := resource.ParseQuantity(resources.CPU); err != nil {
+			allErrs = append(allErrs, field.Invalid(path.Child("cpu"), resources.CPU, err.Error()))
+		}
+	}
+
+	return allErrs
+}
+
+// validateProfile checks the parts of the Profile that are copied to the Pod
+// spec of the functions, an invalid Profile would otherwise only be found
+// when the Deployment of a function using it is rejected
+func validateProfile(profile *faasv1.Profile) field.ErrorList {
+	allErrs := field.ErrorList{}
+	specPath := field.NewPath("spec")
+
+	for i, toleration := range profile.Spec.Tolerations {
+		allErrs = append(allErrs, validateToleration(specPath.Child("tolerations").Index(i), toleration)...)
+	}
+
+	if name := profile.Spec.RuntimeClassName; name != nil {
+		for _, msg := range validation.IsDNS1123Subdomain(*name) {
+			allErrs = append(allErrs, field.Invalid(specPath.Child("runtimeClassName"), *name, msg))
+		}
+	}
+
+	if sc := profile.Spec.PodSecurityContext; sc != nil {
+		path := specPath.Child("podSecurityContext")
+		allErrs = append(allErrs, validateID(path.Child("runAsUser"), sc.RunAsUser)...)
+		allErrs = append(allErrs, validateID(path.Child("runAsGroup"), sc.RunAsGroup)...)
+		allErrs = append(allErrs, validateID(path.Child("fsGroup"), sc.FSGroup)...)
+		for i, group := range sc.SupplementalGroups {
+			allErrs = append(allErrs, validateID(path.Child("supplementalGroups").Index(i), &group)...)
+		}
+	}
+
+	return allErrs
+}
+
+// validateToleration applies the rules of the API server for Pod tolerations
+func validateToleration(path *field.Path, toleration corev1.Toleration) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	if len(toleration.Key) > 0 {
+		for _, msg := range validation.IsQualifiedName(toleration.Key) {
+			allErrs = append(allErrs, field.Invalid(path.Child("key"), toleration.Key, msg))
+		}
+	}
+
+	switch toleration.Operator {
+	case corev1.TolerationOpEqual, "":
+		if len(toleration.Key) == 0 {
+			allErrs = append(allErrs, field.Invalid(path.Child("operator"), toleration.Operator,
+				"operator must be Exists when `key` is empty"))
+		}
+		for _, msg := range validation.IsValidLabelValue(toleration.Value) {
+			allErrs = append(allErrs, field.Invalid(path.Child("value"), toleration.Value, msg))
+		}
+	case corev1.TolerationOpExists:
+		if len(toleration.Value) > 0 {
+			allErrs = append(allErrs, field.Invalid(path.Child("value"), toleration.Value,
+				"value must be empty when `operator` is 'Exists'"))
+		}
+	default:
+		allErrs = append(allErrs, field.NotSupported(path.Child("operator"), toleration.Operator,
+			[]string{string(corev1.TolerationOpEqual), string(corev1.TolerationOpExists)}))
+	}
+
+	switch toleration.Effect {
+	case "", corev1.TaintEffectNoSchedule, corev1.TaintEffectPreferNoSchedule, corev1.TaintEffectNoExecute:
+	default:
+		allErrs = append(allErrs, field.NotSupported(path.Child("effect"), toleration.Effect,
+			[]string{
+				string(corev1.TaintEffectNoSchedule),
+				string(corev1.TaintEffectPreferNoSchedule),
+				string(corev1.TaintEffectNoExecute),
+			}))
+	}
+
+	if toleration.TolerationSeconds != nil && toleration.Effect != corev1.TaintEffectNoExecute {
+		allErrs = append(allErrs, field.Invalid(path.Child("effect"), toleration.Effect,
+			"effect must be 'NoExecute' when `tolerationSeconds` is set"))
+	}
+
+	return allErrs
+}
+
+func validateID(path *field.Path, id *int64) field.ErrorList {
+	if id == nil {
+		return nil
+	}
+
+	if *id < 0 || *id > 2147483647 {
+		return field.ErrorList{field.Invalid(path, *id, fmt.Sprintf("must be between %d and %d, inclusive", 0, 2147483647))}
+	}
+	return nil
+}
@@ -0,0 +1,244 @@
+// Copyright 2020 OpenFaaS Author(s)
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package webhook
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"time"
+
+	faasv1 "github.com/openfaas/faas-netes/pkg/apis/openfaas/v1"
+	"github.com/openfaas/faas-netes/pkg/controller"
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	glog "k8s.io/klog"
+)
+
+const (
+	// MutatePath is the path of the defaulting webhook for Functions
+	MutatePath = "/mutate"
+	// ValidatePath is the path of the validating webhook for Functions and Profiles
+	ValidatePath = "/validate"
+
+	functionKind = "Function"
+	profileKind  = "Profile"
+)
+
+// Config is the configuration of the admission webhook server
+type Config struct {
+	// Port is the HTTPS port of the webhook server
+	Port int
+	// CertDir is the directory of a mounted tls.crt and tls.key, a
+	// self-signed certificate is generated when they are not found
+	CertDir string
+	// ServiceName and ServiceNamespace are the Service used by the API
+	// server to call the webhook, they are used as the names of the
+	// generated certificate
+	ServiceName      string
+	ServiceNamespace string
+	// ConfigurationName is the name of the MutatingWebhookConfiguration
+	// and ValidatingWebhookConfiguration which are given the CA of the
+	// generated certificate
+	ConfigurationName string
+}
+
+// Server validates and defaults Function and Profile resources before they
+// are stored by the API server
+type Server struct {
+	config    Config
+	kube      kubernetes.Interface
+	factory   controller.FunctionFactory
+	secrets   corelisters.SecretLister
+	namespace string
+}
+
+// New creates the admission webhook server. Functions outside of namespace
+// are admitted as they are, an empty namespace admits Functions from all
+// namespaces.
+func New(config Config, kube kubernetes.Interface, factory controller.FunctionFactory, secrets corelisters.SecretLister, namespace string) *Server {
+	return &Server{
+		config:    config,
+		kube:      kube,
+		factory:   factory,
+		secrets:   secrets,
+		namespace: namespace,
+	}
+}
+
+// Start serves the webhook until the process exits
+func (s *Server) Start() error {
+	cert, err := s.certificate(context.Background())
+	if err != nil {
+		return err
+	}
+
+	server := &http.Server{
+		Addr:         fmt.Sprintf(":%d", s.config.Port),
+		Handler:      s.Handler(),
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+		TLSConfig: &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS12,
+		},
+	}
+
+	glog.Infof("Starting admission webhook server on port %d", s.config.Port)
+	return server.ListenAndServeTLS("", "")
+}
+
+// Handler returns the HTTP handler of the webhooks
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(MutatePath, s.serveAdmission(s.mutate))
+	mux.HandleFunc(ValidatePath, s.serveAdmission(s.validate))
+	return mux
+}
+
+type admitFunc func(ctx context.Context, request *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse
+
+func (s *Server) serveAdmission(admit admitFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		review := admissionv1.AdmissionReview{}
+		if err := json.Unmarshal(body, &review); err != nil {
+			http.Error(w, fmt.Sprintf("unable to decode AdmissionReview: %s", err), http.StatusBadRequest)
+			return
+		}
+		if review.Request == nil {
+			http.Error(w, "AdmissionReview has no request", http.StatusBadRequest)
+			return
+		}
+
+		response := admit(r.Context(), review.Request)
+		response.UID = review.Request.UID
+
+		out, err := json.Marshal(admissionv1.AdmissionReview{
+			TypeMeta: review.TypeMeta,
+			Response: response,
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(out)
+	}
+}
+
+// mutate applies the defaults of Functions
+func (s *Server) mutate(ctx context.Context, request *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	if request.Kind.Kind != functionKind || request.Object.Raw == nil {
+		return allowed()
+	}
+
+	function := &faasv1.Function{}
+	if err := json.Unmarshal(request.Object.Raw, function); err != nil {
+		return denied(errors.NewBadRequest(err.Error()))
+	}
+
+	patch := defaultFunction(function)
+	if len(patch) == 0 {
+		return allowed()
+	}
+
+	data, err := json.Marshal(patch)
+	if err != nil {
+		return denied(errors.NewInternalError(err))
+	}
+
+	patchType := admissionv1.PatchTypeJSONPatch
+	response := allowed()
+	response.Patch = data
+	response.PatchType = &patchType
+	return response
+}
+
+// validate checks Functions and Profiles
+func (s *Server) validate(ctx context.Context, request *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	if request.Object.Raw == nil {
+		return allowed()
+	}
+
+	switch request.Kind.Kind {
+	case functionKind:
+		if len(s.namespace) > 0 && request.Namespace != s.namespace {
+			return allowed()
+		}
+
+		function := &faasv1.Function{}
+		if err := json.Unmarshal(request.Object.Raw, function); err != nil {
+			return denied(errors.NewBadRequest(err.Error()))
+		}
+
+		// the operator updates the metadata of Functions, such as the
+		// finalizer, which must not be blocked by a Secret that was deleted
+		// after the Function was created
+		if function.DeletionTimestamp != nil {
+			return allowed()
+		}
+		if request.OldObject.Raw != nil {
+			old := &faasv1.Function{}
+			if err := json.Unmarshal(request.OldObject.Raw, old); err == nil && reflect.DeepEqual(old.Spec, function.Spec) {
+				return allowed()
+			}
+		}
+
+		if errs := s.validateFunction(ctx, function); len(errs) > 0 {
+			return invalid(functionKind, function.Name, errs)
+		}
+
+	case profileKind:
+		profile := &faasv1.Profile{}
+		if err := json.Unmarshal(request.Object.Raw, profile); err != nil {
+			return denied(errors.NewBadRequest(err.Error()))
+		}
+
+		if errs := validateProfile(profile); len(errs) > 0 {
+			return invalid(profileKind, profile.Name, errs)
+		}
+	}
+
+	return allowed()
+}
+
+func allowed() *admissionv1.AdmissionResponse {
+	return &admissionv1.AdmissionResponse{Allowed: true}
+}
+
+func denied(err *errors.StatusError) *admissionv1.AdmissionResponse {
+	status := err.Status()
+	return &admissionv1.AdmissionResponse{
+		Allowed: false,
+		Result:  &status,
+	}
+}
+
+// invalid rejects the object with the message format of the API server, i.e.
+// Function.openfaas.com "env" is invalid: spec.limits.memory: Invalid value: ...
+func invalid(kind, name string, errs field.ErrorList) *admissionv1.AdmissionResponse {
+	gk := schema.GroupKind{Group: faasv1.SchemeGroupVersion.Group, Kind: kind}
+	return denied(errors.NewInvalid(gk, name, errs))
+}
@@ -0,0 +1,317 @@
+// Copyright 2020 OpenFaaS Author(s)
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	faasv1 "github.com/openfaas/faas-netes/pkg/apis/openfaas/v1"
+	faasfake "github.com/openfaas/faas-netes/pkg/client/clientset/versioned/fake"
+	faasinformers "github.com/openfaas/faas-netes/pkg/client/informers/externalversions"
+	"github.com/openfaas/faas-netes/pkg/controller"
+	"github.com/openfaas/faas-netes/pkg/k8s"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubeinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newTestServer(t *testing.T) *Server {
+	kube := fake.NewSimpleClientset()
+	kubeInformers := kubeinformers.NewSharedInformerFactory(kube, 0)
+	profileInformers := faasinformers.NewSharedInformerFactory(faasfake.NewSimpleClientset(), 0)
+
+	secrets := kubeInformers.Core().V1().Secrets()
+	profiles := profileInformers.Openfaas().V1().Profiles()
+
+	secrets.Informer().GetIndexer().Add(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "api-key", Namespace: "openfaas-fn"},
+	})
+	profiles.Informer().GetIndexer().Add(&faasv1.Profile{
+		ObjectMeta: metav1.ObjectMeta{Name: "gpu", Namespace: "openfaas"},
+	})
+
+	factory := controller.FunctionFactory{
+		Factory: k8s.NewFunctionFactory(kube, k8s.DeploymentConfig{
+			RuntimeHTTPPort:   8080,
+			ProfilesNamespace: "openfaas",
+			LivenessProbe:     &k8s.ProbeConfig{},
+			ReadinessProbe:    &k8s.ProbeConfig{},
+		}, profiles.Lister()),
+	}
+
+	return New(Config{}, kube, factory, secrets.Lister(), "openfaas-fn")
+}
+
+func newTestFunction() *faasv1.Function {
+	return &faasv1.Function{
+		ObjectMeta: metav1.ObjectMeta{Name: "nodeinfo", Namespace: "openfaas-fn"},
+		Spec: faasv1.FunctionSpec{
+			Name:  "nodeinfo",
+			Image: "functions/nodeinfo",
+			Annotations: &map[string]string{
+				k8s.ProbeInitialDelay:    "5s",
+				k8s.ProfileAnnotationKey: "gpu",
+			},
+			Secrets: []string{"api-key"},
+			Limits:  &faasv1.FunctionResources{Memory: "128Mi", CPU: "100m"},
+		},
+	}
+}
+
+func review(t *testing.T, s *Server, path, kind string, obj, old runtime.Object) *admissionv1.AdmissionResponse {
+	t.Helper()
+
+	request := &admissionv1.AdmissionRequest{
+		UID:       "a1b2c3",
+		Kind:      metav1.GroupVersionKind{Group: "openfaas.com", Version: "v1", Kind: kind},
+		Namespace: "openfaas-fn",
+		Operation: admissionv1.Create,
+		Object:    runtime.RawExtension{Object: obj},
+	}
+	if old != nil {
+		request.Operation = admissionv1.Update
+		request.OldObject = runtime.RawExtension{Object: old}
+	}
+
+	body, err := json.Marshal(admissionv1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+		Request:  request,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	rr := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("want status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	got := admissionv1.AdmissionReview{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got.Response == nil || got.Response.UID != request.UID {
+		t.Fatalf("want a response for request %s, got %+v", request.UID, got.Response)
+	}
+	return got.Response
+}
+
+func Test_Validate_Function(t *testing.T) {
+	cases := []struct {
+		name    string
+		mutate  func(*faasv1.Function)
+		message string
+	}{
+		{
+			name:   "valid function",
+			mutate: func(*faasv1.Function) {},
+		},
+		{
+			name:    "invalid name",
+			mutate:  func(f *faasv1.Function) { f.Spec.Name = "Node_Info" },
+			message: "spec.name: Invalid value: \"Node_Info\"",
+		},
+		{
+			name:    "missing image",
+			mutate:  func(f *faasv1.Function) { f.Spec.Image = "" },
+			message: "spec.image: Required value",
+		},
+		{
+			name:    "unparseable memory limit",
+			mutate:  func(f *faasv1.Function) { f.Spec.Limits.Memory = "128MB" },
+			message: "spec.limits.memory: Invalid value: \"128MB\"",
+		},
+		{
+			name:    "unparseable cpu request",
+			mutate:  func(f *faasv1.Function) { f.Spec.Requests = &faasv1.FunctionResources{CPU: "one"} },
+			message: "spec.requests.cpu: Invalid value: \"one\"",
+		},
+		{
+			name:    "malformed initial delay",
+			mutate:  func(f *faasv1.Function) { (*f.Spec.Annotations)[k8s.ProbeInitialDelay] = "5" },
+			message: "spec.annotations[com.openfaas.health.http.initialDelay]: Invalid value: \"5\"",
+		},
+		{
+			name:    "port out of range",
+			mutate:  func(f *faasv1.Function) { (*f.Spec.Annotations)[k8s.PortAnnotation] = "70000" },
+			message: "spec.annotations[com.openfaas.port]: Invalid value: \"70000\"",
+		},
+		{
+			name:    "timeout without a unit",
+			mutate:  func(f *faasv1.Function) { (*f.Spec.Annotations)[k8s.TimeoutAnnotation] = "30" },
+			message: "spec.annotations[com.openfaas.timeout]: Invalid value: \"30\"",
+		},
+		{
+			name: "custom scale type without a metric",
+			mutate: func(f *faasv1.Function) {
+				f.Spec.Labels = &map[string]string{k8s.ScaleTypeLabel: k8s.ScaleTypeCustom, k8s.ScaleTargetLabel: "100"}
+			},
+			message: "spec.labels[com.openfaas.scale.type]: Invalid value: \"custom\"",
+		},
+		{
+			name: "scaling window without a duration",
+			mutate: func(f *faasv1.Function) {
+				(*f.Spec.Annotations)[k8s.ScalingScheduleAnnotation] = `[{"name":"business-hours","start":"0 8 * * 1-5","min":3}]`
+			},
+			message: "spec.annotations[com.openfaas.scale.schedule]: Invalid value",
+		},
+		{
+			name:    "missing secret",
+			mutate:  func(f *faasv1.Function) { f.Spec.Secrets = append(f.Spec.Secrets, "db-password") },
+			message: "spec.secrets[1]: Not found: \"db-password\"",
+		},
+		{
+			name:    "missing profile",
+			mutate:  func(f *faasv1.Function) { (*f.Spec.Annotations)[k8s.ProfileAnnotationKey] = "gpu,arm64" },
+			message: "spec.annotations[com.openfaas.profile]: Not found: \"arm64\"",
+		},
+		{
+			name: "missing secret and profile with the degrade policy",
+			mutate: func(f *faasv1.Function) {
+				(*f.Spec.Annotations)[controller.FailurePolicyAnnotation] = controller.FailurePolicyDegrade
+				(*f.Spec.Annotations)[k8s.ProfileAnnotationKey] = "gpu,arm64"
+				f.Spec.Secrets = append(f.Spec.Secrets, "db-password")
+			},
+		},
+		{
+			name:    "invalid failure policy",
+			mutate:  func(f *faasv1.Function) { (*f.Spec.Annotations)[controller.FailurePolicyAnnotation] = "ignore" },
+			message: "spec.annotations[com.openfaas.config.failurePolicy]: Unsupported value: \"ignore\"",
+		},
+	}
+
+	s := newTestServer(t)
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			function := newTestFunction()
+			tc.mutate(function)
+
+			got := review(t, s, ValidatePath, functionKind, function, nil)
+
+			if len(tc.message) == 0 {
+				if !got.Allowed {
+					t.Fatalf("want the Function to be allowed, got: %s", got.Result.Message)
+				}
+				return
+			}
+
+			if got.Allowed {
+				t.Fatalf("want the Function to be denied")
+			}
+			if got.Result.Code != http.StatusUnprocessableEntity {
+				t.Errorf("want code %d, got %d", http.StatusUnprocessableEntity, got.Result.Code)
+			}
+			if !strings.Contains(got.Result.Message, tc.message) {
+				t.Errorf("want message to contain %q, got %q", tc.message, got.Result.Message)
+			}
+		})
+	}
+}
+
+func Test_Validate_FunctionUpdateWithUnchangedSpec(t *testing.T) {
+	s := newTestServer(t)
+
+	// the secret was deleted after the Function was created
+	old := newTestFunction()
+	old.Spec.Secrets = []string{"deleted"}
+
+	function := old.DeepCopy()
+	function.Finalizers = []string{"openfaas.com/function"}
+
+	if got := review(t, s, ValidatePath, functionKind, function, old); !got.Allowed {
+		t.Errorf("want metadata updates to be allowed, got: %s", got.Result.Message)
+	}
+
+	function.Spec.Image = "functions/nodeinfo:latest"
+	if got := review(t, s, ValidatePath, functionKind, function, old); got.Allowed {
+		t.Errorf("want spec updates to be validated")
+	}
+}
+
+func Test_Validate_FunctionInOtherNamespace(t *testing.T) {
+	s := newTestServer(t)
+	s.namespace = "team-a"
+
+	function := newTestFunction()
+	function.Spec.Secrets = []string{"db-password"}
+
+	if got := review(t, s, ValidatePath, functionKind, function, nil); !got.Allowed {
+		t.Errorf("want Functions outside of the namespace to be allowed, got: %s", got.Result.Message)
+	}
+}
+
+func Test_Validate_Profile(t *testing.T) {
+	runtimeClass := "gVisor"
+	runAsUser := int64(-1)
+	seconds := int64(60)
+
+	profile := &faasv1.Profile{
+		ObjectMeta: metav1.ObjectMeta{Name: "sandbox", Namespace: "openfaas"},
+		Spec: faasv1.ProfileSpec{
+			Tolerations: []corev1.Toleration{
+				{Key: "gpu", Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoSchedule},
+				{Key: "gpu", Operator: corev1.TolerationOpExists, Value: "true"},
+				{Key: "arch", Operator: "In"},
+				{Key: "spot", Operator: corev1.TolerationOpExists, TolerationSeconds: &seconds},
+			},
+			RuntimeClassName:   &runtimeClass,
+			PodSecurityContext: &corev1.PodSecurityContext{RunAsUser: &runAsUser},
+		},
+	}
+
+	got := review(t, newTestServer(t), ValidatePath, profileKind, profile, nil)
+	if got.Allowed {
+		t.Fatalf("want the Profile to be denied")
+	}
+
+	want := []string{
+		"spec.tolerations[1].value: Invalid value: \"true\"",
+		"spec.tolerations[2].operator: Unsupported value: \"In\"",
+		"spec.tolerations[3].effect: Invalid value: \"\"",
+		"spec.runtimeClassName: Invalid value: \"gVisor\"",
+		"spec.podSecurityContext.runAsUser: Invalid value: -1",
+	}
+	for _, msg := range want {
+		if !strings.Contains(got.Result.Message, msg) {
+			t.Errorf("want message to contain %q, got %q", msg, got.Result.Message)
+		}
+	}
+	if strings.Contains(got.Result.Message, "spec.tolerations[0]") {
+		t.Errorf("want the first toleration to be valid, got %q", got.Result.Message)
+	}
+}
+
+func Test_Mutate_DefaultsFunctionName(t *testing.T) {
+	s := newTestServer(t)
+
+	function := newTestFunction()
+	function.Spec.Name = ""
+
+	got := review(t, s, MutatePath, functionKind, function, nil)
+	if !got.Allowed {
+		t.Fatalf("want the Function to be allowed, got: %s", got.Result.Message)
+	}
+	if got.PatchType == nil || *got.PatchType != admissionv1.PatchTypeJSONPatch {
+		t.Fatalf("want a JSONPatch, got %v", got.PatchType)
+	}
+
+	want := `[{"op":"add","path":"/spec/name","value":"nodeinfo"}]`
+	if string(got.Patch) != want {
+		t.Errorf("want patch %s, got %s", want, got.Patch)
+	}
+
+	got = review(t, s, MutatePath, functionKind, newTestFunction(), nil)
+	if got.Patch != nil {
+		t.Errorf("want no patch when the name is set, got %s", got.Patch)
+	}
+}